@@ -0,0 +1,443 @@
+package apidiff
+
+import (
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// A Change is a difference in the exported API of a package.
+type Change struct {
+	Package    string // import path of the package
+	Symbol     string // changed symbol, T.M for methods and fields; empty for the package itself
+	Message    string // description of the change
+	Compatible bool   // whether the change is backward compatible
+}
+
+func (c Change) String() string {
+	if c.Symbol == "" {
+		return c.Package + ": " + c.Message
+	}
+	return c.Package + "." + c.Symbol + ": " + c.Message
+}
+
+// A Report lists the changes from one version of a module to another.
+type Report struct {
+	Changes []Change // sorted by package and symbol
+}
+
+// Incompatible returns the incompatible changes in r.
+func (r *Report) Incompatible() []Change {
+	return r.filter(false)
+}
+
+// Compatible returns the compatible changes in r.
+func (r *Report) Compatible() []Change {
+	return r.filter(true)
+}
+
+func (r *Report) filter(compatible bool) []Change {
+	var list []Change
+	for _, c := range r.Changes {
+		if c.Compatible == compatible {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// Diff reports the changes to the exported API of the packages of old
+// in new. Packages with an element named internal are left out, since
+// they cannot be imported by other modules.
+//
+// A change is incompatible if code using old could fail to compile
+// against new: a package or symbol was removed or changed its kind or
+// type, a struct field or method was removed, or a method was added to
+// an interface that other packages can implement. Added packages,
+// symbols, fields and methods are compatible changes. Changes to types
+// that could not be type-checked are not reported.
+func Diff(old, new *Module) *Report {
+	d := &differ{}
+	newPkgs := make(map[string]*types.Package)
+	for _, p := range new.Packages {
+		newPkgs[p.Path()] = p
+	}
+	oldPkgs := make(map[string]bool)
+	for _, op := range old.Packages {
+		if isInternal(op.Path()) {
+			continue
+		}
+		oldPkgs[op.Path()] = true
+		np := newPkgs[op.Path()]
+		if np == nil {
+			d.add(op.Path(), "", "removed", false)
+			continue
+		}
+		d.pkg(op, np)
+	}
+	for _, np := range new.Packages {
+		if !isInternal(np.Path()) && !oldPkgs[np.Path()] {
+			d.add(np.Path(), "", "added", true)
+		}
+	}
+	sort.SliceStable(d.changes, func(i, j int) bool {
+		a, b := d.changes[i], d.changes[j]
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.Symbol < b.Symbol
+	})
+	return &Report{Changes: d.changes}
+}
+
+// A differ accumulates changes.
+type differ struct {
+	changes []Change
+}
+
+func (d *differ) add(pkg, symbol, message string, compatible bool) {
+	d.changes = append(d.changes, Change{Package: pkg, Symbol: symbol, Message: message, Compatible: compatible})
+}
+
+// pkg compares the exported package-level objects of two versions of
+// a package.
+func (d *differ) pkg(op, np *types.Package) {
+	path := op.Path()
+	for _, name := range op.Scope().Names() {
+		oo := op.Scope().Lookup(name)
+		if !oo.Exported() {
+			continue
+		}
+		no := np.Scope().Lookup(name)
+		if no == nil || !no.Exported() {
+			d.add(path, name, "removed", false)
+			continue
+		}
+		if ok, nk := objectKind(oo), objectKind(no); ok != nk {
+			d.add(path, name, fmt.Sprintf("changed from %s to %s", ok, nk), false)
+			continue
+		}
+		switch oo := oo.(type) {
+		case *types.Const:
+			no := no.(*types.Const)
+			if ot, nt := typeString(oo.Type()), typeString(no.Type()); differs(ot, nt) {
+				d.add(path, name, fmt.Sprintf("type changed from %s to %s", ot, nt), false)
+			} else if ov, nv := oo.Val().ExactString(), no.Val().ExactString(); ov != nv {
+				if len(ov) > 40 || len(nv) > 40 {
+					d.add(path, name, "value changed", false)
+				} else {
+					d.add(path, name, fmt.Sprintf("value changed from %s to %s", ov, nv), false)
+				}
+			}
+		case *types.Var:
+			if ot, nt := typeString(oo.Type()), typeString(no.Type()); differs(ot, nt) {
+				d.add(path, name, fmt.Sprintf("type changed from %s to %s", ot, nt), false)
+			}
+		case *types.Func:
+			if ot, nt := signatureString(oo), signatureString(no.(*types.Func)); differs(ot, nt) {
+				d.add(path, name, fmt.Sprintf("changed from %s to %s", ot, nt), false)
+			}
+		case *types.TypeName:
+			d.typeName(path, name, oo, no.(*types.TypeName))
+		}
+	}
+	for _, name := range np.Scope().Names() {
+		no := np.Scope().Lookup(name)
+		if no.Exported() && op.Scope().Lookup(name) == nil {
+			d.add(path, name, "added", true)
+		}
+	}
+}
+
+// typeName compares two versions of the type name.
+func (d *differ) typeName(path, name string, oo, no *types.TypeName) {
+	if oo.IsAlias() || no.IsAlias() {
+		if ot, nt := typeString(unalias(oo.Type())), typeString(unalias(no.Type())); differs(ot, nt) {
+			d.add(path, name, fmt.Sprintf("changed from %s to %s", ot, nt), false)
+		}
+		return
+	}
+	on, ok1 := oo.Type().(*types.Named)
+	nn, ok2 := no.Type().(*types.Named)
+	if !ok1 || !ok2 {
+		return
+	}
+	if ot, nt := typeParamsString(on.TypeParams()), typeParamsString(nn.TypeParams()); differs(ot, nt) {
+		d.add(path, name, fmt.Sprintf("type parameters changed from [%s] to [%s]", ot, nt), false)
+		return
+	}
+	ou, nu := on.Underlying(), nn.Underlying()
+	switch ou := ou.(type) {
+	case *types.Struct:
+		nu, ok := nu.(*types.Struct)
+		if !ok {
+			d.add(path, name, fmt.Sprintf("changed from struct to %s", typeKind(nn.Underlying())), false)
+			return
+		}
+		d.fields(path, name, ou, nu)
+	case *types.Interface:
+		nu, ok := nu.(*types.Interface)
+		if !ok {
+			d.add(path, name, fmt.Sprintf("changed from interface to %s", typeKind(nn.Underlying())), false)
+			return
+		}
+		d.interfaceMethods(path, name, ou, nu)
+		return
+	default:
+		if ot, nt := typeString(ou), typeString(nu); differs(ot, nt) {
+			d.add(path, name, fmt.Sprintf("underlying type changed from %s to %s", ot, nt), false)
+			return
+		}
+	}
+	d.methods(path, name, on, nn)
+}
+
+// fields compares the exported fields of two versions of a struct type.
+func (d *differ) fields(path, name string, ost, nst *types.Struct) {
+	newFields := make(map[string]*types.Var)
+	for i := 0; i < nst.NumFields(); i++ {
+		newFields[nst.Field(i).Name()] = nst.Field(i)
+	}
+	oldFields := make(map[string]bool)
+	for i := 0; i < ost.NumFields(); i++ {
+		of := ost.Field(i)
+		if !of.Exported() {
+			continue
+		}
+		oldFields[of.Name()] = true
+		symbol := name + "." + of.Name()
+		nf := newFields[of.Name()]
+		if nf == nil || !nf.Exported() {
+			d.add(path, symbol, "field removed", false)
+			continue
+		}
+		if ot, nt := typeString(of.Type()), typeString(nf.Type()); differs(ot, nt) {
+			d.add(path, symbol, fmt.Sprintf("field type changed from %s to %s", ot, nt), false)
+		}
+	}
+	for i := 0; i < nst.NumFields(); i++ {
+		nf := nst.Field(i)
+		if nf.Exported() && !oldFields[nf.Name()] {
+			d.add(path, name+"."+nf.Name(), "field added", true)
+		}
+	}
+}
+
+// interfaceMethods compares the methods of two versions of an
+// interface type. Adding a method is incompatible unless the old
+// interface had unexported methods, so that only its own package could
+// implement it.
+func (d *differ) interfaceMethods(path, name string, oi, ni *types.Interface) {
+	sealed := false
+	for i := 0; i < oi.NumMethods(); i++ {
+		if !oi.Method(i).Exported() {
+			sealed = true
+		}
+	}
+	d.methodSets(path, name, methodsOf(oi.NumMethods(), oi.Method), methodsOf(ni.NumMethods(), ni.Method), sealed)
+}
+
+// methods compares the exported methods of two versions of a named
+// type, including those of its pointer type.
+func (d *differ) methods(path, name string, on, nn *types.Named) {
+	oms := types.NewMethodSet(types.NewPointer(on))
+	nms := types.NewMethodSet(types.NewPointer(nn))
+	get := func(ms *types.MethodSet) map[string]*types.Func {
+		m := make(map[string]*types.Func)
+		for i := 0; i < ms.Len(); i++ {
+			if f, ok := ms.At(i).Obj().(*types.Func); ok {
+				m[f.Name()] = f
+			}
+		}
+		return m
+	}
+	d.methodSets(path, name, get(oms), get(nms), true)
+}
+
+// methodSets compares two sets of methods of the type name. Added
+// methods are compatible if addCompatible is set.
+func (d *differ) methodSets(path, name string, om, nm map[string]*types.Func, addCompatible bool) {
+	var names []string
+	for n := range om {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		of := om[n]
+		if !of.Exported() {
+			continue
+		}
+		nf := nm[n]
+		if nf == nil {
+			d.add(path, name+"."+n, "method removed", false)
+			continue
+		}
+		if ot, nt := signatureString(of), signatureString(nf); differs(ot, nt) {
+			d.add(path, name+"."+n, fmt.Sprintf("changed from %s to %s", ot, nt), false)
+		}
+	}
+	names = names[:0]
+	for n := range nm {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if nm[n].Exported() && om[n] == nil {
+			if addCompatible {
+				d.add(path, name+"."+n, "method added", true)
+			} else {
+				d.add(path, name+"."+n, "method added to interface", false)
+			}
+		}
+	}
+}
+
+// methodsOf returns the methods returned by method(i) for i < n,
+// by name.
+func methodsOf(n int, method func(int) *types.Func) map[string]*types.Func {
+	m := make(map[string]*types.Func)
+	for i := 0; i < n; i++ {
+		m[method(i).Name()] = method(i)
+	}
+	return m
+}
+
+// SemverViolation returns a description of the way in which the change
+// from oldVersion to newVersion of a module, with the changes in r,
+// violates semantic versioning, or "" if it does not. Incompatible
+// changes need a new major version, or, for v0, a new minor version;
+// compatible additions need a new minor version.
+func SemverViolation(r *Report, oldVersion, newVersion string) string {
+	if semver.Compare(newVersion, oldVersion) <= 0 || semver.Major(oldVersion) != semver.Major(newVersion) {
+		return ""
+	}
+	sameMinor := semver.MajorMinor(oldVersion) == semver.MajorMinor(newVersion)
+	incompatible := len(r.Incompatible()) > 0
+	compatible := len(r.Compatible()) > 0
+	switch {
+	case incompatible && semver.Major(newVersion) != "v0":
+		return fmt.Sprintf("incompatible changes need a new major version, not %s", newVersion)
+	case incompatible && sameMinor:
+		return fmt.Sprintf("incompatible changes need at least a new minor version, not %s", newVersion)
+	case compatible && sameMinor:
+		return fmt.Sprintf("new API needs a new minor version, not %s", newVersion)
+	}
+	return ""
+}
+
+// differs reports whether two formatted types differ. Types that could
+// not be type-checked, because of missing dependencies, are not
+// compared, to avoid reporting spurious changes.
+func differs(ot, nt string) bool {
+	if strings.Contains(ot, "invalid type") || strings.Contains(nt, "invalid type") {
+		return false
+	}
+	return ot != nt
+}
+
+// objectKind describes the kind of obj.
+func objectKind(obj types.Object) string {
+	switch obj := obj.(type) {
+	case *types.Const:
+		return "const"
+	case *types.Var:
+		return "var"
+	case *types.Func:
+		return "func"
+	case *types.TypeName:
+		if obj.IsAlias() {
+			return "type alias"
+		}
+		return "type"
+	}
+	return "object"
+}
+
+// unalias returns the type denoted by t, following aliases. Since Go
+// 1.23, aliases type-check as *types.Alias, which formats as the alias
+// name rather than the aliased type. (types.Unalias is not available in
+// Go 1.18.)
+func unalias(t types.Type) types.Type {
+	for {
+		a, ok := t.(interface{ Rhs() types.Type })
+		if !ok {
+			return t
+		}
+		t = a.Rhs()
+	}
+}
+
+// typeKind describes the kind of the underlying type t.
+func typeKind(t types.Type) string {
+	switch t.(type) {
+	case *types.Struct:
+		return "struct"
+	case *types.Interface:
+		return "interface"
+	}
+	return typeString(t)
+}
+
+// typeString formats t with package-qualified names, which are the
+// same in both versions of the module.
+func typeString(t types.Type) string {
+	return types.TypeString(t, (*types.Package).Path)
+}
+
+// signatureString formats the signature of f without its receiver
+// or parameter names, which are not part of its API.
+func signatureString(f *types.Func) string {
+	sig := f.Type().(*types.Signature)
+	var b strings.Builder
+	b.WriteString("func(")
+	tupleString(&b, sig.Params(), sig.Variadic())
+	b.WriteString(")")
+	switch res := sig.Results(); res.Len() {
+	case 0:
+	case 1:
+		b.WriteString(" ")
+		b.WriteString(typeString(res.At(0).Type()))
+	default:
+		b.WriteString(" (")
+		tupleString(&b, res, false)
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+// tupleString writes the types in t, separated by commas.
+func tupleString(b *strings.Builder, t *types.Tuple, variadic bool) {
+	for i := 0; i < t.Len(); i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		typ := t.At(i).Type()
+		if s, ok := typ.(*types.Slice); ok && variadic && i == t.Len()-1 {
+			b.WriteString("...")
+			typ = s.Elem()
+		}
+		b.WriteString(typeString(typ))
+	}
+}
+
+// typeParamsString formats the constraints of a type parameter list.
+func typeParamsString(tparams *types.TypeParamList) string {
+	var list []string
+	for i := 0; i < tparams.Len(); i++ {
+		list = append(list, typeString(tparams.At(i).Constraint()))
+	}
+	return strings.Join(list, ", ")
+}
+
+// isInternal reports whether path has an element named internal.
+func isInternal(path string) bool {
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "internal" {
+			return true
+		}
+	}
+	return false
+}
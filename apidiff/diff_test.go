@@ -0,0 +1,213 @@
+package apidiff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+// The two versions of example.com/m compared by TestDiff.
+var (
+	oldFiles = map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.18\n\nrequire example.com/dep v1.0.0\n",
+		"m.go": `package m
+
+import (
+	"example.com/dep"
+	"example.com/missing"
+)
+
+const C = 1
+
+const Same = "s"
+
+var V int
+
+func F(a int) string { return "" }
+
+func Removed() {}
+
+func Kind() {}
+
+type S struct {
+	A      int
+	B      string
+	hidden int
+}
+
+func (S) M()   {}
+func (*S) PM() {}
+
+type I interface{ M() }
+
+type Sealed interface {
+	M()
+	sealed()
+}
+
+type K int
+
+type Alias = int
+
+type G[T any] struct{}
+
+var D dep.T
+
+var X missing.T
+`,
+		"gone/g.go":     "package gone\n\nfunc G() {}\n",
+		"internal/x.go": "package internal\n\nfunc X() {}\n",
+		"cmd/main.go":   "package main\n\nfunc main() {}\n",
+	}
+	newFiles = map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.18\n\nrequire example.com/dep v1.0.0\n",
+		"m.go": `package m
+
+import (
+	"example.com/dep"
+	"example.com/missing"
+)
+
+const C = 2
+
+const Same = "s"
+
+var V int64
+
+func F(a int, b ...string) string { return "" }
+
+var Kind = 1
+
+func Added() {}
+
+type S struct {
+	A int
+	C bool
+}
+
+func (S) M() {}
+func (S) N() {}
+
+type I interface {
+	M()
+	N()
+}
+
+type Sealed interface {
+	M()
+	N()
+	sealed()
+}
+
+type K string
+
+type Alias = string
+
+type G[T comparable] struct{}
+
+var D dep.U
+
+var X missing.U
+`,
+		"newpkg/n.go":   "package newpkg\n",
+		"internal/x.go": "package internal\n\nfunc Y() {}\n",
+		"cmd/main.go":   "package main\n\nfunc main() {}\n",
+	}
+)
+
+func TestDiff(t *testing.T) {
+	modCache := t.TempDir()
+	modtest.WriteModule(t, modCache, "example.com/dep", "v1.0.0", map[string]string{
+		"go.mod": "module example.com/dep\n",
+		"dep.go": "package dep\n\ntype T int\n\ntype U string\n",
+	}, false)
+	modtest.WriteModule(t, modCache, "example.com/m", "v1.0.0", oldFiles, true)
+	cacheDir, _ := modtest.WriteModule(t, modCache, "example.com/m", "v1.1.0", newFiles, false)
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := Load(c, "example.com/m", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := Load(c, "example.com/m", "v1.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pkgs []string
+	for _, p := range old.Packages {
+		pkgs = append(pkgs, p.Path())
+	}
+	// Main packages are left out.
+	if got, want := strings.Join(pkgs, " "), "example.com/m example.com/m/gone example.com/m/internal"; got != want {
+		t.Errorf("Load(v1.0.0) packages = %s, want %s", got, want)
+	}
+
+	r := Diff(old, new)
+	var got strings.Builder
+	for _, c := range r.Changes {
+		kind := "incompatible"
+		if c.Compatible {
+			kind = "compatible"
+		}
+		fmt.Fprintf(&got, "%s: %s\n", kind, c)
+	}
+	// The change to X, whose type is from a package missing from the
+	// cache, is not reported; the change to D, from a cached one, is.
+	want := `compatible: example.com/m.Added: added
+incompatible: example.com/m.Alias: changed from int to string
+incompatible: example.com/m.C: value changed from 1 to 2
+incompatible: example.com/m.D: type changed from example.com/dep.T to example.com/dep.U
+incompatible: example.com/m.F: changed from func(int) string to func(int, ...string) string
+incompatible: example.com/m.G: type parameters changed from [any] to [comparable]
+incompatible: example.com/m.I.N: method added to interface
+incompatible: example.com/m.K: underlying type changed from int to string
+incompatible: example.com/m.Kind: changed from func to var
+incompatible: example.com/m.Removed: removed
+incompatible: example.com/m.S.B: field removed
+compatible: example.com/m.S.C: field added
+compatible: example.com/m.S.N: method added
+incompatible: example.com/m.S.PM: method removed
+compatible: example.com/m.Sealed.N: method added
+incompatible: example.com/m.V: type changed from int to int64
+incompatible: example.com/m/gone: removed
+compatible: example.com/m/newpkg: added
+`
+	if got.String() != want {
+		t.Errorf("Diff:\n%s\nwant:\n%s", got.String(), want)
+	}
+	if n, m := len(r.Compatible()), len(r.Incompatible()); n != 5 || m != 13 {
+		t.Errorf("Diff has %d compatible and %d incompatible changes, want 5 and 13", n, m)
+	}
+	if v := SemverViolation(r, "v1.0.0", "v1.1.0"); v == "" {
+		t.Errorf("SemverViolation(v1.0.0, v1.1.0) = \"\", want a violation")
+	}
+}
+
+func TestSemverViolation(t *testing.T) {
+	compatible := &Report{Changes: []Change{{Package: "p", Symbol: "New", Message: "added", Compatible: true}}}
+	incompatible := &Report{Changes: []Change{{Package: "p", Symbol: "Old", Message: "removed"}}}
+	for _, tt := range []struct {
+		r        *Report
+		old, new string
+		want     string
+	}{
+		{incompatible, "v1.0.0", "v1.1.0", "incompatible changes need a new major version, not v1.1.0"},
+		{incompatible, "v1.0.0", "v2.0.0", ""},
+		{incompatible, "v0.1.0", "v0.1.1", "incompatible changes need at least a new minor version, not v0.1.1"},
+		{incompatible, "v0.1.0", "v0.2.0", ""},
+		{compatible, "v1.0.0", "v1.0.1", "new API needs a new minor version, not v1.0.1"},
+		{compatible, "v1.0.0", "v1.1.0", ""},
+		{&Report{}, "v1.0.0", "v1.0.1", ""},
+		// Downgrades are not checked.
+		{incompatible, "v1.1.0", "v1.0.0", ""},
+	} {
+		if got := SemverViolation(tt.r, tt.old, tt.new); got != tt.want {
+			t.Errorf("SemverViolation(%v, %s, %s) = %q, want %q", tt.r.Changes, tt.old, tt.new, got, tt.want)
+		}
+	}
+}
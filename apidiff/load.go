@@ -0,0 +1,194 @@
+// Package apidiff compares the exported API of two cached versions of a
+// module, type-checking their packages from the module cache, and
+// reports the incompatible changes and compatible additions between
+// them, and whether the change in version number follows semantic
+// versioning.
+package apidiff
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/modgraph"
+	"golang.org/x/mod/module"
+)
+
+// A Module is a type-checked module version.
+type Module struct {
+	Path     string
+	Version  string
+	Packages []*types.Package // non-main packages, sorted by path
+}
+
+// Load type-checks the packages of the cached module version
+// path@version. Its dependencies are the versions selected by its
+// requirement graph (see modgraph.LoadVersion) or, if some of the
+// go.mod files needed for the graph are not cached, the latest cached
+// versions. Standard library packages are read from GOROOT with cgo
+// disabled. Function bodies are not checked, and type errors, such as
+// those caused by dependencies missing from the cache, are ignored:
+// the affected types are reported as invalid.
+func Load(c *catalog.Catalog, modPath, version string) (*Module, error) {
+	m, err := c.Module(modPath)
+	if err != nil {
+		return nil, err
+	}
+	v := m.Lookup(version)
+	if v == nil {
+		return nil, fmt.Errorf("%s@%s: not in module cache", modPath, version)
+	}
+	g, err := modgraph.LoadVersion(c, module.Version{Path: modPath, Version: version})
+	if err != nil {
+		if _, ok := err.(*modgraph.MissingError); !ok {
+			return nil, err
+		}
+		g = nil
+	}
+	im := newImporter(c, g, v)
+	defer im.close()
+	root, err := im.l.Locate(module.Version{Path: modPath, Version: version})
+	if err != nil {
+		return nil, err
+	}
+	srcDir := root.Root
+	dirs, err := catalog.PackageDirs(srcDir, root.Zip)
+	if err != nil {
+		return nil, err
+	}
+	mod := &Module{Path: modPath, Version: version}
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(srcDir, dir)
+		if err != nil {
+			return nil, err
+		}
+		pkg, err := im.Import(path.Join(modPath, filepath.ToSlash(rel)))
+		if err != nil {
+			if _, ok := err.(*build.NoGoError); ok {
+				continue
+			}
+			return nil, err
+		}
+		if pkg.Name() != "main" {
+			mod.Packages = append(mod.Packages, pkg)
+		}
+	}
+	sort.Slice(mod.Packages, func(i, j int) bool {
+		return mod.Packages[i].Path() < mod.Packages[j].Path()
+	})
+	return mod, nil
+}
+
+// An importer type-checks packages from source, reading those of
+// modules from the module cache.
+type importer struct {
+	l    *modgraph.Loader
+	ctx  build.Context
+	fset *token.FileSet
+	pkgs map[string]*types.Package // by directory; nil while being checked
+}
+
+// newImporter returns an importer for the packages of main and its
+// dependencies, those selected by g, or if g is nil, the latest cached
+// versions.
+func newImporter(c *catalog.Catalog, g *modgraph.Graph, main *catalog.Version) *importer {
+	ctx := build.Default
+	ctx.CgoEnabled = false
+	return &importer{
+		l:    modgraph.NewLoader(c, g, module.Version{Path: main.Path, Version: main.Version}),
+		ctx:  ctx,
+		fset: token.NewFileSet(),
+		pkgs: make(map[string]*types.Package),
+	}
+}
+
+func (im *importer) close() {
+	im.l.Close()
+}
+
+func (im *importer) Import(path string) (*types.Package, error) {
+	return im.ImportFrom(path, "", 0)
+}
+
+// ImportFrom implements types.ImporterFrom. The directory of the
+// importing package is used to find the packages vendored in GOROOT.
+func (im *importer) ImportFrom(importPath, fromDir string, mode types.ImportMode) (*types.Package, error) {
+	if importPath == "unsafe" {
+		return types.Unsafe, nil
+	}
+	bp, ctx, err := im.find(importPath, fromDir)
+	if err != nil {
+		return nil, err
+	}
+	if pkg, ok := im.pkgs[bp.Dir]; ok {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through %s", importPath)
+		}
+		return pkg, nil
+	}
+	im.pkgs[bp.Dir] = nil
+	var files []*ast.File
+	for _, name := range bp.GoFiles {
+		filename := filepath.Join(bp.Dir, name)
+		src, err := readFile(ctx, filename)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parser.ParseFile(im.fset, filename, src, parser.SkipObjectResolution)
+		if f == nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	conf := &types.Config{
+		Importer:         im,
+		IgnoreFuncBodies: true,
+		FakeImportC:      true,
+		Error:            func(error) {},
+	}
+	pkg, _ := conf.Check(importPath, im.fset, files, nil)
+	im.pkgs[bp.Dir] = pkg
+	return pkg, nil
+}
+
+// find locates the package importPath, imported by the package in
+// fromDir, and returns it with the build context to read it with.
+func (im *importer) find(importPath, fromDir string) (*build.Package, *build.Context, error) {
+	ctx := &im.ctx
+	if modgraph.IsStandard(importPath) {
+		bp, err := ctx.Import(importPath, "", 0)
+		return bp, ctx, err
+	}
+	if vp := modgraph.VendorPath(ctx, importPath, fromDir); vp != importPath {
+		bp, err := ctx.ImportDir(filepath.Join(ctx.GOROOT, "src", filepath.FromSlash(vp)), 0)
+		return bp, ctx, err
+	}
+	loc, err := im.l.Find(importPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	bp, err := loc.Import(ctx)
+	return bp, loc.Context(ctx), err
+}
+
+// readFile reads the named file using ctx.
+func readFile(ctx *build.Context, name string) ([]byte, error) {
+	if ctx.OpenFile == nil {
+		return os.ReadFile(name)
+	}
+	f, err := ctx.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
@@ -0,0 +1,351 @@
+// Package bundle copies sets of module versions between module caches
+// as tar files, for machines without network access.
+//
+// A bundle holds, under their names relative to GOMODCACHE/cache:
+//
+//	bundle.txt
+//	download/<escaped path>/@v/<escaped version>.info, .mod, .zip and .ziphash
+//	download/<escaped path>/@v/<xx>/<action ID>-a, <output ID>-d
+//	index/<escaped path>@<escaped version>.idx
+//
+// The -a and -d files are the metadata entries of the packages of the
+// bundled versions (see load.Cache): those written by
+// load.LegacyCachedImport for their packages, and by cache.ImportDir,
+// which it uses, for their directories and files. The .idx files are
+// index segments written by index.Dir.ExportVersion. Both are optional.
+//
+// The manifest, bundle.txt, comes first and lists one module version
+// per line:
+//
+//	path version ziphash gomodhash
+//
+// giving the h1: hashes of the version's zip and .mod files, or "-" for
+// files that are not in the bundle. Import checks the files against
+// them before changing the destination cache.
+package bundle
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/julieqiu/modcache/cache"
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/index"
+	"github.com/julieqiu/modcache/load"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+const (
+	manifestName   = "bundle.txt"
+	manifestHeader = "modcache bundle 1"
+)
+
+// A manifestEntry is a line of the manifest.
+type manifestEntry struct {
+	path, version    string
+	zipHash, modHash string // "-" if not bundled
+}
+
+// CreateOptions controls what Create includes in a bundle besides the
+// download cache files.
+type CreateOptions struct {
+	Metadata bool       // include the package metadata entries of the versions
+	Index    *index.Dir // if non-nil, include the versions' index segments
+}
+
+// Create writes a bundle of the given module versions in c to w.
+// Only the files named by each Version are bundled, so a Version whose
+// Zip and ZipHash fields are cleared contributes just its .info and .mod
+// files. The options may be nil.
+func Create(w io.Writer, c *catalog.Catalog, versions []*catalog.Version, opts *CreateOptions) error {
+	if opts == nil {
+		opts = new(CreateOptions)
+	}
+	var manifest []manifestEntry
+	for _, v := range versions {
+		e := manifestEntry{path: v.Path, version: v.Version, zipHash: "-", modHash: "-"}
+		if v.Zip != "" {
+			h, err := dirhash.HashZip(v.Zip, dirhash.Hash1)
+			if err != nil {
+				return err
+			}
+			e.zipHash = h
+		}
+		if v.GoMod != "" {
+			h, err := hashGoMod(v.GoMod)
+			if err != nil {
+				return err
+			}
+			e.modHash = h
+		}
+		manifest = append(manifest, e)
+	}
+
+	tw := tar.NewWriter(w)
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, manifestHeader)
+	for _, e := range manifest {
+		fmt.Fprintf(&buf, "%s %s %s %s\n", e.path, e.version, e.zipHash, e.modHash)
+	}
+	if err := writeBytes(tw, manifestName, buf.Bytes()); err != nil {
+		return err
+	}
+
+	for _, v := range versions {
+		for _, file := range []string{v.Info, v.GoMod, v.Zip, v.ZipHash} {
+			if file == "" {
+				continue
+			}
+			if err := writeFile(tw, c.Dir(), "download", file); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.Metadata {
+		if err := writeMetadata(tw, c, versions); err != nil {
+			return err
+		}
+	}
+	if opts.Index != nil {
+		if err := writeIndex(tw, opts.Index, versions); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeMetadata adds to tw the package metadata entries describing
+// packages of the versions with source in the bundle.
+func writeMetadata(tw *tar.Writer, c *catalog.Catalog, versions []*catalog.Version) error {
+	written := make(map[string]bool) // files already in tw
+	if err := writeFileMetadata(tw, c, versions, written); err != nil {
+		return err
+	}
+	want := make(map[string]bool) // by module@version
+	var paths []string
+	for _, v := range versions {
+		if v.Zip == "" {
+			continue
+		}
+		if _, ok := want[v.Path]; !ok {
+			paths = append(paths, v.Path)
+			want[v.Path] = false
+		}
+		want[v.String()] = true
+	}
+	for _, modPath := range paths {
+		m, err := c.Module(modPath)
+		if err != nil {
+			return err
+		}
+		lc, err := load.Open(m.Dir)
+		if err != nil {
+			return err
+		}
+		ids, err := lc.ActionIDs()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			data, entry, err := lc.GetBytes(id)
+			if err != nil {
+				continue
+			}
+			var dir string
+			if d, err := load.NewPackageDecoder(data); err == nil {
+				if err := d.Field("Build.Dir", &dir); err != nil {
+					continue
+				}
+			} else if cp, err := load.DecodePackage(data); err == nil {
+				// A JSON entry not yet rewritten by LegacyCachedImport.
+				dir = cp.Build.Dir
+			} else {
+				continue
+			}
+			p, version, _, ok := c.ParseSourcePath(dir)
+			if !ok || p != modPath || !want[p+"@"+version] {
+				continue
+			}
+			// Packages with identical metadata share an output file.
+			if out := lc.OutputFile(entry.OutputID); !written[out] {
+				written[out] = true
+				if err := writeFile(tw, c.Dir(), "download", out); err != nil {
+					return err
+				}
+			}
+			if err := writeFile(tw, c.Dir(), "download", lc.ActionFile(id)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFileMetadata adds to tw the entries recorded by cache.ImportDir
+// for the versions with source in the bundle, unless already written.
+func writeFileMetadata(tw *tar.Writer, c *catalog.Catalog, versions []*catalog.Version, written map[string]bool) error {
+	for _, v := range versions {
+		if v.Zip == "" {
+			continue
+		}
+		ids, err := cache.Entries(c, v)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		m, err := c.Module(v.Path)
+		if err != nil {
+			return err
+		}
+		lc, err := load.Open(m.Dir)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			entry, err := lc.Get(id)
+			if err != nil {
+				continue
+			}
+			for _, file := range []string{lc.OutputFile(entry.OutputID), lc.ActionFile(id)} {
+				if written[file] {
+					continue
+				}
+				written[file] = true
+				if err := writeFile(tw, c.Dir(), "download", file); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeIndex adds to tw the index segments of the versions with
+// source in the bundle that are indexed in d and up to date.
+func writeIndex(tw *tar.Writer, d *index.Dir, versions []*catalog.Version) error {
+	tmp, err := os.MkdirTemp("", "bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	for _, v := range versions {
+		if v.Zip == "" {
+			continue
+		}
+		zipHash, ok := d.ZipHash(v.Path, v.Version)
+		if want, err := v.ReadZipHash(); !ok || err != nil || zipHash != want {
+			// Not indexed, or indexed from an older copy.
+			continue
+		}
+		file := filepath.Join(tmp, "segment.idx")
+		if _, err := d.ExportVersion(v.Path, v.Version, file); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		name, err := segmentName(v.Path, v.Version)
+		if err != nil {
+			return err
+		}
+		if err := writeBytes(tw, name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segmentName returns the name in a bundle of the index segment
+// of path@version.
+func segmentName(modPath, version string) (string, error) {
+	escPath, err := module.EscapePath(modPath)
+	if err != nil {
+		return "", err
+	}
+	escVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return "", err
+	}
+	return "index/" + escPath + "@" + escVersion + ".idx", nil
+}
+
+// writeFile adds file, which is in dir, to tw under the name it has
+// relative to dir, prefixed with prefix.
+func writeFile(tw *tar.Writer, dir, prefix, file string) error {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join(prefix, filepath.ToSlash(rel)),
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	return nil
+}
+
+// writeBytes adds a file with the given name and contents to tw.
+func writeBytes(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data))}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// hashGoMod returns the h1: hash of the go.mod file, as recorded in
+// go.sum for path version/go.mod.
+func hashGoMod(file string) (string, error) {
+	return dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return os.Open(file)
+	})
+}
+
+// parseManifest parses the manifest of a bundle.
+func parseManifest(data []byte) ([]manifestEntry, error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	if !s.Scan() || s.Text() != manifestHeader {
+		return nil, fmt.Errorf("%s: unsupported bundle format", manifestName)
+	}
+	var entries []manifestEntry
+	for lineno := 2; s.Scan(); lineno++ {
+		f := strings.Fields(s.Text())
+		if len(f) != 4 {
+			return nil, fmt.Errorf("%s:%d: malformed entry", manifestName, lineno)
+		}
+		if err := module.Check(f[0], f[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", manifestName, lineno, err)
+		}
+		entries = append(entries, manifestEntry{path: f[0], version: f[1], zipHash: f[2], modHash: f[3]})
+	}
+	return entries, s.Err()
+}
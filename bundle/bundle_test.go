@@ -0,0 +1,507 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/index"
+	"github.com/julieqiu/modcache/internal/modtest"
+	"github.com/julieqiu/modcache/load"
+)
+
+// A bundleSource is a module cache to create bundles from.
+type bundleSource struct {
+	modCache string
+	c        *catalog.Catalog
+	ix       *index.Dir
+	versions []*catalog.Version // to bundle
+}
+
+// newBundleSource returns a module cache holding example.com/a@v1.0.0,
+// extracted and with the metadata of its package, and example.com/b,
+// whose v1.1.0 is only a zip file and v1.0.0 is to be bundled without
+// one. Both zip files are indexed.
+func newBundleSource(t *testing.T) *bundleSource {
+	s := &bundleSource{modCache: t.TempDir()}
+	var cacheDir, aDir string
+	for _, m := range []struct {
+		path, version, src string
+		extract            bool
+	}{
+		{"example.com/a", "v1.0.0", "package a\n\n// Needle is found by searches.\nconst Needle = \"haystack\"\n", true},
+		{"example.com/b", "v1.0.0", "package b\n", false},
+		{"example.com/b", "v1.1.0", "package b\n\nconst Straw = \"haystack\"\n", false},
+	} {
+		files := map[string]string{
+			"go.mod": "module " + m.path + "\n",
+			"x.go":   m.src,
+		}
+		var dir string
+		cacheDir, dir = modtest.WriteModule(t, s.modCache, m.path, m.version, files, m.extract)
+		if m.path == "example.com/a" {
+			aDir = dir
+		}
+	}
+	var err error
+	if s.c, err = catalog.Open(cacheDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := load.LegacyCachedImport(&build.Default, ".", aDir, "example.com/a", cacheDir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if s.ix, err = index.OpenDir(filepath.Join(s.modCache, "cache", "index")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ix.Update(s.c); err != nil {
+		t.Fatal(err)
+	}
+	for _, mv := range []string{"example.com/a@v1.0.0", "example.com/b@v1.0.0", "example.com/b@v1.1.0"} {
+		path, version, _ := strings.Cut(mv, "@")
+		m, err := s.c.Module(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := m.Lookup(version)
+		if mv == "example.com/b@v1.0.0" {
+			v = modOnly(v)
+		}
+		s.versions = append(s.versions, v)
+	}
+	return s
+}
+
+// create returns a bundle of s's versions with their metadata and index
+// segments.
+func (s *bundleSource) create(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := Create(&buf, s.c, s.versions, &CreateOptions{Metadata: true, Index: s.ix}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// importBundle imports the bundle data into the module cache modCache,
+// with its index in modCache/cache/index.
+func importBundle(t *testing.T, data []byte, modCache string) (ImportStats, error) {
+	ix, err := index.OpenDir(filepath.Join(modCache, "cache", "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Import(bytes.NewReader(data), filepath.Join(modCache, "cache", "download"), &ImportOptions{Index: ix})
+}
+
+// cacheFiles returns the contents of the files of the download cache of
+// modCache, by slash-separated name.
+func cacheFiles(t *testing.T, modCache string) map[string]string {
+	files := make(map[string]string)
+	dir := filepath.Join(modCache, "cache", "download")
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && file == dir {
+			return filepath.SkipDir
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// actionIDs returns the IDs of the package metadata entries of the
+// module path in the download cache of modCache.
+func actionIDs(t *testing.T, modCache, path string) []string {
+	lc, err := load.Open(filepath.Join(modCache, "cache", "download", path, "@v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := lc.ActionIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []string
+	for _, id := range ids {
+		list = append(list, string(id[:]))
+	}
+	sort.Strings(list)
+	return list
+}
+
+// search returns the results of searching the index of modCache for
+// expr, with the file names relative to modCache. The files are read
+// from the extracted or zipped source in the source cache src.
+func search(t *testing.T, modCache string, src *bundleSource) []string {
+	ix, err := index.OpenDir(filepath.Join(modCache, "cache", "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readFile := func(file string) ([]byte, error) {
+		rel, err := filepath.Rel(modCache, file)
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(src.modCache, rel)
+		if data, err := os.ReadFile(file); err == nil {
+			return data, nil
+		}
+		path, version, _, ok := src.c.ParseSourcePath(file)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		m, err := src.c.Module(path)
+		if err != nil {
+			return nil, err
+		}
+		z, err := src.c.OpenZip(m.Lookup(version))
+		if err != nil {
+			return nil, err
+		}
+		defer z.Close()
+		return z.ReadFile(file)
+	}
+	results, err := ix.Search("haystack", &index.SearchOptions{ReadFile: readFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []string
+	for _, r := range results {
+		for _, f := range r.Files {
+			rel, err := filepath.Rel(modCache, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			list = append(list, filepath.ToSlash(rel)+": "+r.Text)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func TestCreateImport(t *testing.T) {
+	src := newBundleSource(t)
+	data := src.create(t)
+	dst := t.TempDir()
+
+	stats, err := importBundle(t, data, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Versions != 3 || stats.Existing != 0 || stats.Entries == 0 || stats.Segments != 2 {
+		t.Errorf("first import: stats %+v, want 3 versions, 0 existing, some entries and 2 segments", stats)
+	}
+
+	// The download cache files are copied unchanged, except for the
+	// zip of b@v1.0.0, which is not bundled.
+	want := cacheFiles(t, src.modCache)
+	for name := range want {
+		if strings.Contains(name, "b/@v/v1.0.0.zip") || strings.HasSuffix(name, "-a") || strings.HasSuffix(name, "-d") || strings.HasSuffix(name, "trim.txt") {
+			delete(want, name)
+		}
+	}
+	got := cacheFiles(t, dst)
+	for name := range got {
+		if strings.HasSuffix(name, "-a") || strings.HasSuffix(name, "-d") || strings.HasSuffix(name, "trim.txt") {
+			delete(got, name)
+		}
+	}
+	// The go command lists every version with a .mod file.
+	want["example.com/b/@v/list"] = "v1.0.0\nv1.1.0\n"
+	want["example.com/a/@v/list"] = "v1.0.0\n"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imported download cache:\n%v\nwant:\n%v", got, want)
+	}
+	for _, path := range []string{"example.com/a", "example.com/b"} {
+		if g, w := actionIDs(t, dst, path), actionIDs(t, src.modCache, path); !reflect.DeepEqual(g, w) {
+			t.Errorf("%s: imported %d metadata entries, want %d", path, len(g), len(w))
+		}
+	}
+	wantSearch := search(t, src.modCache, src)
+	if len(wantSearch) != 2 {
+		t.Fatalf("source search: %q, want 2 results", wantSearch)
+	}
+	if got := search(t, dst, src); !reflect.DeepEqual(got, wantSearch) {
+		t.Errorf("search after import: %q, want %q", got, wantSearch)
+	}
+
+	// The imported metadata is used in the new location.
+	c, err := catalog.Open(filepath.Join(dst, "cache", "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := c.Module("example.com/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := c.OpenZip(m.Lookup("v1.0.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	ctx := z.Context(&build.Default)
+	ctx.OpenFile = func(name string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("read %s despite imported metadata", name)
+	}
+	p, err := load.LegacyCachedImport(ctx, ".", z.Root(), "example.com/a", c.Dir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Dir != z.Root() || !reflect.DeepEqual(p.GoFiles, []string{"x.go"}) {
+		t.Errorf("imported package: Dir %s, GoFiles %v, want %s, [x.go]", p.Dir, p.GoFiles, z.Root())
+	}
+
+	// Importing again changes nothing.
+	before := cacheFiles(t, dst)
+	stats, err = importBundle(t, data, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (ImportStats{Existing: 10}) {
+		t.Errorf("second import: stats %+v, want only 10 existing files", stats)
+	}
+	if after := cacheFiles(t, dst); !reflect.DeepEqual(after, before) {
+		t.Errorf("second import changed the download cache")
+	}
+	if got := search(t, dst, src); !reflect.DeepEqual(got, wantSearch) {
+		t.Errorf("search after second import: %q, want %q", got, wantSearch)
+	}
+}
+
+// TestImportNewerEntries checks that Import replaces a package metadata
+// entry only with one written more recently.
+func TestImportNewerEntries(t *testing.T) {
+	src := newBundleSource(t)
+	dst := t.TempDir()
+	if _, err := importBundle(t, src.create(t), dst); err != nil {
+		t.Fatal(err)
+	}
+	srcCache, err := load.Open(filepath.Join(src.modCache, "cache", "download", "example.com", "a", "@v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstCache, err := load.Open(filepath.Join(dst, "cache", "download", "example.com", "a", "@v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := srcCache.ActionIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The entry for the package, rather than those for its files.
+	var id load.ActionID
+	var cp *load.LegacyCachedPackage
+	for _, x := range ids {
+		data, _, err := srcCache.GetBytes(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, err := load.DecodePackage(data); err == nil && p.Build.Name == "a" {
+			id, cp = x, p
+		}
+	}
+	if cp == nil {
+		t.Fatal("no package entry in the source cache")
+	}
+	withDoc := func(doc string) []byte {
+		p := *cp
+		p.Build.Doc = doc
+		return load.EncodePackage(&p)
+	}
+	entry := func() string {
+		data, _, err := dstCache.GetBytes(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := load.DecodePackage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Build.Doc
+	}
+
+	// An entry newer in the destination is kept.
+	if err := dstCache.PutBytes(id, withDoc("newer")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := importBundle(t, src.create(t), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entry(); got != "newer" || stats.Entries != 0 {
+		t.Errorf("import of older entry: entry %q, %d entries imported, want %q, 0", got, stats.Entries, "newer")
+	}
+
+	// An entry newer in the bundle replaces it.
+	if err := srcCache.PutBytes(id, withDoc("newest")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = importBundle(t, src.create(t), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entry(); got != "newest" || stats.Entries != 1 {
+		t.Errorf("import of newer entry: entry %q, %d entries imported, want %q, 1", got, stats.Entries, "newest")
+	}
+}
+
+// rewrite returns a copy of the bundle data with the contents of the
+// files whose names end in suffix changed by edit.
+func rewrite(t *testing.T, data []byte, suffix string, edit func([]byte) []byte) []byte {
+	tr := tar.NewReader(bytes.NewReader(data))
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(hdr.Name, suffix) {
+			body = edit(body)
+		}
+		hdr.Size = int64(len(body))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(body)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestImportMismatch checks that Import rejects a bundle whose files do
+// not match its manifest or the destination cache, leaving the
+// destination unchanged.
+func TestImportMismatch(t *testing.T) {
+	src := newBundleSource(t)
+	data := src.create(t)
+	for _, tt := range []struct {
+		name  string
+		data  []byte
+		setup func(t *testing.T, dst string) // prepares the destination
+	}{
+		{
+			name: "zip",
+			data: rewrite(t, data, "a/@v/v1.0.0.zip", func(zip []byte) []byte {
+				zip = append([]byte(nil), zip...)
+				i := bytes.Index(zip, []byte("haystack"))
+				copy(zip[i:], "needles!")
+				return zip
+			}),
+		},
+		{
+			name: "ziphash",
+			data: rewrite(t, data, "a/@v/v1.0.0.ziphash", func([]byte) []byte { return []byte("h1:bad\n") }),
+		},
+		{
+			name: "mod",
+			data: rewrite(t, data, "b/@v/v1.0.0.mod", func(mod []byte) []byte { return append(mod, "go 1.18\n"...) }),
+		},
+		{
+			name: "output",
+			data: rewrite(t, data, "-d", func(out []byte) []byte { return append(out, 0) }),
+		},
+		{
+			name: "destination ziphash",
+			data: data,
+			setup: func(t *testing.T, dst string) {
+				modtest.WriteFile(t, filepath.Join(dst, "cache", "download", "example.com", "a", "@v", "v1.0.0.ziphash"), "h1:other\n")
+			},
+		},
+		{
+			name: "destination mod",
+			data: data,
+			setup: func(t *testing.T, dst string) {
+				modtest.WriteFile(t, filepath.Join(dst, "cache", "download", "example.com", "b", "@v", "v1.0.0.mod"), "module example.com/other\n")
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			if tt.setup != nil {
+				tt.setup(t, dst)
+			}
+			before := cacheFiles(t, dst)
+			if _, err := importBundle(t, tt.data, dst); err == nil {
+				t.Errorf("Import succeeded, want error")
+			}
+			if after := cacheFiles(t, dst); !reflect.DeepEqual(after, before) {
+				t.Errorf("failed Import changed the download cache:\n%v\nwant:\n%v", after, before)
+			}
+		})
+	}
+}
+
+// TestFromGoModReplaceAll checks that when a replacement covers every
+// version of a path, the selected version's replacement is bundled with
+// its zip file, even after that of a version that is not selected.
+func TestFromGoModReplaceAll(t *testing.T) {
+	modCache := t.TempDir()
+	var cacheDir string
+	for _, m := range []struct{ path, version, gomod string }{
+		{"example.com/fork", "v1.0.0", "module example.com/fork\n"},
+		{"example.com/y", "v1.0.0", "module example.com/y\n\nrequire example.com/x v1.0.0\n"},
+	} {
+		cacheDir, _ = modtest.WriteModule(t, modCache, m.path, m.version, map[string]string{"go.mod": m.gomod}, false)
+	}
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gomod := filepath.Join(t.TempDir(), "go.mod")
+	modtest.WriteFile(t, gomod, `module example.com/main
+
+go 1.16
+
+require (
+	example.com/x v1.1.0
+	example.com/y v1.0.0
+)
+
+replace example.com/x => example.com/fork v1.0.0
+`)
+	versions, err := FromGoMod(c, gomod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, v := range versions {
+		s := v.String()
+		if v.Zip == "" {
+			s += " (.mod only)"
+		}
+		got = append(got, s)
+	}
+	want := []string{"example.com/fork@v1.0.0", "example.com/y@v1.0.0"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("FromGoMod = %s, want %s", strings.Join(got, ", "), strings.Join(want, ", "))
+	}
+}
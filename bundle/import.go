@@ -0,0 +1,406 @@
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/index"
+	"github.com/julieqiu/modcache/load"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// ImportOptions controls Import.
+type ImportOptions struct {
+	Index *index.Dir // if non-nil, add the bundle's index segments to it
+}
+
+// ImportStats reports the changes made by Import.
+type ImportStats struct {
+	Versions int // module versions with files added to the download cache
+	Existing int // download cache files already present, and kept
+	Entries  int // package metadata entries added or replaced
+	Segments int // index segments added
+}
+
+// Import merges the bundle read from r into the download cache in
+// downloadDir, creating it if needed.
+//
+// The bundle is unpacked into a temporary directory next to downloadDir
+// and checked against the hashes in its manifest, and against the
+// .ziphash and .mod files already in downloadDir, before anything is
+// moved into place; if any check fails, Import returns an error and
+// leaves the cache unchanged.
+//
+// Files already in the download cache are kept: module versions are
+// immutable, so they can only be identical to the bundled ones.
+// A package metadata entry replaces an existing entry only if it was
+// written more recently, and an index segment is added only if the
+// index does not already hold the same copy of the version.
+// The options may be nil.
+func Import(r io.Reader, downloadDir string, opts *ImportOptions) (ImportStats, error) {
+	var stats ImportStats
+	if opts == nil {
+		opts = new(ImportOptions)
+	}
+	if err := os.MkdirAll(downloadDir, 0777); err != nil {
+		return stats, err
+	}
+	c, err := catalog.Open(downloadDir)
+	if err != nil {
+		return stats, err
+	}
+	// Unpack next to the cache, so that files can be renamed into it.
+	tmp, err := os.MkdirTemp(filepath.Dir(downloadDir), "bundle")
+	if err != nil {
+		return stats, err
+	}
+	defer os.RemoveAll(tmp)
+
+	u, err := unpack(r, tmp)
+	if err != nil {
+		return stats, err
+	}
+	if err := u.check(c); err != nil {
+		return stats, err
+	}
+
+	// Download cache files, in the order the go command writes them:
+	// a .ziphash file marks a complete download.
+	for _, e := range u.manifest {
+		added := false
+		for _, ext := range []string{".mod", ".info", ".zip", ".ziphash"} {
+			name := u.versionFile(e, ext)
+			src := filepath.Join(tmp, filepath.FromSlash(name))
+			if _, err := os.Stat(src); err != nil {
+				continue
+			}
+			dst := filepath.Join(filepath.Dir(downloadDir), filepath.FromSlash(name))
+			if _, err := os.Stat(dst); err == nil {
+				stats.Existing++
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+				return stats, err
+			}
+			if err := os.Rename(src, dst); err != nil {
+				return stats, err
+			}
+			added = true
+		}
+		if added {
+			stats.Versions++
+			modDir := filepath.Join(filepath.Dir(downloadDir), filepath.FromSlash(path.Dir(u.versionFile(e, ".mod"))))
+			if err := writeVersionList(modDir); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	// Package metadata entries.
+	for _, name := range u.actions {
+		srcDir := filepath.Join(tmp, filepath.FromSlash(path.Dir(path.Dir(name))))
+		dstDir := filepath.Join(filepath.Dir(downloadDir), filepath.FromSlash(path.Dir(path.Dir(name))))
+		id, _ := actionID(path.Base(name))
+		src, err := load.Open(srcDir)
+		if err != nil {
+			return stats, err
+		}
+		entry, err := src.Get(id)
+		if err != nil {
+			return stats, err
+		}
+		dst, err := load.Open(dstDir)
+		if err != nil {
+			return stats, err
+		}
+		if old, err := dst.Get(id); err == nil && !old.Time.Before(entry.Time) {
+			continue
+		}
+		out := dst.OutputFile(entry.OutputID)
+		if _, err := os.Stat(out); err != nil {
+			if err := os.MkdirAll(filepath.Dir(out), 0777); err != nil {
+				return stats, err
+			}
+			if err := os.Rename(src.OutputFile(entry.OutputID), out); err != nil {
+				return stats, err
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(dst.ActionFile(id)), 0777); err != nil {
+			return stats, err
+		}
+		if err := os.Rename(src.ActionFile(id), dst.ActionFile(id)); err != nil {
+			return stats, err
+		}
+		stats.Entries++
+	}
+
+	// Index segments.
+	if opts.Index != nil {
+		for _, s := range u.segments {
+			if zipHash, ok := opts.Index.ZipHash(s.path, s.version); ok && zipHash == s.zipHash {
+				continue
+			}
+			srcDir, err := c.SourceDir(s.path, s.version)
+			if err != nil {
+				return stats, err
+			}
+			file := filepath.Join(tmp, filepath.FromSlash(s.name))
+			if err := opts.Index.ImportVersion(s.path, s.version, s.zipHash, srcDir, file); err != nil {
+				return stats, err
+			}
+			stats.Segments++
+		}
+	}
+	return stats, nil
+}
+
+// An unpacked is a bundle unpacked into a directory.
+type unpacked struct {
+	dir      string
+	manifest []manifestEntry
+	files    map[string]bool // download cache files, by name in the bundle
+	actions  []string        // names of -a files
+	outputs  []string        // names of -d files
+	segments []*segment
+}
+
+// A segment is an index segment in a bundle.
+type segment struct {
+	name string
+	manifestEntry
+}
+
+// unpack writes the files of the bundle read from r into dir.
+func unpack(r io.Reader, dir string) (*unpacked, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("reading bundle: %v", err)
+	}
+	if hdr.Name != manifestName {
+		return nil, fmt.Errorf("not a bundle: first file is %s, not %s", hdr.Name, manifestName)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, err
+	}
+	u := &unpacked{dir: dir, files: make(map[string]bool)}
+	if u.manifest, err = parseManifest(data); err != nil {
+		return nil, err
+	}
+	expect := make(map[string]bool)   // download cache files listed in the manifest
+	escPaths := make(map[string]bool) // escaped module paths in the manifest
+	bySegment := make(map[string]manifestEntry)
+	for _, e := range u.manifest {
+		escPath, _ := module.EscapePath(e.path)
+		escPaths[escPath] = true
+		expect[u.versionFile(e, ".info")] = true
+		if e.modHash != "-" {
+			expect[u.versionFile(e, ".mod")] = true
+		}
+		if e.zipHash != "-" {
+			expect[u.versionFile(e, ".zip")] = true
+			expect[u.versionFile(e, ".ziphash")] = true
+			name, err := segmentName(e.path, e.version)
+			if err != nil {
+				return nil, err
+			}
+			bySegment[name] = e
+		}
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading bundle: %v", err)
+		}
+		name := hdr.Name
+		if hdr.Typeflag != tar.TypeReg || path.Clean(name) != name || path.IsAbs(name) || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("bundle contains unexpected file %s", name)
+		}
+		switch {
+		case expect[name]:
+			u.files[name] = true
+		case strings.HasSuffix(name, ".idx") && bySegment[name].path != "":
+			u.segments = append(u.segments, &segment{name, bySegment[name]})
+		case isEntryName(name, escPaths):
+			if strings.HasSuffix(name, "-a") {
+				u.actions = append(u.actions, name)
+			} else {
+				u.outputs = append(u.outputs, name)
+			}
+		default:
+			return nil, fmt.Errorf("bundle contains unexpected file %s", name)
+		}
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			return nil, err
+		}
+		f, err := os.Create(file)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading bundle: %s: %v", name, err)
+		}
+		if !hdr.ModTime.IsZero() {
+			os.Chtimes(file, hdr.ModTime, hdr.ModTime)
+		}
+	}
+	return u, nil
+}
+
+// isEntryName reports whether name is the name in a bundle of a
+// package metadata file, download/<escaped path>/@v/<xx>/<hex>-a or -d,
+// of one of the modules with the given escaped paths.
+func isEntryName(name string, escPaths map[string]bool) bool {
+	rest := strings.TrimPrefix(name, "download/")
+	i := strings.LastIndex(rest, "/@v/")
+	if rest == name || i < 0 || !escPaths[rest[:i]] {
+		return false
+	}
+	sub, base := path.Split(rest[i+len("/@v/"):])
+	id := strings.TrimSuffix(strings.TrimSuffix(base, "-a"), "-d")
+	if id == base || len(id) != 2*load.HashSize || sub != id[:2]+"/" {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// actionID parses the name of a -a file.
+func actionID(base string) (load.ActionID, bool) {
+	var id load.ActionID
+	b, err := hex.DecodeString(strings.TrimSuffix(base, "-a"))
+	if err != nil || len(b) != len(id) || !strings.HasSuffix(base, "-a") {
+		return id, false
+	}
+	copy(id[:], b)
+	return id, true
+}
+
+// versionFile returns the name in the bundle of the download cache
+// file with extension ext for the version e.
+func (u *unpacked) versionFile(e manifestEntry, ext string) string {
+	escPath, _ := module.EscapePath(e.path)
+	escVersion, _ := module.EscapeVersion(e.version)
+	return "download/" + escPath + "/@v/" + escVersion + ext
+}
+
+// check verifies the unpacked files against the manifest and against
+// the download cache of c.
+func (u *unpacked) check(c *catalog.Catalog) error {
+	dst := filepath.Dir(c.Dir())
+	for _, e := range u.manifest {
+		v := e.path + "@" + e.version
+		if e.zipHash != "-" {
+			name := u.versionFile(e, ".zip")
+			if !u.files[name] {
+				return fmt.Errorf("%s: zip file missing from bundle", v)
+			}
+			h, err := dirhash.HashZip(filepath.Join(u.dir, filepath.FromSlash(name)), dirhash.Hash1)
+			if err != nil {
+				return fmt.Errorf("%s: %v", v, err)
+			}
+			if h != e.zipHash {
+				return fmt.Errorf("%s: zip has hash %s, but the bundle manifest records %s", v, h, e.zipHash)
+			}
+			for _, dir := range []string{u.dir, dst} {
+				file := filepath.Join(dir, filepath.FromSlash(u.versionFile(e, ".ziphash")))
+				if data, err := os.ReadFile(file); err == nil && strings.TrimSpace(string(data)) != e.zipHash {
+					return fmt.Errorf("%s: %s records %s, but the bundle manifest records %s", v, file, strings.TrimSpace(string(data)), e.zipHash)
+				}
+			}
+		}
+		if e.modHash != "-" {
+			name := u.versionFile(e, ".mod")
+			if !u.files[name] {
+				return fmt.Errorf("%s: .mod file missing from bundle", v)
+			}
+			for _, dir := range []string{u.dir, dst} {
+				file := filepath.Join(dir, filepath.FromSlash(name))
+				if _, err := os.Stat(file); err != nil {
+					continue
+				}
+				h, err := hashGoMod(file)
+				if err != nil {
+					return err
+				}
+				if h != e.modHash {
+					return fmt.Errorf("%s: %s has hash %s, but the bundle manifest records %s", v, file, h, e.modHash)
+				}
+			}
+		}
+	}
+	for _, name := range u.outputs {
+		data, err := os.ReadFile(filepath.Join(u.dir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:])+"-d" != path.Base(name) {
+			return fmt.Errorf("bundle file %s has hash %x", name, sum)
+		}
+	}
+	for _, name := range u.actions {
+		lc, err := load.Open(filepath.Join(u.dir, filepath.FromSlash(path.Dir(path.Dir(name)))))
+		if err != nil {
+			return err
+		}
+		id, _ := actionID(path.Base(name))
+		entry, err := lc.Get(id)
+		if err != nil {
+			return fmt.Errorf("bundle file %s: %v", name, err)
+		}
+		if _, err := os.Stat(lc.OutputFile(entry.OutputID)); err != nil {
+			return fmt.Errorf("bundle file %s: output %x missing from bundle", name, entry.OutputID)
+		}
+	}
+	for _, s := range u.segments {
+		ix, err := index.Open(filepath.Join(u.dir, filepath.FromSlash(s.name)))
+		if err != nil {
+			return err
+		}
+		ix.Close()
+	}
+	return nil
+}
+
+// writeVersionList rewrites the list file in the @v directory dir to
+// list the versions with a .mod file, as the go command does.
+func writeVersionList(dir string) error {
+	mods, err := filepath.Glob(filepath.Join(dir, "*.mod"))
+	if err != nil {
+		return err
+	}
+	var list []string
+	for _, mod := range mods {
+		v, err := module.UnescapeVersion(strings.TrimSuffix(filepath.Base(mod), ".mod"))
+		if err == nil && semver.IsValid(v) {
+			list = append(list, v)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return semver.Compare(list[i], list[j]) < 0 })
+	name := filepath.Join(dir, "list")
+	if err := os.WriteFile(name+".tmp", []byte(strings.Join(list, "\n")+"\n"), 0666); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
@@ -0,0 +1,141 @@
+package bundle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/modgraph"
+	"golang.org/x/mod/module"
+)
+
+// FromGoSum returns the cached module versions listed in the go.sum
+// file, for bundling. A version listed only by the hash of its go.mod
+// file is returned without its zip file, as the go command needs only
+// its .mod file. It is an error for a listed version not to be cached.
+func FromGoSum(c *catalog.Catalog, file string) ([]*catalog.Version, error) {
+	sum, err := catalog.ReadGoSum(file)
+	if err != nil {
+		return nil, err
+	}
+	full := make(map[module.Version]bool)
+	for key := range sum {
+		f := strings.Fields(key)
+		mv := module.Version{Path: f[0], Version: strings.TrimSuffix(f[1], "/go.mod")}
+		full[mv] = full[mv] || mv.Version == f[1]
+	}
+	var list []module.Version
+	for mv := range full {
+		list = append(list, mv)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+		return list[i].Version < list[j].Version
+	})
+
+	var versions []*catalog.Version
+	var missing []string
+	for _, mv := range list {
+		v := lookup(c, mv)
+		if v == nil || v.GoMod == "" || full[mv] && v.Zip == "" {
+			missing = append(missing, mv.String())
+			continue
+		}
+		if !full[mv] {
+			v = modOnly(v)
+		}
+		versions = append(versions, v)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing from cache: %s", strings.Join(missing, ", "))
+	}
+	return versions, nil
+}
+
+// FromGoMod returns, for bundling, the cached module versions in the
+// requirement graph of the main module whose go.mod file is file, or of
+// the workspace described by a go.work file, computed as described in
+// package modgraph. The go command needs the .mod files of all of
+// them, but the source only of the selected versions, so only those
+// are returned with their zip files, if cached. It is an error for
+// a version required by a main module itself not to be cached with its
+// zip file.
+//
+// Replacements by local directories are left out.
+func FromGoMod(c *catalog.Catalog, file string) ([]*catalog.Version, error) {
+	g, err := modgraph.Load(c, file)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, m := range g.Main() {
+		for _, r := range g.Required(m) {
+			if g.Selected(r.Path) != r.Version {
+				continue
+			}
+			r = g.Replacement(r)
+			if v := lookup(c, r); r.Version != "" && (v == nil || v.Zip == "") {
+				missing = append(missing, r.String())
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing from cache: %s", strings.Join(missing, ", "))
+	}
+
+	var versions []*catalog.Version
+	index := make(map[module.Version]int) // of each replacement in versions
+	add := func(m module.Version) {
+		r := g.Replacement(m)
+		if r.Version == "" {
+			return
+		}
+		selected := g.Selected(m.Path) == m.Version
+		if i, ok := index[r]; ok {
+			// A replacement of every version of a path may have been
+			// added for its .mod file, as the replacement of a version
+			// that is not selected, before the selected one.
+			if selected && versions[i].Zip == "" {
+				if v := lookup(c, r); v != nil {
+					versions[i] = v
+				}
+			}
+			return
+		}
+		v := lookup(c, r)
+		if v == nil {
+			return
+		}
+		if !selected {
+			v = modOnly(v)
+		}
+		index[r] = len(versions)
+		versions = append(versions, v)
+	}
+	for _, m := range g.Modules() {
+		add(m)
+	}
+	for _, m := range g.BuildList()[len(g.Main()):] {
+		add(m)
+	}
+	return versions, nil
+}
+
+// lookup returns the cached version mv, or nil.
+func lookup(c *catalog.Catalog, mv module.Version) *catalog.Version {
+	m, err := c.Module(mv.Path)
+	if err != nil {
+		return nil
+	}
+	return m.Lookup(mv.Version)
+}
+
+// modOnly returns a copy of v without its zip file.
+func modOnly(v *catalog.Version) *catalog.Version {
+	mv := *v
+	mv.Zip, mv.ZipHash = "", ""
+	return &mv
+}
@@ -1,10 +1,23 @@
-package main
+// Package cache describes the per-file metadata recorded for packages
+// in the module cache.
+package cache
 
-import "fmt"
+import "go/token"
 
+// A Cache is a package assembled from the metadata of its files, as
+// returned by ImportDir.
 type Cache struct {
+	Dir        string   // directory containing package sources
+	Name       string   // package name
+	Doc        string   // documentation synopsis
+	AllTags    []string // tags that can influence file selection in this directory
+	BinaryOnly bool     // cannot be rebuilt from source (has //go:binary-only-package comment)
+
 	// Source files
 	GoFiles           []*File  // .go source files (excluding CgoFiles, TestGoFiles, XTestGoFiles)
+	CgoFiles          []*File  // .go source files that import "C"
+	TestGoFiles       []*File  // _test.go files in package
+	XTestGoFiles      []*File  // _test.go files outside package
 	IgnoredGoFiles    []string // .go source files ignored for this build (including ignored _test.go files)
 	InvalidGoFiles    []string // .go source files with detected problems (parse error, wrong package name, and so on)
 	IgnoredOtherFiles []string // non-.go source files ignored for this build
@@ -17,15 +30,29 @@ type Cache struct {
 	SwigFiles         []string // .swig files
 	SwigCXXFiles      []string // .swigcxx files
 	SysoFiles         []string // .syso system object files to add to archive
+
+	// Cgo directives
+	CgoCFLAGS    []string // Cgo CFLAGS directives
+	CgoCPPFLAGS  []string // Cgo CPPFLAGS directives
+	CgoCXXFLAGS  []string // Cgo CXXFLAGS directives
+	CgoFFLAGS    []string // Cgo FFLAGS directives
+	CgoLDFLAGS   []string // Cgo LDFLAGS directives
+	CgoPkgConfig []string // Cgo pkg-config directives
 }
 
+// A File is the metadata of a Go source file, as returned by ParseFile.
 type File struct {
-	Name      string                      // package name
-	BuildTags []string                    // tags that can influence file selection in this directory
-	Imports   []string                    // import paths from GoFiles, CgoFiles
-	ImportPos map[string][]token.Position // line information for Imports
+	Filename   string                      // file name, as passed to ParseFile
+	Name       string                      // package name
+	Doc        string                      // synopsis of the package doc comment, if any
+	BuildTags  []string                    // tags that can influence file selection in this directory
+	Constraint string                      // build constraint, in //go:build syntax without the prefix, or ""
+	BinaryOnly bool                        // whether the file has a //go:binary-only-package comment
+	Imports    []string                    // import paths from GoFiles, CgoFiles
+	ImportPos  map[string][]token.Position // line information for Imports
+	Cgo        []string                    // #cgo lines of the preambles of import "C"
 
-	// //go:embed patterns found in Go source files
+	// //go:embed patterns found in Go source files importing "embed"
 	// For example, if a source file says
 	//	//go:embed a* b.c
 	// then the list will contain those two strings as separate entries.
@@ -33,5 +60,16 @@ type File struct {
 	EmbedPatterns   []string                    // patterns from GoFiles, CgoFiles
 	EmbedPatternPos map[string][]token.Position // line information for EmbedPatterns
 
-	Exports []string
+	Exports   []string                  // exported top-level identifiers
+	ExportPos map[string]token.Position // line information for Exports and exported methods (T.M)
+
+	// ExportDecl holds the declaration of each of Exports, and of each
+	// exported method, keyed by T.M, in a canonical one-line form that
+	// changes only if the declaration's API does: bodies, comments,
+	// parameter names and unexported struct fields are left out.
+	ExportDecl map[string]string
+
+	// Incomplete is set if the file does not parse beyond its imports,
+	// so that Exports, ExportPos and ExportDecl are empty.
+	Incomplete bool
 }
@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"go/build/constraint"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// saveCgo records the #cgo directives lines, from the preamble of the
+// cgo file filename, that apply to ctx in p, as go/build does.
+func saveCgo(ctx *build.Context, filename string, p *Cache, lines []string) error {
+	for _, orig := range lines {
+		line := strings.TrimSpace(orig)
+
+		// #cgo (nocallback|noescape) <function name>
+		if fields := strings.Fields(line); len(fields) == 3 && (fields[1] == "nocallback" || fields[1] == "noescape") {
+			continue
+		}
+
+		// Split at colon.
+		line, argstr, ok := strings.Cut(strings.TrimSpace(line[4:]), ":")
+		if !ok {
+			return fmt.Errorf("%s: invalid #cgo line: %s", filename, orig)
+		}
+
+		// Parse GOOS/GOARCH stuff.
+		f := strings.Fields(line)
+		if len(f) < 1 {
+			return fmt.Errorf("%s: invalid #cgo line: %s", filename, orig)
+		}
+		cond, verb := f[:len(f)-1], f[len(f)-1]
+		if len(cond) > 0 {
+			ok := false
+			for _, c := range cond {
+				if matchAuto(ctx, c) {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		args, err := splitQuoted(argstr)
+		if err != nil {
+			return fmt.Errorf("%s: invalid #cgo line: %s", filename, orig)
+		}
+		for i, arg := range args {
+			if arg, ok = expandSrcDir(arg, p.Dir); !ok {
+				return fmt.Errorf("%s: malformed #cgo argument: %s", filename, arg)
+			}
+			args[i] = arg
+		}
+
+		switch verb {
+		case "CFLAGS", "CPPFLAGS", "CXXFLAGS", "FFLAGS", "LDFLAGS":
+			// Change relative paths to absolute.
+			makePathsAbsolute(args, p.Dir)
+		}
+
+		switch verb {
+		case "CFLAGS":
+			p.CgoCFLAGS = append(p.CgoCFLAGS, args...)
+		case "CPPFLAGS":
+			p.CgoCPPFLAGS = append(p.CgoCPPFLAGS, args...)
+		case "CXXFLAGS":
+			p.CgoCXXFLAGS = append(p.CgoCXXFLAGS, args...)
+		case "FFLAGS":
+			p.CgoFFLAGS = append(p.CgoFFLAGS, args...)
+		case "LDFLAGS":
+			p.CgoLDFLAGS = append(p.CgoLDFLAGS, args...)
+		case "pkg-config":
+			p.CgoPkgConfig = append(p.CgoPkgConfig, args...)
+		default:
+			return fmt.Errorf("%s: invalid #cgo verb: %s", filename, orig)
+		}
+	}
+	return nil
+}
+
+// matchAuto reports whether the condition of a #cgo line, in either
+// //go:build or // +build syntax, is satisfied by ctx.
+func matchAuto(ctx *build.Context, text string) bool {
+	if strings.ContainsAny(text, "&|()") {
+		text = "//go:build " + text
+	} else {
+		text = "// +build " + text
+	}
+	x, err := constraint.Parse(text)
+	if err != nil {
+		return false
+	}
+	return x.Eval(func(tag string) bool { return matchTag(ctx, tag) })
+}
+
+// expandSrcDir expands any occurrence of ${SRCDIR} in str, making sure
+// the result is safe for the shell.
+func expandSrcDir(str string, srcdir string) (string, bool) {
+	// "\" delimited paths cause safeCgoName to fail
+	// so convert native paths with a different delimiter
+	// to "/" before starting (eg: on windows).
+	srcdir = filepath.ToSlash(srcdir)
+
+	chunks := strings.Split(str, "${SRCDIR}")
+	if len(chunks) < 2 {
+		return str, safeCgoName(str)
+	}
+	ok := true
+	for _, chunk := range chunks {
+		ok = ok && (chunk == "" || safeCgoName(chunk))
+	}
+	ok = ok && (srcdir == "" || safeCgoName(srcdir))
+	res := strings.Join(chunks, srcdir)
+	return res, ok && res != ""
+}
+
+// makePathsAbsolute makes the paths given to the -I and -L flags in
+// args relative to srcDir.
+func makePathsAbsolute(args []string, srcDir string) {
+	nextPath := false
+	for i, arg := range args {
+		if nextPath {
+			if !filepath.IsAbs(arg) {
+				args[i] = filepath.Join(srcDir, arg)
+			}
+			nextPath = false
+		} else if strings.HasPrefix(arg, "-I") || strings.HasPrefix(arg, "-L") {
+			if len(arg) == 2 {
+				nextPath = true
+			} else if !filepath.IsAbs(arg[2:]) {
+				args[i] = arg[:2] + filepath.Join(srcDir, arg[2:])
+			}
+		}
+	}
+}
+
+// safeString is the set of ASCII characters allowed in #cgo arguments.
+// NOTE: $ is not safe for the shell, but it is allowed here because of
+// linker options like -Wl,$ORIGIN.
+const safeString = "+-.,/0123456789=ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz:$@%! ~^"
+
+// safeCgoName reports whether s is a safe #cgo argument.
+func safeCgoName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < utf8.RuneSelf && strings.IndexByte(safeString, c) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// splitQuoted splits s into arguments separated by spaces, which may
+// be quoted with single or double quotes or escaped with backslashes.
+func splitQuoted(s string) (r []string, err error) {
+	var args []string
+	arg := make([]rune, len(s))
+	escaped := false
+	quoted := false
+	quote := '\x00'
+	i := 0
+	for _, rune := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case rune == '\\':
+			escaped = true
+			continue
+		case quote != '\x00':
+			if rune == quote {
+				quote = '\x00'
+				continue
+			}
+		case rune == '"' || rune == '\'':
+			quoted = true
+			quote = rune
+			continue
+		case unicode.IsSpace(rune):
+			if quoted || i > 0 {
+				quoted = false
+				args = append(args, string(arg[:i]))
+				i = 0
+			}
+			continue
+		}
+		arg[i] = rune
+		i++
+	}
+	if quoted || i > 0 {
+		args = append(args, string(arg[:i]))
+	}
+	if quote != 0 {
+		err = errors.New("unclosed quote")
+	} else if escaped {
+		err = errors.New("unfinished escaping")
+	}
+	return args, err
+}
@@ -0,0 +1,817 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"go/build"
+	"go/build/constraint"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/load"
+	"golang.org/x/mod/module"
+)
+
+// The metadata of files is cached independently of the build context,
+// so that the package in a directory can be assembled for any GOOS,
+// GOARCH and set of tags from the cache alone, without opening any of
+// its files. Two kinds of entries are stored, in the same cache as the
+// packages of the module version (see load.ModCache):
+//
+//   - the listing of a package directory of a module version: the
+//     names of its source files, with the hash of each .go file (see
+//     load.FileHash) and the build constraint of each other file;
+//   - the File parsed from a .go file, keyed by the hash of its
+//     contents, so that files shared by several versions are parsed once.
+//
+// Both are encoded with load.EncodeStruct. Entries are keyed by
+// fileCacheVersion, which must be incremented when ParseFile changes.
+const fileCacheVersion = 3
+
+// A dirEntry is the cached listing of a package directory.
+type dirEntry struct {
+	Files []dirFile // source files with a known extension, sorted by name
+}
+
+// A dirFile is a file in a dirEntry.
+type dirFile struct {
+	Name       string
+	Hash       string // SHA-256 of the contents of a .go file, hex-encoded
+	Constraint string // build constraint of a non-Go file, as in File
+}
+
+// ImportDir returns the package in dir, a directory of the module
+// modulePath in the module cache whose download cache is cacheDir, as
+// selected by ctx. Like ctx.ImportDir, it classifies the files of the
+// directory by evaluating their build constraints, but using the
+// metadata recorded in the cache: only files not seen before are read
+// and parsed, whatever the build context. ctx may read the directory
+// from a module zip (see catalog.ZipDir.Context).
+//
+// As with ctx.ImportDir, the files are classified even if some are
+// invalid, and the error returned for the first of them, or a
+// *build.NoGoError if there are no Go files, comes with the package.
+// Files whose names begin with "_" or "." are ignored. Directories
+// outside the module cache are read and parsed afresh on every call.
+func ImportDir(ctx *build.Context, dir, modulePath, cacheDir string) (*Cache, error) {
+	c, key := fileCache(dir, modulePath, cacheDir)
+	d, err := readDirEntry(c, key, ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	p := &Cache{Dir: dir}
+	var badGoError error
+	badGoFiles := make(map[string]bool)
+	badGoFile := func(name string, err error) {
+		if badGoError == nil {
+			badGoError = err
+		}
+		if !badGoFiles[name] {
+			p.InvalidGoFiles = append(p.InvalidGoFiles, name)
+			badGoFiles[name] = true
+		}
+	}
+	allTags := make(map[string]bool)
+	var cgoSFiles []string
+	var firstFile string
+	for _, df := range d.Files {
+		name := df.Name
+		ext := filepath.Ext(name)
+		for _, tag := range nameTags(name) {
+			allTags[tag] = true
+		}
+		if ext != ".go" {
+			good := goodOSArchFile(ctx, name)
+			if good {
+				constraintTags(df.Constraint, allTags)
+			}
+			if !good || !matchConstraint(ctx, df.Constraint) {
+				p.IgnoredOtherFiles = append(p.IgnoredOtherFiles, name)
+				continue
+			}
+			if ext == ".S" || ext == ".sx" {
+				// Assembled by the C compiler, so only used with cgo.
+				cgoSFiles = append(cgoSFiles, name)
+				continue
+			}
+			list := otherFiles(p, ext)
+			*list = append(*list, name)
+			continue
+		}
+
+		if !goodOSArchFile(ctx, name) {
+			p.IgnoredGoFiles = append(p.IgnoredGoFiles, name)
+			continue
+		}
+		filename := filepath.Join(dir, name)
+		f, err := readFile(c, ctx, filename, df.Hash)
+		if err != nil {
+			badGoFile(name, err)
+			continue
+		}
+		constraintTags(f.Constraint, allTags)
+		if !matchConstraint(ctx, f.Constraint) {
+			p.IgnoredGoFiles = append(p.IgnoredGoFiles, name)
+			continue
+		}
+		isTest := strings.HasSuffix(name, "_test.go")
+		if f.BinaryOnly && !isTest {
+			p.BinaryOnly = true
+		}
+		pkgName := f.Name
+		if pkgName == "documentation" {
+			p.IgnoredGoFiles = append(p.IgnoredGoFiles, name)
+			continue
+		}
+		isXTest := isTest && strings.HasSuffix(pkgName, "_test") && p.Name != pkgName
+		if isXTest {
+			pkgName = strings.TrimSuffix(pkgName, "_test")
+		}
+		if p.Name == "" {
+			p.Name = pkgName
+			firstFile = name
+		} else if pkgName != p.Name {
+			// The file is still listed, as by go/build.
+			badGoFile(name, &build.MultiplePackageError{
+				Dir:      dir,
+				Packages: []string{p.Name, pkgName},
+				Files:    []string{firstFile, name},
+			})
+		}
+		if p.Doc == "" && !isTest {
+			p.Doc = f.Doc
+		}
+
+		isCgo := false
+		if importsC(f) {
+			if isTest {
+				badGoFile(name, fmt.Errorf("use of cgo in test %s not supported", filename))
+			} else {
+				isCgo = true
+				if err := saveCgo(ctx, filename, p, f.Cgo); err != nil {
+					badGoFile(name, err)
+				}
+			}
+		}
+		switch {
+		case isCgo:
+			allTags["cgo"] = true
+			if ctx.CgoEnabled {
+				p.CgoFiles = append(p.CgoFiles, f)
+			} else {
+				p.IgnoredGoFiles = append(p.IgnoredGoFiles, name)
+			}
+		case isXTest:
+			p.XTestGoFiles = append(p.XTestGoFiles, f)
+		case isTest:
+			p.TestGoFiles = append(p.TestGoFiles, f)
+		default:
+			p.GoFiles = append(p.GoFiles, f)
+		}
+	}
+	for tag := range allTags {
+		p.AllTags = append(p.AllTags, tag)
+	}
+	sort.Strings(p.AllTags)
+	if len(p.CgoFiles) > 0 {
+		p.SFiles = append(p.SFiles, cgoSFiles...)
+		sort.Strings(p.SFiles)
+	} else {
+		p.IgnoredOtherFiles = append(p.IgnoredOtherFiles, cgoSFiles...)
+		sort.Strings(p.IgnoredOtherFiles)
+	}
+	if badGoError != nil {
+		return p, badGoError
+	}
+	if len(p.GoFiles)+len(p.CgoFiles)+len(p.TestGoFiles)+len(p.XTestGoFiles) == 0 {
+		return p, &build.NoGoError{Dir: dir}
+	}
+	return p, nil
+}
+
+func init() {
+	load.ImportDirFunc = importPackage
+}
+
+// importPackage returns the package in dir, a directory of the module
+// modulePath in the module cache whose download cache is cacheDir, as
+// ctx.ImportDir(dir, 0) would, but assembled by ImportDir from the
+// cached metadata of its files. The Directives fields are not set.
+// It is the load.ImportDirFunc, so that load.LegacyCachedImport reads
+// packages missing from the package cache this way.
+func importPackage(ctx *build.Context, dir, modulePath, cacheDir string) (*build.Package, error) {
+	bp, err := ctx.ImportDir(dir, build.FindOnly)
+	if err != nil {
+		return bp, err
+	}
+	p, err := ImportDir(ctx, dir, modulePath, cacheDir)
+	if p == nil {
+		return bp, err
+	}
+	bp.Name = p.Name
+	bp.Doc = p.Doc
+	bp.AllTags = p.AllTags
+	bp.BinaryOnly = p.BinaryOnly
+
+	bp.GoFiles = fileNames(p.GoFiles)
+	bp.CgoFiles = fileNames(p.CgoFiles)
+	bp.TestGoFiles = fileNames(p.TestGoFiles)
+	bp.XTestGoFiles = fileNames(p.XTestGoFiles)
+	bp.IgnoredGoFiles = p.IgnoredGoFiles
+	bp.InvalidGoFiles = p.InvalidGoFiles
+	bp.IgnoredOtherFiles = p.IgnoredOtherFiles
+	bp.CFiles = p.CFiles
+	bp.CXXFiles = p.CXXFiles
+	bp.MFiles = p.MFiles
+	bp.HFiles = p.HFiles
+	bp.FFiles = p.FFiles
+	bp.SFiles = p.SFiles
+	bp.SwigFiles = p.SwigFiles
+	bp.SwigCXXFiles = p.SwigCXXFiles
+	bp.SysoFiles = p.SysoFiles
+
+	bp.CgoCFLAGS = p.CgoCFLAGS
+	bp.CgoCPPFLAGS = p.CgoCPPFLAGS
+	bp.CgoCXXFLAGS = p.CgoCXXFLAGS
+	bp.CgoFFLAGS = p.CgoFFLAGS
+	bp.CgoLDFLAGS = p.CgoLDFLAGS
+	bp.CgoPkgConfig = p.CgoPkgConfig
+
+	files := sortedFiles(p.GoFiles, p.CgoFiles)
+	bp.Imports, bp.ImportPos = imports(files)
+	bp.TestImports, bp.TestImportPos = imports(p.TestGoFiles)
+	bp.XTestImports, bp.XTestImportPos = imports(p.XTestGoFiles)
+	bp.EmbedPatterns, bp.EmbedPatternPos = embeds(files)
+	bp.TestEmbedPatterns, bp.TestEmbedPatternPos = embeds(p.TestGoFiles)
+	bp.XTestEmbedPatterns, bp.XTestEmbedPatternPos = embeds(p.XTestGoFiles)
+	return bp, err
+}
+
+// fileNames returns the base names of files.
+func fileNames(files []*File) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f.Filename))
+	}
+	return names
+}
+
+// sortedFiles returns the files of lists in name order, the order in
+// which go/build reads them.
+func sortedFiles(lists ...[]*File) []*File {
+	var files []*File
+	for _, list := range lists {
+		files = append(files, list...)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Filename < files[j].Filename })
+	return files
+}
+
+// imports returns the sorted import paths of files, and their
+// positions, in the form of build.Package.Imports and ImportPos.
+func imports(files []*File) ([]string, map[string][]token.Position) {
+	pos := make(map[string][]token.Position)
+	for _, f := range files {
+		for _, path := range f.Imports {
+			pos[path] = append(pos[path], f.ImportPos[path]...)
+		}
+	}
+	return sortedKeys(pos), pos
+}
+
+// embeds returns the sorted //go:embed patterns of files, and their
+// positions, in the form of build.Package.EmbedPatterns and
+// EmbedPatternPos.
+func embeds(files []*File) ([]string, map[string][]token.Position) {
+	pos := make(map[string][]token.Position)
+	for _, f := range files {
+		for _, pattern := range f.EmbedPatterns {
+			pos[pattern] = append(pos[pattern], f.EmbedPatternPos[pattern]...)
+		}
+	}
+	return sortedKeys(pos), pos
+}
+
+// sortedKeys returns the keys of m in sorted order. As in go/build,
+// the result is not nil.
+func sortedKeys(m map[string][]token.Position) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Files returns the metadata of the Go files in dir, as recorded by
+// ImportDir, in name order. Unlike ImportDir, it returns every file,
+// whatever its build constraints and package name. Files that cannot
+// be parsed are left out.
+func Files(ctx *build.Context, dir, modulePath, cacheDir string) ([]*File, error) {
+	c, key := fileCache(dir, modulePath, cacheDir)
+	d, err := readDirEntry(c, key, ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []*File
+	for _, df := range d.Files {
+		if filepath.Ext(df.Name) != ".go" {
+			continue
+		}
+		f, err := readFile(c, ctx, filepath.Join(dir, df.Name), df.Hash)
+		if err != nil {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// Entries returns the IDs of the entries recorded by ImportDir for the
+// package directories of the module version v, whose source must be in
+// the module cache c, extracted or as a zip file: the listing of each
+// directory and the metadata of each of its Go files. The entries are
+// stored in the cache of v's module (see load.ModCache). Directories
+// not yet read by ImportDir are left out.
+func Entries(c *catalog.Catalog, v *catalog.Version) ([]load.ActionID, error) {
+	srcDir, err := c.SourceDir(v.Path, v.Version)
+	if err != nil {
+		return nil, err
+	}
+	var z *catalog.ZipDir
+	if _, err := os.Stat(srcDir); err != nil {
+		if z, err = c.OpenZip(v); err != nil {
+			return nil, err
+		}
+		defer z.Close()
+	}
+	dirs, err := catalog.PackageDirs(srcDir, z)
+	if err != nil {
+		return nil, err
+	}
+	var ids []load.ActionID
+	seen := make(map[load.ActionID]bool)
+	for _, dir := range dirs {
+		lc, key := fileCache(dir, v.Path, c.Dir())
+		if lc == nil {
+			continue
+		}
+		id := dirEntryID(key)
+		data, _, err := lc.GetBytes(id)
+		if err != nil {
+			continue
+		}
+		d := new(dirEntry)
+		if err := load.DecodeStruct(data, d); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+		for _, df := range d.Files {
+			if df.Hash == "" {
+				continue
+			}
+			fid := fileEntryID(df.Hash)
+			if _, err := lc.Get(fid); err == nil && !seen[fid] {
+				seen[fid] = true
+				ids = append(ids, fid)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// fileCache returns the cache holding the metadata of the files in dir,
+// and the key of dir in it, or nil if dir is not in the module cache.
+func fileCache(dir, modulePath, cacheDir string) (*load.Cache, string) {
+	cat, err := catalog.Open(cacheDir)
+	if err != nil {
+		return nil, ""
+	}
+	path, version, rel, ok := cat.ParseSourcePath(dir)
+	if !ok || path != modulePath {
+		return nil, ""
+	}
+	escPath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return nil, ""
+	}
+	c, err := load.Open(filepath.Join(cacheDir, escPath, "@v"))
+	if err != nil {
+		return nil, ""
+	}
+	return c, fmt.Sprintf("module %s@%s dir %s", path, version, rel)
+}
+
+// dirEntryID returns the action ID of the listing of the directory
+// whose key is key.
+func dirEntryID(key string) load.ActionID {
+	h := load.NewHash("cache.ImportDir")
+	fmt.Fprintf(h, "dir entry %d\n", fileCacheVersion)
+	fmt.Fprintf(h, "%s\n", key)
+	return h.Sum()
+}
+
+// fileEntryID returns the action ID of the metadata of the Go files
+// whose contents have the given hash.
+func fileEntryID(hash string) load.ActionID {
+	h := load.NewHash("cache.File")
+	fmt.Fprintf(h, "file entry %d\n", fileCacheVersion)
+	fmt.Fprintf(h, "hash %s\n", hash)
+	return h.Sum()
+}
+
+// readDirEntry returns the listing of dir, from c if possible.
+func readDirEntry(c *load.Cache, key string, ctx *build.Context, dir string) (*dirEntry, error) {
+	var id load.ActionID
+	if c != nil {
+		id = dirEntryID(key)
+		if data, _, err := c.GetBytes(id); err == nil {
+			d := new(dirEntry)
+			if err := load.DecodeStruct(data, d); err == nil {
+				// Record the hashes of the Go files, so that
+				// load.LegacyCachedImport need not read them again.
+				for _, df := range d.Files {
+					var sum [load.HashSize]byte
+					if x, err := hex.DecodeString(df.Hash); err == nil && len(x) == load.HashSize {
+						copy(sum[:], x)
+						load.SetFileHash(filepath.Join(dir, df.Name), sum)
+					}
+				}
+				return d, nil
+			}
+		}
+	}
+
+	readDir := ioutil.ReadDir
+	if ctx.ReadDir != nil {
+		readDir = ctx.ReadDir
+	}
+	infos, err := readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	d := new(dirEntry)
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") || !knownExt(filepath.Ext(name)) {
+			continue
+		}
+		df := dirFile{Name: name}
+		filename := filepath.Join(dir, name)
+		switch filepath.Ext(name) {
+		case ".go":
+			sum, err := load.ContextFileHash(ctx, filename)
+			if err != nil {
+				return nil, err
+			}
+			df.Hash = hex.EncodeToString(sum[:])
+		case ".syso":
+			// Binary; selected by name only.
+		default:
+			data, err := readHeader(ctx, filename)
+			if err != nil {
+				return nil, err
+			}
+			df.Constraint, _ = fileHeader(data)
+		}
+		d.Files = append(d.Files, df)
+	}
+	if c != nil {
+		c.PutBytes(id, load.EncodeStruct(d))
+	}
+	return d, nil
+}
+
+// readFile returns the metadata of the Go file filename, whose contents
+// have the given hash, from c if possible.
+func readFile(c *load.Cache, ctx *build.Context, filename, hash string) (*File, error) {
+	var id load.ActionID
+	if c != nil {
+		id = fileEntryID(hash)
+		if data, _, err := c.GetBytes(id); err == nil {
+			f := new(File)
+			if err := load.DecodeStruct(data, f); err == nil {
+				f.rename(filename)
+				return f, nil
+			}
+		}
+	}
+	src, err := readAll(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ParseFile(token.NewFileSet(), filename, src)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		c.PutBytes(id, load.EncodeStruct(f))
+	}
+	return f, nil
+}
+
+// rename sets the name of f, as recorded in its positions, to filename:
+// a cached File may have been parsed from a copy of the file elsewhere.
+func (f *File) rename(filename string) {
+	if f.Filename == filename {
+		return
+	}
+	f.Filename = filename
+	for _, m := range []map[string][]token.Position{f.ImportPos, f.EmbedPatternPos} {
+		for _, list := range m {
+			for i := range list {
+				list[i].Filename = filename
+			}
+		}
+	}
+	for k, pos := range f.ExportPos {
+		pos.Filename = filename
+		f.ExportPos[k] = pos
+	}
+}
+
+// importsC reports whether f imports "C", and so uses cgo.
+func importsC(f *File) bool {
+	for _, imp := range f.Imports {
+		if imp == "C" {
+			return true
+		}
+	}
+	return false
+}
+
+// otherFiles returns the list of p holding non-Go files with the
+// extension ext.
+func otherFiles(p *Cache, ext string) *[]string {
+	switch ext {
+	case ".c":
+		return &p.CFiles
+	case ".cc", ".cpp", ".cxx":
+		return &p.CXXFiles
+	case ".m":
+		return &p.MFiles
+	case ".h", ".hh", ".hpp", ".hxx":
+		return &p.HFiles
+	case ".f", ".F", ".for", ".f90":
+		return &p.FFiles
+	case ".s", ".S", ".sx":
+		return &p.SFiles
+	case ".swig":
+		return &p.SwigFiles
+	case ".swigcxx":
+		return &p.SwigCXXFiles
+	}
+	return &p.SysoFiles
+}
+
+// knownExt reports whether go/build considers files with extension ext.
+func knownExt(ext string) bool {
+	switch ext {
+	case ".go", ".c", ".cc", ".cxx", ".cpp", ".m", ".s", ".h", ".hh", ".hpp", ".hxx", ".f", ".F", ".for", ".f90", ".S", ".sx", ".swig", ".swigcxx", ".syso":
+		return true
+	}
+	return false
+}
+
+// readAll returns the contents of the named file, read using ctx.
+func readAll(ctx *build.Context, name string) ([]byte, error) {
+	if ctx.OpenFile == nil {
+		return os.ReadFile(name)
+	}
+	r, err := ctx.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// readHeader returns the beginning of the named file, which holds its
+// build constraints if any.
+func readHeader(ctx *build.Context, name string) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	if ctx.OpenFile != nil {
+		r, err = ctx.OpenFile(name)
+	} else {
+		r, err = os.Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r, 64<<10))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// fileHeader returns the build constraint in the header of a source
+// file, its leading run of comments and blank lines, in the form of
+// File.Constraint, and whether the header has a //go:binary-only-package
+// comment. As in go/build, a //go:build line takes precedence over
+// // +build lines, which count only if followed by a blank line.
+func fileHeader(data []byte) (string, bool) {
+	var goBuild string
+	binaryOnly := false
+	end := 0
+	p := data
+	ended := false       // found a non-blank, non-// line
+	inSlashStar := false // in a /* */ comment
+Lines:
+	for len(p) > 0 {
+		line := p
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line, p = line[:i], p[i+1:]
+		} else {
+			p = nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 && !ended {
+			end = len(data) - len(p)
+			continue
+		}
+		if !bytes.HasPrefix(line, []byte("//")) {
+			ended = true
+		}
+		if !inSlashStar {
+			text := string(line)
+			if constraint.IsGoBuild(text) && goBuild == "" {
+				goBuild = text
+			}
+			if text == "//go:binary-only-package" {
+				binaryOnly = true
+			}
+		}
+	Comments:
+		for len(line) > 0 {
+			if inSlashStar {
+				if i := bytes.Index(line, []byte("*/")); i >= 0 {
+					inSlashStar = false
+					line = bytes.TrimSpace(line[i+2:])
+					continue Comments
+				}
+				continue Lines
+			}
+			if bytes.HasPrefix(line, []byte("//")) {
+				continue Lines
+			}
+			if bytes.HasPrefix(line, []byte("/*")) {
+				inSlashStar = true
+				line = bytes.TrimSpace(line[2:])
+				continue Comments
+			}
+			break Lines
+		}
+	}
+
+	if goBuild != "" {
+		if expr, err := constraint.Parse(goBuild); err == nil {
+			return expr.String(), binaryOnly
+		}
+		return "", binaryOnly
+	}
+	var plusBuild constraint.Expr
+	for _, line := range strings.Split(string(data[:end]), "\n") {
+		text := strings.TrimSpace(line)
+		if !constraint.IsPlusBuild(text) {
+			continue
+		}
+		if expr, err := constraint.Parse(text); err == nil {
+			plusBuild = and(plusBuild, expr)
+		}
+	}
+	if plusBuild == nil {
+		return "", binaryOnly
+	}
+	return plusBuild.String(), binaryOnly
+}
+
+// constraintTags adds the tags mentioned by the build constraint c, in
+// the form of File.Constraint, to tags.
+func constraintTags(c string, tags map[string]bool) {
+	if c == "" {
+		return
+	}
+	if expr, err := constraint.Parse("//go:build " + c); err == nil {
+		collectTags(expr, tags)
+	}
+}
+
+// matchConstraint reports whether the build constraint c, in the form of
+// File.Constraint, is satisfied by ctx.
+func matchConstraint(ctx *build.Context, c string) bool {
+	if c == "" || ctx.UseAllFiles {
+		return true
+	}
+	expr, err := constraint.Parse("//go:build " + c)
+	if err != nil {
+		return false
+	}
+	return expr.Eval(func(tag string) bool { return matchTag(ctx, tag) })
+}
+
+// matchTag reports whether the build tag name is satisfied by ctx,
+// as in go/build.
+func matchTag(ctx *build.Context, name string) bool {
+	if ctx.CgoEnabled && name == "cgo" {
+		return true
+	}
+	if name == ctx.GOOS || name == ctx.GOARCH || name == ctx.Compiler {
+		return true
+	}
+	if ctx.GOOS == "android" && name == "linux" ||
+		ctx.GOOS == "illumos" && name == "solaris" ||
+		ctx.GOOS == "ios" && name == "darwin" {
+		return true
+	}
+	if name == "unix" && unixOS[ctx.GOOS] {
+		return true
+	}
+	if name == "boringcrypto" {
+		name = "goexperiment.boringcrypto"
+	}
+	for _, list := range [][]string{ctx.BuildTags, ctx.ToolTags, ctx.ReleaseTags} {
+		for _, tag := range list {
+			if tag == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// goodOSArchFile reports whether the GOOS and GOARCH suffixes of name,
+// such as _linux_amd64 in x_linux_amd64.go, are satisfied by ctx.
+func goodOSArchFile(ctx *build.Context, name string) bool {
+	if ctx.UseAllFiles {
+		return true
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	i := strings.Index(name, "_")
+	if i < 0 {
+		return true
+	}
+	l := strings.Split(name[i:], "_")
+	if n := len(l); n > 0 && l[n-1] == "test" {
+		l = l[:n-1]
+	}
+	n := len(l)
+	if n >= 2 && knownOS[l[n-2]] && knownArch[l[n-1]] {
+		return matchTag(ctx, l[n-1]) && matchTag(ctx, l[n-2])
+	}
+	if n >= 1 && (knownOS[l[n-1]] || knownArch[l[n-1]]) {
+		return matchTag(ctx, l[n-1])
+	}
+	return true
+}
+
+// nameTags returns the GOOS and GOARCH tags in the suffixes of name,
+// which go/build records in Package.AllTags.
+func nameTags(name string) []string {
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	i := strings.Index(name, "_")
+	if i < 0 {
+		return nil
+	}
+	l := strings.Split(name[i:], "_")
+	if n := len(l); n > 0 && l[n-1] == "test" {
+		l = l[:n-1]
+	}
+	n := len(l)
+	if n >= 2 && knownOS[l[n-2]] && knownArch[l[n-1]] {
+		return l[n-2:]
+	}
+	if n >= 1 && (knownOS[l[n-1]] || knownArch[l[n-1]]) {
+		return l[n-1:]
+	}
+	return nil
+}
+
+// knownOS, unixOS and knownArch are the lists of GOOS and GOARCH values
+// used by go/build (see internal/syslist).
+var (
+	knownOS   = setOf("aix android darwin dragonfly freebsd hurd illumos ios js linux nacl netbsd openbsd plan9 solaris wasip1 windows zos")
+	unixOS    = setOf("aix android darwin dragonfly freebsd hurd illumos ios linux netbsd openbsd solaris")
+	knownArch = setOf("386 amd64 amd64p32 arm armbe arm64 arm64be loong64 mips mipsle mips64 mips64le mips64p32 mips64p32le ppc ppc64 ppc64le riscv riscv64 s390 s390x sparc sparc64 wasm")
+)
+
+func setOf(list string) map[string]bool {
+	m := make(map[string]bool)
+	for _, s := range strings.Fields(list) {
+		m[s] = true
+	}
+	return m
+}
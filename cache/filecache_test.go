@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/julieqiu/modcache/load"
+)
+
+// packageDirs returns the directories of root that may hold packages,
+// relative to root, leaving out testdata.
+func packageDirs(t *testing.T, root string) []string {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if name := d.Name(); path != root && (name == "testdata" || strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".")) {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testing.Short() && len(dirs) > 100 {
+		dirs = dirs[:100]
+	}
+	return dirs
+}
+
+// newModCache returns the download cache of a new, empty module cache
+// holding only the module version at src, linked as path@version.
+func newModCache(t *testing.T, src, path, version string) (cacheDir, root string) {
+	modCache := t.TempDir()
+	cacheDir = filepath.Join(modCache, "cache", "download")
+	if err := os.MkdirAll(filepath.Join(cacheDir, filepath.FromSlash(path), "@v"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	root = filepath.Join(modCache, filepath.FromSlash(path)+"@"+version)
+	if err := os.MkdirAll(filepath.Dir(root), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(src, root); err != nil {
+		t.Skip(err)
+	}
+	return cacheDir, root
+}
+
+// packageDiff returns the fields in which got differs from want, other
+// than the Directives fields, which ImportDir does not record.
+func packageDiff(got, want *build.Package) []string {
+	var diffs []string
+	gv, wv := reflect.ValueOf(got).Elem(), reflect.ValueOf(want).Elem()
+	for i := 0; i < gv.NumField(); i++ {
+		name := gv.Type().Field(i).Name
+		if strings.HasSuffix(name, "Directives") {
+			continue
+		}
+		g, w := gv.Field(i).Interface(), wv.Field(i).Interface()
+		if !reflect.DeepEqual(g, w) {
+			diffs = append(diffs, fmt.Sprintf("%s = %v, want %v", name, g, w))
+		}
+	}
+	return diffs
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
+
+// TestImportParity checks that load.LegacyCachedImport, reading the
+// packages of GOROOT/src linked into a module cache, returns what
+// ctx.ImportDir does, for several build contexts sharing the cache.
+// The first pass reads the files. The second adds a build tag, so that
+// the package cache misses but no file need be read again: the packages
+// are assembled from the metadata the first pass recorded. The third
+// is served by the package cache.
+func TestImportParity(t *testing.T) {
+	src := filepath.Join(runtime.GOROOT(), "src")
+	dirs := packageDirs(t, src)
+	cacheDir, root := newModCache(t, src, "example.com/goroot", "v1.0.0")
+
+	var contexts []*build.Context
+	for _, tt := range []struct{ goos, goarch string }{
+		{"linux", "amd64"},
+		{"darwin", "arm64"},
+		{"windows", "amd64"},
+		{"js", "wasm"},
+	} {
+		for _, cgo := range []bool{false, true} {
+			ctx := build.Default
+			ctx.GOOS, ctx.GOARCH, ctx.CgoEnabled = tt.goos, tt.goarch, cgo
+			contexts = append(contexts, &ctx)
+		}
+	}
+
+	for pass := 0; pass < 3; pass++ {
+		for _, ctx := range contexts {
+			ctx := *ctx
+			if pass > 0 {
+				ctx.BuildTags = []string{"parity"}
+			}
+			name := fmt.Sprintf("pass %d %s/%s cgo=%v", pass, ctx.GOOS, ctx.GOARCH, ctx.CgoEnabled)
+			cached := ctx
+			if pass > 0 {
+				cached.OpenFile = func(path string) (io.ReadCloser, error) {
+					return nil, errors.New("read file with warm cache")
+				}
+			}
+			for _, rel := range dirs {
+				dir := filepath.Join(root, rel)
+				want, wantErr := ctx.ImportDir(dir, 0)
+				got, err := load.LegacyCachedImport(&cached, ".", dir, "example.com/goroot", cacheDir, 0)
+				if errorString(err) != errorString(wantErr) {
+					t.Errorf("%s: import %s: error %v, want %v", name, rel, err, wantErr)
+					continue
+				}
+				for _, d := range packageDiff(got, want) {
+					t.Errorf("%s: import %s: %s", name, rel, d)
+				}
+			}
+		}
+	}
+}
+
+// importFiles are the files of a package exercising the corners of
+// go/build's file selection that x/tools does not.
+var importFiles = map[string]string{
+	"a.go":               "// Package p is a test.\npackage p\n\nimport _ \"embed\"\n\n//go:embed a.txt \"b c.txt\"\nvar s string\n",
+	"b.go":               "//go:build linux || darwin\n\npackage p\n\nimport \"os\"\n\nvar _ = os.Args\n",
+	"c.go":               "package p\n\n/*\n#cgo CFLAGS: -I include -DX=1\n#cgo linux LDFLAGS: -L${SRCDIR}/lib -lm\n#cgo windows pkg-config: foo\n*/\nimport \"C\"\n",
+	"d.go":               "// +build ignore\npackage p\n",
+	"e.go":               "// +build ignore\n\npackage p\n",
+	"f_windows_arm64.go": "package p\n",
+	"g.go":               "//go:build tag1 && !tag2\n\npackage p\n",
+	"h.go":               "package p\n\nfunc f() { syntax error }\n",
+	"i.go":               "package documentation\n",
+	"j.go":               "package q\n",
+	"k_test.go":          "package p\n\nimport \"testing\"\n\nvar _ testing.T\n",
+	"l_test.go":          "package p_test\n\nimport (\n\t\"embed\"\n\t\"p\"\n)\n\n//go:embed testdata\nvar fs embed.FS\n",
+	"m.s":                "// +build amd64\n#include \"textflag.h\"\n",
+	"n.S":                "//go:build linux\n",
+	"o.c":                "//go:build darwin\n\nint x;\n",
+	"p.h":                "",
+	"q.syso":             "",
+	"_r.go":              "package p\n",
+	".s.go":              "package p\n",
+}
+
+func TestImportCorners(t *testing.T) {
+	modCache := t.TempDir()
+	cacheDir := filepath.Join(modCache, "cache", "download")
+	if err := os.MkdirAll(filepath.Join(cacheDir, "example.com", "p", "@v"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(modCache, "example.com", "p@v1.0.0")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range importFiles {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		goos, goarch string
+		cgo          bool
+		tags         []string
+	}{
+		{"linux", "amd64", true, nil},
+		{"linux", "amd64", false, []string{"tag1"}},
+		{"darwin", "arm64", true, []string{"tag1", "tag2"}},
+		{"windows", "arm64", true, nil},
+	} {
+		ctx := build.Default
+		ctx.GOOS, ctx.GOARCH, ctx.CgoEnabled, ctx.BuildTags = tt.goos, tt.goarch, tt.cgo, tt.tags
+		for _, pass := range []string{"cold", "warm"} {
+			want, wantErr := ctx.ImportDir(dir, 0)
+			got, err := load.LegacyCachedImport(&ctx, ".", dir, "example.com/p", cacheDir, 0)
+			if errorString(err) != errorString(wantErr) {
+				t.Errorf("%s/%s cgo=%v tags=%v %s: error %v, want %v", tt.goos, tt.goarch, tt.cgo, tt.tags, pass, err, wantErr)
+			}
+			for _, d := range packageDiff(got, want) {
+				t.Errorf("%s/%s cgo=%v tags=%v %s: %s", tt.goos, tt.goarch, tt.cgo, tt.tags, pass, d)
+			}
+		}
+	}
+}
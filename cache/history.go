@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"fmt"
+	"go/build"
+	"go/token"
+	"os"
+	"path/filepath"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/load"
+)
+
+// A SymbolChange is a change to an exported symbol of a package from
+// one cached version of its module to the next.
+type SymbolChange struct {
+	Version string         // version in which the change appears
+	Kind    string         // "added", "changed" or "removed"
+	Decl    string         // declaration in Version; see File.ExportDecl
+	Pos     token.Position // position of the declaration in Version
+}
+
+// History returns the changes to the exported symbol of the package
+// importPath across the cached versions of the module providing it,
+// in semver order: the versions in which the symbol was added, had its
+// declaration changed, or was removed. The symbol is a top-level name,
+// or T.M for a method M of type T.
+//
+// Versions whose source is not in the cache, extracted or as a zip
+// file, are skipped, as are versions in which the package cannot be
+// read, or is read only in part, as when its files declare different
+// package names or cannot be parsed. The package's files are those selected by the
+// default build context, and their declarations are read from the
+// cached metadata of the files (see Files).
+func History(c *catalog.Catalog, importPath, symbol string) ([]*SymbolChange, error) {
+	latest, err := c.Resolve(importPath, "")
+	if err != nil {
+		return nil, err
+	}
+	modPath := latest.Version.Path
+	m, err := c.Module(modPath)
+	if err != nil {
+		return nil, err
+	}
+	versions := append([]*catalog.Version(nil), m.Versions...)
+	catalog.SortVersions(versions)
+
+	var changes []*SymbolChange
+	var prev string // declaration in the previous version, or "" if absent
+	for _, v := range versions {
+		if !hasSource(c, v) {
+			continue
+		}
+		decl, pos, err := declIn(c, importPath, v, symbol)
+		if err != nil {
+			// Compare the next version with the last one read.
+			continue
+		}
+		var kind string
+		switch {
+		case prev == "" && decl != "":
+			kind = "added"
+		case prev != "" && decl == "":
+			kind = "removed"
+		case decl != prev:
+			kind = "changed"
+		}
+		if kind != "" {
+			changes = append(changes, &SymbolChange{Version: v.Version, Kind: kind, Decl: decl, Pos: pos})
+		}
+		prev = decl
+	}
+	return changes, nil
+}
+
+// hasSource reports whether the source of v is in the module cache.
+func hasSource(c *catalog.Catalog, v *catalog.Version) bool {
+	if v.Zip != "" {
+		return true
+	}
+	srcDir, err := c.SourceDir(v.Path, v.Version)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(srcDir)
+	return err == nil
+}
+
+// declIn returns the declaration of symbol in the package importPath
+// of the module version v, and its position, or "" if the package
+// does not declare it or does not exist in v. It returns an error if
+// the package cannot be read in full.
+func declIn(c *catalog.Catalog, importPath string, v *catalog.Version, symbol string) (string, token.Position, error) {
+	pkg, err := c.Resolve(importPath, v.Version)
+	if err != nil || pkg.Version.Path != v.Path {
+		// Not in this version, or provided by another module.
+		return "", token.Position{}, nil
+	}
+	ctx := &build.Default
+	if pkg.Zipped {
+		z, err := c.OpenZip(v)
+		if err != nil {
+			return "", token.Position{}, err
+		}
+		defer z.Close()
+		ctx = z.Context(ctx)
+	}
+	p, err := load.LegacyCachedImport(ctx, ".", pkg.Dir, v.Path, c.Dir(), 0)
+	if err != nil {
+		if _, ok := err.(*build.NoGoError); ok {
+			return "", token.Position{}, nil
+		}
+		return "", token.Position{}, fmt.Errorf("%s: %v", v, err)
+	}
+	files, err := Files(ctx, pkg.Dir, v.Path, c.Dir())
+	if err != nil {
+		return "", token.Position{}, fmt.Errorf("%s: %v", v, err)
+	}
+	selected := make(map[string]bool)
+	for _, name := range load.StringList(p.GoFiles, p.CgoFiles) {
+		selected[name] = true
+	}
+	for _, f := range files {
+		if !selected[filepath.Base(f.Filename)] {
+			continue
+		}
+		if f.Incomplete {
+			return "", token.Position{}, fmt.Errorf("%s: %s does not parse", v, f.Filename)
+		}
+	}
+	for _, f := range files {
+		if !selected[filepath.Base(f.Filename)] {
+			continue
+		}
+		if decl, ok := f.ExportDecl[symbol]; ok {
+			return decl, f.ExportPos[symbol], nil
+		}
+	}
+	return "", token.Position{}, nil
+}
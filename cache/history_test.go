@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+func TestHistory(t *testing.T) {
+	modCache := t.TempDir()
+	var cacheDir string
+	for _, v := range []struct {
+		version string
+		src     string // of h.go
+		other   string // of other.go, if any
+		extract bool
+	}{
+		{"v1.0.0", "package h\n\nfunc G() {}\n", "", true},
+		{"v1.1.0", "package h\n\nfunc F(x int) int { return x }\n", "", true},
+		// Read in part: skipped.
+		{"v1.2.0", "package h\n\nfunc F(x, y int) int { return x }\n", "package other\n", true},
+		{"v1.2.1", "package h\n\nfunc F(x, y int) int { return x +}\n", "", true},
+		{"v1.3.0", "package h\n\n// F adds.\nfunc F(x, y int) int { return x + y }\n", "", true},
+		{"v1.3.1", "package h\n\nfunc F(a, b int) int { return b + a }\n", "", true},
+		{"v1.4.0", "package h\n\nfunc G() {}\n", "", false},
+		{"v1.5.0", "package h\n", "", false},
+	} {
+		files := map[string]string{
+			"go.mod": "module example.com/h\n",
+			"h.go":   v.src,
+		}
+		if v.other != "" {
+			files["other.go"] = v.other
+		}
+		cacheDir, _ = modtest.WriteModule(t, modCache, "example.com/h", v.version, files, v.extract)
+	}
+	// Only the .mod file of v1.5.0 is cached.
+	base := filepath.Join(cacheDir, "example.com", "h", "@v", "v1.5.0")
+	for _, ext := range []string{".info", ".zip", ".ziphash"} {
+		if err := os.Remove(base + ext); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		importPath, symbol string
+		want               []string
+	}{
+		{"example.com/h", "F", []string{
+			"v1.1.0 added func F(int) int",
+			"v1.3.0 changed func F(int, int) int",
+			"v1.4.0 removed ",
+		}},
+		{"example.com/h", "G", []string{
+			"v1.0.0 added func G()",
+			"v1.1.0 removed ",
+			"v1.4.0 added func G()",
+		}},
+		{"example.com/h", "H", nil},
+	} {
+		// Twice: the second time, from the cache.
+		for pass := 0; pass < 2; pass++ {
+			changes, err := History(c, tt.importPath, tt.symbol)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, ch := range changes {
+				got = append(got, fmt.Sprintf("%s %s %s", ch.Version, ch.Kind, ch.Decl))
+				if ch.Decl != "" && !strings.HasSuffix(ch.Pos.Filename, "h.go") {
+					t.Errorf("%s.%s: %s declared at %v, want h.go", tt.importPath, tt.symbol, ch.Version, ch.Pos)
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("History(%s, %s), pass %d:\n%s\nwant:\n%s", tt.importPath, tt.symbol, pass, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		}
+	}
+
+	if _, err := History(c, "example.com/none", "F"); err == nil {
+		t.Errorf("History of a package not in the cache succeeded, want error")
+	}
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/julieqiu/modcache/catalog"
+)
+
+// A Match is a package in the module cache that declares a symbol.
+type Match struct {
+	ImportPath string         // import path of the package
+	Module     string         // path of the module containing the package
+	Version    string         // version of the module
+	Pos        token.Position // position of the declaration
+}
+
+// Lookup returns every package in the module cache whose package name
+// is name and which exports symbol.
+//
+// downloadDir is the download cache, GOMODCACHE/cache/download. Module
+// versions whose source is in the cache, extracted into GOMODCACHE or
+// only as a zip file, are searched.
+func Lookup(downloadDir, name, symbol string) ([]*Match, error) {
+	c, err := catalog.Open(downloadDir)
+	if err != nil {
+		return nil, err
+	}
+	mods, err := c.Modules()
+	if err != nil {
+		return nil, err
+	}
+	var matches []*Match
+	for _, m := range mods {
+		for _, v := range m.Versions {
+			if !hasSource(c, v) {
+				continue
+			}
+			ms, err := lookupVersion(c, v, name, symbol)
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, ms...)
+		}
+	}
+	return matches, nil
+}
+
+// lookupVersion searches the module version v, whose source is in the
+// module cache c, for packages named name that export symbol. It reads
+// the zip file of v if v has not been extracted.
+func lookupVersion(c *catalog.Catalog, v *catalog.Version, name, symbol string) ([]*Match, error) {
+	srcDir, err := c.SourceDir(v.Path, v.Version)
+	if err != nil {
+		return nil, err
+	}
+	ctx := &build.Default
+	var z *catalog.ZipDir
+	if _, err := os.Stat(srcDir); err != nil {
+		if z, err = c.OpenZip(v); err != nil {
+			return nil, err
+		}
+		defer z.Close()
+		ctx = z.Context(ctx)
+	}
+	dirs, err := catalog.PackageDirs(srcDir, z)
+	if err != nil {
+		return nil, err
+	}
+	var matches []*Match
+	fset := token.NewFileSet()
+	for _, dir := range dirs {
+		pos, ok := lookupDir(ctx, fset, dir, name, symbol)
+		if !ok {
+			continue
+		}
+		rel, err := filepath.Rel(srcDir, dir)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, &Match{
+			ImportPath: path.Join(v.Path, filepath.ToSlash(rel)),
+			Module:     v.Path,
+			Version:    v.Version,
+			Pos:        pos,
+		})
+	}
+	return matches, nil
+}
+
+// lookupDir reports whether the package in dir is named name and
+// declares the exported symbol, and if so where. The directory and its
+// files are read using ctx's file system hooks, if set.
+func lookupDir(ctx *build.Context, fset *token.FileSet, dir, name, symbol string) (token.Position, bool) {
+	readDir := ioutil.ReadDir
+	if ctx.ReadDir != nil {
+		readDir = ctx.ReadDir
+	}
+	entries, err := readDir(dir)
+	if err != nil {
+		return token.Position{}, false
+	}
+	for _, e := range entries {
+		elem := e.Name()
+		if e.IsDir() || !strings.HasSuffix(elem, ".go") || strings.HasSuffix(elem, "_test.go") {
+			continue
+		}
+		filename := filepath.Join(dir, elem)
+		// Check the package clause before reading and parsing the
+		// whole file.
+		if pkg, err := packageName(ctx, fset, filename); err != nil || pkg != name {
+			continue
+		}
+		src, err := readAll(ctx, filename)
+		if err != nil {
+			continue
+		}
+		file, err := ParseFile(fset, filename, src)
+		if err != nil {
+			continue
+		}
+		if pos, ok := file.ExportPos[symbol]; ok {
+			return pos, true
+		}
+	}
+	return token.Position{}, false
+}
+
+// packageName returns the package name declared by the Go file
+// filename, read using ctx.OpenFile if set, reading no more of the file
+// than needed to find it.
+func packageName(ctx *build.Context, fset *token.FileSet, filename string) (string, error) {
+	var f io.ReadCloser
+	var err error
+	if ctx.OpenFile != nil {
+		f, err = ctx.OpenFile(filename)
+	} else {
+		f, err = os.Open(filename)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var src []byte
+	for n := 4 << 10; ; n *= 2 {
+		buf := make([]byte, n-len(src))
+		m, err := io.ReadFull(f, buf)
+		src = append(src, buf[:m]...)
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return "", err
+		}
+		pf, perr := parser.ParseFile(fset, filename, src, parser.PackageClauseOnly)
+		// A name at the very end of src may be cut short.
+		if perr == nil && (eof || fset.Position(pf.Name.End()).Offset < len(src)) {
+			return pf.Name.Name, nil
+		}
+		if eof {
+			return "", perr
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+func TestLookup(t *testing.T) {
+	modCache := t.TempDir()
+	var cacheDir string
+	for _, m := range []struct {
+		path    string
+		files   map[string]string
+		extract bool
+	}{
+		{"example.com/extracted", map[string]string{
+			"semver/semver.go": "package semver\n\nfunc Compare(v, w string) int { return 0 }\n",
+		}, true},
+		{"example.com/zipped", map[string]string{
+			"semver.go":   "package semver\n\nimport \"strings\"\n\nfunc Compare(v, w string) int { return strings.Compare(v, w) }\n",
+			"a/semver.go": "package semver\n\nfunc Compare() {}\n",
+			"b/b.go":      "package b\n\nfunc Compare() {}\n",
+			"c/semver.go": "package semver\n\nfunc compare() {}\n",
+		}, false},
+		{"example.com/skipped", map[string]string{
+			"testdata/semver.go": "package semver\n\nfunc Compare() {}\n",
+			"vendor/semver.go":   "package semver\n\nfunc Compare() {}\n",
+			"x/semver_test.go":   "package semver\n\nfunc Compare() {}\n",
+		}, false},
+	} {
+		m.files["go.mod"] = "module " + m.path + "\n"
+		cacheDir, _ = modtest.WriteModule(t, modCache, m.path, "v1.0.0", m.files, m.extract)
+	}
+
+	matches, err := Lookup(cacheDir, "semver", "Compare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range matches {
+		rel, err := filepath.Rel(modCache, m.Pos.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s@%s %s:%d", m.ImportPath, m.Module, m.Version, filepath.ToSlash(rel), m.Pos.Line))
+	}
+	want := []string{
+		"example.com/extracted/semver example.com/extracted@v1.0.0 example.com/extracted@v1.0.0/semver/semver.go:3",
+		"example.com/zipped example.com/zipped@v1.0.0 example.com/zipped@v1.0.0/semver.go:5",
+		"example.com/zipped/a example.com/zipped@v1.0.0 example.com/zipped@v1.0.0/a/semver.go:3",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Lookup(semver, Compare):\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,397 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/build/constraint"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseFile parses the Go source file filename and returns its metadata.
+// If src != nil, ParseFile parses the source from src and the filename
+// is only used when recording position information, as with
+// go/parser.ParseFile.
+//
+// As go/build reads only the header of a file, up to its imports, to
+// select it and list its imports, a file whose header parses but whose
+// body does not is not an error: its metadata then has no exports.
+func ParseFile(fset *token.FileSet, filename string, src interface{}) (*File, error) {
+	data, err := readSource(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parser.ParseFile(fset, filename, data, parser.ParseComments)
+	incomplete := err != nil
+	if incomplete {
+		f, err = parser.ParseFile(fset, filename, data, parser.ImportsOnly|parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+	}
+	file := &File{
+		Filename:        filename,
+		Name:            f.Name.Name,
+		ImportPos:       make(map[string][]token.Position),
+		EmbedPatternPos: make(map[string][]token.Position),
+		ExportPos:       make(map[string]token.Position),
+		ExportDecl:      make(map[string]string),
+		Incomplete:      incomplete,
+	}
+	if f.Doc != nil {
+		file.Doc = doc.Synopsis(f.Doc.Text())
+	}
+	file.BuildTags = buildTags(f)
+	file.Constraint, file.BinaryOnly = fileHeader(data)
+
+	embed := false
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		if _, ok := file.ImportPos[path]; !ok {
+			file.Imports = append(file.Imports, path)
+		}
+		file.ImportPos[path] = append(file.ImportPos[path], fset.Position(spec.Pos()))
+		switch path {
+		case "embed":
+			embed = true
+		case "C":
+			file.Cgo = append(file.Cgo, cgoLines(importDoc(f, spec))...)
+		}
+	}
+
+	// As in go/build, //go:embed comments count only in files importing
+	// "embed", and are found anywhere in the file, even in a body that
+	// does not parse.
+	if embed {
+		efset := token.NewFileSet()
+		tf := efset.AddFile(filename, -1, len(data))
+		var sc scanner.Scanner
+		sc.Init(tf, data, nil, scanner.ScanComments)
+		for {
+			pos, tok, lit := sc.Scan()
+			if tok == token.EOF {
+				break
+			}
+			if tok != token.COMMENT || !strings.HasPrefix(lit, "//go:embed") {
+				continue
+			}
+			for _, e := range embedPatterns(lit) {
+				if _, ok := file.EmbedPatternPos[e.pattern]; !ok {
+					file.EmbedPatterns = append(file.EmbedPatterns, e.pattern)
+				}
+				file.EmbedPatternPos[e.pattern] = append(file.EmbedPatternPos[e.pattern], efset.Position(pos+token.Pos(e.offset)))
+			}
+		}
+	}
+
+	export := func(id *ast.Ident, decl string) {
+		if id.IsExported() {
+			file.Exports = append(file.Exports, id.Name)
+			file.ExportPos[id.Name] = fset.Position(id.Pos())
+			file.ExportDecl[id.Name] = decl
+		}
+	}
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			// Methods are reached through their receiver type.
+			if d.Recv == nil {
+				export(d.Name, funcDecl(fset, d))
+			} else if recv := recvName(d.Recv); recv != "" && d.Name.IsExported() {
+				file.ExportPos[recv+"."+d.Name.Name] = fset.Position(d.Name.Pos())
+				file.ExportDecl[recv+"."+d.Name.Name] = funcDecl(fset, d)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					export(s.Name, typeDecl(fset, s))
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						export(name, valueDecl(fset, d.Tok, name, s))
+					}
+				}
+			}
+		}
+	}
+	return file, nil
+}
+
+// readSource returns the source of the file filename, from src if it is
+// non-nil, as in go/parser.ParseFile.
+func readSource(filename string, src interface{}) ([]byte, error) {
+	switch s := src.(type) {
+	case nil:
+		return os.ReadFile(filename)
+	case string:
+		return []byte(s), nil
+	case []byte:
+		return s, nil
+	case io.Reader:
+		return io.ReadAll(s)
+	}
+	return nil, errors.New("invalid source")
+}
+
+// importDoc returns the doc comment of the import spec in f, which for
+// import "C" holds the cgo preamble: the comment of the spec itself or,
+// if the import declaration has only that spec, of the declaration.
+func importDoc(f *ast.File, spec *ast.ImportSpec) *ast.CommentGroup {
+	if spec.Doc != nil {
+		return spec.Doc
+	}
+	for _, decl := range f.Decls {
+		if d, ok := decl.(*ast.GenDecl); ok && len(d.Specs) == 1 && d.Specs[0] == spec {
+			return d.Doc
+		}
+	}
+	return nil
+}
+
+// cgoLines returns the #cgo lines of the cgo preamble cg.
+func cgoLines(cg *ast.CommentGroup) []string {
+	var lines []string
+	for _, line := range strings.Split(cg.Text(), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) >= 5 && line[:4] == "#cgo" && (line[4] == ' ' || line[4] == '\t') {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// buildTags returns the sorted set of tags mentioned by the build
+// constraints in the header of f.
+func buildTags(f *ast.File) []string {
+	seen := make(map[string]bool)
+	for _, cg := range f.Comments {
+		// Build constraints must appear before the package clause.
+		if cg.Pos() >= f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if !constraint.IsGoBuild(c.Text) && !constraint.IsPlusBuild(c.Text) {
+				continue
+			}
+			expr, err := constraint.Parse(c.Text)
+			if err != nil {
+				continue
+			}
+			collectTags(expr, seen)
+		}
+	}
+	var tags []string
+	for tag := range seen {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// and returns x && y, or y if x is nil.
+func and(x, y constraint.Expr) constraint.Expr {
+	if x == nil {
+		return y
+	}
+	return &constraint.AndExpr{X: x, Y: y}
+}
+
+// collectTags adds every tag mentioned in expr to seen.
+func collectTags(expr constraint.Expr, seen map[string]bool) {
+	switch x := expr.(type) {
+	case *constraint.TagExpr:
+		seen[x.Tag] = true
+	case *constraint.NotExpr:
+		collectTags(x.X, seen)
+	case *constraint.AndExpr:
+		collectTags(x.X, seen)
+		collectTags(x.Y, seen)
+	case *constraint.OrExpr:
+		collectTags(x.X, seen)
+		collectTags(x.Y, seen)
+	}
+}
+
+// An embedPattern is a pattern of a //go:embed directive.
+type embedPattern struct {
+	pattern string
+	offset  int // byte offset of the pattern in the directive
+}
+
+// embedPatterns returns the patterns of the //go:embed directive in the
+// comment text. Patterns may be quoted using Go string literal syntax.
+// As in go/build, a malformed directive has no patterns.
+func embedPatterns(text string) []embedPattern {
+	args := strings.TrimPrefix(text, "//go:embed")
+	if args != "" && args[0] != ' ' && args[0] != '\t' {
+		// Another directive, such as //go:embedded.
+		return nil
+	}
+	var patterns []embedPattern
+	for {
+		trimmed := strings.TrimLeft(args, " \t")
+		if trimmed == "" {
+			return patterns
+		}
+		offset := len(text) - len(trimmed)
+		args = trimmed
+		var pattern string
+		switch args[0] {
+		case '"', '`':
+			quote, err := strconv.QuotedPrefix(args)
+			if err != nil {
+				return nil
+			}
+			pattern, _ = strconv.Unquote(quote)
+			args = args[len(quote):]
+			if args != "" && args[0] != ' ' && args[0] != '\t' {
+				return nil
+			}
+		default:
+			i := strings.IndexAny(args, " \t")
+			if i < 0 {
+				i = len(args)
+			}
+			pattern, args = args[:i], args[i:]
+		}
+		patterns = append(patterns, embedPattern{pattern, offset})
+	}
+}
+
+// funcDecl returns the canonical form of the function or method
+// declaration d, without its body or parameter names.
+func funcDecl(fset *token.FileSet, d *ast.FuncDecl) string {
+	fd := &ast.FuncDecl{
+		Recv: stripNames(d.Recv),
+		Name: d.Name,
+		Type: &ast.FuncType{
+			TypeParams: d.Type.TypeParams,
+			Params:     stripNames(d.Type.Params),
+			Results:    stripNames(d.Type.Results),
+		},
+	}
+	return nodeString(fset, fd)
+}
+
+// typeDecl returns the canonical form of the type declaration s,
+// without unexported struct fields.
+func typeDecl(fset *token.FileSet, s *ast.TypeSpec) string {
+	ts := &ast.TypeSpec{Name: s.Name, TypeParams: s.TypeParams, Assign: s.Assign, Type: exportedFields(s.Type)}
+	return "type " + nodeString(fset, ts)
+}
+
+// valueDecl returns the canonical form of the declaration of the
+// constant or variable name in s, giving its type, if explicit, but
+// not its value.
+func valueDecl(fset *token.FileSet, tok token.Token, name *ast.Ident, s *ast.ValueSpec) string {
+	decl := tok.String() + " " + name.Name
+	if s.Type != nil {
+		decl += " " + nodeString(fset, s.Type)
+	}
+	return decl
+}
+
+// recvName returns the name of the receiver type in recv,
+// or "" if it cannot be determined.
+func recvName(recv *ast.FieldList) string {
+	if recv == nil || len(recv.List) != 1 {
+		return ""
+	}
+	t := recv.List[0].Type
+	for {
+		switch x := t.(type) {
+		case *ast.StarExpr:
+			t = x.X
+		case *ast.ParenExpr:
+			t = x.X
+		case *ast.IndexExpr:
+			t = x.X
+		case *ast.IndexListExpr:
+			t = x.X
+		case *ast.Ident:
+			return x.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// stripNames returns a copy of the parameter list fl with one unnamed
+// field per parameter.
+func stripNames(fl *ast.FieldList) *ast.FieldList {
+	if fl == nil {
+		return nil
+	}
+	out := &ast.FieldList{}
+	for _, f := range fl.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			out.List = append(out.List, &ast.Field{Type: f.Type})
+		}
+	}
+	return out
+}
+
+// exportedFields returns t, or, if t is a struct type, a copy of it
+// without its unexported fields. Embedded fields are kept.
+func exportedFields(t ast.Expr) ast.Expr {
+	st, ok := t.(*ast.StructType)
+	if !ok {
+		return t
+	}
+	out := &ast.StructType{Fields: &ast.FieldList{}}
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			out.Fields.List = append(out.Fields.List, &ast.Field{Type: f.Type})
+			continue
+		}
+		for _, name := range f.Names {
+			if name.IsExported() {
+				out.Fields.List = append(out.Fields.List, &ast.Field{Names: []*ast.Ident{name}, Type: f.Type})
+			}
+		}
+	}
+	return out
+}
+
+// nodeString formats node on a single line, separating the lines
+// gofmt would print, such as struct fields, with semicolons.
+func nodeString(fset *token.FileSet, node interface{}) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, node); err != nil {
+		return ""
+	}
+	var out strings.Builder
+	prev := ""
+	for _, line := range strings.Split(buf.String(), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			continue
+		}
+		if prev != "" {
+			if strings.HasSuffix(prev, "{") || strings.HasPrefix(line, "}") {
+				out.WriteString(" ")
+			} else {
+				out.WriteString("; ")
+			}
+		}
+		out.WriteString(line)
+		prev = line
+	}
+	return out.String()
+}
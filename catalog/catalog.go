@@ -0,0 +1,307 @@
+// Package catalog enumerates the modules and versions held in the
+// module download cache, GOMODCACHE/cache/download.
+//
+// The download cache stores each module under its case-escaped path
+// (see golang.org/x/mod/module.EscapePath), with one directory per
+// module:
+//
+//	<escaped module path>/@v/list
+//	<escaped module path>/@v/<escaped version>.info
+//	<escaped module path>/@v/<escaped version>.mod
+//	<escaped module path>/@v/<escaped version>.zip
+//	<escaped module path>/@v/<escaped version>.ziphash
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// A Catalog is a read-only view of a module download cache.
+type Catalog struct {
+	dir string
+}
+
+// A Module is a module with at least one version in the download cache.
+type Module struct {
+	Path     string     // module path
+	Dir      string     // directory holding the module's @v files
+	Versions []*Version // cached versions, in semver order
+}
+
+// A Version is a single version of a module in the download cache.
+// The file fields are empty if the corresponding file is not cached.
+type Version struct {
+	Path    string // module path
+	Version string // module version
+	Listed  bool   // whether the version appears in @v/list
+
+	Info    string // .info file
+	GoMod   string // .mod file
+	Zip     string // .zip file
+	ZipHash string // .ziphash file
+}
+
+// Info is the JSON form of a .info file.
+type Info struct {
+	Version string
+	Time    time.Time
+}
+
+// Open returns a catalog of the download cache in dir.
+func Open(dir string) (*Catalog, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: dir, Err: fmt.Errorf("not a directory")}
+	}
+	return &Catalog{dir: dir}, nil
+}
+
+// Dir returns the download cache directory.
+func (c *Catalog) Dir() string {
+	return c.dir
+}
+
+// SourceDir returns the directory into which the go command extracts
+// the zip of path@version. The directory may not exist.
+func (c *Catalog) SourceDir(path, version string) (string, error) {
+	escPath, err := module.EscapePath(path)
+	if err != nil {
+		return "", err
+	}
+	escVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return "", err
+	}
+	modRoot := filepath.Dir(filepath.Dir(c.dir))
+	return filepath.Join(modRoot, escPath+"@"+escVersion), nil
+}
+
+// Modules returns every module in the download cache, sorted by path.
+func (c *Catalog) Modules() ([]*Module, error) {
+	var mods []*Module
+	err := filepath.WalkDir(c.dir, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if dir == filepath.Join(c.dir, "sumdb") {
+			return filepath.SkipDir
+		}
+		if d.Name() != "@v" {
+			return nil
+		}
+		escPath, err := filepath.Rel(c.dir, filepath.Dir(dir))
+		if err != nil {
+			return err
+		}
+		path, err := module.UnescapePath(filepath.ToSlash(escPath))
+		if err != nil {
+			// Not something the go command wrote.
+			return filepath.SkipDir
+		}
+		m, err := c.readModule(path, dir)
+		if err != nil {
+			return err
+		}
+		if len(m.Versions) > 0 {
+			mods = append(mods, m)
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(mods, func(i, j int) bool { return mods[i].Path < mods[j].Path })
+	return mods, nil
+}
+
+// Module returns the cached versions of the module with the given path.
+func (c *Catalog) Module(path string) (*Module, error) {
+	escPath, err := module.EscapePath(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(c.dir, escPath, "@v")
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	m, err := c.readModule(path, dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Versions) == 0 {
+		return nil, &fs.PathError{Op: "open", Path: dir, Err: fs.ErrNotExist}
+	}
+	return m, nil
+}
+
+// readModule reads the @v directory dir of the module path.
+func (c *Catalog) readModule(path, dir string) (*Module, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	m := &Module{Path: path, Dir: dir}
+	versions := make(map[string]*Version)
+	lookup := func(escVersion string) *Version {
+		version, err := module.UnescapeVersion(escVersion)
+		if err != nil {
+			return nil
+		}
+		v := versions[version]
+		if v == nil {
+			v = &Version{Path: path, Version: version}
+			versions[version] = v
+		}
+		return v
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || name == "list" {
+			continue
+		}
+		ext := filepath.Ext(name)
+		var field *string
+		v := lookup(strings.TrimSuffix(name, ext))
+		if v == nil {
+			continue
+		}
+		switch ext {
+		case ".info":
+			field = &v.Info
+		case ".mod":
+			field = &v.GoMod
+		case ".zip":
+			field = &v.Zip
+		case ".ziphash":
+			field = &v.ZipHash
+		default:
+			// .lock, .partial and other in-progress files.
+			continue
+		}
+		*field = filepath.Join(dir, name)
+	}
+	list, err := os.ReadFile(filepath.Join(dir, "list"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	s := bufio.NewScanner(bytes.NewReader(list))
+	for s.Scan() {
+		// Each line is a version, optionally followed by a timestamp.
+		f := strings.Fields(s.Text())
+		if len(f) == 0 {
+			continue
+		}
+		if v := versions[f[0]]; v != nil {
+			v.Listed = true
+		}
+	}
+	for _, v := range versions {
+		if v.Info != "" || v.GoMod != "" || v.Zip != "" {
+			m.Versions = append(m.Versions, v)
+		}
+	}
+	SortVersions(m.Versions)
+	return m, nil
+}
+
+// Latest returns the highest cached version of m, preferring release
+// versions over prereleases and pseudo-versions, or nil if m has no
+// valid semantic versions.
+func (m *Module) Latest() *Version {
+	var latest *Version
+	for _, v := range m.Versions {
+		if !semver.IsValid(v.Version) {
+			continue
+		}
+		if semver.Prerelease(v.Version) == "" || latest == nil || semver.Prerelease(latest.Version) != "" {
+			latest = v
+		}
+	}
+	return latest
+}
+
+// Lookup returns the version of m with the given version string,
+// or nil if it is not cached.
+func (m *Module) Lookup(version string) *Version {
+	for _, v := range m.Versions {
+		if v.Version == version {
+			return v
+		}
+	}
+	return nil
+}
+
+// SortVersions sorts versions in increasing semver order.
+// Versions that are not valid semantic versions sort last.
+func SortVersions(versions []*Version) {
+	sort.Slice(versions, func(i, j int) bool {
+		vi, vj := versions[i].Version, versions[j].Version
+		if c := semver.Compare(vi, vj); c != 0 {
+			// Compare treats invalid versions as less than valid ones.
+			if !semver.IsValid(vi) || !semver.IsValid(vj) {
+				return semver.IsValid(vi)
+			}
+			return c < 0
+		}
+		return vi < vj
+	})
+}
+
+// String returns the module@version form of v.
+func (v *Version) String() string {
+	return v.Path + "@" + v.Version
+}
+
+// ReadInfo reads and decodes the .info file of v.
+func (v *Version) ReadInfo() (*Info, error) {
+	if v.Info == "" {
+		return nil, fmt.Errorf("%s: .info file not in cache", v)
+	}
+	data, err := os.ReadFile(v.Info)
+	if err != nil {
+		return nil, err
+	}
+	info := new(Info)
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("%s: %v", v.Info, err)
+	}
+	return info, nil
+}
+
+// ReadGoMod returns the contents of the .mod file of v.
+func (v *Version) ReadGoMod() ([]byte, error) {
+	if v.GoMod == "" {
+		return nil, fmt.Errorf("%s: .mod file not in cache", v)
+	}
+	return os.ReadFile(v.GoMod)
+}
+
+// ReadZipHash returns the h1: hash recorded in the .ziphash file of v.
+func (v *Version) ReadZipHash() (string, error) {
+	if v.ZipHash == "" {
+		return "", fmt.Errorf("%s: .ziphash file not in cache", v)
+	}
+	data, err := os.ReadFile(v.ZipHash)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
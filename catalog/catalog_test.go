@@ -0,0 +1,151 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+func TestModules(t *testing.T) {
+	modCache := t.TempDir()
+	var cacheDir string
+	for _, v := range []struct {
+		path, version string
+	}{
+		{"example.com/a", "v1.0.0"},
+		{"example.com/a", "v1.10.0"},
+		{"example.com/a", "v1.2.0"},
+		{"example.com/a", "v1.11.0-pre"},
+		{"example.com/a", "v0.0.0-20240101000000-abcdefabcdef"},
+		{"example.com/Upper", "v1.0.0-RC"},
+		{"example.com/pre", "v1.0.0-rc.1"},
+		{"example.com/pre", "v1.0.0-rc.2"},
+	} {
+		cacheDir, _ = modtest.WriteModule(t, modCache, v.path, v.version, map[string]string{"go.mod": "module " + v.path + "\n"}, false)
+	}
+	aDir := filepath.Join(cacheDir, "example.com", "a", "@v")
+	modtest.WriteFile(t, filepath.Join(aDir, "list"), "v1.0.0\nv1.2.0 2024-01-01T00:00:00Z\nv9.0.0\n")
+	// In-progress downloads and files the go command did not write are
+	// not versions.
+	modtest.WriteFile(t, filepath.Join(aDir, "v1.3.0.lock"), "")
+	modtest.WriteFile(t, filepath.Join(aDir, "v1.3.0.partial"), "")
+	modtest.WriteFile(t, filepath.Join(aDir, "v1.4.0.ziphash"), "h1:x\n")
+	modtest.WriteFile(t, filepath.Join(cacheDir, "example.com", "BAD", "@v", "v1.0.0.mod"), "module example.com/BAD\n")
+	modtest.WriteFile(t, filepath.Join(cacheDir, "example.com", "empty", "@v", "list"), "")
+	modtest.WriteFile(t, filepath.Join(cacheDir, "sumdb", "sum.golang.org", "lookup", "@v", "v1.0.0.mod"), "")
+
+	c, err := Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mods, err := c.Modules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range mods {
+		var versions []string
+		for _, v := range m.Versions {
+			s := v.Version
+			if v.Listed {
+				s += "(listed)"
+			}
+			versions = append(versions, s)
+		}
+		got = append(got, m.Path+" "+strings.Join(versions, " "))
+	}
+	want := []string{
+		"example.com/Upper v1.0.0-RC",
+		"example.com/a v0.0.0-20240101000000-abcdefabcdef v1.0.0(listed) v1.2.0(listed) v1.10.0 v1.11.0-pre",
+		"example.com/pre v1.0.0-rc.1 v1.0.0-rc.2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Modules:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, tt := range []struct {
+		path   string
+		latest string // "" if the module is not found
+	}{
+		{"example.com/a", "v1.10.0"},
+		{"example.com/Upper", "v1.0.0-RC"},
+		{"example.com/pre", "v1.0.0-rc.2"},
+		{"example.com/upper", ""},
+		{"example.com/empty", ""},
+		{"example.com/none", ""},
+	} {
+		m, err := c.Module(tt.path)
+		if tt.latest == "" {
+			if err == nil {
+				t.Errorf("Module(%s) succeeded, want error", tt.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Module(%s): %v", tt.path, err)
+			continue
+		}
+		if v := m.Latest(); v == nil || v.Version != tt.latest {
+			t.Errorf("Module(%s).Latest() = %v, want %s", tt.path, v, tt.latest)
+		}
+	}
+
+	m, err := c.Module("example.com/Upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := m.Lookup("v1.0.0-RC")
+	if v == nil {
+		t.Fatalf("Lookup(v1.0.0-RC) = nil")
+	}
+	base := filepath.Join(cacheDir, "example.com", "!upper", "@v", "v1.0.0-!r!c")
+	if v.Info != base+".info" || v.GoMod != base+".mod" || v.Zip != base+".zip" || v.ZipHash != base+".ziphash" {
+		t.Errorf("files of %s = %s, %s, %s, %s, want %s.*", v, v.Info, v.GoMod, v.Zip, v.ZipHash, base)
+	}
+	if info, err := v.ReadInfo(); err != nil || info.Version != "v1.0.0-RC" {
+		t.Errorf("ReadInfo = %+v, %v, want version v1.0.0-RC", info, err)
+	}
+	if data, err := v.ReadGoMod(); err != nil || string(data) != "module example.com/Upper\n" {
+		t.Errorf("ReadGoMod = %q, %v", data, err)
+	}
+	if h, err := v.ReadZipHash(); err != nil || !strings.HasPrefix(h, "h1:") || strings.HasSuffix(h, "\n") {
+		t.Errorf("ReadZipHash = %q, %v, want an h1: hash", h, err)
+	}
+	if dir, err := c.SourceDir(v.Path, v.Version); err != nil || dir != filepath.Join(modCache, "example.com", "!upper@v1.0.0-!r!c") {
+		t.Errorf("SourceDir(%s) = %s, %v", v, dir, err)
+	}
+	if m.Lookup("v1.0.0-rc") != nil {
+		t.Errorf("Lookup(v1.0.0-rc) found a version, want nil")
+	}
+
+	// A version whose files are missing reports it.
+	os.Remove(v.Info)
+	v.Info = ""
+	if _, err := v.ReadInfo(); err == nil {
+		t.Errorf("ReadInfo of a version without a .info file succeeded")
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	var versions []*Version
+	for _, s := range []string{"v1.10.0", "bad", "v1.2.0", "v1.2.0-pre", "v0.1.0", "also-bad", "v1.2"} {
+		versions = append(versions, &Version{Version: s})
+	}
+	SortVersions(versions)
+	var got []string
+	for _, v := range versions {
+		got = append(got, v.Version)
+	}
+	// v1.2 is valid, and equal to v1.2.0.
+	want := []string{"v0.1.0", "v1.2.0-pre", "v1.2", "v1.2.0", "v1.10.0", "also-bad", "bad"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SortVersions = %v, want %v", got, want)
+	}
+	if v := (&Module{Versions: versions[5:]}).Latest(); v != nil {
+		t.Errorf("Latest of invalid versions = %v, want nil", v)
+	}
+}
@@ -0,0 +1,90 @@
+package catalog
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PackageDirs returns the directories of the module source in srcDir
+// that may hold packages, or, if z is not nil, those of the source in
+// z, whose root is srcDir. As in the go command, directories named
+// testdata or vendor or beginning with . or _ are skipped, as are
+// nested modules.
+func PackageDirs(srcDir string, z *ZipDir) ([]string, error) {
+	if z != nil {
+		return zipPackageDirs(z, srcDir), nil
+	}
+	var dirs []string
+	err := filepath.WalkDir(srcDir, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if dir != srcDir {
+			if skipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			// Nested modules are separate entries in the cache.
+			if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+		}
+		dirs = append(dirs, dir)
+		return nil
+	})
+	return dirs, err
+}
+
+// zipPackageDirs returns the directories of the files in z that may
+// hold packages, in the order PackageDirs walks an extracted tree.
+// Module zip files do not hold nested modules.
+func zipPackageDirs(z *ZipDir, srcDir string) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+Files:
+	for _, name := range z.Files() {
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		dir := filepath.Dir(name)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		rel, err := filepath.Rel(srcDir, dir)
+		if err != nil {
+			continue
+		}
+		if rel != "." {
+			for _, elem := range strings.Split(filepath.ToSlash(rel), "/") {
+				if skipDir(elem) {
+					continue Files
+				}
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+	// Sort parents before their children, and siblings by name.
+	sort.Slice(dirs, func(i, j int) bool {
+		ei := strings.Split(filepath.ToSlash(dirs[i]), "/")
+		ej := strings.Split(filepath.ToSlash(dirs[j]), "/")
+		for k := 0; k < len(ei) && k < len(ej); k++ {
+			if ei[k] != ej[k] {
+				return ei[k] < ej[k]
+			}
+		}
+		return len(ei) < len(ej)
+	})
+	return dirs
+}
+
+// skipDir reports whether the go command ignores directories named elem
+// when matching packages.
+func skipDir(elem string) bool {
+	return elem == "testdata" || elem == "vendor" || strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_")
+}
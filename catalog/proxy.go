@@ -0,0 +1,145 @@
+package catalog
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// A Proxy is an http.Handler serving the module versions in a
+// download cache using the GOPROXY protocol (see "go help goproxy"),
+// so that the go command can download modules from a machine with a
+// warm cache:
+//
+//	GOPROXY=http://host:3000 go mod download
+//
+// It serves $module/@v/list, $module/@v/$version.info, .mod and .zip,
+// and $module/@latest, with module paths and versions case-escaped as
+// in the download cache. Anything not in the cache is reported as
+// 404 Not Found, so that the go command moves on to the next proxy in
+// GOPROXY, if any. The checksum database is not proxied; clients
+// without the hashes in go.sum need GONOSUMDB or GOSUMDB=off.
+type Proxy struct {
+	c *Catalog
+}
+
+// NewProxy returns a Proxy serving the modules in c.
+func NewProxy(c *Catalog) *Proxy {
+	return &Proxy{c: c}
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	urlPath := strings.TrimPrefix(r.URL.Path, "/")
+	if escPath := strings.TrimSuffix(urlPath, "/@latest"); escPath != urlPath {
+		m := p.module(w, escPath)
+		if m == nil {
+			return
+		}
+		// @latest reports the version a query for "latest" would
+		// resolve to, which needs a .info file to serve.
+		var withInfo []*Version
+		for _, v := range m.Versions {
+			if v.Info != "" {
+				withInfo = append(withInfo, v)
+			}
+		}
+		latest := (&Module{Versions: withInfo}).Latest()
+		if latest == nil {
+			http.Error(w, "not found: no .info files for "+m.Path, http.StatusNotFound)
+			return
+		}
+		p.serveFile(w, r, latest.Info, "application/json")
+		return
+	}
+
+	i := strings.LastIndex(urlPath, "/@v/")
+	if i < 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	m := p.module(w, urlPath[:i])
+	if m == nil {
+		return
+	}
+	file := urlPath[i+len("/@v/"):]
+	if file == "list" {
+		var buf bytes.Buffer
+		for _, v := range m.Versions {
+			// Like proxy.golang.org, list only tagged versions:
+			// pseudo-versions are found by query, not listed.
+			if v.GoMod != "" && !module.IsPseudoVersion(v.Version) {
+				buf.WriteString(v.Version + "\n")
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(buf.Bytes())
+		return
+	}
+	ext := path.Ext(file)
+	version, err := module.UnescapeVersion(strings.TrimSuffix(file, ext))
+	if err != nil {
+		http.Error(w, "not found: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	v := m.Lookup(version)
+	if v == nil || !semver.IsValid(version) {
+		http.Error(w, "not found: "+m.Path+"@"+version+" is not in the module cache", http.StatusNotFound)
+		return
+	}
+	switch ext {
+	case ".info":
+		p.serveFile(w, r, v.Info, "application/json")
+	case ".mod":
+		p.serveFile(w, r, v.GoMod, "text/plain; charset=utf-8")
+	case ".zip":
+		p.serveFile(w, r, v.Zip, "application/zip")
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// module returns the cached module with the escaped path escPath,
+// or writes a 404 response and returns nil.
+func (p *Proxy) module(w http.ResponseWriter, escPath string) *Module {
+	modPath, err := module.UnescapePath(escPath)
+	if err != nil {
+		http.Error(w, "not found: "+err.Error(), http.StatusNotFound)
+		return nil
+	}
+	m, err := p.c.Module(modPath)
+	if err != nil {
+		http.Error(w, "not found: "+modPath+" is not in the module cache", http.StatusNotFound)
+		return nil
+	}
+	return m
+}
+
+// serveFile serves the named file, which is empty if it is not cached.
+func (p *Proxy) serveFile(w http.ResponseWriter, r *http.Request, name, contentType string) {
+	if name == "" {
+		http.Error(w, "not found: "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		http.Error(w, "not found: "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
@@ -0,0 +1,191 @@
+package catalog
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const pseudoVersion = "v1.1.1-0.20240101000000-abcdefabcdef"
+
+// newProxyCache returns a module cache holding versions of example.com/m
+// and example.com/Upper, and the Catalog of its download cache.
+// example.com/m@v0.9.0 has only its .mod file, as when the go command
+// needed only the module graph.
+func newProxyCache(t *testing.T) (*Catalog, string) {
+	modCache := t.TempDir()
+	for _, v := range []string{"v0.9.0", "v1.0.0", "v1.1.0", "v1.2.0-pre", pseudoVersion} {
+		writeModule(t, modCache, "example.com/m", v, map[string]string{
+			"go.mod": "module example.com/m\n\ngo 1.18\n",
+			"m.go":   "package m\n\nconst Version = \"" + v + "\"\n",
+		})
+	}
+	writeModule(t, modCache, "example.com/Upper", "v1.0.0", map[string]string{
+		"go.mod": "module example.com/Upper\n",
+		"u.go":   "package upper\n",
+	})
+	base := filepath.Join(modCache, "cache", "download", "example.com", "m", "@v", "v0.9.0")
+	for _, ext := range []string{".info", ".zip", ".ziphash"} {
+		if err := os.Remove(base + ext); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := Open(filepath.Join(modCache, "cache", "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, modCache
+}
+
+func TestProxy(t *testing.T) {
+	c, modCache := newProxyCache(t)
+	srv := httptest.NewServer(NewProxy(c))
+	defer srv.Close()
+	cached := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(modCache, "cache", "download", filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		code   int
+		body   string // if code is 200
+	}{
+		{"GET", "/example.com/m/@v/list", 200, "v0.9.0\nv1.0.0\nv1.1.0\nv1.2.0-pre\n"},
+		{"GET", "/example.com/m/@latest", 200, cached("example.com/m/@v/v1.1.0.info")},
+		{"GET", "/example.com/m/@v/v1.0.0.info", 200, cached("example.com/m/@v/v1.0.0.info")},
+		{"GET", "/example.com/m/@v/v1.0.0.mod", 200, cached("example.com/m/@v/v1.0.0.mod")},
+		{"GET", "/example.com/m/@v/v1.0.0.zip", 200, cached("example.com/m/@v/v1.0.0.zip")},
+		{"GET", "/example.com/m/@v/" + pseudoVersion + ".info", 200, cached("example.com/m/@v/" + pseudoVersion + ".info")},
+		{"GET", "/example.com/m/@v/v0.9.0.mod", 200, cached("example.com/m/@v/v0.9.0.mod")},
+		{"HEAD", "/example.com/m/@v/v1.0.0.mod", 200, ""},
+		{"GET", "/example.com/!upper/@v/list", 200, "v1.0.0\n"},
+		{"GET", "/example.com/!upper/@v/v1.0.0.mod", 200, cached("example.com/!upper/@v/v1.0.0.mod")},
+		{"GET", "/example.com/Upper/@v/list", 404, ""},
+		{"GET", "/example.com/m/@v/v0.9.0.info", 404, ""},
+		{"GET", "/example.com/m/@v/v0.9.0.zip", 404, ""},
+		{"GET", "/example.com/m/@v/v2.0.0.mod", 404, ""},
+		{"GET", "/example.com/m/@v/v1.0.0.ziphash", 404, ""},
+		{"GET", "/example.com/m/@v/latest.info", 404, ""},
+		{"GET", "/example.com/m/@v/", 404, ""},
+		{"GET", "/example.com/m", 404, ""},
+		{"GET", "/example.com/none/@v/list", 404, ""},
+		{"GET", "/example.com/none/@latest", 404, ""},
+		{"POST", "/example.com/m/@v/list", 405, ""},
+	} {
+		req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.code)
+			continue
+		}
+		if tt.code == 200 && string(body) != tt.body {
+			t.Errorf("%s %s: body %q, want %q", tt.method, tt.path, body, tt.body)
+		}
+	}
+}
+
+// TestProxyDownload runs "go mod download" against a Proxy, and checks
+// that the go command downloads the cached module versions unchanged.
+func TestProxyDownload(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the go command")
+	}
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip(err)
+	}
+	c, _ := newProxyCache(t)
+	srv := httptest.NewServer(NewProxy(c))
+	defer srv.Close()
+
+	download := func(queries ...string) ([]byte, error) {
+		cmd := exec.Command(gocmd, append([]string{"mod", "download", "-json"}, queries...)...)
+		cmd.Dir = t.TempDir()
+		cmd.Env = append(os.Environ(),
+			"GOPROXY="+srv.URL,
+			"GOMODCACHE="+t.TempDir(),
+			"GOFLAGS=-modcacherw",
+			"GOSUMDB=off",
+			"GONOPROXY=",
+			"GOPRIVATE=",
+			"GOTOOLCHAIN=local",
+			"GOWORK=off",
+			"GO111MODULE=on",
+		)
+		return cmd.Output()
+	}
+
+	out, err := download("example.com/m@latest", "example.com/m@v1.0.0", "example.com/m@"+pseudoVersion, "example.com/Upper@v1.0.0")
+	if err != nil {
+		t.Fatalf("go mod download: %v\n%s", err, out)
+	}
+	var got []string
+	dec := json.NewDecoder(strings.NewReader(string(out)))
+	for dec.More() {
+		var d struct {
+			Path, Version, Error string
+			Sum, GoModSum        string
+		}
+		if err := dec.Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, d.Path+"@"+d.Version)
+		if d.Error != "" || d.Sum == "" || d.GoModSum == "" {
+			t.Errorf("%s@%s: sums %q and %q, error %q", d.Path, d.Version, d.Sum, d.GoModSum, d.Error)
+			continue
+		}
+		m, err := c.Module(d.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := m.Lookup(d.Version)
+		if v == nil {
+			t.Errorf("downloaded %s@%s, which is not in the cache", d.Path, d.Version)
+			continue
+		}
+		r, err := c.Verify(v, GoSum{
+			d.Path + " " + d.Version:             d.Sum,
+			d.Path + " " + d.Version + "/go.mod": d.GoModSum,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range r.Errors() {
+			t.Errorf("%s@%s: %s", d.Path, d.Version, e)
+		}
+	}
+	want := []string{"example.com/m@v1.1.0", "example.com/m@v1.0.0", "example.com/m@" + pseudoVersion, "example.com/Upper@v1.0.0"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("go mod download downloaded %q, want %q", got, want)
+	}
+
+	// Versions without a zip file are not downloadable, and nor are
+	// modules not in the cache.
+	for _, q := range []string{"example.com/m@v0.9.0", "example.com/none@v1.0.0"} {
+		if out, err := download(q); err == nil {
+			t.Errorf("go mod download %s succeeded, want error\n%s", q, out)
+		}
+	}
+}
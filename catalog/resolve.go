@@ -0,0 +1,144 @@
+package catalog
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// A Package is an import path resolved to the cached module version
+// that provides it.
+type Package struct {
+	ImportPath string   // import path of the package
+	Version    *Version // module version providing the package
+	Dir        string   // directory holding the package
+	Zipped     bool     // Dir is not extracted; read it with OpenZip
+}
+
+// Resolve finds the module that provides the package importPath.
+//
+// The owning module is the cached module with the longest path that is
+// a prefix of importPath and that has a version containing the package,
+// either in its extracted directory or, if the version has not been
+// extracted, in its zip file. If version is empty or "latest", the
+// highest such version is used; otherwise only that version is
+// considered.
+func (c *Catalog) Resolve(importPath, version string) (*Package, error) {
+	if err := module.CheckImportPath(importPath); err != nil {
+		return nil, err
+	}
+	if version == "latest" {
+		version = ""
+	}
+	for modPath := importPath; modPath != "." && modPath != "/"; modPath = path.Dir(modPath) {
+		if _, _, ok := module.SplitPathVersion(modPath); !ok {
+			// A gopkg.in path without its .vN suffix, for example.
+			continue
+		}
+		m, err := c.Module(modPath)
+		if err != nil {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(importPath, modPath), "/")
+		if pkg := c.resolveIn(m, importPath, rel, version); pkg != nil {
+			return pkg, nil
+		}
+	}
+	if version != "" {
+		return nil, fmt.Errorf("no cached module provides package %s@%s", importPath, version)
+	}
+	return nil, fmt.Errorf("no cached module provides package %s", importPath)
+}
+
+// resolveIn returns the package rel within the highest version of m
+// that contains it, considering only version if it is non-empty.
+// Release versions are preferred over prereleases and pseudo-versions.
+func (c *Catalog) resolveIn(m *Module, importPath, rel, version string) *Package {
+	for _, release := range []bool{true, false} {
+		for i := len(m.Versions) - 1; i >= 0; i-- {
+			v := m.Versions[i]
+			if version != "" && v.Version != version {
+				continue
+			}
+			if (semver.Prerelease(v.Version) == "") != release {
+				continue
+			}
+			// Check rejects versions whose major version does not
+			// match the module path, such as v2.0.0 of a module
+			// without a /v2 suffix, or v3 of gopkg.in/yaml.v2.
+			if module.Check(v.Path, v.Version) != nil {
+				continue
+			}
+			srcDir, err := c.SourceDir(v.Path, v.Version)
+			if err != nil {
+				continue
+			}
+			dir := filepath.Join(srcDir, filepath.FromSlash(rel))
+			if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+				return &Package{ImportPath: importPath, Version: v, Dir: dir}
+			}
+			if _, err := os.Stat(srcDir); err == nil || v.Zip == "" {
+				continue
+			}
+			if z, err := c.OpenZip(v); err == nil {
+				ok := z.IsDir(dir)
+				z.Close()
+				if ok {
+					return &Package{ImportPath: importPath, Version: v, Dir: dir, Zipped: true}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ParseSourcePath reports whether file is inside the extracted copy of
+// a module version in the module cache, or where it would be if the
+// version were extracted. If so, it returns the module
+// path and version and the slash-separated path of file relative to the
+// module root.
+func (c *Catalog) ParseSourcePath(file string) (path, version, rel string, ok bool) {
+	modRoot := filepath.Dir(filepath.Dir(c.dir))
+	r, err := filepath.Rel(modRoot, file)
+	if err != nil {
+		return "", "", "", false
+	}
+	r = filepath.ToSlash(r)
+	i := strings.Index(r, "@")
+	if i < 0 {
+		return "", "", "", false
+	}
+	escPath, r := r[:i], r[i+1:]
+	escVersion := r
+	if j := strings.Index(r, "/"); j >= 0 {
+		escVersion, rel = r[:j], r[j+1:]
+	}
+	path, err = module.UnescapePath(escPath)
+	if err != nil {
+		return "", "", "", false
+	}
+	version, err = module.UnescapeVersion(escVersion)
+	if err != nil {
+		return "", "", "", false
+	}
+	return path, version, rel, true
+}
+
+// LatestSource returns the highest version of the module path whose
+// source is in the module cache, extracted or as a zip file, preferring
+// release versions.
+func (c *Catalog) LatestSource(path string) (*Version, error) {
+	m, err := c.Module(path)
+	if err != nil {
+		return nil, err
+	}
+	if pkg := c.resolveIn(m, path, "", ""); pkg != nil {
+		return pkg.Version, nil
+	}
+	return nil, fmt.Errorf("no version of %s with source in the module cache", path)
+}
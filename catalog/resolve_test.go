@@ -0,0 +1,117 @@
+package catalog
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+func TestResolve(t *testing.T) {
+	modCache := t.TempDir()
+	var cacheDir string
+	for _, m := range []struct {
+		path, version string
+		files         []string
+		extract       bool
+	}{
+		{"example.com/m", "v1.0.0", []string{"m.go", "old/o.go", "sub/s.go", "sub/deep/d.go"}, true},
+		{"example.com/m", "v1.1.0", []string{"m.go", "new/n.go", "sub/s.go"}, false},
+		{"example.com/m", "v1.2.0-pre", []string{"m.go", "preonly/p.go"}, true},
+		// Not a valid version of example.com/m.
+		{"example.com/m", "v2.0.0", []string{"m.go", "v2only/v.go"}, true},
+		{"example.com/m/sub", "v1.5.0", []string{"s.go"}, true},
+		{"example.com/m/v2", "v2.0.0", []string{"m.go"}, false},
+		{"gopkg.in/yaml.v3", "v3.0.1", []string{"yaml.go"}, true},
+	} {
+		files := map[string]string{"go.mod": "module " + m.path + "\n"}
+		for _, name := range m.files {
+			files[name] = "package " + filepath.Base(filepath.Dir(name)) + "\n"
+		}
+		cacheDir, _ = modtest.WriteModule(t, modCache, m.path, m.version, files, m.extract)
+	}
+	c, err := Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		importPath, version string
+		want                string // "" if an error is wanted
+	}{
+		// Release versions are preferred, and v2.0.0 is not a
+		// version of example.com/m.
+		{"example.com/m", "", "example.com/m@v1.1.0 . zipped"},
+		{"example.com/m", "latest", "example.com/m@v1.1.0 . zipped"},
+		{"example.com/m", "v1.0.0", "example.com/m@v1.0.0 . extracted"},
+		{"example.com/m", "v2.0.0", ""},
+		{"example.com/m", "v9.0.0", ""},
+		{"example.com/m/new", "", "example.com/m@v1.1.0 new zipped"},
+		{"example.com/m/old", "", "example.com/m@v1.0.0 old extracted"},
+		{"example.com/m/preonly", "", "example.com/m@v1.2.0-pre preonly extracted"},
+		{"example.com/m/v2only", "", ""},
+		// The longest module path providing the package wins.
+		{"example.com/m/sub", "", "example.com/m/sub@v1.5.0 . extracted"},
+		{"example.com/m/sub/deep", "", "example.com/m@v1.0.0 sub/deep extracted"},
+		{"example.com/m/v2", "", "example.com/m/v2@v2.0.0 . zipped"},
+		{"gopkg.in/yaml.v3", "", "gopkg.in/yaml.v3@v3.0.1 . extracted"},
+		{"example.com/none", "", ""},
+		{"example.com/m/none", "", ""},
+		{"not a path", "", ""},
+	} {
+		pkg, err := c.Resolve(tt.importPath, tt.version)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Resolve(%q, %q) = %s, want error", tt.importPath, tt.version, pkg.Version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%q, %q): %v", tt.importPath, tt.version, err)
+			continue
+		}
+		srcDir, err := c.SourceDir(pkg.Version.Path, pkg.Version.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, err := filepath.Rel(srcDir, pkg.Dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		how := "extracted"
+		if pkg.Zipped {
+			how = "zipped"
+		}
+		got := fmt.Sprintf("%s %s %s", pkg.Version, filepath.ToSlash(rel), how)
+		if got != tt.want || pkg.ImportPath != tt.importPath {
+			t.Errorf("Resolve(%q, %q) = %s %s, want %s", tt.importPath, tt.version, pkg.ImportPath, got, tt.want)
+		}
+	}
+
+	if v, err := c.LatestSource("example.com/m"); err != nil || v.Version != "v1.1.0" {
+		t.Errorf("LatestSource(example.com/m) = %v, %v, want v1.1.0", v, err)
+	}
+}
+
+func TestParseSourcePath(t *testing.T) {
+	modCache := t.TempDir()
+	cacheDir := filepath.Join(modCache, "cache", "download")
+	c := &Catalog{dir: cacheDir}
+	for _, tt := range []struct {
+		file               string
+		path, version, rel string
+		ok                 bool
+	}{
+		{"example.com/!m@v1.0.0-!r!c/a/b.go", "example.com/M", "v1.0.0-RC", "a/b.go", true},
+		{"example.com/m@v1.0.0", "example.com/m", "v1.0.0", "", true},
+		{"example.com/m/b.go", "", "", "", false},
+		{"example.com/M@v1.0.0/b.go", "", "", "", false},
+		{"../other/example.com/m@v1.0.0/b.go", "", "", "", false},
+	} {
+		path, version, rel, ok := c.ParseSourcePath(filepath.Join(modCache, filepath.FromSlash(tt.file)))
+		if path != tt.path || version != tt.version || rel != tt.rel || ok != tt.ok {
+			t.Errorf("ParseSourcePath(%s) = %q, %q, %q, %v, want %q, %q, %q, %v", tt.file, path, version, rel, ok, tt.path, tt.version, tt.rel, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package catalog
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/julieqiu/modcache/load"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// A GoSum holds the h1: hashes listed in a go.sum file, keyed by
+// "path version" for module contents and "path version/go.mod" for
+// go.mod files.
+type GoSum map[string]string
+
+// ReadGoSum reads the go.sum file.
+func ReadGoSum(file string) (GoSum, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	sum := make(GoSum)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; s.Scan(); lineno++ {
+		f := strings.Fields(s.Text())
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 3 {
+			return nil, fmt.Errorf("%s:%d: malformed line", file, lineno)
+		}
+		if strings.HasPrefix(f[2], "h1:") {
+			sum[f[0]+" "+f[1]] = f[2]
+		}
+	}
+	return sum, s.Err()
+}
+
+// A Verification is the result of verifying a module version in the
+// cache. Hashes are h1: hashes as computed by dirhash.Hash1, and are
+// empty when the corresponding file or directory is not present.
+type Verification struct {
+	Version *Version
+
+	ZipHash  string // recorded in the .ziphash file
+	GoSum    string // recorded in go.sum for the module contents
+	GoSumMod string // recorded in go.sum for the go.mod file
+
+	Zip   string // computed from the zip file
+	Dir   string // computed from the extracted directory
+	GoMod string // computed from the .mod file
+
+	// Files of the extracted directory that differ from the zip file,
+	// as slash-separated paths relative to the module root.
+	Modified []string // contents differ
+	Missing  []string // in the zip file but not the directory
+	Extra    []string // in the directory but not the zip file
+}
+
+// Errors returns descriptions of the problems found,
+// or nil if the module version verified.
+func (r *Verification) Errors() []string {
+	var errs []string
+	if r.ZipHash != "" && r.Zip != "" && r.Zip != r.ZipHash {
+		errs = append(errs, fmt.Sprintf("zip has hash %s, but .ziphash records %s", r.Zip, r.ZipHash))
+	}
+	want := r.ZipHash
+	if want == "" {
+		want = r.Zip
+	}
+	if r.Dir != "" && want != "" && r.Dir != want {
+		errs = append(errs, fmt.Sprintf("extracted directory has hash %s, want %s", r.Dir, want))
+	}
+	if r.GoSum != "" {
+		for _, h := range []struct{ what, hash string }{
+			{".ziphash", r.ZipHash},
+			{"zip", r.Zip},
+			{"extracted directory", r.Dir},
+		} {
+			if h.hash != "" && h.hash != r.GoSum {
+				errs = append(errs, fmt.Sprintf("%s has hash %s, but go.sum records %s", h.what, h.hash, r.GoSum))
+			}
+		}
+	}
+	if r.GoSumMod != "" && r.GoMod != "" && r.GoMod != r.GoSumMod {
+		errs = append(errs, fmt.Sprintf("go.mod has hash %s, but go.sum records %s", r.GoMod, r.GoSumMod))
+	}
+	for _, f := range r.Modified {
+		errs = append(errs, "modified: "+f)
+	}
+	for _, f := range r.Missing {
+		errs = append(errs, "missing: "+f)
+	}
+	for _, f := range r.Extra {
+		errs = append(errs, "extra: "+f)
+	}
+	return errs
+}
+
+// Verify recomputes the hashes of the zip file, extracted directory and
+// .mod file of v and compares them with the hash recorded in the
+// .ziphash file and, if sum is non-nil, with those recorded in go.sum.
+// If both the zip file and the extracted directory are present, Verify
+// also compares them file by file.
+//
+// Files in the extracted directory are hashed using load.FileHash,
+// so each is read at most once per process.
+func (c *Catalog) Verify(v *Version, sum GoSum) (*Verification, error) {
+	r := &Verification{Version: v}
+	if sum != nil {
+		r.GoSum = sum[v.Path+" "+v.Version]
+		r.GoSumMod = sum[v.Path+" "+v.Version+"/go.mod"]
+	}
+	if h, err := v.ReadZipHash(); err == nil {
+		r.ZipHash = h
+	}
+	if v.GoMod != "" {
+		h, err := load.FileHash(v.GoMod)
+		if err != nil {
+			return nil, err
+		}
+		r.GoMod = hash1(map[string][sha256.Size]byte{"go.mod": h})
+	}
+
+	prefix := v.Path + "@" + v.Version + "/"
+	var zipFiles map[string][sha256.Size]byte
+	if v.Zip != "" {
+		var err error
+		if zipFiles, err = hashZip(v.Zip); err != nil {
+			return nil, err
+		}
+		r.Zip = hash1(zipFiles)
+	}
+
+	srcDir, err := c.SourceDir(v.Path, v.Version)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(srcDir); err != nil {
+		return r, nil
+	}
+	names, err := dirhash.DirFiles(srcDir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	dirFiles := make(map[string][sha256.Size]byte)
+	for _, name := range names {
+		file := filepath.Join(srcDir, filepath.FromSlash(strings.TrimPrefix(name, prefix)))
+		h, err := load.FileHash(file)
+		if err != nil {
+			return nil, err
+		}
+		dirFiles[name] = h
+	}
+	r.Dir = hash1(dirFiles)
+
+	if zipFiles != nil && r.Dir != r.Zip {
+		for name, h := range zipFiles {
+			rel := strings.TrimPrefix(name, prefix)
+			if dh, ok := dirFiles[name]; !ok {
+				r.Missing = append(r.Missing, rel)
+			} else if dh != h {
+				r.Modified = append(r.Modified, rel)
+			}
+		}
+		for name := range dirFiles {
+			if _, ok := zipFiles[name]; !ok {
+				r.Extra = append(r.Extra, strings.TrimPrefix(name, prefix))
+			}
+		}
+		sort.Strings(r.Modified)
+		sort.Strings(r.Missing)
+		sort.Strings(r.Extra)
+	}
+	return r, nil
+}
+
+// hashZip returns the SHA-256 hashes of the files in the zip file,
+// keyed by name.
+func hashZip(file string) (map[string][sha256.Size]byte, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	files := make(map[string][sha256.Size]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", file, f.Name, err)
+		}
+		var sum [sha256.Size]byte
+		h.Sum(sum[:0])
+		files[f.Name] = sum
+	}
+	return files, nil
+}
+
+// hash1 returns the h1: hash of the files with the given SHA-256
+// hashes. It is dirhash.Hash1, but using hashes computed in advance.
+func hash1(files map[string][sha256.Size]byte) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%x  %s\n", files[name], name)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
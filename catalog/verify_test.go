@@ -0,0 +1,176 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+// The files of example.com/m@v1.0.0, and their h1: hashes, as computed
+// by dirhash.HashZip and recorded in go.sum by the go command.
+var (
+	verifyFiles = map[string]string{
+		"go.mod":    "module example.com/m\n\ngo 1.18\n",
+		"m.go":      "package m\n\nconst X = 1\n",
+		"sub/s.go":  "package sub\n",
+		"README.md": "m\n",
+	}
+	verifyZipHash = "h1:V4UJ64hgbE32re4SeASeT//0NVI4buePK7Eofs0yCqE="
+	verifyModHash = "h1:PffO4q3vfsQn/guMkOHT60YC+0vBC4XTh4FxDd18Q7A="
+)
+
+// writeModule writes the .info, .mod, .zip and .ziphash files of the
+// module version path@version holding files to the module cache
+// modCache, and extracts it there. It returns the Catalog of the
+// download cache and the extracted directory.
+func writeModule(t *testing.T, modCache, path, version string, files map[string]string) (*Catalog, string) {
+	cacheDir, srcDir := modtest.WriteModule(t, modCache, path, version, files, true)
+	c, err := Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, srcDir
+}
+
+func TestVerify(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		edit  func(t *testing.T, v *Version, srcDir string)
+		sum   GoSum
+		want  Verification // compared, but for Version
+		nerrs int          // number of Errors
+	}{
+		{
+			name: "ok",
+			want: Verification{ZipHash: verifyZipHash, Zip: verifyZipHash, Dir: verifyZipHash, GoMod: verifyModHash},
+		},
+		{
+			name: "go.sum",
+			sum: GoSum{
+				"example.com/m v1.0.0":        verifyZipHash,
+				"example.com/m v1.0.0/go.mod": verifyModHash,
+			},
+			want: Verification{
+				ZipHash: verifyZipHash, GoSum: verifyZipHash, GoSumMod: verifyModHash,
+				Zip: verifyZipHash, Dir: verifyZipHash, GoMod: verifyModHash,
+			},
+		},
+		{
+			name: "go.sum mismatch",
+			sum: GoSum{
+				"example.com/m v1.0.0":        "h1:bad",
+				"example.com/m v1.0.0/go.mod": "h1:badmod",
+			},
+			want: Verification{
+				ZipHash: verifyZipHash, GoSum: "h1:bad", GoSumMod: "h1:badmod",
+				Zip: verifyZipHash, Dir: verifyZipHash, GoMod: verifyModHash,
+			},
+			nerrs: 4, // .ziphash, zip and directory against go.sum, and go.mod
+		},
+		{
+			name: "ziphash mismatch",
+			edit: func(t *testing.T, v *Version, srcDir string) {
+				os.WriteFile(v.ZipHash, []byte("h1:wrong\n"), 0666)
+			},
+			want:  Verification{ZipHash: "h1:wrong", Zip: verifyZipHash, Dir: verifyZipHash, GoMod: verifyModHash},
+			nerrs: 2, // zip and directory against .ziphash
+		},
+		{
+			name: "modified",
+			edit: func(t *testing.T, v *Version, srcDir string) {
+				os.WriteFile(filepath.Join(srcDir, "m.go"), []byte("package m\n\nconst X = 2\n"), 0666)
+				os.Remove(filepath.Join(srcDir, "sub", "s.go"))
+				os.WriteFile(filepath.Join(srcDir, "extra.go"), []byte("package m\n"), 0666)
+			},
+			want: Verification{
+				ZipHash: verifyZipHash, Zip: verifyZipHash, Dir: "changed", GoMod: verifyModHash,
+				Modified: []string{"m.go"}, Missing: []string{"sub/s.go"}, Extra: []string{"extra.go"},
+			},
+			nerrs: 4,
+		},
+		{
+			name: "not extracted",
+			edit: func(t *testing.T, v *Version, srcDir string) {
+				os.RemoveAll(srcDir)
+			},
+			want: Verification{ZipHash: verifyZipHash, Zip: verifyZipHash, GoMod: verifyModHash},
+		},
+		{
+			name: "no zip",
+			edit: func(t *testing.T, v *Version, srcDir string) {
+				os.Remove(v.Zip)
+				v.Zip = ""
+			},
+			want: Verification{ZipHash: verifyZipHash, Dir: verifyZipHash, GoMod: verifyModHash},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, srcDir := writeModule(t, t.TempDir(), "example.com/m", "v1.0.0", verifyFiles)
+			m, err := c.Module("example.com/m")
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := m.Lookup("v1.0.0")
+			if tt.edit != nil {
+				tt.edit(t, v, srcDir)
+			}
+			r, err := c.Verify(v, tt.sum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := *r
+			got.Version = nil
+			if tt.want.Dir == "changed" {
+				if got.Dir == "" || got.Dir == verifyZipHash {
+					t.Errorf("Dir = %q, want a hash other than %s", got.Dir, verifyZipHash)
+				}
+				got.Dir = "changed"
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Verify = %+v, want %+v", got, tt.want)
+			}
+			if errs := r.Errors(); len(errs) != tt.nerrs {
+				t.Errorf("Errors() = %q, want %d errors", errs, tt.nerrs)
+			}
+		})
+	}
+}
+
+// TestVerifyGoSum verifies the version of golang.org/x/mod this module
+// requires against the hashes in its go.sum file, if the version is in
+// the module cache.
+func TestVerifyGoSum(t *testing.T) {
+	sum, err := ReadGoSum(filepath.Join("..", "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	modCache := os.Getenv("GOMODCACHE")
+	if modCache == "" {
+		modCache = filepath.Join(os.Getenv("HOME"), "go", "pkg", "mod")
+	}
+	c, err := Open(filepath.Join(modCache, "cache", "download"))
+	if err != nil {
+		t.Skip(err)
+	}
+	m, err := c.Module("golang.org/x/mod")
+	if err != nil {
+		t.Skip(err)
+	}
+	v := m.Lookup("v0.20.0")
+	if v == nil || v.Zip == "" {
+		t.Skip("golang.org/x/mod@v0.20.0 not in the module cache")
+	}
+	r, err := c.Verify(v, sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.GoSum == "" || r.Zip != r.GoSum || r.GoMod != r.GoSumMod {
+		t.Errorf("Verify = %+v, want zip and go.mod hashes matching go.sum", r)
+	}
+	if errs := r.Errors(); len(errs) > 0 {
+		t.Errorf("Errors() = %q", errs)
+	}
+}
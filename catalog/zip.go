@@ -0,0 +1,193 @@
+package catalog
+
+import (
+	"archive/zip"
+	"fmt"
+	"go/build"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A ZipDir presents the contents of a module version's zip file as the
+// directory tree that the version would be extracted to, so that its
+// packages can be read and indexed when only the download cache is
+// present, as after "go mod download" in a minimal CI image.
+//
+// File names passed to a ZipDir's methods are those the files would
+// have if extracted: the version's source directory, as returned by
+// SourceDir, joined with the file's path within the module.
+type ZipDir struct {
+	root  string
+	zr    *zip.ReadCloser
+	files map[string]*zip.File     // by slash-separated path relative to root
+	dirs  map[string][]fs.FileInfo // by slash-separated path relative to root; "." for root
+}
+
+// OpenZip opens the zip file of v as a ZipDir rooted at v's
+// source directory.
+func (c *Catalog) OpenZip(v *Version) (*ZipDir, error) {
+	if v.Zip == "" {
+		return nil, fmt.Errorf("%s: no zip file in the module cache", v)
+	}
+	root, err := c.SourceDir(v.Path, v.Version)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.OpenReader(v.Zip)
+	if err != nil {
+		return nil, err
+	}
+	z := &ZipDir{
+		root:  root,
+		zr:    zr,
+		files: make(map[string]*zip.File),
+		dirs:  map[string][]fs.FileInfo{".": nil},
+	}
+	prefix := v.Path + "@" + v.Version + "/"
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, prefix) || strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		rel := f.Name[len(prefix):]
+		z.files[rel] = f
+		z.addEntry(rel, f.FileInfo())
+	}
+	for _, list := range z.dirs {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	}
+	return z, nil
+}
+
+// addEntry adds info, describing the file or directory rel, to the
+// listing of its parent directory, adding the parent itself if needed.
+func (z *ZipDir) addEntry(rel string, info fs.FileInfo) {
+	parent := path.Dir(rel)
+	list, ok := z.dirs[parent]
+	z.dirs[parent] = append(list, info)
+	if !ok && parent != "." {
+		z.addEntry(parent, zipDirInfo(path.Base(parent)))
+	}
+}
+
+// Root returns the directory that z presents the zip file's contents in.
+func (z *ZipDir) Root() string {
+	return z.root
+}
+
+// Close closes the zip file.
+func (z *ZipDir) Close() error {
+	return z.zr.Close()
+}
+
+// rel returns the slash-separated path of name relative to z's root,
+// reporting whether name is inside it.
+func (z *ZipDir) rel(name string) (string, bool) {
+	r, err := filepath.Rel(z.root, name)
+	if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(r), true
+}
+
+// Files returns the names of all the files in z, sorted.
+func (z *ZipDir) Files() []string {
+	var names []string
+	for rel := range z.files {
+		names = append(names, filepath.Join(z.root, filepath.FromSlash(rel)))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsDir reports whether name is a directory in z.
+func (z *ZipDir) IsDir(name string) bool {
+	rel, ok := z.rel(name)
+	if !ok {
+		return false
+	}
+	_, ok = z.dirs[rel]
+	return ok
+}
+
+// ReadDir returns the entries of the directory name, sorted by name.
+func (z *ZipDir) ReadDir(name string) ([]fs.FileInfo, error) {
+	rel, ok := z.rel(name)
+	if list, isDir := z.dirs[rel]; ok && isDir {
+		return list, nil
+	}
+	return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+}
+
+// OpenFile opens the file name for reading.
+func (z *ZipDir) OpenFile(name string) (io.ReadCloser, error) {
+	rel, ok := z.rel(name)
+	if f, isFile := z.files[rel]; ok && isFile {
+		return f.Open()
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadFile returns the contents of the file name.
+func (z *ZipDir) ReadFile(name string) ([]byte, error) {
+	r, err := z.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// Context returns a copy of ctx whose file system hooks read the
+// directories and files under z's root from the zip file, so that
+// ctx.Import and ctx.ImportDir work on them as if they were extracted.
+// Other paths are handled by ctx's own hooks or the local file system.
+func (z *ZipDir) Context(ctx *build.Context) *build.Context {
+	orig := *ctx
+	zctx := *ctx
+	zctx.IsDir = func(name string) bool {
+		if _, ok := z.rel(name); ok {
+			return z.IsDir(name)
+		}
+		if orig.IsDir != nil {
+			return orig.IsDir(name)
+		}
+		fi, err := os.Stat(name)
+		return err == nil && fi.IsDir()
+	}
+	zctx.ReadDir = func(dir string) ([]fs.FileInfo, error) {
+		if _, ok := z.rel(dir); ok {
+			return z.ReadDir(dir)
+		}
+		if orig.ReadDir != nil {
+			return orig.ReadDir(dir)
+		}
+		return ioutil.ReadDir(dir)
+	}
+	zctx.OpenFile = func(name string) (io.ReadCloser, error) {
+		if _, ok := z.rel(name); ok {
+			return z.OpenFile(name)
+		}
+		if orig.OpenFile != nil {
+			return orig.OpenFile(name)
+		}
+		return os.Open(name)
+	}
+	return &zctx
+}
+
+// A zipDirInfo describes a directory implied by the file names in a zip.
+type zipDirInfo string
+
+func (d zipDirInfo) Name() string       { return string(d) }
+func (d zipDirInfo) Size() int64        { return 0 }
+func (d zipDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (d zipDirInfo) ModTime() time.Time { return time.Time{} }
+func (d zipDirInfo) IsDir() bool        { return true }
+func (d zipDirInfo) Sys() interface{}   { return nil }
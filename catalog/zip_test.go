@@ -0,0 +1,171 @@
+package catalog
+
+import (
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// zipFiles are the files of a module version read both from its zip
+// file and from its extracted directory.
+var zipFiles = map[string]string{
+	"go.mod":              "module example.com/z\n",
+	"z.go":                "package z\n\nimport _ \"example.com/z/sub\"\n",
+	"z_linux.go":          "package z\n\nimport \"os\"\n\nvar _ = os.Args\n",
+	"z_windows.go":        "package z\n\nimport \"syscall\"\n\nvar _ = syscall.Getpid\n",
+	"z_test.go":           "package z\n\nimport \"testing\"\n\nfunc Test(t *testing.T) {}\n",
+	"tagged.go":           "//go:build special\n\npackage z\n",
+	"sub/s.go":            "package sub\n",
+	"sub/deeper/d.go":     "package deeper\n",
+	"sub/deeper/d.s":      "TEXT ·f(SB),0,$0\n",
+	"docs/README":         "not a package\n",
+	"testdata/t.go":       "package t\n",
+	"vendor/v/v.go":       "package v\n",
+	"_skip/s.go":          "package skip\n",
+	".hidden/h.go":        "package hidden\n",
+	"sub/testdata/t.go":   "package t\n",
+	"embed/e.go":          "package embed\n\nimport _ \"embed\"\n\n//go:embed data.txt\nvar s string\n",
+	"embed/data.txt":      "data\n",
+	"bad/b.go":            "package bad\n",
+	"bad/c.go":            "package other\n",
+	"sub/deeper/x/doc.go": "// Package x is documented.\npackage x\n",
+}
+
+func TestZipDir(t *testing.T) {
+	c, srcDir := writeModule(t, t.TempDir(), "example.com/z", "v1.0.0", zipFiles)
+	m, err := c.Module("example.com/z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := c.OpenZip(m.Lookup("v1.0.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if z.Root() != srcDir {
+		t.Errorf("Root() = %s, want %s", z.Root(), srcDir)
+	}
+
+	var want []string
+	for name := range zipFiles {
+		want = append(want, filepath.Join(srcDir, filepath.FromSlash(name)))
+	}
+	sort.Strings(want)
+	if got := z.Files(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Files() = %q, want %q", got, want)
+	}
+	for name, data := range zipFiles {
+		file := filepath.Join(srcDir, filepath.FromSlash(name))
+		if got, err := z.ReadFile(file); err != nil || string(got) != data {
+			t.Errorf("ReadFile(%s) = %q, %v, want %q", name, got, err, data)
+		}
+	}
+	for _, tt := range []struct {
+		name string
+		dir  bool
+	}{
+		{".", true},
+		{"sub", true},
+		{"sub/deeper/x", true},
+		{"z.go", false},
+		{"none", false},
+		{"..", false},
+	} {
+		if got := z.IsDir(filepath.Join(srcDir, filepath.FromSlash(tt.name))); got != tt.dir {
+			t.Errorf("IsDir(%s) = %v, want %v", tt.name, got, tt.dir)
+		}
+	}
+	if _, err := z.ReadFile(filepath.Join(srcDir, "none.go")); err == nil {
+		t.Errorf("ReadFile of a missing file succeeded")
+	}
+	if _, err := z.ReadFile(filepath.Join(srcDir, "..", "z@v1.0.0.go")); err == nil {
+		t.Errorf("ReadFile outside the root succeeded")
+	}
+	entries, err := z.ReadDir(filepath.Join(srcDir, "sub", "deeper"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name()+map[bool]string{true: "/"}[e.IsDir()])
+	}
+	if got := strings.Join(names, " "); got != "d.go d.s x/" {
+		t.Errorf("ReadDir(sub/deeper) = %s, want d.go d.s x/", got)
+	}
+}
+
+// TestZipContext checks that packages read through ZipDir.Context
+// match those read from the extracted directory.
+func TestZipContext(t *testing.T) {
+	c, srcDir := writeModule(t, t.TempDir(), "example.com/z", "v1.0.0", zipFiles)
+	m, err := c.Module("example.com/z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := c.OpenZip(m.Lookup("v1.0.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	dirs, err := PackageDirs(srcDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zdirs, err := PackageDirs(srcDir, z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rels []string
+	for _, dir := range zdirs {
+		rel, _ := filepath.Rel(srcDir, dir)
+		rels = append(rels, filepath.ToSlash(rel))
+	}
+	if got, want := strings.Join(rels, " "), ". bad embed sub sub/deeper sub/deeper/x"; got != want {
+		t.Errorf("PackageDirs from zip = %s, want %s", got, want)
+	}
+	// The extracted directory also lists docs, which has no Go files.
+	var withGo []string
+	for _, dir := range dirs {
+		if filepath.Base(dir) != "docs" {
+			withGo = append(withGo, dir)
+		}
+	}
+	if !reflect.DeepEqual(withGo, zdirs) {
+		t.Errorf("PackageDirs from zip = %q, extracted = %q", zdirs, dirs)
+	}
+
+	for _, goos := range []string{"linux", "windows"} {
+		for _, tags := range [][]string{nil, {"special"}} {
+			ctx := build.Default
+			ctx.GOOS, ctx.BuildTags = goos, tags
+			for _, dir := range dirs {
+				want, werr := ctx.ImportDir(dir, build.ImportComment)
+				got, gerr := z.Context(&ctx).ImportDir(dir, build.ImportComment)
+				if (werr == nil) != (gerr == nil) {
+					t.Errorf("%s %v %s: ImportDir from zip: %v, extracted: %v", goos, tags, dir, gerr, werr)
+					continue
+				}
+				// Error values differ in type only.
+				if werr != nil && werr.Error() != gerr.Error() {
+					t.Errorf("%s %v %s: ImportDir from zip: %v, extracted: %v", goos, tags, dir, gerr, werr)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s %v %s: ImportDir from zip:\n%+v\nextracted:\n%+v", goos, tags, dir, got, want)
+				}
+			}
+		}
+	}
+
+	// Paths outside the zip are read from disk.
+	ctx := z.Context(&build.Default)
+	if _, err := ctx.ReadDir(filepath.Dir(srcDir)); err != nil {
+		t.Errorf("ReadDir outside the zip: %v", err)
+	}
+	if !ctx.IsDir(filepath.Dir(srcDir)) {
+		t.Errorf("IsDir outside the zip = false, want true")
+	}
+}
@@ -0,0 +1,201 @@
+// Package depgraph builds the module requirement graph and the package
+// import graph of a main module from the data in the module cache (see
+// package modgraph), and writes them as Graphviz DOT, GraphML or JSON.
+package depgraph
+
+import (
+	"github.com/julieqiu/modcache/modgraph"
+	"golang.org/x/mod/module"
+)
+
+// A Graph is a directed dependency graph.
+type Graph struct {
+	Nodes []*Node
+	Edges []Edge
+}
+
+// A Node is a module version or a package.
+type Node struct {
+	ID      string // path@version for modules, import path for packages
+	Module  string `json:",omitempty"` // module path, if known
+	Version string `json:",omitempty"` // module version; empty for main modules
+	Std     bool   `json:",omitempty"` // whether it is in the standard library
+	Target  bool   `json:",omitempty"` // whether it matches Options.Target
+}
+
+// An Edge is a requirement of a module version on another, or an import
+// of a package by another.
+type Edge struct {
+	From, To string // node IDs
+}
+
+// Options controls the nodes included in a graph.
+type Options struct {
+	// Depth is the maximum number of edges between a main module or
+	// package and the nodes included, or 0 for no limit.
+	Depth int
+
+	// NoStd leaves standard library packages out of package graphs.
+	NoStd bool
+
+	// Collapse turns a package graph into a graph of the modules
+	// providing the packages, with an edge between two modules if a
+	// package of one imports a package of the other.
+	Collapse bool
+
+	// Target is the ID of a node to highlight: a package's import path,
+	// or a module's path@version or path, which matches every version.
+	Target string
+}
+
+// stdModule is the ID of the node standing for the standard library
+// in collapsed package graphs.
+const stdModule = "std"
+
+// Modules returns the module requirement graph g, with an edge from
+// each module version to each of its requirements, as in the output of
+// "go mod graph". The versions whose requirements were pruned from g
+// have no outgoing edges.
+func Modules(g *modgraph.Graph, opts *Options) *Graph {
+	if opts == nil {
+		opts = new(Options)
+	}
+	b := newBuilder(opts)
+	versions := make(map[string]module.Version)
+	depth := make(map[string]int)
+	var queue []string
+	for _, m := range g.Main() {
+		id := m.String()
+		b.node(&Node{ID: id, Module: m.Path, Version: m.Version})
+		versions[id] = m
+		depth[id] = 0
+		queue = append(queue, id)
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if opts.Depth > 0 && depth[id] >= opts.Depth {
+			continue
+		}
+		for _, r := range g.Required(versions[id]) {
+			rid := r.String()
+			if _, ok := depth[rid]; !ok {
+				b.node(&Node{ID: rid, Module: r.Path, Version: r.Version})
+				versions[rid] = r
+				depth[rid] = depth[id] + 1
+				queue = append(queue, rid)
+			}
+			b.edge(id, rid)
+		}
+	}
+	return b.graph
+}
+
+// Packages returns the package import graph of the packages pkgs,
+// as returned by g.Packages, starting from the packages of the main
+// modules of g.
+func Packages(g *modgraph.Graph, pkgs []*modgraph.Package, opts *Options) *Graph {
+	if opts == nil {
+		opts = new(Options)
+	}
+	byPath := make(map[string]*modgraph.Package)
+	for _, p := range pkgs {
+		byPath[p.ImportPath] = p
+	}
+	main := make(map[string]bool)
+	for _, m := range g.Main() {
+		main[m.Path] = true
+	}
+
+	b := newBuilder(opts)
+	// id returns the ID of the node standing for the package p, or for
+	// the standard library package path if p is nil.
+	id := func(path string, p *modgraph.Package) string {
+		if !opts.Collapse {
+			return path
+		}
+		if p == nil {
+			return stdModule
+		}
+		return p.Module.String()
+	}
+	add := func(path string, p *modgraph.Package) string {
+		n := &Node{ID: id(path, p), Std: p == nil}
+		if p != nil {
+			n.Module, n.Version = p.Module.Path, p.Module.Version
+		}
+		b.node(n)
+		return n.ID
+	}
+
+	depth := make(map[string]int)
+	var queue []string
+	for _, p := range pkgs {
+		if main[p.Module.Path] {
+			add(p.ImportPath, p)
+			depth[p.ImportPath] = 0
+			queue = append(queue, p.ImportPath)
+		}
+	}
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		p := byPath[path]
+		if p == nil || opts.Depth > 0 && depth[path] >= opts.Depth {
+			continue
+		}
+		from := id(path, p)
+		for _, imp := range p.Imports {
+			ip := byPath[imp]
+			if ip == nil && (opts.NoStd || !modgraph.IsStandard(imp)) {
+				// Left out, or not loaded.
+				continue
+			}
+			if _, ok := depth[imp]; !ok {
+				depth[imp] = depth[path] + 1
+				queue = append(queue, imp)
+			}
+			b.edge(from, add(imp, ip))
+		}
+	}
+	return b.graph
+}
+
+// A builder accumulates the nodes and edges of a graph, dropping
+// duplicates and self-loops, which appear when collapsing.
+type builder struct {
+	opts  *Options
+	graph *Graph
+	nodes map[string]bool
+	edges map[Edge]bool
+}
+
+func newBuilder(opts *Options) *builder {
+	return &builder{
+		opts:  opts,
+		graph: new(Graph),
+		nodes: make(map[string]bool),
+		edges: make(map[Edge]bool),
+	}
+}
+
+// node adds n to the graph, unless a node with its ID is present.
+func (b *builder) node(n *Node) {
+	if b.nodes[n.ID] {
+		return
+	}
+	b.nodes[n.ID] = true
+	t := b.opts.Target
+	n.Target = t != "" && (n.ID == t || n.Module == t && n.ID == n.Module+"@"+n.Version)
+	b.graph.Nodes = append(b.graph.Nodes, n)
+}
+
+// edge adds an edge from one node to another.
+func (b *builder) edge(from, to string) {
+	e := Edge{from, to}
+	if from == to || b.edges[e] {
+		return
+	}
+	b.edges[e] = true
+	b.graph.Edges = append(b.graph.Edges, e)
+}
@@ -0,0 +1,165 @@
+package depgraph
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/internal/modtest"
+	"github.com/julieqiu/modcache/modgraph"
+)
+
+// loadGraph returns the requirement graph and the packages of a main
+// module importing example.com/x/p, which imports example.com/y.
+func loadGraph(t *testing.T) (*modgraph.Graph, []*modgraph.Package) {
+	modCache := t.TempDir()
+	var cacheDir string
+	for _, m := range []struct {
+		path  string
+		files map[string]string
+	}{
+		{"example.com/x", map[string]string{
+			"go.mod": "module example.com/x\n\ngo 1.17\n\nrequire example.com/y v1.0.0\n",
+			"p/p.go": "package p\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/y\"\n)\n\nvar _ = fmt.Sprint(y.Y)\n",
+		}},
+		{"example.com/y", map[string]string{
+			"go.mod": "module example.com/y\n\ngo 1.17\n",
+			"y.go":   "package y\n\nimport \"strings\"\n\nvar Y = strings.ToUpper(\"y\")\n",
+		}},
+	} {
+		cacheDir, _ = modtest.WriteModule(t, modCache, m.path, "v1.0.0", m.files, m.path == "example.com/x")
+	}
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	modtest.WriteFile(t, filepath.Join(dir, "go.mod"), "module example.com/main\n\ngo 1.17\n\nrequire (\n\texample.com/x v1.0.0\n\texample.com/y v1.0.0\n)\n")
+	modtest.WriteFile(t, filepath.Join(dir, "main.go"), "package main\n\nimport (\n\t\"os\"\n\n\t_ \"example.com/x/p\"\n)\n\nfunc main() { os.Exit(0) }\n")
+	g, err := modgraph.Load(c, filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err := g.Packages(c, &build.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g, pkgs
+}
+
+// describe returns the nodes and edges of g, one per line, with "*"
+// marking targets and "(std)" standard library nodes.
+func describe(g *Graph) string {
+	var b strings.Builder
+	for _, n := range g.Nodes {
+		b.WriteString(n.ID)
+		if n.Std {
+			b.WriteString(" (std)")
+		}
+		if n.Target {
+			b.WriteString(" *")
+		}
+		b.WriteString("\n")
+	}
+	for _, e := range g.Edges {
+		b.WriteString(e.From + " -> " + e.To + "\n")
+	}
+	return b.String()
+}
+
+func TestModules(t *testing.T) {
+	g, _ := loadGraph(t)
+	for _, tt := range []struct {
+		name string
+		opts *Options
+		want string
+	}{
+		{"all", nil, `example.com/main
+example.com/x@v1.0.0
+example.com/y@v1.0.0
+example.com/main -> example.com/x@v1.0.0
+example.com/main -> example.com/y@v1.0.0
+example.com/x@v1.0.0 -> example.com/y@v1.0.0
+`},
+		{"depth", &Options{Depth: 1}, `example.com/main
+example.com/x@v1.0.0
+example.com/y@v1.0.0
+example.com/main -> example.com/x@v1.0.0
+example.com/main -> example.com/y@v1.0.0
+`},
+		{"target path", &Options{Target: "example.com/y"}, `example.com/main
+example.com/x@v1.0.0
+example.com/y@v1.0.0 *
+example.com/main -> example.com/x@v1.0.0
+example.com/main -> example.com/y@v1.0.0
+example.com/x@v1.0.0 -> example.com/y@v1.0.0
+`},
+		{"target version", &Options{Target: "example.com/x@v1.0.0", Depth: 1}, `example.com/main
+example.com/x@v1.0.0 *
+example.com/y@v1.0.0
+example.com/main -> example.com/x@v1.0.0
+example.com/main -> example.com/y@v1.0.0
+`},
+		{"other target version", &Options{Target: "example.com/x@v1.1.0", Depth: 1}, `example.com/main
+example.com/x@v1.0.0
+example.com/y@v1.0.0
+example.com/main -> example.com/x@v1.0.0
+example.com/main -> example.com/y@v1.0.0
+`},
+	} {
+		if got := describe(Modules(g, tt.opts)); got != tt.want {
+			t.Errorf("%s: Modules:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPackages(t *testing.T) {
+	g, pkgs := loadGraph(t)
+	for _, tt := range []struct {
+		name string
+		opts *Options
+		want string
+	}{
+		{"all", nil, `example.com/main
+example.com/x/p
+os (std)
+example.com/y
+fmt (std)
+strings (std)
+example.com/main -> example.com/x/p
+example.com/main -> os
+example.com/x/p -> example.com/y
+example.com/x/p -> fmt
+example.com/y -> strings
+`},
+		{"nostd, target", &Options{NoStd: true, Target: "example.com/y"}, `example.com/main
+example.com/x/p
+example.com/y *
+example.com/main -> example.com/x/p
+example.com/x/p -> example.com/y
+`},
+		{"depth", &Options{Depth: 1}, `example.com/main
+example.com/x/p
+os (std)
+example.com/main -> example.com/x/p
+example.com/main -> os
+`},
+		// The standard library packages become one node.
+		{"collapse", &Options{Collapse: true, Target: "example.com/x"}, `example.com/main
+example.com/x@v1.0.0 *
+std (std)
+example.com/y@v1.0.0
+example.com/main -> example.com/x@v1.0.0
+example.com/main -> std
+example.com/x@v1.0.0 -> example.com/y@v1.0.0
+example.com/x@v1.0.0 -> std
+example.com/y@v1.0.0 -> std
+`},
+	} {
+		if got := describe(Packages(g, pkgs, tt.opts)); got != tt.want {
+			t.Errorf("%s: Packages:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
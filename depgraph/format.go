@@ -0,0 +1,120 @@
+package depgraph
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// WriteDOT writes g to w in the Graphviz DOT language. Standard library
+// nodes are drawn in gray, and target nodes in bold red.
+func WriteDOT(w io.Writer, g *Graph) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph deps {")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+	for _, n := range g.Nodes {
+		switch {
+		case n.Target:
+			fmt.Fprintf(bw, "\t%s [color=red, fontcolor=red, penwidth=2];\n", strconv.Quote(n.ID))
+		case n.Std:
+			fmt.Fprintf(bw, "\t%s [color=gray, fontcolor=gray];\n", strconv.Quote(n.ID))
+		default:
+			fmt.Fprintf(bw, "\t%s;\n", strconv.Quote(n.ID))
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(bw, "\t%s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To))
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// WriteJSON writes g to w as a JSON object with Nodes and Edges fields.
+func WriteJSON(w io.Writer, g *Graph) error {
+	data, err := json.MarshalIndent(g, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// The graphml* types are the XML form of a graph written by WriteGraphML.
+type (
+	graphmlDoc struct {
+		XMLName xml.Name     `xml:"graphml"`
+		XMLNS   string       `xml:"xmlns,attr"`
+		Keys    []graphmlKey `xml:"key"`
+		Graph   graphmlGraph `xml:"graph"`
+	}
+	graphmlKey struct {
+		ID   string `xml:"id,attr"`
+		For  string `xml:"for,attr"`
+		Name string `xml:"attr.name,attr"`
+		Type string `xml:"attr.type,attr"`
+	}
+	graphmlGraph struct {
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphmlNode `xml:"node"`
+		Edges       []graphmlEdge `xml:"edge"`
+	}
+	graphmlNode struct {
+		ID   string        `xml:"id,attr"`
+		Data []graphmlData `xml:"data"`
+	}
+	graphmlEdge struct {
+		Source string `xml:"source,attr"`
+		Target string `xml:"target,attr"`
+	}
+	graphmlData struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+)
+
+// WriteGraphML writes g to w in the GraphML format, with the module
+// path, version, and standard library and target flags of each node as
+// data attributes.
+func WriteGraphML(w io.Writer, g *Graph) error {
+	doc := graphmlDoc{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphmlKey{
+			{ID: "module", For: "node", Name: "module", Type: "string"},
+			{ID: "version", For: "node", Name: "version", Type: "string"},
+			{ID: "std", For: "node", Name: "std", Type: "boolean"},
+			{ID: "target", For: "node", Name: "target", Type: "boolean"},
+		},
+		Graph: graphmlGraph{EdgeDefault: "directed"},
+	}
+	for _, n := range g.Nodes {
+		gn := graphmlNode{ID: n.ID}
+		if n.Module != "" {
+			gn.Data = append(gn.Data, graphmlData{"module", n.Module})
+		}
+		if n.Version != "" {
+			gn.Data = append(gn.Data, graphmlData{"version", n.Version})
+		}
+		if n.Std {
+			gn.Data = append(gn.Data, graphmlData{"std", "true"})
+		}
+		if n.Target {
+			gn.Data = append(gn.Data, graphmlData{"target", "true"})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gn)
+	}
+	for _, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphmlEdge{e.From, e.To})
+	}
+	data, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,127 @@
+package depgraph
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+// formatGraph is written in each format by the tests below.
+var formatGraph = &Graph{
+	Nodes: []*Node{
+		{ID: "example.com/main", Module: "example.com/main"},
+		{ID: "example.com/x/p", Module: "example.com/x", Version: "v1.0.0", Target: true},
+		{ID: "fmt", Std: true},
+	},
+	Edges: []Edge{
+		{"example.com/main", "example.com/x/p"},
+		{"example.com/x/p", "fmt"},
+	},
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteDOT(&buf, formatGraph); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph deps {
+	node [shape=box];
+	"example.com/main";
+	"example.com/x/p" [color=red, fontcolor=red, penwidth=2];
+	"fmt" [color=gray, fontcolor=gray];
+	"example.com/main" -> "example.com/x/p";
+	"example.com/x/p" -> "fmt";
+}
+`
+	if buf.String() != want {
+		t.Errorf("WriteDOT:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, formatGraph); err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+	"Nodes": [
+		{
+			"ID": "example.com/main",
+			"Module": "example.com/main"
+		},
+		{
+			"ID": "example.com/x/p",
+			"Module": "example.com/x",
+			"Version": "v1.0.0",
+			"Target": true
+		},
+		{
+			"ID": "fmt",
+			"Std": true
+		}
+	],
+	"Edges": [
+		{
+			"From": "example.com/main",
+			"To": "example.com/x/p"
+		},
+		{
+			"From": "example.com/x/p",
+			"To": "fmt"
+		}
+	]
+}
+`
+	if buf.String() != want {
+		t.Errorf("WriteJSON:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	var g Graph
+	if err := json.Unmarshal(buf.Bytes(), &g); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&g, formatGraph) {
+		t.Errorf("WriteJSON does not read back as the graph written")
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteGraphML(&buf, formatGraph); err != nil {
+		t.Fatal(err)
+	}
+	want := xml.Header + `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+	<key id="module" for="node" attr.name="module" attr.type="string"></key>
+	<key id="version" for="node" attr.name="version" attr.type="string"></key>
+	<key id="std" for="node" attr.name="std" attr.type="boolean"></key>
+	<key id="target" for="node" attr.name="target" attr.type="boolean"></key>
+	<graph edgedefault="directed">
+		<node id="example.com/main">
+			<data key="module">example.com/main</data>
+		</node>
+		<node id="example.com/x/p">
+			<data key="module">example.com/x</data>
+			<data key="version">v1.0.0</data>
+			<data key="target">true</data>
+		</node>
+		<node id="fmt">
+			<data key="std">true</data>
+		</node>
+		<edge source="example.com/main" target="example.com/x/p"></edge>
+		<edge source="example.com/x/p" target="fmt"></edge>
+	</graph>
+</graphml>
+`
+	if buf.String() != want {
+		t.Errorf("WriteGraphML:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	var doc graphmlDoc
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Graph.Nodes) != len(formatGraph.Nodes) || len(doc.Graph.Edges) != len(formatGraph.Edges) {
+		t.Errorf("WriteGraphML reads back with %d nodes and %d edges, want %d and %d",
+			len(doc.Graph.Nodes), len(doc.Graph.Edges), len(formatGraph.Nodes), len(formatGraph.Edges))
+	}
+}
@@ -0,0 +1,287 @@
+// Package driver answers package metadata queries in the external
+// driver protocol of golang.org/x/tools/go/packages (GOPACKAGESDRIVER)
+// from the module cache, instead of running "go list".
+//
+// The packages of module dependencies are read using
+// load.LegacyCachedImport, so that their metadata, and that of their
+// files, comes from the cache when present, and those of the standard
+// library from GOROOT. Only queries for the name, files and imports of
+// packages (NeedName, NeedFiles, NeedImports and NeedDeps) are
+// answered, and only when every package involved can be read this way;
+// otherwise the response has NotHandled set, and go/packages falls back
+// to "go list".
+package driver
+
+import (
+	"go/build"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/modgraph"
+	"golang.org/x/mod/module"
+)
+
+// A LoadMode is a set of go/packages.LoadMode bits.
+type LoadMode int
+
+// The LoadMode bits answered by Serve.
+const (
+	NeedName    LoadMode = 1 << 0
+	NeedFiles   LoadMode = 1 << 1
+	NeedImports LoadMode = 1 << 3
+	NeedDeps    LoadMode = 1 << 4
+)
+
+// A Request is the JSON message read by a driver from its standard
+// input, go/packages.DriverRequest.
+type Request struct {
+	Mode       LoadMode          `json:"mode"`
+	Env        []string          `json:"env"`
+	BuildFlags []string          `json:"build_flags"`
+	Tests      bool              `json:"tests"`
+	Overlay    map[string][]byte `json:"overlay"`
+}
+
+// A Response is the JSON message written by a driver to its standard
+// output, go/packages.DriverResponse.
+type Response struct {
+	NotHandled bool
+	Compiler   string
+	Arch       string
+	Roots      []string `json:",omitempty"`
+	Packages   []*Package
+	GoVersion  int
+}
+
+// A Package is a package in a Response, in the JSON form of
+// go/packages.Package. Imports maps import paths to package IDs.
+type Package struct {
+	ID           string
+	Name         string            `json:",omitempty"`
+	PkgPath      string            `json:",omitempty"`
+	GoFiles      []string          `json:",omitempty"`
+	OtherFiles   []string          `json:",omitempty"`
+	IgnoredFiles []string          `json:",omitempty"`
+	Imports      map[string]string `json:",omitempty"`
+}
+
+// Serve answers req for the packages named by patterns, which must be
+// import paths, in the build of the main modules of g: the packages of
+// a module are those of the version selected by g, which must have been
+// extracted into the module cache. The packages are read with the build
+// context given by req.Env and the -tags flag in req.BuildFlags or
+// GOFLAGS. If NeedImports is set, the packages they import, directly or
+// indirectly, are returned as well, as "go list -deps" would.
+//
+// Requests for other information, for test packages, or for packages
+// of the main modules, directory replacements or versions whose source
+// is not extracted are not handled. Neither are requests whose overlay
+// replaces files in the module cache or GOROOT.
+func Serve(c *catalog.Catalog, g *modgraph.Graph, req *Request, patterns []string) (*Response, error) {
+	notHandled := &Response{NotHandled: true}
+	if req.Mode&^(NeedName|NeedFiles|NeedImports|NeedDeps) != 0 || req.Tests {
+		return notHandled, nil
+	}
+	ctx, ok := buildContext(req)
+	if !ok {
+		return notHandled, nil
+	}
+	for file := range req.Overlay {
+		if _, _, _, ok := c.ParseSourcePath(file); ok || modgraph.InGoroot(ctx, file) {
+			return notHandled, nil
+		}
+	}
+
+	r := &reader{l: modgraph.NewLoader(c, g, module.Version{}), g: g, ctx: ctx}
+	defer r.l.Close()
+	resp := &Response{
+		Compiler: ctx.Compiler,
+		Arch:     ctx.GOARCH,
+	}
+	for _, tag := range ctx.ReleaseTags {
+		if minor, err := strconv.Atoi(strings.TrimPrefix(tag, "go1.")); err == nil {
+			resp.GoVersion = minor
+		}
+	}
+	seen := make(map[string]bool)
+	var queue []*build.Package
+	add := func(p *build.Package) {
+		if !seen[p.ImportPath] {
+			seen[p.ImportPath] = true
+			queue = append(queue, p)
+		}
+	}
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "...") || build.IsLocalImport(pattern) || strings.Contains(pattern, "=") {
+			return notHandled, nil
+		}
+		p := r.load(pattern, "")
+		if p == nil {
+			return notHandled, nil
+		}
+		if !seen[p.ImportPath] {
+			resp.Roots = append(resp.Roots, p.ImportPath)
+		}
+		add(p)
+	}
+	for i := 0; i < len(queue); i++ {
+		p := queue[i]
+		pkg := &Package{
+			ID:      p.ImportPath,
+			Name:    p.Name,
+			PkgPath: p.ImportPath,
+			GoFiles: absJoin(p.Dir, p.GoFiles, p.CgoFiles),
+			OtherFiles: absJoin(p.Dir, p.CFiles, p.CXXFiles, p.MFiles, p.HFiles, p.FFiles,
+				p.SFiles, p.SwigFiles, p.SwigCXXFiles, p.SysoFiles),
+			IgnoredFiles: absJoin(p.Dir, p.IgnoredGoFiles, p.IgnoredOtherFiles),
+		}
+		if req.Mode&NeedImports == 0 {
+			resp.Packages = append(resp.Packages, pkg)
+			continue
+		}
+		pkg.Imports = make(map[string]string)
+		for _, imp := range imports(p) {
+			ip := r.load(imp, p.Dir)
+			if ip == nil {
+				return notHandled, nil
+			}
+			pkg.Imports[imp] = ip.ImportPath
+			add(ip)
+		}
+		resp.Packages = append(resp.Packages, pkg)
+	}
+	return resp, nil
+}
+
+// buildContext returns the build context for req: the default one,
+// adjusted by the GOOS, GOARCH and CGO_ENABLED settings in req.Env and
+// by the -tags flag. It reports false if the request has other build
+// flags, in GOFLAGS or req.BuildFlags, that would change the result.
+func buildContext(req *Request) (*build.Context, bool) {
+	ctx := build.Default
+	var flags []string
+	for _, kv := range req.Env {
+		k, v := kv, ""
+		if i := strings.Index(kv, "="); i >= 0 {
+			k, v = kv[:i], kv[i+1:]
+		}
+		switch k {
+		case "GOOS":
+			ctx.GOOS = v
+		case "GOARCH":
+			ctx.GOARCH = v
+		case "CGO_ENABLED":
+			ctx.CgoEnabled = v == "1"
+		case "GOFLAGS":
+			flags = strings.Fields(v)
+		}
+	}
+	flags = append(flags, req.BuildFlags...)
+	for i := 0; i < len(flags); i++ {
+		f := strings.TrimPrefix(strings.TrimPrefix(flags[i], "-"), "-")
+		switch {
+		case f == "tags":
+			if i+1 == len(flags) {
+				return nil, false
+			}
+			i++
+			ctx.BuildTags = splitTags(flags[i])
+		case strings.HasPrefix(f, "tags="):
+			ctx.BuildTags = splitTags(strings.TrimPrefix(f, "tags="))
+		case f == "mod=mod" || f == "mod=readonly":
+			// The build list is the same.
+		default:
+			return nil, false
+		}
+	}
+	return &ctx, true
+}
+
+// splitTags splits the value of a -tags flag, a comma-separated list
+// or, in older releases, a space-separated one.
+func splitTags(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// imports returns the imports of p other than the pseudo-package "C",
+// which go/packages omits.
+func imports(p *build.Package) []string {
+	var list []string
+	for _, imp := range p.Imports {
+		if imp != "C" {
+			list = append(list, imp)
+		}
+	}
+	return list
+}
+
+// A reader reads packages from the module cache and GOROOT.
+type reader struct {
+	l   *modgraph.Loader
+	g   *modgraph.Graph
+	ctx *build.Context
+}
+
+// load reads the package importPath, imported by the package in
+// fromDir, or by the query if fromDir is "". It returns nil if the
+// package cannot be read from the module cache or GOROOT, including
+// packages of the main modules.
+func (r *reader) load(importPath, fromDir string) *build.Package {
+	if r.mainModule(importPath) {
+		return nil
+	}
+	if modgraph.IsStandard(importPath) {
+		p, err := r.ctx.Import(importPath, "", 0)
+		if err != nil {
+			return nil
+		}
+		return p
+	}
+	if vp := modgraph.VendorPath(r.ctx, importPath, fromDir); vp != importPath {
+		// Packages vendored in GOROOT, which "go list" names
+		// vendor/importPath.
+		p, err := r.ctx.ImportDir(filepath.Join(r.ctx.GOROOT, "src", filepath.FromSlash(vp)), 0)
+		if err != nil {
+			return nil
+		}
+		p.ImportPath = vp
+		return p
+	}
+
+	loc, err := r.l.Find(importPath)
+	if err != nil || loc.Version == nil || loc.Zip != nil {
+		// Not provided by exactly one module version, or not
+		// extracted in the module cache.
+		return nil
+	}
+	p, err := loc.Import(r.ctx)
+	if err != nil {
+		return nil
+	}
+	return p
+}
+
+// mainModule reports whether importPath is in a main module.
+func (r *reader) mainModule(importPath string) bool {
+	for _, m := range r.g.Main() {
+		if importPath == m.Path || strings.HasPrefix(importPath, m.Path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// absJoin returns the names in lists, joined to dir.
+func absJoin(dir string, lists ...[]string) []string {
+	var files []string
+	var names []string
+	for _, list := range lists {
+		names = append(names, list...)
+	}
+	for _, name := range names {
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files
+}
@@ -0,0 +1,248 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/internal/modtest"
+	"github.com/julieqiu/modcache/modgraph"
+)
+
+// serveModules holds the files of the module versions required by the
+// main module of TestServeGoList, by path and version.
+var serveModules = []struct {
+	path, version string
+	files         map[string]string
+}{
+	{"example.com/a", "v1.0.0", map[string]string{
+		"go.mod":          "module example.com/a\n\ngo 1.18\n\nrequire example.com/b v1.0.0\n",
+		"a.go":            "package a\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/b\"\n)\n\nvar A = fmt.Sprint(b.B)\n",
+		"a_other.go":      "//go:build never\n\npackage a\n\nimport _ \"example.com/none\"\n",
+		"a.s":             "",
+		"notes.txt":       "not a source file\n",
+		"a_test.go":       "package a\n\nimport _ \"example.com/test/only\"\n",
+		"sub/sub.go":      "package sub\n\nimport _ \"example.com/a/internal/i\"\n",
+		"internal/i/i.go": "package i\n\nimport \"strings\"\n\nvar I = strings.ToUpper(\"i\")\n",
+	}},
+	{"example.com/b", "v1.0.0", map[string]string{
+		"go.mod": "module example.com/b\n\ngo 1.18\n",
+		"b.go":   "package b\n\nimport \"strings\"\n\nvar B = strings.ToUpper(\"b\")\n",
+	}},
+}
+
+// A listPackage holds the fields printed by "go list -json" from which
+// go/packages builds a Package.
+type listPackage struct {
+	ImportPath, Name, Dir                    string
+	GoFiles, CgoFiles                        []string
+	CFiles, CXXFiles, MFiles, HFiles, FFiles []string
+	SFiles, SwigFiles, SwigCXXFiles          []string
+	SysoFiles                                []string
+	IgnoredGoFiles, IgnoredOtherFiles        []string
+	Imports                                  []string
+	ImportMap                                map[string]string
+	DepOnly                                  bool
+}
+
+// TestServeGoList compares the packages served by Serve with those
+// "go list -json -deps" lists, as go/packages would present them, for
+// a main module whose dependencies the go command downloaded, through a
+// proxy serving serveModules, into a new module cache, from which Serve
+// then reads them.
+func TestServeGoList(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the go command")
+	}
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip(err)
+	}
+	modCache := t.TempDir()
+	var cacheDir string
+	for _, m := range serveModules {
+		cacheDir, _ = modtest.WriteModule(t, modCache, m.path, m.version, m.files, false)
+	}
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(catalog.NewProxy(c))
+	defer srv.Close()
+
+	patterns := []string{"example.com/a", "example.com/a/sub", "example.com/b"}
+	dir := t.TempDir()
+	goMod := filepath.Join(dir, "go.mod")
+	modtest.WriteFile(t, goMod, "module example.com/main\n\ngo 1.18\n\nrequire example.com/a v1.0.0\n")
+	goModCache := t.TempDir()
+	cmd := exec.Command(gocmd, append([]string{"list", "-json", "-deps"}, patterns...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GOPROXY="+srv.URL,
+		"GOMODCACHE="+goModCache,
+		"GOFLAGS=-modcacherw -mod=mod",
+		"GOSUMDB=off",
+		"GONOPROXY=",
+		"GOPRIVATE=",
+		"GOTOOLCHAIN=local",
+		"GOWORK=off",
+		"GO111MODULE=on",
+		"CGO_ENABLED=0",
+	)
+	out, err := cmd.Output()
+	if ee, ok := err.(*exec.ExitError); ok {
+		t.Fatalf("go list: %v\n%s", err, ee.Stderr)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	// The packages go/packages would return, by ID.
+	want := make(map[string]*Package)
+	var roots []string
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var lp listPackage
+		if err := dec.Decode(&lp); err != nil {
+			t.Fatal(err)
+		}
+		if !lp.DepOnly {
+			roots = append(roots, lp.ImportPath)
+		}
+		p := &Package{
+			ID:      lp.ImportPath,
+			Name:    lp.Name,
+			PkgPath: lp.ImportPath,
+			GoFiles: absJoin(lp.Dir, lp.GoFiles, lp.CgoFiles),
+			OtherFiles: absJoin(lp.Dir, lp.CFiles, lp.CXXFiles, lp.MFiles, lp.HFiles, lp.FFiles,
+				lp.SFiles, lp.SwigFiles, lp.SwigCXXFiles, lp.SysoFiles),
+			IgnoredFiles: absJoin(lp.Dir, lp.IgnoredGoFiles, lp.IgnoredOtherFiles),
+			Imports:      make(map[string]string),
+		}
+		source := make(map[string]string)
+		for from, to := range lp.ImportMap {
+			source[to] = from
+		}
+		for _, imp := range lp.Imports {
+			if imp == "C" {
+				continue
+			}
+			from := imp
+			if s, ok := source[imp]; ok {
+				from = s
+			}
+			p.Imports[from] = imp
+		}
+		want[p.ID] = p
+	}
+
+	gc, err := catalog.Open(filepath.Join(goModCache, "cache", "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := modgraph.Load(gc, goMod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &Request{Mode: NeedName | NeedFiles | NeedImports | NeedDeps, Env: []string{"CGO_ENABLED=0"}}
+	resp, err := Serve(gc, g, req, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.NotHandled {
+		t.Fatal("Serve: not handled")
+	}
+	// go list prints the named packages after their dependencies, but
+	// go/packages does not promise any order.
+	got := append([]string(nil), resp.Roots...)
+	sort.Strings(got)
+	sort.Strings(roots)
+	if !reflect.DeepEqual(got, roots) {
+		t.Errorf("Roots = %v, go list %v", resp.Roots, roots)
+	}
+	var ids []string
+	for _, p := range resp.Packages {
+		ids = append(ids, p.ID)
+		w := want[p.ID]
+		if w == nil {
+			t.Errorf("Serve returned %s, not listed by go list", p.ID)
+			continue
+		}
+		if !reflect.DeepEqual(p, w) {
+			gj, _ := json.MarshalIndent(p, "", "\t")
+			wj, _ := json.MarshalIndent(w, "", "\t")
+			t.Errorf("package %s:\n%s\ngo list:\n%s", p.ID, gj, wj)
+		}
+	}
+	if len(ids) != len(want) {
+		sort.Strings(ids)
+		t.Errorf("Serve returned %d packages, go list %d: %s", len(ids), len(want), strings.Join(ids, " "))
+	}
+
+	// Without NeedImports, only the named packages are returned.
+	resp, err = Serve(gc, g, &Request{Mode: NeedName | NeedFiles, Env: []string{"CGO_ENABLED=0"}}, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = nil
+	for _, p := range resp.Packages {
+		ids = append(ids, p.ID)
+		if p.Imports != nil {
+			t.Errorf("without NeedImports, %s has imports %v", p.ID, p.Imports)
+		}
+	}
+	if !reflect.DeepEqual(ids, patterns) {
+		t.Errorf("without NeedImports, Serve returned %v, want %v", ids, patterns)
+	}
+
+	// Queries that Serve cannot answer exactly are left to go list.
+	srcDir, err := gc.SourceDir("example.com/a", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name     string
+		req      *Request
+		patterns []string
+	}{
+		{"types", &Request{Mode: NeedName | 1<<6}, patterns},
+		{"tests", &Request{Mode: NeedName, Tests: true}, patterns},
+		{"build flags", &Request{Mode: NeedName, BuildFlags: []string{"-race"}}, patterns},
+		{"GOFLAGS", &Request{Mode: NeedName, Env: []string{"GOFLAGS=-mod=vendor"}}, patterns},
+		{"overlay", &Request{Mode: NeedName, Overlay: map[string][]byte{filepath.Join(srcDir, "a.go"): []byte("package a\n")}}, patterns},
+		{"wildcard", &Request{Mode: NeedName}, []string{"example.com/a/..."}},
+		{"query", &Request{Mode: NeedName}, []string{"file=a.go"}},
+		{"main module", &Request{Mode: NeedName}, []string{"example.com/main"}},
+		{"missing", &Request{Mode: NeedName}, []string{"example.com/none"}},
+	} {
+		resp, err := Serve(gc, g, tt.req, tt.patterns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.NotHandled {
+			t.Errorf("%s: Serve handled the request", tt.name)
+		}
+	}
+	// A build tag changes the files, and the overlay of a file outside
+	// the module cache does not matter.
+	req = &Request{
+		Mode:       NeedName | NeedFiles,
+		Env:        []string{"CGO_ENABLED=0"},
+		BuildFlags: []string{"-tags=never"},
+		Overlay:    map[string][]byte{filepath.Join(dir, "main.go"): []byte("package main\n")},
+	}
+	resp, err = Serve(gc, g, req, []string{"example.com/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.NotHandled || len(resp.Packages) != 1 || len(resp.Packages[0].GoFiles) != 2 {
+		t.Errorf("with -tags=never, Serve = %+v, want example.com/a with two Go files", resp)
+	}
+}
@@ -1,5 +1,8 @@
 module github.com/julieqiu/modcache
 
-go 1.16
+go 1.18
 
-require mvdan.cc/sh v2.6.4+incompatible // indirect
+require (
+	github.com/google/codesearch v1.2.0
+	golang.org/x/mod v0.20.0
+)
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/julieqiu/modcache/apidiff"
+	"github.com/julieqiu/modcache/catalog"
+)
+
+// runAPIDiff implements "gocmd apidiff", which type-checks two cached
+// versions of a module, named as path@version arguments, and lists the
+// incompatible and compatible changes to their exported API. It exits
+// with status 1 if the version change violates semantic versioning,
+// such as incompatible changes in a minor release.
+func runAPIDiff(args []string) {
+	fs := flag.NewFlagSet("apidiff", flag.ExitOnError)
+	var (
+		incompatibleOnly = fs.Bool("incompatible", false, "list only incompatible changes")
+		jsonOut          = fs.Bool("json", false, "print changes as JSON objects, one per line")
+	)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: gocmd apidiff [-incompatible] [-json] module@old module@new")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var mods [2]*apidiff.Module
+	for i, arg := range fs.Args() {
+		j := strings.Index(arg, "@")
+		if j < 0 {
+			log.Fatalf("%s: want module@version", arg)
+		}
+		if mods[i], err = apidiff.Load(c, arg[:j], arg[j+1:]); err != nil {
+			log.Fatal(err)
+		}
+	}
+	old, new := mods[0], mods[1]
+	r := apidiff.Diff(old, new)
+
+	if *jsonOut {
+		for _, ch := range r.Changes {
+			if !*incompatibleOnly || !ch.Compatible {
+				printJSON(ch)
+			}
+		}
+	} else {
+		printChanges("Incompatible changes:", r.Incompatible())
+		if !*incompatibleOnly {
+			printChanges("Compatible changes:", r.Compatible())
+		}
+	}
+	if v := apidiff.SemverViolation(r, old.Version, new.Version); v != "" {
+		fmt.Fprintf(os.Stderr, "%s %s -> %s: %s\n", old.Path, old.Version, new.Version, v)
+		os.Exit(1)
+	}
+}
+
+// printChanges prints a heading followed by the changes, if any.
+func printChanges(heading string, changes []apidiff.Change) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Println(heading)
+	for _, ch := range changes {
+		fmt.Printf("- %s\n", ch)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/julieqiu/modcache/bundle"
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/index"
+)
+
+// runBundle implements "gocmd bundle create" and "gocmd bundle import",
+// which copy a set of module versions from one module cache to another
+// as a tar file, for machines without network access.
+func runBundle(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "create":
+			runBundleCreate(args[1:])
+			return
+		case "import":
+			runBundleImport(args[1:])
+			return
+		}
+	}
+	fmt.Fprintln(os.Stderr, "usage: gocmd bundle create|import [flags]")
+	os.Exit(2)
+}
+
+// runBundleCreate implements "gocmd bundle create", which writes a
+// bundle of the module versions listed in a go.sum file, required by a
+// go.mod file, or named as arguments (path@version, or a module path
+// standing for all its versions).
+func runBundleCreate(args []string) {
+	fs := flag.NewFlagSet("bundle create", flag.ExitOnError)
+	var (
+		out      = fs.String("o", "", "write the bundle to `file`")
+		goSum    = fs.String("gosum", "", "bundle the module versions listed in the go.sum `file`")
+		goMod    = fs.String("gomod", "", "bundle the requirement graph of the go.mod or go.work `file`")
+		metadata = fs.Bool("meta", false, "include cached package metadata")
+		withIdx  = fs.Bool("index", false, "include the index segments of the module versions")
+		dir      = fs.String("index-dir", indexDir(), "index directory")
+	)
+	fs.Parse(args)
+	if *out == "" || *goSum != "" && *goMod != "" || *goSum == "" && *goMod == "" && fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: gocmd bundle create -o file [flags] (-gosum file | -gomod file | module[@version]...)")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var versions []*catalog.Version
+	switch {
+	case *goSum != "":
+		versions, err = bundle.FromGoSum(c, *goSum)
+	case *goMod != "":
+		versions, err = bundle.FromGoMod(c, *goMod)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, arg := range fs.Args() {
+		list, err := selectVersions(c, arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		versions = append(versions, list...)
+	}
+
+	opts := &bundle.CreateOptions{Metadata: *metadata}
+	if *withIdx {
+		if opts.Index, err = index.OpenDir(*dir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := bundle.Create(f, c, versions, opts); err != nil {
+		f.Close()
+		os.Remove(*out)
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("bundled %d module versions\n", len(versions))
+}
+
+// runBundleImport implements "gocmd bundle import", which verifies a
+// bundle and merges it into the module cache given by -cache.
+func runBundleImport(args []string) {
+	fs := flag.NewFlagSet("bundle import", flag.ExitOnError)
+	var (
+		noIdx = fs.Bool("no-index", false, "do not add the bundle's index segments to the index")
+		dir   = fs.String("index-dir", indexDir(), "index directory")
+	)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gocmd bundle import [flags] file")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	opts := new(bundle.ImportOptions)
+	if !*noIdx {
+		d, err := index.OpenDir(*dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Index = d
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	stats, err := bundle.Import(f, *cacheDir, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("imported %d module versions (%d files already cached), %d metadata entries, %d index segments\n",
+		stats.Versions, stats.Existing, stats.Entries, stats.Segments)
+}
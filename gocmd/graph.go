@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/depgraph"
+	"github.com/julieqiu/modcache/modgraph"
+	"golang.org/x/mod/module"
+)
+
+// runGraph implements "gocmd graph", which prints the module
+// requirement graph or, with -pkg, the package import graph of the
+// main module whose go.mod or go.work file is given by -gomod, or of
+// the cached module version named as an argument (path@version, or a
+// module path standing for its latest cached version), built from the
+// module cache. The graph is printed in the format given by -format:
+// dot, graphml or json.
+func runGraph(args []string) {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	var (
+		goMod    = fs.String("gomod", "go.mod", "graph the main module of the go.mod or go.work `file`")
+		pkgs     = fs.Bool("pkg", false, "print the package import graph instead of the module requirement graph")
+		depth    = fs.Int("depth", 0, "include only nodes at most `N` edges from the main module or its packages")
+		noStd    = fs.Bool("nostd", false, "leave out standard library packages")
+		collapse = fs.Bool("collapse", false, "collapse the package import graph to the modules providing the packages")
+		target   = fs.String("target", "", "highlight the `node`, an import path or module path[@version]")
+		format   = fs.String("format", "dot", "output `format`: dot, graphml or json")
+	)
+	fs.Parse(args)
+	write := map[string]func(*os.File, *depgraph.Graph) error{
+		"dot":     func(f *os.File, g *depgraph.Graph) error { return depgraph.WriteDOT(f, g) },
+		"graphml": func(f *os.File, g *depgraph.Graph) error { return depgraph.WriteGraphML(f, g) },
+		"json":    func(f *os.File, g *depgraph.Graph) error { return depgraph.WriteJSON(f, g) },
+	}[*format]
+	if fs.NArg() > 1 || write == nil {
+		fmt.Fprintln(os.Stderr, "usage: gocmd graph [flags] [module[@version]]")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var g *modgraph.Graph
+	if fs.NArg() == 1 {
+		g, err = loadVersion(c, fs.Arg(0))
+	} else {
+		g, err = modgraph.Load(c, *goMod)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := &depgraph.Options{Depth: *depth, NoStd: *noStd, Collapse: *collapse, Target: *target}
+	var dg *depgraph.Graph
+	if *pkgs || *collapse {
+		list, err := g.Packages(c, &build.Default)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dg = depgraph.Packages(g, list, opts)
+	} else {
+		dg = depgraph.Modules(g, opts)
+	}
+	if err := write(os.Stdout, dg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadVersion loads the requirement graph of the cached module version
+// named by arg, path@version or a module path standing for its latest
+// cached version.
+func loadVersion(c *catalog.Catalog, arg string) (*modgraph.Graph, error) {
+	modPath, version := arg, ""
+	if i := strings.Index(arg, "@"); i >= 0 {
+		modPath, version = arg[:i], arg[i+1:]
+	}
+	if version == "" {
+		m, err := c.Module(modPath)
+		if err != nil {
+			return nil, err
+		}
+		if v := m.Latest(); v != nil {
+			version = v.Version
+		}
+	}
+	return modgraph.LoadVersion(c, module.Version{Path: modPath, Version: version})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+func TestLoadVersion(t *testing.T) {
+	modCache := t.TempDir()
+	for _, m := range []struct {
+		path, version, gomod string
+	}{
+		{"example.com/m", "v1.0.0", "require example.com/d v1.0.0\n"},
+		{"example.com/m", "v1.1.0", "require example.com/d v1.1.0\n"},
+		{"example.com/m", "v1.2.0-pre", "require example.com/d v1.2.0\n"},
+		{"example.com/d", "v1.0.0", ""},
+		{"example.com/d", "v1.1.0", ""},
+	} {
+		modtest.WriteModule(t, modCache, m.path, m.version, map[string]string{"go.mod": "module " + m.path + "\n\n" + m.gomod}, false)
+	}
+	c, err := catalog.Open(filepath.Join(modCache, "cache", "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		arg      string
+		selected string // version of example.com/d, "" if an error is wanted
+	}{
+		// The latest release, not the prerelease.
+		{"example.com/m", "v1.1.0"},
+		{"example.com/m@v1.0.0", "v1.0.0"},
+		// example.com/d@v1.2.0 is not cached.
+		{"example.com/m@v1.2.0-pre", ""},
+		{"example.com/none", ""},
+	} {
+		g, err := loadVersion(c, tt.arg)
+		if tt.selected == "" {
+			if err == nil {
+				t.Errorf("loadVersion(%s) succeeded, want error", tt.arg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("loadVersion(%s): %v", tt.arg, err)
+			continue
+		}
+		if v := g.Selected("example.com/d"); v != tt.selected {
+			t.Errorf("loadVersion(%s) selects example.com/d %s, want %s", tt.arg, v, tt.selected)
+		}
+	}
+}
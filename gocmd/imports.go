@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/rdeps"
+)
+
+// runImports implements "gocmd imports", which suggests import paths
+// for an unresolved selector name.Symbol, as goimports would: the cached
+// packages named name that export Symbol, best first. Packages of the
+// versions required by the go.mod file rank first, then those imported
+// by the most modules. Packages are found by name in the
+// reverse-dependency index built by "gocmd index", which must be up to
+// date.
+func runImports(args []string) {
+	fs := flag.NewFlagSet("imports", flag.ExitOnError)
+	var (
+		goMod   = fs.String("gomod", "go.mod", "prefer the versions required by the go.mod `file`, if it exists")
+		dir     = fs.String("index", indexDir(), "index directory")
+		jsonOut = fs.Bool("json", false, "print candidates as JSON objects, one per line")
+	)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: gocmd imports [-gomod file] [-index dir] [-json] name Symbol")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ix, err := rdeps.Open(rdepsFile(*dir))
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := new(rdeps.CandidateOptions)
+	if _, err := os.Stat(*goMod); err == nil {
+		opts.GoMod = *goMod
+	}
+	cands, err := ix.Candidates(c, fs.Arg(0), fs.Arg(1), opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, cand := range cands {
+		if *jsonOut {
+			printJSON(cand)
+			continue
+		}
+		fmt.Printf("%s\t%s@%s\t%s\n", cand.ImportPath, cand.Module, cand.Version, cand.Pos)
+	}
+}
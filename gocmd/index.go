@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/index"
+	"github.com/julieqiu/modcache/rdeps"
+)
+
+// indexDir returns the default location of the trigram index,
+// alongside the download cache in GOMODCACHE/cache.
+func indexDir() string {
+	return filepath.Join(filepath.Dir(*cacheDir), "index")
+}
+
+// runIndex implements "gocmd index", which brings the index of the
+// source of the module versions in the module cache, extracted or as
+// zip files, up to date, indexing only the versions added since the
+// last run. It also updates the reverse-dependency index used by
+// "gocmd rdeps".
+func runIndex(args []string) {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	var (
+		dir     = fs.String("index", indexDir(), "index directory")
+		compact = fs.Bool("compact", false, "merge the index segments even if not needed")
+	)
+	fs.Parse(args)
+
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d, err := index.OpenDir(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stats, err := d.Update(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("indexed %d module versions, removed %d\n", stats.Added, stats.Removed)
+	// Compact while the reverse-dependency index is updated.
+	var compacted <-chan error
+	if *compact || d.NeedsCompaction() {
+		compacted = d.CompactInBackground()
+	}
+
+	rd, err := rdeps.Open(rdepsFile(*dir))
+	if err != nil {
+		log.Fatal(err)
+	}
+	rstats, err := rd.Update(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("recorded dependencies of %d module versions, removed %d\n", rstats.Added, rstats.Removed)
+	if compacted != nil {
+		if err := <-compacted; err != nil {
+			log.Fatal(err)
+		}
+	}
+}
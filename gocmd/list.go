@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/build"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/golist"
+)
+
+// runList implements "gocmd list", which prints the cached packages
+// named by its arguments, each an import path pattern optionally
+// followed by @version, in the forms printed by "go list": by default
+// their import paths, with -json the JSON objects "go list -json"
+// prints, and with -f the output of a template applied to each, which
+// has the same fields and the join and context functions.
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	var (
+		jsonOut = fs.Bool("json", false, "print packages in JSON format")
+		format  = fs.String("f", "", "print packages using the template `format`")
+		deps    = fs.Bool("deps", false, "also print the dependencies of the packages, before them")
+		errOK   = fs.Bool("e", false, "do not fail on packages with erroneous dependencies")
+	)
+	fs.Parse(args)
+	if fs.NArg() == 0 || *jsonOut && *format != "" {
+		fmt.Fprintln(os.Stderr, "usage: gocmd list [-json | -f format] [-deps] [-e] pattern[@version]...")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	ctx := &build.Default
+	var tmpl *template.Template
+	if !*jsonOut {
+		if *format == "" {
+			*format = "{{.ImportPath}}"
+		}
+		var err error
+		tmpl, err = template.New("main").Funcs(template.FuncMap{
+			"join":    strings.Join,
+			"context": func() *build.Context { return ctx },
+		}).Parse(*format)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pkgs, err := golist.List(c, ctx, fs.Args(), *deps)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	failed := false
+	for _, p := range pkgs {
+		if *jsonOut {
+			data, err := json.MarshalIndent(p, "", "\t")
+			if err != nil {
+				log.Fatal(err)
+			}
+			w.Write(data)
+			w.WriteByte('\n')
+		} else {
+			var out strings.Builder
+			if err := tmpl.Execute(&out, p); err != nil {
+				w.Flush()
+				log.Fatal(err)
+			}
+			// As in go list, terminate non-empty output with a newline.
+			if s := out.String(); s != "" {
+				w.WriteString(s)
+				if !strings.HasSuffix(s, "\n") {
+					w.WriteByte('\n')
+				}
+			}
+		}
+		if !*errOK && !p.DepOnly {
+			for _, e := range p.DepsErrors {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", strings.Join(e.ImportStack, ": import "), e.Err)
+				failed = true
+			}
+		}
+	}
+	w.Flush()
+	if failed {
+		os.Exit(1)
+	}
+}
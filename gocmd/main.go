@@ -6,9 +6,10 @@ import (
 	"go/build"
 	"log"
 	"os"
-	"path/filepath"
 	"strings"
 
+	"github.com/julieqiu/modcache/cache"
+	"github.com/julieqiu/modcache/catalog"
 	"github.com/julieqiu/modcache/load"
 )
 
@@ -17,48 +18,104 @@ var (
 	cacheDir = flag.String("cache", "/Users/julieqiu/go/pkg/mod/cache/download", "")
 )
 
+// commands maps subcommand names to their implementations.
+// Each is passed the arguments following the subcommand name.
+var commands = map[string]func(args []string){
+	"apidiff":     runAPIDiff,
+	"bundle":      runBundle,
+	"graph":       runGraph,
+	"imports":     runImports,
+	"index":       runIndex,
+	"list":        runList,
+	"rdeps":       runRdeps,
+	"search":      runSearch,
+	"serve-proxy": runServeProxy,
+	"since":       runSince,
+	"trim":        runTrim,
+	"verify":      runVerify,
+	"why":         runWhy,
+}
+
 func main() {
 	flag.Usage = func() {
 		out := flag.CommandLine.Output()
-		fmt.Fprintln(out, `
-gocmd [module path] [import path]
+		fmt.Fprint(out, `
+gocmd [import path][@version]
 gocmd -q [name] [symbol]
+gocmd apidiff [-incompatible] [-json] module@old module@new
+gocmd bundle create -o file [-gosum file | -gomod file] [-meta] [-index] [-index-dir dir] [module[@version]...]
+gocmd bundle import [-no-index] [-index-dir dir] file
+gocmd graph [-gomod file] [-pkg] [-depth N] [-nostd] [-collapse] [-target node] [-format dot|graphml|json] [module[@version]]
+gocmd imports [-gomod file] [-index dir] [-json] name Symbol
+gocmd index [-index dir] [-compact]
+gocmd list [-json | -f format] [-deps] [-e] pattern[@version]...
+gocmd rdeps [-index dir] [-m] [-latest] [-json] package|module
+gocmd search [-i] [-l] [-C N] [-m N] [-json] [-module pattern] [-pkg pattern] [-file glob] [-latest] [-all] regexp
+gocmd serve-proxy [-addr address]
+gocmd since [-json] pkg.Symbol
+gocmd trim [-dry-run] [-max-age duration] [-max-size bytes] [-force]
+gocmd verify [-gosum file] [-v] [module[@version]...]
+gocmd why [-gomod file] [-m] module[@version]...
+
 `)
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 
-	if flag.NArg() != 2 {
-		flag.Usage()
-		os.Exit(1)
-	}
 	args := flag.Args()
+	if len(args) > 0 {
+		if run, ok := commands[args[0]]; ok {
+			run(args[1:])
+			return
+		}
+	}
 	if *q {
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(1)
+		}
 		name := args[0]
 		symbol := args[1]
-		fmt.Println(name, symbol)
-		return
-	} else {
-		modulePath := args[0]
-		pkgPath := args[1]
-		if !strings.HasPrefix(pkgPath, modulePath) {
-			flag.Usage()
-			log.Fatalf("The specified import path must be a package in the module.")
+		matches, err := cache.Lookup(*cacheDir, name, symbol)
+		if err != nil {
+			log.Fatal(err)
 		}
-		f := filepath.Join(*cacheDir, modulePath, "@v", cachefile())
-		if _, err := os.Stat(f); err != nil {
-			if !os.IsNotExist(err) {
-				log.Fatal(err)
-			}
-			fmt.Printf("%q does not exist.\n", f)
+		for _, m := range matches {
+			fmt.Printf("%s\t%s@%s\t%s\n", m.ImportPath, m.Module, m.Version, m.Pos)
 		}
-
-		srcDir := "/Users/julieqiu/go/pkg/mod/golang.org/x/tools@v0.1.0/godoc"
-		load.LegacyCachedImport(&build.Default, pkgPath, srcDir, modulePath, *cacheDir, build.FindOnly)
+		return
 	}
-	// Check for file.
-}
 
-func cachefile() string {
-	return "cachefile"
+	if len(args) != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	pkgPath, version := args[0], ""
+	if i := strings.Index(pkgPath, "@"); i >= 0 {
+		pkgPath, version = pkgPath[:i], pkgPath[i+1:]
+	}
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pkg, err := c.Resolve(pkgPath, version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := &build.Default
+	if pkg.Zipped {
+		z, err := c.OpenZip(pkg.Version)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer z.Close()
+		ctx = z.Context(ctx)
+	}
+	// The package directory is already known, so import it as a local
+	// path rather than asking go/build to search for it.
+	p, err := load.LegacyCachedImport(ctx, ".", pkg.Dir, pkg.Version.Path, *cacheDir, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s\t%s\t%s\n", pkg.ImportPath, pkg.Version, p.Dir)
 }
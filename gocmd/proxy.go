@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/julieqiu/modcache/catalog"
+)
+
+// runServeProxy implements "gocmd serve-proxy", which serves the
+// download cache over HTTP using the GOPROXY protocol.
+func runServeProxy(args []string) {
+	fs := flag.NewFlagSet("serve-proxy", flag.ExitOnError)
+	addr := fs.String("addr", ":3000", "listen on `address`")
+	fs.Parse(args)
+
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("serving %s on %s", c.Dir(), *addr)
+	log.Fatal(http.ListenAndServe(*addr, catalog.NewProxy(c)))
+}
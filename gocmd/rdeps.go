@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/rdeps"
+)
+
+// rdepsFile returns the location of the reverse-dependency index
+// in the index directory dir.
+func rdepsFile(dir string) string {
+	return filepath.Join(dir, "rdeps")
+}
+
+// runRdeps implements "gocmd rdeps", which lists the cached packages
+// importing the package named as its argument, or, with -m, the cached
+// module versions requiring the module, using the reverse-dependency
+// index built by "gocmd index". A package path ending in /... also
+// matches the packages below it.
+func runRdeps(args []string) {
+	fs := flag.NewFlagSet("rdeps", flag.ExitOnError)
+	var f rdepsFlags
+	dir := fs.String("index", indexDir(), "index directory")
+	fs.BoolVar(&f.modules, "m", false, "list the module versions requiring the module, instead of importing packages")
+	fs.BoolVar(&f.latest, "latest", false, "list only the latest cached version of each dependent module")
+	fs.BoolVar(&f.jsonOut, "json", false, "print results as JSON objects, one per line")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gocmd rdeps [flags] package | -m module")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ix, err := rdeps.Open(rdepsFile(*dir))
+	if err != nil {
+		log.Fatal(err)
+	}
+	listRdeps(os.Stdout, c, ix, fs.Arg(0), &f)
+}
+
+// rdepsFlags holds the flags of "gocmd rdeps".
+type rdepsFlags struct {
+	modules bool
+	latest  bool
+	jsonOut bool
+}
+
+// listRdeps writes to w the dependents of arg recorded in ix, as
+// directed by f. The latest versions are those in c.
+func listRdeps(w io.Writer, c *catalog.Catalog, ix *rdeps.Index, arg string, f *rdepsFlags) {
+	latestVersion := make(map[string]string)
+	isLatest := func(modPath, version string) bool {
+		v, ok := latestVersion[modPath]
+		if !ok {
+			var lv *catalog.Version
+			if f.modules {
+				if m, err := c.Module(modPath); err == nil {
+					lv = m.Latest()
+				}
+			} else {
+				lv, _ = c.LatestSource(modPath)
+			}
+			if lv != nil {
+				v = lv.Version
+			}
+			latestVersion[modPath] = v
+		}
+		return version == v
+	}
+
+	if f.modules {
+		for _, r := range ix.Requirers(arg) {
+			if f.latest && !isLatest(r.Module.Path, r.Module.Version) {
+				continue
+			}
+			if f.jsonOut {
+				writeJSON(w, r)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\n", r.Module, r.Version)
+		}
+		return
+	}
+	for _, imp := range ix.Importers(arg) {
+		if f.latest && !isLatest(imp.Module.Path, imp.Module.Version) {
+			continue
+		}
+		if f.jsonOut {
+			writeJSON(w, imp)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", imp.ImportPath, imp.Module, imp.Imports)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/internal/modtest"
+	"github.com/julieqiu/modcache/rdeps"
+)
+
+func TestListRdeps(t *testing.T) {
+	modCache := t.TempDir()
+	var cacheDir string
+	for _, m := range []struct {
+		path, version, lib, imp string
+	}{
+		{"example.com/u", "v1.0.0", "v1.0.0", "example.com/lib/p"},
+		{"example.com/u", "v1.1.0", "v1.1.0", "example.com/lib/p"},
+		{"example.com/v", "v1.0.0", "v1.0.0", "example.com/lib/p/sub"},
+	} {
+		cacheDir, _ = modtest.WriteModule(t, modCache, m.path, m.version, map[string]string{
+			"go.mod": "module " + m.path + "\n\nrequire example.com/lib " + m.lib + "\n",
+			"x.go":   "package x\n\nimport _ \"" + m.imp + "\"\n",
+		}, m.version == "v1.0.0")
+	}
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix, err := rdeps.Open(filepath.Join(t.TempDir(), "rdeps"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ix.Update(c); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		arg   string
+		flags rdepsFlags
+		want  string
+	}{
+		{"example.com/lib/p", rdepsFlags{}, "example.com/u\texample.com/u@v1.0.0\texample.com/lib/p\nexample.com/u\texample.com/u@v1.1.0\texample.com/lib/p\n"},
+		{"example.com/lib/...", rdepsFlags{latest: true}, "example.com/u\texample.com/u@v1.1.0\texample.com/lib/p\nexample.com/v\texample.com/v@v1.0.0\texample.com/lib/p/sub\n"},
+		{"example.com/lib", rdepsFlags{}, ""},
+		{"example.com/lib", rdepsFlags{modules: true}, "example.com/u@v1.0.0\tv1.0.0\nexample.com/u@v1.1.0\tv1.1.0\nexample.com/v@v1.0.0\tv1.0.0\n"},
+		{"example.com/lib", rdepsFlags{modules: true, latest: true, jsonOut: true}, `{"Module":{"Path":"example.com/u","Version":"v1.1.0"},"Version":"v1.1.0"}` + "\n" +
+			`{"Module":{"Path":"example.com/v","Version":"v1.0.0"},"Version":"v1.0.0"}` + "\n"},
+		{"example.com/lib/p/sub", rdepsFlags{jsonOut: true}, `{"ImportPath":"example.com/v","Module":{"Path":"example.com/v","Version":"v1.0.0"},"Imports":"example.com/lib/p/sub"}` + "\n"},
+	} {
+		var buf bytes.Buffer
+		listRdeps(&buf, c, ix, tt.arg, &tt.flags)
+		if buf.String() != tt.want {
+			t.Errorf("rdeps %+v %s:\n%s\nwant:\n%s", tt.flags, tt.arg, buf.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/index"
+	"golang.org/x/mod/semver"
+)
+
+// A searchHit is the JSON form of a search result printed by
+// "gocmd search -json".
+type searchHit struct {
+	File     string
+	Line     int
+	Column   int
+	Text     string
+	Module   string   `json:",omitempty"`
+	Version  string   `json:",omitempty"`
+	Package  string   `json:",omitempty"`
+	Versions []string `json:",omitempty"` // versions with an identical copy of File
+	Before   []string `json:",omitempty"` // context lines before Text
+	After    []string `json:",omitempty"` // context lines after Text
+}
+
+// runSearch implements "gocmd search", which searches the source of the
+// modules in the module cache using the trigram index built by
+// "gocmd index". Matches are printed as path:line:col:text, like
+// "grep -n", so that editors can parse them. Files of module versions
+// indexed from their zip files are named as if extracted.
+//
+// A file that is identical across several versions of a module is
+// reported once, for the highest of those versions, with the range of
+// versions containing it: "(found in v1.2.0–v1.5.3)". With -l, file
+// names are printed alone, and the versions are only in -json output.
+// As with grep, -m counts the lines printed, and -C merges the context
+// of nearby matches.
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	var f searchFlags
+	dir := fs.String("index", indexDir(), "index directory")
+	fs.BoolVar(&f.ignoreCase, "i", false, "match case-insensitively")
+	fs.BoolVar(&f.filesOnly, "l", false, "print only the names of files containing matches")
+	fs.IntVar(&f.context, "C", 0, "print `N` lines of context around each match")
+	fs.IntVar(&f.maxResults, "m", 0, "stop after `N` matches")
+	fs.BoolVar(&f.jsonOut, "json", false, "print matches as JSON objects, one per line")
+	fs.StringVar(&f.modFilter, "module", "", "search only modules matching `pattern` (a path, or a path ending in /...)")
+	fs.StringVar(&f.pkgFilter, "pkg", "", "search only packages matching `pattern` (a path, or a path ending in /...)")
+	fs.StringVar(&f.fileGlob, "file", "", "search only files whose base name matches `glob`")
+	fs.BoolVar(&f.latest, "latest", false, "search only the latest extracted version of each module")
+	fs.BoolVar(&f.all, "all", false, "report every copy of identical files, instead of one per module")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gocmd search [flags] regexp")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ix, err := index.OpenDir(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := search(os.Stdout, c, ix, fs.Arg(0), &f); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// searchFlags holds the flags of "gocmd search".
+type searchFlags struct {
+	ignoreCase bool
+	filesOnly  bool
+	context    int
+	maxResults int
+	jsonOut    bool
+	modFilter  string
+	pkgFilter  string
+	fileGlob   string
+	latest     bool
+	all        bool
+}
+
+// search writes to w the matches of expr in the module versions in c
+// indexed by ix, as directed by f.
+func search(w io.Writer, c *catalog.Catalog, ix *index.Dir, expr string, f *searchFlags) error {
+	latestVersion := make(map[string]string)
+	filter := func(name string) bool {
+		if f.fileGlob != "" {
+			if ok, _ := filepath.Match(f.fileGlob, filepath.Base(name)); !ok {
+				return false
+			}
+		}
+		if f.modFilter == "" && f.pkgFilter == "" && !f.latest {
+			return true
+		}
+		modPath, version, rel, ok := c.ParseSourcePath(name)
+		if !ok {
+			return false
+		}
+		if f.modFilter != "" && !matchPattern(f.modFilter, modPath) {
+			return false
+		}
+		if f.pkgFilter != "" && !matchPattern(f.pkgFilter, path.Join(modPath, path.Dir(rel))) {
+			return false
+		}
+		if f.latest {
+			v, ok := latestVersion[modPath]
+			if !ok {
+				if lv, err := c.LatestSource(modPath); err == nil {
+					v = lv.Version
+				}
+				latestVersion[modPath] = v
+			}
+			if version != v {
+				return false
+			}
+		}
+		return true
+	}
+
+	src := newSourceReader(c)
+	defer src.Close()
+	opts := &index.SearchOptions{IgnoreCase: f.ignoreCase, Filter: filter, ReadFile: src.ReadFile}
+	if !f.filesOnly {
+		// Each result is printed at least once, so no more are
+		// needed.
+		opts.MaxResults = f.maxResults
+	}
+	results, err := ix.Search(expr, opts)
+	if err != nil {
+		return err
+	}
+
+	copies := func(r index.Result) []*fileCopies {
+		if f.all {
+			var list []*fileCopies
+			for _, file := range r.Files {
+				list = append(list, groupCopies(c, []string{file})...)
+			}
+			return list
+		}
+		return groupCopies(c, r.Files)
+	}
+
+	if f.filesOnly {
+		var n int
+		seen := make(map[string]bool)
+		for _, r := range results {
+			for _, g := range copies(r) {
+				if seen[g.file] {
+					continue
+				}
+				seen[g.file] = true
+				if f.maxResults > 0 && n >= f.maxResults {
+					return nil
+				}
+				n++
+				if f.jsonOut {
+					writeJSON(w, searchHit{File: g.file, Versions: g.versionList()})
+				} else {
+					fmt.Fprintln(w, g.file)
+				}
+			}
+		}
+		return nil
+	}
+
+	// The lines printed are counted against -m, and grouped by file
+	// so that the context of nearby matches in a file can be merged.
+	var matches []searchMatch
+	var files []string
+	byFile := make(map[string][]searchMatch)
+	for _, r := range results {
+		for _, g := range copies(r) {
+			if f.maxResults > 0 && len(matches) >= f.maxResults {
+				break
+			}
+			m := searchMatch{g, r}
+			matches = append(matches, m)
+			if _, ok := byFile[g.file]; !ok {
+				files = append(files, g.file)
+			}
+			byFile[g.file] = append(byFile[g.file], m)
+		}
+	}
+
+	if f.jsonOut {
+		for _, m := range matches {
+			g, r := m.g, m.r
+			hit := searchHit{File: g.file, Line: r.Line, Column: r.Column, Text: r.Text, Versions: g.versionList()}
+			if f.context > 0 {
+				hit.Before, hit.After = contextLines(readLines(src, g.file), r.Line-1, f.context)
+			}
+			if g.module != "" {
+				hit.Module, hit.Version, hit.Package = g.module, g.versions[len(g.versions)-1], path.Join(g.module, path.Dir(g.rel))
+			}
+			writeJSON(w, hit)
+		}
+		return nil
+	}
+
+	p := &contextPrinter{w: w, n: f.context}
+	for _, file := range files {
+		var lines []string
+		if f.context > 0 {
+			lines = readLines(src, file)
+		}
+		p.startFile(file, lines)
+		for _, m := range byFile[file] {
+			p.match(m.r.Line, fmt.Sprintf("%d:%s%s", m.r.Column, m.r.Text, m.g.foundIn()))
+		}
+	}
+	p.flush()
+	return nil
+}
+
+// A searchMatch is a matching line in a group of copies of a file.
+type searchMatch struct {
+	g *fileCopies
+	r index.Result
+}
+
+// readLines returns the lines of the named file, or nil if it cannot
+// be read.
+func readLines(src *sourceReader, file string) []string {
+	data, err := src.ReadFile(file)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	return strings.Split(string(bytes.TrimSuffix(data, []byte("\n"))), "\n")
+}
+
+// A contextPrinter prints matching lines with n lines of context around
+// them, the way grep -C does: the context of matches that are close
+// together in a file is merged, and separate groups of lines are
+// separated by "--".
+type contextPrinter struct {
+	w       io.Writer
+	n       int
+	file    string
+	lines   []string // of file; nil if there is no context to print
+	printed int      // last line of file printed, or 0
+	pending int      // last line of context after printed still to print
+	started bool     // whether a group of lines has been printed
+}
+
+// startFile starts printing the matches in file, whose lines are lines.
+func (p *contextPrinter) startFile(file string, lines []string) {
+	p.flush()
+	p.file, p.lines, p.printed, p.pending = file, lines, 0, 0
+}
+
+// match prints the matching line numbered line, followed by text, and
+// the context before it. Matches must be in increasing line order.
+func (p *contextPrinter) match(line int, text string) {
+	before, after := contextLines(p.lines, line-1, p.n)
+	if first := line - len(before); p.printed == 0 || first > p.pending+1 {
+		// Not contiguous with the last group of lines.
+		p.flush()
+		if p.started && p.n > 0 {
+			fmt.Fprintln(p.w, "--")
+		}
+		p.printed = first - 1
+	}
+	p.pending = line - 1
+	p.context(line - 1)
+	fmt.Fprintf(p.w, "%s:%d:%s\n", p.file, line, text)
+	p.started = true
+	p.printed, p.pending = line, line+len(after)
+}
+
+// context prints the context lines after those printed up to line
+// last, or up to the pending context, whichever comes first.
+func (p *contextPrinter) context(last int) {
+	if last > p.pending {
+		last = p.pending
+	}
+	for l := p.printed + 1; l <= last; l++ {
+		fmt.Fprintf(p.w, "%s-%d-%s\n", p.file, l, p.lines[l-1])
+	}
+	if last > p.printed {
+		p.printed = last
+	}
+}
+
+// flush prints the pending context after the last match.
+func (p *contextPrinter) flush() {
+	p.context(p.pending)
+}
+
+// contextLines returns up to n lines on either side of lines[i].
+// It returns no lines if i is out of range, as when the file could
+// not be read.
+func contextLines(lines []string, i, n int) (before, after []string) {
+	if i < 0 || i >= len(lines) {
+		return nil, nil
+	}
+	lo, hi := i-n, i+1+n
+	if lo < 0 {
+		lo = 0
+	}
+	if hi > len(lines) {
+		hi = len(lines)
+	}
+	return lines[lo:i], lines[i+1 : hi]
+}
+
+// A fileCopies is a set of identical copies of a file, at the same path
+// in several versions of a module.
+type fileCopies struct {
+	file     string   // the copy in the highest version
+	module   string   // module path, or "" if file is not in the module cache
+	rel      string   // path of the file within the module
+	versions []string // versions containing a copy, in semver order
+}
+
+// groupCopies groups files, which have identical contents, by module
+// and path within the module, so that a file unchanged across versions
+// is reported once.
+func groupCopies(c *catalog.Catalog, files []string) []*fileCopies {
+	var list []*fileCopies
+	byKey := make(map[string]*fileCopies)
+	latest := make(map[*fileCopies]string)
+	for _, file := range files {
+		modPath, version, rel, ok := c.ParseSourcePath(file)
+		if !ok {
+			list = append(list, &fileCopies{file: file})
+			continue
+		}
+		key := modPath + "@/" + rel
+		g := byKey[key]
+		if g == nil {
+			g = &fileCopies{file: file, module: modPath, rel: rel}
+			byKey[key] = g
+			list = append(list, g)
+		}
+		g.versions = append(g.versions, version)
+		if semver.Compare(version, latest[g]) > 0 {
+			g.file = file
+			latest[g] = version
+		}
+	}
+	for _, g := range list {
+		sort.Slice(g.versions, func(i, j int) bool { return semver.Compare(g.versions[i], g.versions[j]) < 0 })
+	}
+	return list
+}
+
+// foundIn returns the suffix noting the versions containing g,
+// or "" if there is only one.
+func (g *fileCopies) foundIn() string {
+	if len(g.versions) < 2 {
+		return ""
+	}
+	return fmt.Sprintf(" (found in %s–%s)", g.versions[0], g.versions[len(g.versions)-1])
+}
+
+// versionList returns g's versions if there are several, or nil.
+func (g *fileCopies) versionList() []string {
+	if len(g.versions) < 2 {
+		return nil
+	}
+	return g.versions
+}
+
+// matchPattern reports whether path matches pattern, which is either
+// a path or a path ending in "/...", matching that path and everything
+// below it.
+func matchPattern(pattern, path string) bool {
+	if prefix := strings.TrimSuffix(pattern, "/..."); prefix != pattern {
+		return path == prefix || strings.HasPrefix(path, prefix+"/")
+	}
+	return path == pattern
+}
+
+func printJSON(v interface{}) {
+	writeJSON(os.Stdout, v)
+}
+
+func writeJSON(w io.Writer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(w, "%s\n", data)
+}
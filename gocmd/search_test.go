@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/index"
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+const searchSource = `package a
+
+// Hello says hello.
+func Hello() string { return "hello" }
+
+// Goodbye says goodbye.
+func Goodbye() string { return "goodbye" }
+
+
+
+func Other() {}
+`
+
+// TestSearch compares the output of search with the golden files in
+// testdata/search, in which $MODCACHE stands for the module cache.
+// Run "go test -update" to rewrite them.
+func TestSearch(t *testing.T) {
+	modCache := t.TempDir()
+	var cacheDir string
+	for _, m := range []struct {
+		version string
+		files   map[string]string
+	}{
+		{"v1.0.0", map[string]string{"a.go": searchSource}},
+		// Identical copies at two paths are reported separately.
+		{"v1.1.0", map[string]string{
+			"a.go":     searchSource,
+			"dup/x.go": "package dup\n\nfunc Dup() {}\n",
+			"dup/y.go": "package dup\n\nfunc Dup() {}\n",
+		}},
+	} {
+		m.files["go.mod"] = "module example.com/m\n"
+		// The newer version is read from its zip file.
+		cacheDir, _ = modtest.WriteModule(t, modCache, "example.com/m", m.version, m.files, m.version == "v1.0.0")
+	}
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix, err := index.OpenDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ix.Update(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// Searches stopped by -m see the results of the segments of an
+	// index in no particular order, so those below match in only
+	// one version.
+	for _, tt := range []struct {
+		name  string
+		expr  string
+		flags searchFlags
+	}{
+		{"found_in", `Hello`, searchFlags{}},
+		{"files", `Dup`, searchFlags{filesOnly: true}},
+		{"files_max", `Dup`, searchFlags{filesOnly: true, maxResults: 1}},
+		{"files_json", `Dup`, searchFlags{filesOnly: true, jsonOut: true}},
+		// One result, printed for each copy: -m counts the lines.
+		{"max_copies", `Dup`, searchFlags{maxResults: 1}},
+		{"max", `^func|^package`, searchFlags{maxResults: 3, pkgFilter: "example.com/m/dup"}},
+		// Adjacent context is merged.
+		{"context1", `^func`, searchFlags{context: 1, fileGlob: "a.go"}},
+		// Overlapping context is merged.
+		{"context2", `^func`, searchFlags{context: 2, fileGlob: "a.go"}},
+		{"context_files", `^func Dup`, searchFlags{context: 1}},
+		{"json", `Goodbye|Dup`, searchFlags{jsonOut: true, context: 1}},
+		{"json_max", `Dup`, searchFlags{jsonOut: true, maxResults: 1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := search(&buf, c, ix, tt.expr, &tt.flags); err != nil {
+				t.Fatal(err)
+			}
+			got := strings.ReplaceAll(buf.String(), modCache, "$MODCACHE")
+			golden := filepath.Join("testdata", "search", tt.name+".golden")
+			if *update {
+				modtest.WriteFile(t, golden, got)
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("search %q:\n%s\nwant:\n%s", tt.expr, got, want)
+			}
+		})
+	}
+}
+
+func TestGroupCopies(t *testing.T) {
+	modCache := t.TempDir()
+	cacheDir := filepath.Join(modCache, "cache", "download")
+	if err := os.MkdirAll(cacheDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := func(s string) string { return filepath.Join(modCache, filepath.FromSlash(s)) }
+	groups := groupCopies(c, []string{
+		file("example.com/m@v1.10.0/a.go"),
+		file("example.com/m@v1.2.0/a.go"),
+		file("example.com/m@v1.9.0/b/a.go"),
+		file("example.com/n@v0.1.0/a.go"),
+		file("example.com/m@v1.0.0/a.go"),
+		"/elsewhere/a.go",
+	})
+	var got []string
+	for _, g := range groups {
+		got = append(got, strings.ReplaceAll(g.file, modCache, "$MODCACHE")+g.foundIn())
+	}
+	want := []string{
+		filepath.FromSlash("$MODCACHE/example.com/m@v1.10.0/a.go") + " (found in v1.0.0–v1.10.0)",
+		filepath.FromSlash("$MODCACHE/example.com/m@v1.9.0/b/a.go"),
+		filepath.FromSlash("$MODCACHE/example.com/n@v0.1.0/a.go"),
+		"/elsewhere/a.go",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("groupCopies:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if v := groups[0].versionList(); strings.Join(v, " ") != "v1.0.0 v1.2.0 v1.10.0" {
+		t.Errorf("versionList = %v, want [v1.0.0 v1.2.0 v1.10.0]", v)
+	}
+	if v := groups[1].versionList(); v != nil {
+		t.Errorf("versionList of one copy = %v, want nil", v)
+	}
+}
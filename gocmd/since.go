@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/token"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/julieqiu/modcache/cache"
+	"github.com/julieqiu/modcache/catalog"
+)
+
+// runSince implements "gocmd since", which reports the cached versions
+// of a module in which an exported symbol of one of its packages was
+// added, changed its declaration, or was removed, answering which
+// minimum version provides it. The symbol is named as pkg.Name, or
+// pkg.T.M for a method.
+func runSince(args []string) {
+	fs := flag.NewFlagSet("since", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "print changes as JSON objects, one per line")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gocmd since [-json] pkg.Symbol")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pkgPath, symbol, err := splitSymbol(c, fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	changes, err := cache.History(c, pkgPath, symbol)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(changes) == 0 {
+		log.Fatalf("%s.%s: not found in any cached version", pkgPath, symbol)
+	}
+	for _, ch := range changes {
+		if *jsonOut {
+			printJSON(ch)
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\n", ch.Version, ch.Kind, ch.Decl)
+	}
+}
+
+// splitSymbol splits pkg.Symbol, where pkg may itself contain dots, as
+// in gopkg.in/yaml.v3.Unmarshal, and Symbol may be T.M. Each dot after
+// the last slash is tried in turn, longest package path first, and the
+// first split whose package path is provided by a cached module is
+// used.
+func splitSymbol(c *catalog.Catalog, arg string) (pkgPath, symbol string, err error) {
+	err = fmt.Errorf("%s: want package path followed by .Symbol", arg)
+	slash := strings.LastIndex(arg, "/")
+	for i := len(arg) - 1; i > slash; i-- {
+		if arg[i] != '.' || !isSymbol(arg[i+1:]) {
+			continue
+		}
+		if _, rerr := c.Resolve(arg[:i], ""); rerr != nil {
+			err = fmt.Errorf("%s: %v", arg, rerr)
+			continue
+		}
+		return arg[:i], arg[i+1:], nil
+	}
+	return "", "", err
+}
+
+// isSymbol reports whether s names a top-level symbol, Name, or a
+// method, T.M.
+func isSymbol(s string) bool {
+	parts := strings.Split(s, ".")
+	if len(parts) > 2 {
+		return false
+	}
+	for _, p := range parts {
+		if !token.IsIdentifier(p) {
+			return false
+		}
+	}
+	return true
+}
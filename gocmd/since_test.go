@@ -0,0 +1,64 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+func TestSplitSymbol(t *testing.T) {
+	modCache := t.TempDir()
+	for _, m := range []struct {
+		path, version string
+		files         map[string]string
+	}{
+		{"gopkg.in/yaml.v3", "v3.0.1", map[string]string{
+			"go.mod":  "module gopkg.in/yaml.v3\n",
+			"yaml.go": "package yaml\n\nfunc Unmarshal([]byte, any) error { return nil }\n",
+		}},
+		{"example.com/m", "v1.0.0", map[string]string{
+			"go.mod":      "module example.com/m\n",
+			"m.go":        "package m\n\ntype T int\n\nfunc (T) M() {}\n",
+			"pkg.v2/p.go": "package p\n\nfunc F() {}\n",
+			"a/a.go":      "package a\n\ntype b int\n",
+			"a.b/ab.go":   "package ab\n\nfunc F() {}\n",
+		}},
+	} {
+		modtest.WriteModule(t, modCache, m.path, m.version, m.files, true)
+	}
+	c, err := catalog.Open(filepath.Join(modCache, "cache", "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		arg, pkgPath, symbol string // pkgPath "" if an error is wanted
+	}{
+		{"gopkg.in/yaml.v3.Unmarshal", "gopkg.in/yaml.v3", "Unmarshal"},
+		{"example.com/m.T", "example.com/m", "T"},
+		{"example.com/m.T.M", "example.com/m", "T.M"},
+		{"example.com/m/pkg.v2.F", "example.com/m/pkg.v2", "F"},
+		{"example.com/m/pkg.v2.T.M", "example.com/m/pkg.v2", "T.M"},
+		// The longest package path wins.
+		{"example.com/m/a.b.F", "example.com/m/a.b", "F"},
+		{"example.com/m", "", ""},
+		{"example.com/m.", "", ""},
+		{"example.com/m.A.B.C", "", ""},
+		{"example.com/m.1x", "", ""},
+		{"gopkg.in/yaml.v2.Unmarshal", "", ""},
+		{"example.com/none.F", "", ""},
+	} {
+		pkgPath, symbol, err := splitSymbol(c, tt.arg)
+		if tt.pkgPath == "" {
+			if err == nil {
+				t.Errorf("splitSymbol(%q) = %q, %q, want error", tt.arg, pkgPath, symbol)
+			}
+			continue
+		}
+		if err != nil || pkgPath != tt.pkgPath || symbol != tt.symbol {
+			t.Errorf("splitSymbol(%q) = %q, %q, %v, want %q, %q", tt.arg, pkgPath, symbol, err, tt.pkgPath, tt.symbol)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+
+	"github.com/julieqiu/modcache/catalog"
+)
+
+// A sourceReader reads files in the module cache, falling back to the
+// zip file of a module version that has not been extracted.
+type sourceReader struct {
+	c    *catalog.Catalog
+	zips map[string]*catalog.ZipDir // by module@version; nil if there is no zip
+}
+
+func newSourceReader(c *catalog.Catalog) *sourceReader {
+	return &sourceReader{c: c, zips: make(map[string]*catalog.ZipDir)}
+}
+
+// ReadFile returns the contents of the named file, which need not
+// have been extracted.
+func (r *sourceReader) ReadFile(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err == nil || !os.IsNotExist(err) {
+		return data, err
+	}
+	modPath, version, _, ok := r.c.ParseSourcePath(name)
+	if !ok {
+		return nil, err
+	}
+	key := modPath + "@" + version
+	z, ok := r.zips[key]
+	if !ok {
+		if m, merr := r.c.Module(modPath); merr == nil {
+			if v := m.Lookup(version); v != nil && v.Zip != "" {
+				z, _ = r.c.OpenZip(v)
+			}
+		}
+		r.zips[key] = z
+	}
+	if z == nil {
+		return nil, err
+	}
+	return z.ReadFile(name)
+}
+
+// Close closes the zip files opened by r.
+func (r *sourceReader) Close() {
+	for _, z := range r.zips {
+		if z != nil {
+			z.Close()
+		}
+	}
+}
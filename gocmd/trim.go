@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/load"
+)
+
+// runTrim implements "gocmd trim", which trims the package metadata
+// caches stored alongside each module in the download cache. The caches
+// are trimmed together, so -max-size bounds their total size, and the
+// time of the last trim is recorded in trim.txt in the download cache.
+func runTrim(args []string) {
+	fs := flag.NewFlagSet("trim", flag.ExitOnError)
+	var (
+		dryRun  = fs.Bool("dry-run", false, "report what would be removed without removing it")
+		force   = fs.Bool("force", false, "trim even if the caches were trimmed recently")
+		maxAge  = fs.Duration("max-age", 0, "remove entries unused for longer than this")
+		maxSize = fs.String("max-size", "", "remove least recently used entries until the caches total at most this size (for example 512M or 2G)")
+	)
+	fs.Parse(args)
+
+	opts := load.TrimOptions{MaxAge: *maxAge, Force: *force, DryRun: *dryRun}
+	if *maxSize != "" {
+		n, err := parseSize(*maxSize)
+		if err != nil {
+			log.Fatalf("invalid -max-size: %v", err)
+		}
+		opts.MaxSize = n
+	}
+
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mods, err := c.Modules()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var caches []*load.Cache
+	for _, m := range mods {
+		mc, err := load.ModCache(*cacheDir, m.Path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		caches = append(caches, mc)
+	}
+	trimmed, err := load.Trim(caches, filepath.Join(*cacheDir, "trim.txt"), opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+	var size int64
+	for _, f := range trimmed {
+		fmt.Printf("%s %s\t%d\t%s\n", verb, f.Name, f.Size, f.ModTime.Format("2006-01-02T15:04:05"))
+		size += f.Size
+	}
+	fmt.Printf("%s %d files, %d bytes\n", verb, len(trimmed), size)
+}
+
+// parseSize parses a non-negative byte count with an optional K, M or
+// G suffix.
+func parseSize(size string) (int64, error) {
+	s := size
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative size %s", size)
+	}
+	if n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("size %s out of range", size)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/julieqiu/modcache/catalog"
+)
+
+// runVerify implements "gocmd verify", which checks that the zip files
+// and extracted directories in the module cache have not been modified
+// since they were downloaded, by recomputing their hashes and comparing
+// them with the .ziphash files and, given -gosum, a project's go.sum.
+//
+// The arguments select the module versions to verify, as path@version
+// or as a module path standing for all its versions. With no
+// arguments, the versions listed in the go.sum file are verified if
+// -gosum is set, and every version in the cache otherwise.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var (
+		goSum   = fs.String("gosum", "", "also compare hashes with those in the go.sum `file`")
+		verbose = fs.Bool("v", false, "print every module version verified")
+	)
+	fs.Parse(args)
+
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var sum catalog.GoSum
+	if *goSum != "" {
+		if sum, err = catalog.ReadGoSum(*goSum); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var versions []*catalog.Version
+	switch {
+	case fs.NArg() > 0:
+		for _, arg := range fs.Args() {
+			list, err := selectVersions(c, arg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			versions = append(versions, list...)
+		}
+	case sum != nil:
+		var keys []string
+		for key := range sum {
+			if !strings.HasSuffix(key, "/go.mod") {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			f := strings.Fields(key)
+			v, err := selectVersions(c, f[0]+"@"+f[1])
+			if err != nil {
+				if *verbose {
+					fmt.Printf("%s@%s: not in module cache\n", f[0], f[1])
+				}
+				continue
+			}
+			versions = append(versions, v...)
+		}
+	default:
+		mods, err := c.Modules()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range mods {
+			versions = append(versions, m.Versions...)
+		}
+	}
+
+	failed := false
+	for _, v := range versions {
+		r, err := c.Verify(v, sum)
+		if err != nil {
+			fmt.Printf("%s: %v\n", v, err)
+			failed = true
+			continue
+		}
+		errs := r.Errors()
+		for _, e := range errs {
+			fmt.Printf("%s: %s\n", v, e)
+		}
+		if len(errs) > 0 {
+			failed = true
+		} else if *verbose {
+			fmt.Printf("%s: ok\n", v)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+	fmt.Println("all modules verified")
+}
+
+// selectVersions returns the cached versions of the module named by
+// arg, which is path@version or a module path standing for all its
+// versions.
+func selectVersions(c *catalog.Catalog, arg string) ([]*catalog.Version, error) {
+	modPath, version := arg, ""
+	if i := strings.Index(arg, "@"); i >= 0 {
+		modPath, version = arg[:i], arg[i+1:]
+	}
+	m, err := c.Module(modPath)
+	if err != nil {
+		return nil, err
+	}
+	if version == "" {
+		return m.Versions, nil
+	}
+	v := m.Lookup(version)
+	if v == nil {
+		return nil, fmt.Errorf("%s@%s: not in module cache", modPath, version)
+	}
+	return []*catalog.Version{v}, nil
+}
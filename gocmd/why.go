@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"io"
+	"log"
+	"os"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/modgraph"
+)
+
+// runWhy implements "gocmd why", which explains why each module named
+// as an argument (path, or path@version for a specific version) is in
+// the requirement graph of a main module, by printing the shortest
+// chain of requirements leading to it, computed from the go.mod files
+// in the module cache. Unless -m is set, it also prints the shortest
+// chain of imports from a package of the main module to one of the
+// module's packages, read from the package metadata cache when present.
+func runWhy(args []string) {
+	fs := flag.NewFlagSet("why", flag.ExitOnError)
+	var (
+		goMod   = fs.String("gomod", "go.mod", "explain the requirement graph of the go.mod or go.work `file`")
+		modOnly = fs.Bool("m", false, "print only the requirement chain, not the import chain")
+	)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: gocmd why [-gomod file] [-m] module[@version]...")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	c, err := catalog.Open(*cacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	g, err := modgraph.Load(c, *goMod)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := why(os.Stdout, c, g, fs.Args(), *modOnly); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// why writes to w the explanation of why each module in args is in the
+// requirement graph g, as printed by "gocmd why".
+func why(w io.Writer, c *catalog.Catalog, g *modgraph.Graph, args []string, modOnly bool) error {
+	ctx := build.Default
+	for i, arg := range args {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "# %s\n", arg)
+		chain := g.Why(arg)
+		if chain == nil {
+			fmt.Fprintf(w, "(main module does not require %s)\n", arg)
+			continue
+		}
+		for _, m := range chain {
+			fmt.Fprintln(w, m)
+		}
+		if modOnly {
+			continue
+		}
+		target := chain[len(chain)-1].Path
+		pkgs, err := g.ImportChain(c, &ctx, target)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+		if pkgs == nil {
+			fmt.Fprintf(w, "(main module does not need to import packages of %s)\n", target)
+			continue
+		}
+		for _, p := range pkgs {
+			fmt.Fprintln(w, p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/internal/modtest"
+	"github.com/julieqiu/modcache/modgraph"
+)
+
+func TestWhy(t *testing.T) {
+	modCache := t.TempDir()
+	var cacheDir string
+	for _, m := range []struct {
+		path  string
+		files map[string]string
+	}{
+		{"example.com/x", map[string]string{
+			"go.mod": "module example.com/x\n\ngo 1.17\n\nrequire example.com/y v1.0.0\n",
+			"x.go":   "package x\n\nimport _ \"example.com/y/p\"\n",
+		}},
+		{"example.com/y", map[string]string{
+			"go.mod": "module example.com/y\n\ngo 1.17\n",
+			"p/p.go": "package p\n",
+		}},
+		{"example.com/z", map[string]string{
+			"go.mod": "module example.com/z\n\ngo 1.17\n",
+			"z.go":   "package z\n",
+		}},
+	} {
+		cacheDir, _ = modtest.WriteModule(t, modCache, m.path, "v1.0.0", m.files, true)
+	}
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	modtest.WriteFile(t, filepath.Join(dir, "go.mod"), "module example.com/main\n\ngo 1.17\n\nrequire (\n\texample.com/x v1.0.0\n\texample.com/y v1.0.0\n\texample.com/z v1.0.0\n)\n")
+	modtest.WriteFile(t, filepath.Join(dir, "main.go"), "package main\n\nimport _ \"example.com/x\"\n\nfunc main() {}\n")
+	g, err := modgraph.Load(c, filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		args    []string
+		modOnly bool
+		want    string
+	}{
+		{[]string{"example.com/y", "example.com/z", "example.com/none"}, false, `# example.com/y
+example.com/main
+example.com/y@v1.0.0
+
+example.com/main
+example.com/x
+example.com/y/p
+
+# example.com/z
+example.com/main
+example.com/z@v1.0.0
+
+(main module does not need to import packages of example.com/z)
+
+# example.com/none
+(main module does not require example.com/none)
+`},
+		{[]string{"example.com/y@v1.0.0", "example.com/y@v1.1.0"}, true, `# example.com/y@v1.0.0
+example.com/main
+example.com/y@v1.0.0
+
+# example.com/y@v1.1.0
+(main module does not require example.com/y@v1.1.0)
+`},
+	} {
+		var buf bytes.Buffer
+		if err := why(&buf, c, g, tt.args, tt.modOnly); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("why %v (modOnly %v):\n%s\nwant:\n%s", tt.args, tt.modOnly, buf.String(), tt.want)
+		}
+	}
+}
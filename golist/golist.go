@@ -0,0 +1,572 @@
+// Package golist describes packages in the module cache in the form
+// printed by "go list -json", without running the go command: package
+// metadata is read using load.LegacyCachedImport, so that the metadata
+// of packages and their files comes from the cache when present, and
+// module metadata from the download cache.
+package golist
+
+import (
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/modgraph"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// A Package is a package as described by "go list -json". Fields that
+// depend on building the package, such as Target, Stale and
+// CompiledGoFiles, and those needing the files matched by embed
+// patterns to be resolved, such as EmbedFiles, are not set.
+type Package struct {
+	Dir           string   `json:",omitempty"` // directory containing package sources
+	ImportPath    string   `json:",omitempty"` // import path of package in dir
+	ImportComment string   `json:",omitempty"` // path in import comment on package statement
+	Name          string   `json:",omitempty"` // package name
+	Doc           string   `json:",omitempty"` // package documentation string
+	Root          string   `json:",omitempty"` // Go root or module root dir containing this package
+	Module        *Module  `json:",omitempty"` // info about package's module, if any
+	Match         []string `json:",omitempty"` // command-line patterns matching this package
+	Goroot        bool     `json:",omitempty"` // is this package found in the Go root?
+	Standard      bool     `json:",omitempty"` // is this package part of the standard Go library?
+	DepOnly       bool     `json:",omitempty"` // package is only as a dependency, not explicitly listed
+	BinaryOnly    bool     `json:",omitempty"` // package cannot be recompiled
+	Incomplete    bool     `json:",omitempty"` // was there an error loading this package or dependencies?
+
+	// Source files
+	GoFiles           []string `json:",omitempty"` // .go source files (excluding CgoFiles, TestGoFiles, XTestGoFiles)
+	CgoFiles          []string `json:",omitempty"` // .go source files that import "C"
+	IgnoredGoFiles    []string `json:",omitempty"` // .go source files ignored due to build constraints
+	InvalidGoFiles    []string `json:",omitempty"` // .go source files with detected problems
+	IgnoredOtherFiles []string `json:",omitempty"` // non-.go source files ignored due to build constraints
+	CFiles            []string `json:",omitempty"` // .c source files
+	CXXFiles          []string `json:",omitempty"` // .cc, .cpp and .cxx source files
+	MFiles            []string `json:",omitempty"` // .m source files
+	HFiles            []string `json:",omitempty"` // .h, .hh, .hpp and .hxx source files
+	FFiles            []string `json:",omitempty"` // .f, .F, .for and .f90 Fortran source files
+	SFiles            []string `json:",omitempty"` // .s source files
+	SwigFiles         []string `json:",omitempty"` // .swig files
+	SwigCXXFiles      []string `json:",omitempty"` // .swigcxx files
+	SysoFiles         []string `json:",omitempty"` // .syso system object files added to package
+
+	// Embedded files
+	EmbedPatterns []string `json:",omitempty"` // //go:embed patterns
+
+	// Cgo directives
+	CgoCFLAGS    []string `json:",omitempty"` // cgo: flags for C compiler
+	CgoCPPFLAGS  []string `json:",omitempty"` // cgo: flags for C preprocessor
+	CgoCXXFLAGS  []string `json:",omitempty"` // cgo: flags for C++ compiler
+	CgoFFLAGS    []string `json:",omitempty"` // cgo: flags for Fortran compiler
+	CgoLDFLAGS   []string `json:",omitempty"` // cgo: flags for linker
+	CgoPkgConfig []string `json:",omitempty"` // cgo: pkg-config names
+
+	// Dependency information
+	Imports   []string          `json:",omitempty"` // import paths used by this package
+	ImportMap map[string]string `json:",omitempty"` // map from source import to ImportPath (identity entries omitted)
+	Deps      []string          `json:",omitempty"` // all (recursively) imported dependencies
+
+	// Error information
+	Error      *PackageError   `json:",omitempty"` // error loading this package (not dependencies)
+	DepsErrors []*PackageError `json:",omitempty"` // errors loading dependencies
+
+	// Test information
+	TestGoFiles        []string `json:",omitempty"` // _test.go files in package
+	TestImports        []string `json:",omitempty"` // imports from TestGoFiles
+	TestEmbedPatterns  []string `json:",omitempty"` // //go:embed patterns
+	XTestGoFiles       []string `json:",omitempty"` // _test.go files outside package
+	XTestImports       []string `json:",omitempty"` // imports from XTestGoFiles
+	XTestEmbedPatterns []string `json:",omitempty"` // //go:embed patterns
+
+	imports []string // imports resolved as for Deps, including those implied by cgo
+	err     error    // error loading the package, as in Error
+}
+
+// A Module is a module version as described by "go list -json".
+type Module struct {
+	Path      string     `json:",omitempty"` // module path
+	Version   string     `json:",omitempty"` // module version
+	Time      *time.Time `json:",omitempty"` // time version was created
+	Dir       string     `json:",omitempty"` // directory holding files for this module
+	GoMod     string     `json:",omitempty"` // path to go.mod file describing module
+	GoVersion string     `json:",omitempty"` // go version used in module
+	Sum       string     `json:",omitempty"` // checksum for path, version (as in go.sum)
+	GoModSum  string     `json:",omitempty"` // checksum for go.mod (as in go.sum)
+}
+
+// A PackageError is an error loading a package.
+type PackageError struct {
+	ImportStack []string // shortest path from package named on command line to this one
+	Pos         string   // position of error
+	Err         string   // the error itself
+}
+
+// List returns the packages matching args, each an import path pattern
+// optionally followed by @version, in the order printed by "go list".
+// A pattern may contain "..." wildcards, matching the packages of one
+// module version: the version given, or else the latest version whose
+// source is in the module cache. With deps set, List also returns the
+// packages they import, directly or indirectly, before the packages
+// importing them, as "go list -deps" does.
+//
+// The dependencies of the packages matching an argument are those of
+// the versions selected by the requirement graph of its module version
+// (see modgraph.LoadVersion) or, if some of the go.mod files needed for
+// the graph are not cached, the latest cached versions. Standard
+// library packages are read from GOROOT. Package directories are given
+// as if the module cache were fully extracted, even for versions only
+// present as zip files.
+func List(c *catalog.Catalog, ctx *build.Context, args []string, deps bool) ([]*Package, error) {
+	l := &lister{
+		c:    c,
+		ctx:  ctx,
+		pkgs: make(map[string]*Package),
+		mods: make(map[string]*Module),
+	}
+	defer l.close()
+	var roots []*Package
+	for _, arg := range args {
+		pattern, version := arg, ""
+		if i := strings.Index(arg, "@"); i >= 0 {
+			pattern, version = arg[:i], arg[i+1:]
+		}
+		pkgs, err := l.match(pattern, version)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pkgs {
+			if p.Match == nil {
+				roots = append(roots, p)
+			}
+			p.Match = append(p.Match, pattern)
+		}
+	}
+
+	var list []*Package
+	listed := make(map[*Package]bool)
+	var visit func(p *Package)
+	visit = func(p *Package) {
+		if listed[p] {
+			return
+		}
+		listed[p] = true
+		for _, imp := range p.imports {
+			if dep := l.pkgs[imp]; dep != nil && dep.Error == nil {
+				visit(dep)
+			}
+		}
+		if p.Match == nil {
+			p.DepOnly = true
+		}
+		list = append(list, p)
+	}
+	for _, p := range roots {
+		if deps {
+			visit(p)
+		} else {
+			list = append(list, p)
+		}
+	}
+	return list, nil
+}
+
+// A lister loads the packages of module versions in the module cache
+// and of the standard library.
+type lister struct {
+	c       *catalog.Catalog
+	ctx     *build.Context
+	pkgs    map[string]*Package // by import path
+	mods    map[string]*Module  // by module@version
+	loaders []*modgraph.Loader
+}
+
+func (l *lister) close() {
+	for _, r := range l.loaders {
+		r.Close()
+	}
+}
+
+// match loads the packages matching pattern in the module version
+// providing it, with their dependencies.
+func (l *lister) match(pattern, version string) ([]*Package, error) {
+	dir := pattern
+	if i := strings.Index(pattern, "..."); i >= 0 {
+		dir = strings.TrimSuffix(path.Dir(pattern[:i]+"x"), "/")
+	}
+	if pattern == "std" {
+		dir, pattern = "", "..."
+	}
+	if modgraph.IsStandard(dir) {
+		if version != "" {
+			return nil, fmt.Errorf("%s@%s: standard library packages have no module version", pattern, version)
+		}
+		return l.matchStandard(pattern)
+	}
+	pkg, err := l.c.Resolve(dir, version)
+	if err != nil {
+		return nil, err
+	}
+	v := pkg.Version
+	mv := module.Version{Path: v.Path, Version: v.Version}
+	g, err := modgraph.LoadVersion(l.c, mv)
+	if err != nil {
+		if _, ok := err.(*modgraph.MissingError); !ok {
+			return nil, err
+		}
+		g = nil
+	}
+	r := modgraph.NewLoader(l.c, g, mv)
+	l.loaders = append(l.loaders, r)
+
+	var importPaths []string
+	if dir == pattern {
+		importPaths = []string{pattern}
+	} else {
+		root, err := r.Locate(mv)
+		if err != nil {
+			return nil, err
+		}
+		dirs, err := catalog.PackageDirs(root.Root, root.Zip)
+		if err != nil {
+			return nil, err
+		}
+		match := matchPattern(pattern)
+		for _, d := range dirs {
+			rel, err := filepath.Rel(root.Root, d)
+			if err != nil {
+				return nil, err
+			}
+			if p := path.Join(v.Path, filepath.ToSlash(rel)); match(p) {
+				importPaths = append(importPaths, p)
+			}
+		}
+	}
+	var pkgs []*Package
+	for _, importPath := range importPaths {
+		p := l.load(importPath, "", r)
+		if p.Error != nil {
+			if _, ok := p.err.(*build.NoGoError); ok && dir != pattern {
+				continue
+			}
+			return nil, fmt.Errorf("%s", p.Error.Err)
+		}
+		l.deps(p, r, nil)
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+// matchStandard loads the standard library packages matching pattern,
+// with their dependencies.
+func (l *lister) matchStandard(pattern string) ([]*Package, error) {
+	importPaths := []string{pattern}
+	if strings.Contains(pattern, "...") {
+		importPaths = nil
+		src := filepath.Join(l.ctx.GOROOT, "src")
+		match := matchPattern(pattern)
+		err := filepath.Walk(src, func(dir string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.IsDir() {
+				return err
+			}
+			// As in the go command, the packages vendored in GOROOT
+			// are part of the standard library, but commands are not.
+			elem := fi.Name()
+			top := filepath.Dir(dir) == src
+			if dir != src && (elem == "testdata" || elem == "vendor" && !top || elem == "cmd" && top || strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_")) {
+				return filepath.SkipDir
+			}
+			rel, err := filepath.Rel(src, dir)
+			if err != nil {
+				return err
+			}
+			if p := filepath.ToSlash(rel); rel != "." && p != "builtin" && match(p) {
+				importPaths = append(importPaths, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var pkgs []*Package
+	for _, importPath := range importPaths {
+		p := l.load(importPath, "", nil)
+		if p.Error != nil {
+			if _, ok := p.err.(*build.NoGoError); ok && importPath != pattern {
+				continue
+			}
+			return nil, fmt.Errorf("%s", p.Error.Err)
+		}
+		l.deps(p, nil, nil)
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+// load returns the package importPath, imported by the package in
+// fromDir, or named on the command line if fromDir is "". The package
+// has Error set if it cannot be loaded. Packages of modules are
+// found using r.
+func (l *lister) load(importPath, fromDir string, r *modgraph.Loader) *Package {
+	importPath = modgraph.VendorPath(l.ctx, importPath, fromDir)
+	if p, ok := l.pkgs[importPath]; ok {
+		return p
+	}
+	p := &Package{ImportPath: importPath}
+	l.pkgs[importPath] = p
+
+	var bp *build.Package
+	var err error
+	switch {
+	case strings.HasPrefix(importPath, "vendor/"):
+		bp, err = l.ctx.ImportDir(filepath.Join(l.ctx.GOROOT, "src", filepath.FromSlash(importPath)), 0)
+	case modgraph.IsStandard(importPath):
+		bp, err = l.ctx.Import(importPath, "", 0)
+	case r == nil:
+		err = fmt.Errorf("no module provides package %s", importPath)
+	default:
+		var loc *modgraph.Location
+		loc, err = r.Find(importPath)
+		if err != nil {
+			break
+		}
+		bp, err = loc.Import(l.ctx)
+		if bp != nil {
+			bp.Root = loc.Root
+		}
+		if loc.Version != nil {
+			p.Module, _ = l.module(loc.Version)
+		}
+	}
+	if bp != nil {
+		p.fill(bp)
+	}
+	if err != nil {
+		p.Incomplete = true
+		p.Error = &PackageError{Err: err.Error()}
+		p.err = err
+	}
+	return p
+}
+
+// fill sets the fields of p from bp.
+func (p *Package) fill(bp *build.Package) {
+	p.Dir = bp.Dir
+	p.ImportComment = bp.ImportComment
+	p.Name = bp.Name
+	p.Doc = bp.Doc
+	p.Root = bp.Root
+	p.Goroot = bp.Goroot
+	p.Standard = bp.Goroot && modgraph.IsStandard(p.ImportPath)
+	p.BinaryOnly = bp.BinaryOnly
+	p.GoFiles = bp.GoFiles
+	p.CgoFiles = bp.CgoFiles
+	p.IgnoredGoFiles = bp.IgnoredGoFiles
+	p.InvalidGoFiles = bp.InvalidGoFiles
+	p.IgnoredOtherFiles = bp.IgnoredOtherFiles
+	p.CFiles = bp.CFiles
+	p.CXXFiles = bp.CXXFiles
+	p.MFiles = bp.MFiles
+	p.HFiles = bp.HFiles
+	p.FFiles = bp.FFiles
+	p.SFiles = bp.SFiles
+	p.SwigFiles = bp.SwigFiles
+	p.SwigCXXFiles = bp.SwigCXXFiles
+	p.SysoFiles = bp.SysoFiles
+	p.EmbedPatterns = bp.EmbedPatterns
+	p.CgoCFLAGS = bp.CgoCFLAGS
+	p.CgoCPPFLAGS = bp.CgoCPPFLAGS
+	p.CgoCXXFLAGS = bp.CgoCXXFLAGS
+	p.CgoFFLAGS = bp.CgoFFLAGS
+	p.CgoLDFLAGS = bp.CgoLDFLAGS
+	p.CgoPkgConfig = bp.CgoPkgConfig
+	p.TestGoFiles = bp.TestGoFiles
+	p.TestImports = bp.TestImports
+	p.TestEmbedPatterns = bp.TestEmbedPatterns
+	p.XTestGoFiles = bp.XTestGoFiles
+	p.XTestImports = bp.XTestImports
+	p.XTestEmbedPatterns = bp.XTestEmbedPatterns
+
+	// Imports of packages vendored in GOROOT are listed under their
+	// vendor paths, keeping the order of bp.Imports, as go list does.
+	for _, imp := range bp.Imports {
+		if p.Goroot && imp != "C" && !modgraph.IsStandard(imp) {
+			if p.ImportMap == nil {
+				p.ImportMap = make(map[string]string)
+			}
+			p.ImportMap[imp] = "vendor/" + imp
+			imp = "vendor/" + imp
+		}
+		p.Imports = append(p.Imports, imp)
+	}
+	if p.Goroot {
+		p.TestImports = vendorImports(bp.TestImports)
+		p.XTestImports = vendorImports(bp.XTestImports)
+	}
+
+	// As in the go command, cgo adds imports of "unsafe", "runtime/cgo"
+	// and "syscall", except to the packages they depend on.
+	p.imports = nil
+	for _, imp := range bp.Imports {
+		if imp != "C" {
+			p.imports = append(p.imports, imp)
+		}
+	}
+	if len(bp.CgoFiles) > 0 {
+		p.imports = appendNew(p.imports, "unsafe")
+		if !p.Standard || p.ImportPath != "runtime/cgo" {
+			p.imports = appendNew(p.imports, "runtime/cgo")
+		}
+		switch {
+		case p.Standard && (p.ImportPath == "runtime/cgo" || p.ImportPath == "runtime/race" || p.ImportPath == "runtime/msan" || p.ImportPath == "runtime/asan"):
+		default:
+			p.imports = appendNew(p.imports, "syscall")
+		}
+	}
+}
+
+// vendorImports returns the imports of GOROOT test files, with those
+// of vendored packages replaced by their vendor paths, sorted.
+func vendorImports(imports []string) []string {
+	var list []string
+	for _, imp := range imports {
+		if imp != "C" && !modgraph.IsStandard(imp) {
+			imp = "vendor/" + imp
+		}
+		list = append(list, imp)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// deps loads the dependencies of p, setting p.Deps and p.DepsErrors.
+// stack is the import stack leading to p, used to detect cycles.
+func (l *lister) deps(p *Package, r *modgraph.Loader, stack []string) {
+	if p.Deps != nil || p.Error != nil {
+		return
+	}
+	stack = append(stack, p.ImportPath)
+	deps := make(map[string]bool)
+	var errs []*PackageError
+	for i, imp := range p.imports {
+		dep := l.load(imp, p.Dir, r)
+		p.imports[i] = dep.ImportPath
+		deps[dep.ImportPath] = true
+		if dep.Error != nil {
+			errs = append(errs, &PackageError{
+				ImportStack: append(append([]string(nil), stack...), dep.ImportPath),
+				Err:         dep.Error.Err,
+			})
+			continue
+		}
+		if inStack(stack, dep.ImportPath) {
+			errs = append(errs, &PackageError{
+				ImportStack: append(append([]string(nil), stack...), dep.ImportPath),
+				Err:         "import cycle not allowed",
+			})
+			continue
+		}
+		l.deps(dep, r, stack)
+		for _, d := range dep.Deps {
+			deps[d] = true
+		}
+		errs = append(errs, dep.DepsErrors...)
+	}
+	p.Deps = []string{}
+	for d := range deps {
+		p.Deps = append(p.Deps, d)
+	}
+	sort.Strings(p.Deps)
+	p.DepsErrors = dedupErrors(errs)
+	if len(p.DepsErrors) > 0 {
+		p.Incomplete = true
+	}
+}
+
+// module returns the description of v, read from the download cache.
+func (l *lister) module(v *catalog.Version) (*Module, error) {
+	if m, ok := l.mods[v.String()]; ok {
+		return m, nil
+	}
+	m := &Module{Path: v.Path, Version: v.Version, GoMod: v.GoMod}
+	l.mods[v.String()] = m
+	if dir, err := l.c.SourceDir(v.Path, v.Version); err == nil {
+		m.Dir = dir
+	}
+	if info, err := v.ReadInfo(); err == nil && !info.Time.IsZero() {
+		m.Time = &info.Time
+	}
+	if sum, err := v.ReadZipHash(); err == nil {
+		m.Sum = sum
+	}
+	if v.GoMod != "" {
+		data, err := v.ReadGoMod()
+		if err != nil {
+			return m, err
+		}
+		if f, err := modfile.ParseLax(v.GoMod, data, nil); err == nil && f.Go != nil {
+			m.GoVersion = f.Go.Version
+		}
+		m.GoModSum, err = dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+			return os.Open(v.GoMod)
+		})
+		if err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// matchPattern returns a function reporting whether an import path
+// matches pattern, in which "..." matches any string. As in the go
+// command, a pattern ending in /... also matches the path before it.
+func matchPattern(pattern string) func(string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.Replace(re, `\.\.\.`, `.*`, -1)
+	if strings.HasSuffix(re, `/.*`) {
+		re = strings.TrimSuffix(re, `/.*`) + `(/.*)?`
+	}
+	rx := regexp.MustCompile(`^` + re + `$`)
+	return rx.MatchString
+}
+
+// dedupErrors returns errs without errors for the same package.
+func dedupErrors(errs []*PackageError) []*PackageError {
+	var list []*PackageError
+	seen := make(map[string]bool)
+	for _, e := range errs {
+		key := e.ImportStack[len(e.ImportStack)-1] + "\x00" + e.Err
+		if !seen[key] {
+			seen[key] = true
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// appendNew appends elem to list unless it contains it.
+func appendNew(list []string, elem string) []string {
+	if inStack(list, elem) {
+		return list
+	}
+	return append(list, elem)
+}
+
+// inStack reports whether list contains elem.
+func inStack(list []string, elem string) bool {
+	for _, x := range list {
+		if x == elem {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package golist
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/build"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+// listModules holds the files of the module versions listed by
+// TestListGoList, by path@version.
+var listModules = map[string]map[string]string{
+	"example.com/a@v1.0.0": {
+		"go.mod":           "module example.com/a\n\ngo 1.18\n\nrequire example.com/b v1.0.0\n",
+		"a.go":             "// Package a is listed.\npackage a // import \"example.com/a\"\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/b\"\n)\n\nvar A = fmt.Sprint(b.B)\n",
+		"a_other.go":       "//go:build never\n\npackage a\n\nimport _ \"example.com/none\"\n",
+		"a.s":              "",
+		"a_test.go":        "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n",
+		"x_test.go":        "package a_test\n\nimport (\n\t\"testing\"\n\n\t_ \"example.com/a\"\n)\n\nfunc TestX(t *testing.T) {}\n",
+		"sub/sub.go":       "package sub\n\nimport _ \"embed\"\n\n//go:embed data.txt\nvar Data string\n",
+		"sub/data.txt":     "data\n",
+		"internal/i/i.go":  "package i\n\nimport \"strings\"\n\nvar I = strings.ToUpper(\"i\")\n",
+		"cmd/tool/main.go": "package main\n\nimport _ \"example.com/a/internal/i\"\n\nfunc main() {}\n",
+		"docs/README":      "not a package\n",
+	},
+	"example.com/b@v1.0.0": {
+		"go.mod": "module example.com/b\n\ngo 1.18\n",
+		"b.go":   "package b\n\nimport \"strings\"\n\nvar B = strings.ToUpper(\"b\")\n",
+	},
+}
+
+// TestListGoList compares the packages listed by List with those
+// printed by "go list -json -deps" for a module downloaded by the go
+// command, through a proxy serving listModules, into a new module
+// cache, from which List then reads them.
+func TestListGoList(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the go command")
+	}
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip(err)
+	}
+	modCache := t.TempDir()
+	var cacheDir string
+	for mv, files := range listModules {
+		path, version := splitVersion(mv)
+		cacheDir, _ = modtest.WriteModule(t, modCache, path, version, files, false)
+	}
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(catalog.NewProxy(c))
+	defer srv.Close()
+
+	args := []string{"example.com/a/...", "example.com/b"}
+	dir := t.TempDir()
+	modtest.WriteFile(t, filepath.Join(dir, "go.mod"), "module example.com/main\n\ngo 1.18\n\nrequire example.com/a v1.0.0\n")
+	goModCache := t.TempDir()
+	cmd := exec.Command(gocmd, append([]string{"list", "-json", "-deps"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GOPROXY="+srv.URL,
+		"GOMODCACHE="+goModCache,
+		"GOFLAGS=-modcacherw -mod=mod",
+		"GOSUMDB=off",
+		"GONOPROXY=",
+		"GOPRIVATE=",
+		"GOTOOLCHAIN=local",
+		"GOWORK=off",
+		"GO111MODULE=on",
+		"CGO_ENABLED=0",
+	)
+	out, err := cmd.Output()
+	if ee, ok := err.(*exec.ExitError); ok {
+		t.Fatalf("go list: %v\n%s", err, ee.Stderr)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	var want []*Package
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		p := new(Package)
+		if err := dec.Decode(p); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, p)
+	}
+
+	gc, err := catalog.Open(filepath.Join(goModCache, "cache", "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := build.Default
+	ctx.CgoEnabled = false
+	pkgs, err := List(gc, &ctx, args, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Compare the JSON forms, as printed by "gocmd list -json".
+	var got []*Package
+	for _, p := range pkgs {
+		data, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := new(Package)
+		if err := json.Unmarshal(data, p); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p)
+	}
+
+	if len(got) != len(want) {
+		t.Errorf("List returned %d packages, go list %d", len(got), len(want))
+	}
+	for i := 0; i < len(got) && i < len(want); i++ {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			g, _ := json.MarshalIndent(got[i], "", "\t")
+			w, _ := json.MarshalIndent(want[i], "", "\t")
+			t.Errorf("package %d:\n%s\ngo list:\n%s", i, g, w)
+		}
+	}
+}
+
+// splitVersion splits path@version.
+func splitVersion(mv string) (path, version string) {
+	for i := len(mv) - 1; i >= 0; i-- {
+		if mv[i] == '@' {
+			return mv[:i], mv[i+1:]
+		}
+	}
+	return mv, ""
+}
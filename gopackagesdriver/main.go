@@ -0,0 +1,109 @@
+// Gopackagesdriver is an external driver for golang.org/x/tools/go/packages
+// that answers queries for the packages of module dependencies from the
+// module cache, using the package metadata cache, instead of running
+// "go list". To use it, set GOPACKAGESDRIVER to its path.
+//
+// It reads a JSON request from its standard input and writes the
+// response to its standard output, as described in package driver.
+// Queries it cannot answer exactly are marked as not handled, and
+// go/packages falls back to "go list".
+package main
+
+import (
+	"encoding/json"
+	"go/build"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/driver"
+	"github.com/julieqiu/modcache/modgraph"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("gopackagesdriver: ")
+
+	var req driver.Request
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		log.Fatal(err)
+	}
+	resp, err := serve(&req, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// serve answers req for patterns in the main module of the current
+// directory, or reports it as not handled if there is none, or if the
+// go.mod files needed for its requirement graph are not all cached.
+func serve(req *driver.Request, patterns []string) (*driver.Response, error) {
+	file := mainFile()
+	if file == "" {
+		return &driver.Response{NotHandled: true}, nil
+	}
+	c, err := catalog.Open(filepath.Join(modCacheDir(), "cache", "download"))
+	if err != nil {
+		return &driver.Response{NotHandled: true}, nil
+	}
+	g, err := modgraph.Load(c, file)
+	if err != nil {
+		if _, ok := err.(*modgraph.MissingError); ok {
+			return &driver.Response{NotHandled: true}, nil
+		}
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(file), "vendor", "modules.txt")); err == nil {
+		// Vendor mode: packages are read from the vendor directory.
+		return &driver.Response{NotHandled: true}, nil
+	}
+	return driver.Serve(c, g, req, patterns)
+}
+
+// mainFile returns the go.work file selecting the main modules, as the
+// go command finds it, or else the go.mod file of the main module
+// containing the current directory, or "" if there is neither.
+func mainFile() string {
+	switch work := os.Getenv("GOWORK"); work {
+	case "off":
+	case "":
+		if file := findUp("go.work"); file != "" {
+			return file
+		}
+	default:
+		return work
+	}
+	return findUp("go.mod")
+}
+
+// findUp returns the file with the given name in the current directory
+// or its closest parent containing one, or "" if there is none.
+func findUp(name string) string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		file := filepath.Join(dir, name)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// modCacheDir returns the effective GOMODCACHE setting.
+func modCacheDir() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	return filepath.Join(filepath.SplitList(build.Default.GOPATH)[0], "pkg", "mod")
+}
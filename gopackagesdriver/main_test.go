@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/julieqiu/modcache/driver"
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+// chdir changes the current directory to dir until the end of the test.
+func chdir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestMainFile(t *testing.T) {
+	dir := t.TempDir()
+	modtest.WriteFile(t, filepath.Join(dir, "go.work"), "go 1.18\n\nuse ./m\n")
+	modtest.WriteFile(t, filepath.Join(dir, "m", "go.mod"), "module example.com/m\n")
+	modtest.WriteFile(t, filepath.Join(dir, "m", "sub", "s.go"), "package sub\n")
+	other := filepath.Join(t.TempDir(), "other.work")
+	for _, tt := range []struct {
+		wd, gowork string
+		want       string
+	}{
+		{"m/sub", "", "go.work"},
+		{"m/sub", "off", "m/go.mod"},
+		{"m", other, other},
+	} {
+		chdir(t, filepath.Join(dir, filepath.FromSlash(tt.wd)))
+		t.Setenv("GOWORK", tt.gowork)
+		want := tt.want
+		if !filepath.IsAbs(want) {
+			want = filepath.Join(dir, filepath.FromSlash(want))
+		}
+		if got := mainFile(); got != want {
+			t.Errorf("in %s with GOWORK=%s, mainFile() = %s, want %s", tt.wd, tt.gowork, got, want)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	modCache := t.TempDir()
+	modtest.WriteModule(t, modCache, "example.com/a", "v1.0.0", map[string]string{
+		"go.mod": "module example.com/a\n\ngo 1.18\n",
+		"a.go":   "package a\n\nimport \"strings\"\n\nvar A = strings.ToUpper(\"a\")\n",
+	}, true)
+	t.Setenv("GOMODCACHE", modCache)
+	t.Setenv("GOWORK", "off")
+	req := &driver.Request{Mode: driver.NeedName | driver.NeedFiles | driver.NeedImports}
+
+	dir := t.TempDir()
+	chdir(t, dir)
+	modtest.WriteFile(t, filepath.Join(dir, "go.mod"), "module example.com/main\n\ngo 1.18\n\nrequire example.com/a v1.0.0\n")
+	resp, err := serve(req, []string{"example.com/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.NotHandled || len(resp.Roots) != 1 || resp.Roots[0] != "example.com/a" {
+		t.Fatalf("serve = %+v, want example.com/a", resp)
+	}
+	p := resp.Packages[0]
+	if want := filepath.Join(modCache, "example.com", "a@v1.0.0", "a.go"); p.Name != "a" || len(p.GoFiles) != 1 || p.GoFiles[0] != want || p.Imports["strings"] != "strings" {
+		t.Errorf("serve returned %+v, want package a in %s importing strings", p, want)
+	}
+
+	// Requests are not handled when the requirement graph is not all
+	// cached, or in vendor mode.
+	modtest.WriteFile(t, filepath.Join(dir, "go.mod"), "module example.com/main\n\ngo 1.18\n\nrequire (\n\texample.com/a v1.0.0\n\texample.com/z v1.0.0\n)\n")
+	if resp, err := serve(req, []string{"example.com/a"}); err != nil || !resp.NotHandled {
+		t.Errorf("with a missing requirement, serve = %+v, %v, want not handled", resp, err)
+	}
+	modtest.WriteFile(t, filepath.Join(dir, "go.mod"), "module example.com/main\n\ngo 1.18\n\nrequire example.com/a v1.0.0\n")
+	modtest.WriteFile(t, filepath.Join(dir, "vendor", "modules.txt"), "# example.com/a v1.0.0\n## explicit\nexample.com/a\n")
+	if resp, err := serve(req, []string{"example.com/a"}); err != nil || !resp.NotHandled {
+		t.Errorf("in vendor mode, serve = %+v, %v, want not handled", resp, err)
+	}
+}
@@ -0,0 +1,104 @@
+package index
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// ZipHash returns the zip hash recorded for the live version
+// path@version, reporting whether the version is in the index.
+func (d *Dir) ZipHash(path, version string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.lookup(path, version)
+	if e == nil {
+		return "", false
+	}
+	return e.zipHash, true
+}
+
+// ExportVersion writes the index of path@version to file as a
+// standalone index file whose file names are slash-separated paths
+// relative to the version's source directory, so that it can be added
+// to the index of another module cache by ImportVersion.
+// It returns the zip hash recorded for the version.
+func (d *Dir) ExportVersion(path, version, file string) (zipHash string, err error) {
+	d.mu.Lock()
+	var e segmentEntry
+	if le := d.lookup(path, version); le != nil {
+		e = *le
+	}
+	d.mu.Unlock()
+	if e.segment == "" {
+		return "", &fs.PathError{Op: "export", Path: path + "@" + version, Err: fs.ErrNotExist}
+	}
+
+	ix, err := Open(filepath.Join(d.dir, e.segment))
+	if err != nil {
+		return "", err
+	}
+	defer ix.Close()
+	// A compacted segment holds other versions too; the names of this
+	// version's files begin with its source directory.
+	prefix := e.srcDir + string(filepath.Separator)
+	w := Create(file)
+	defer w.removeTemp()
+	err = w.mergeNames(ix, func(name string) (string, bool) {
+		if !strings.HasPrefix(name, prefix) {
+			return "", false
+		}
+		return filepath.ToSlash(strings.TrimPrefix(name, prefix)), true
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", filepath.Join(d.dir, e.segment), err)
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return e.zipHash, nil
+}
+
+// ImportVersion adds the index file written by ExportVersion to d as a
+// new segment holding path@version, whose source is extracted in
+// srcDir, or would be if it was extracted. Any existing entry for the
+// version is marked deleted.
+func (d *Dir) ImportVersion(path, version, zipHash, srcDir, file string) error {
+	ix, err := Open(file)
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	for id := 0; id < ix.nfiles; id++ {
+		names, err := ix.Names(uint32(id))
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		for _, name := range names {
+			if name == "" || filepath.IsAbs(name) || strings.HasPrefix(name, "../") || strings.Contains(name, "/../") {
+				return fmt.Errorf("%s: invalid file name %q", file, name)
+			}
+		}
+	}
+	d.mu.Lock()
+	if e := d.lookup(path, version); e != nil {
+		e.deleted = true
+	}
+	d.mu.Unlock()
+	err = d.add(path, version, zipHash, srcDir, func(w *Writer) error {
+		err := w.mergeNames(ix, func(name string) (string, bool) {
+			return filepath.Join(srcDir, filepath.FromSlash(name)), true
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.writeManifest()
+}
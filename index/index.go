@@ -1,62 +1,280 @@
-package trigram
+// Package index implements a persistent trigram index over the source
+// files in the module cache, in the style of Russ Cox's codesearch.
+//
+// An index file is read through mmap, so opening even a large index is
+// cheap and its contents are paged in only as searches touch them.
+//
+// Files are indexed by content: the unit of the index, called a file
+// below, is a distinct file body identified by its SHA-256 hash (the
+// hash computed by load.FileHash), and each is recorded along with the
+// names of every file having that content. Most files are unchanged
+// from one version of a module to the next, so this keeps the index
+// from growing with each version of a module cached.
+//
+// The index file format, with all integers little-endian, is:
+//
+//	header (64 bytes)
+//		magic            [8]byte "mcindex\n"
+//		version          uint32
+//		number of files  uint32
+//		number of trigrams uint32
+//		unused           uint32
+//		name index       uint64 offset
+//		name data        uint64 offset
+//		hash table       uint64 offset
+//		trigram table    uint64 offset
+//		posting lists    uint64 offset
+//	name index
+//		(number of files + 1) uint64 offsets into the name data
+//	name data
+//		for each file, in file ID order, the names of the files with
+//		that content, separated by newlines
+//	hash table
+//		one [32]byte SHA-256 hash per file, in file ID order
+//	trigram table
+//		one 15-byte entry per trigram, sorted by trigram:
+//		[3]byte trigram, uint32 number of files, uint64 posting list
+//		offset relative to the start of the posting lists
+//	posting lists
+//		for each trigram, the IDs of the files containing it, in
+//		increasing order, each written as a uvarint delta from the
+//		previous ID (the first as ID+1)
+//
+// Version 2 of the format, which Open also reads, differs only in
+// using uint32 offsets in the name index.
+package index
 
-import "fmt"
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
 
+const (
+	magic   = "mcindex\n"
+	version = 3
+
+	headerSize       = 64
+	hashSize         = sha256.Size
+	trigramEntrySize = 3 + 4 + 8
+)
+
+var errCorrupt = errors.New("corrupt index")
+
+// An Index is an open index file.
 type Index struct {
-	// hash to filename
-	// TODO: what is the hash for
-	filehash map[string]string
-	// trigram to hash
-	trigram map[string][]string
+	data      mmapData
+	nfiles    int
+	ntrigrams int
+	offSize   int // size of a name index entry: 4 in version 2, else 8
+	nameIndex []byte
+	nameData  []byte
+	hashes    []byte
+	trigrams  []byte
+	postings  []byte
 }
 
-// TODO: what information is needed here
-type Package struct {
+// Open opens the index file.
+func Open(file string) (*Index, error) {
+	data, err := mmap(file)
+	if err != nil {
+		return nil, err
+	}
+	ix, err := newIndex(data)
+	if err != nil {
+		data.close()
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return ix, nil
 }
 
-func indexDirectory(dir string) {
-	// TODO: walk the directory to get the files
-	files := []string{}
-
-	// TODO: open and read the file
-	for _, filename := range files {
-		file := openfile(filename)
-		hash := hashfile(file)
-		filehash[filename] = hash
-		for i := 0; i < len(file)-2; i++ {
-			addTrigram(file[i:i+2], filename)
+func newIndex(data mmapData) (*Index, error) {
+	d := data.d
+	if len(d) < headerSize || !bytes.HasPrefix(d, []byte(magic)) {
+		return nil, errCorrupt
+	}
+	ix := &Index{
+		data:      data,
+		nfiles:    int(binary.LittleEndian.Uint32(d[12:])),
+		ntrigrams: int(binary.LittleEndian.Uint32(d[16:])),
+	}
+	switch v := binary.LittleEndian.Uint32(d[8:]); v {
+	case 2:
+		ix.offSize = 4
+	case version:
+		ix.offSize = 8
+	default:
+		return nil, fmt.Errorf("unsupported index version %d", v)
+	}
+	var off [6]uint64
+	for i := 0; i < 5; i++ {
+		off[i] = binary.LittleEndian.Uint64(d[24+8*i:])
+	}
+	off[5] = uint64(len(d))
+	for i := 0; i < 5; i++ {
+		if off[i] < headerSize || off[i] > off[i+1] {
+			return nil, errCorrupt
 		}
 	}
+	ix.nameIndex = d[off[0]:off[1]]
+	ix.nameData = d[off[1]:off[2]]
+	ix.hashes = d[off[2]:off[3]]
+	ix.trigrams = d[off[3]:off[4]]
+	ix.postings = d[off[4]:off[5]]
+	if len(ix.nameIndex) != ix.offSize*(ix.nfiles+1) || len(ix.hashes) != hashSize*ix.nfiles ||
+		len(ix.trigrams) != trigramEntrySize*ix.ntrigrams {
+		return nil, errCorrupt
+	}
+	return ix, nil
 }
 
-func (idx *Index) addTrigram(s string, filename string) {
-	// TODO: should i be the document set or the position in the file?
-	// Probably just which file it is?
-	_, ok := idx.trigram[s]
+// Close closes the index. The index must not be used afterward.
+func (ix *Index) Close() error {
+	return ix.data.close()
+}
+
+// NumFiles returns the number of distinct files in the index.
+func (ix *Index) NumFiles() int {
+	return ix.nfiles
+}
+
+// Name returns the first name of the file with the given ID.
+func (ix *Index) Name(fileid uint32) (string, error) {
+	names, err := ix.nameBytes(fileid)
+	if err != nil {
+		return "", err
+	}
+	if i := bytes.IndexByte(names, '\n'); i >= 0 {
+		names = names[:i]
+	}
+	return string(names), nil
+}
+
+// Names returns the names of all the files whose content is that of
+// the file with the given ID.
+func (ix *Index) Names(fileid uint32) ([]string, error) {
+	names, err := ix.nameBytes(fileid)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(names), "\n"), nil
+}
+
+func (ix *Index) nameBytes(fileid uint32) ([]byte, error) {
+	if int64(fileid) >= int64(ix.nfiles) {
+		return nil, errCorrupt
+	}
+	start, end := ix.nameOffset(int(fileid)), ix.nameOffset(int(fileid)+1)
+	if start > end || end > uint64(len(ix.nameData)) {
+		return nil, errCorrupt
+	}
+	return ix.nameData[start:end], nil
+}
+
+// nameOffset returns entry i of the name index.
+func (ix *Index) nameOffset(i int) uint64 {
+	if ix.offSize == 4 {
+		return uint64(binary.LittleEndian.Uint32(ix.nameIndex[4*i:]))
+	}
+	return binary.LittleEndian.Uint64(ix.nameIndex[8*i:])
+}
+
+// Hash returns the SHA-256 hash of the content of the file with the
+// given ID.
+func (ix *Index) Hash(fileid uint32) ([hashSize]byte, error) {
+	var h [hashSize]byte
+	if int64(fileid) >= int64(ix.nfiles) {
+		return h, errCorrupt
+	}
+	copy(h[:], ix.hashes[hashSize*int(fileid):])
+	return h, nil
+}
+
+// PostingList returns the IDs of the files containing the trigram,
+// in increasing order.
+func (ix *Index) PostingList(trigram uint32) ([]uint32, error) {
+	count, offset, ok := ix.findTrigram(trigram)
 	if !ok {
-		// TODO: how does csearch show the substring
-		// Do we need to store the file position here also
-		idx.trigram[s] = map[string]bool{
-			filename: true,
+		return nil, nil
+	}
+	// Each ID takes at least one byte.
+	if offset > uint64(len(ix.postings)) || count > len(ix.postings)-int(offset) || count > ix.nfiles {
+		return nil, errCorrupt
+	}
+	p := ix.postings[offset:]
+	list := make([]uint32, 0, count)
+	id1 := uint64(0) // previous ID + 1
+	for i := 0; i < count; i++ {
+		delta, n := binary.Uvarint(p)
+		if n <= 0 || delta == 0 || delta > uint64(ix.nfiles)-id1 {
+			return nil, errCorrupt
 		}
-		return
+		p = p[n:]
+		id1 += delta
+		list = append(list, uint32(id1-1))
+	}
+	return list, nil
+}
+
+// findTrigram returns the number of files containing trigram and the
+// offset of its posting list, using binary search over the trigram table.
+func (ix *Index) findTrigram(trigram uint32) (count int, offset uint64, ok bool) {
+	i := sort.Search(ix.ntrigrams, func(i int) bool {
+		return entryTrigram(ix.trigrams[i*trigramEntrySize:]) >= trigram
+	})
+	if i >= ix.ntrigrams {
+		return 0, 0, false
+	}
+	e := ix.trigrams[i*trigramEntrySize:]
+	if entryTrigram(e) != trigram {
+		return 0, 0, false
 	}
-	idx.trigram[s] = append(idx.trigram[s], filename)
+	count = int(binary.LittleEndian.Uint32(e[3:]))
+	offset = binary.LittleEndian.Uint64(e[7:])
+	return count, offset, true
 }
 
-// TODO: what should Search output
-func (idx *Index) Search(q string) {
-	trigrams := queryToTrigrams(q)
+func entryTrigram(e []byte) uint32 {
+	return uint32(e[0])<<16 | uint32(e[1])<<8 | uint32(e[2])
+}
 
-	var files []string
-	for _, t := range trigrams {
-		filenames := idx.trigram[t]
-		for f := range filenames {
-			// TODO: open file and search for query?
+// intersect returns the IDs present in both sorted lists.
+func intersect(a, b []uint32) []uint32 {
+	var out []uint32
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			a = a[1:]
+		case a[0] > b[0]:
+			b = b[1:]
+		default:
+			out = append(out, a[0])
+			a, b = a[1:], b[1:]
 		}
 	}
+	return out
 }
 
-func queryToTrigrams(q string) []string {
-	// TODO: split query into trigrams
+// union returns the IDs present in either sorted list.
+func union(a, b []uint32) []uint32 {
+	out := make([]uint32, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			out = append(out, a[0])
+			a = a[1:]
+		case a[0] > b[0]:
+			out = append(out, b[0])
+			b = b[1:]
+		default:
+			out = append(out, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	out = append(out, a...)
+	return append(out, b...)
 }
@@ -0,0 +1,248 @@
+package index
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeIndex writes an index of files, added in order of name, and
+// returns its file name.
+func writeIndex(t *testing.T, files map[string]string) string {
+	file := filepath.Join(t.TempDir(), "test.idx")
+	w := Create(file)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.Add(name, []byte(files[name]))
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// trigrams returns the distinct trigrams of s.
+func trigrams(s string) map[string]bool {
+	m := make(map[string]bool)
+	for i := 0; i+3 <= len(s); i++ {
+		m[s[i:i+3]] = true
+	}
+	return m
+}
+
+func TestWriteRead(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files map[string]string
+		want  [][]string // names of each file ID
+	}{
+		{"empty", nil, nil},
+		{"one", map[string]string{"a.go": "package a\n"}, [][]string{{"a.go"}}},
+		{"short", map[string]string{"a": "ab", "b": ""}, [][]string{{"a"}, {"b"}}},
+		{"duplicates", map[string]string{
+			"m@v1/a.go": "package a\n",
+			"m@v2/a.go": "package a\n",
+			"m@v2/b.go": "package b\n",
+		}, [][]string{{"m@v1/a.go", "m@v2/a.go"}, {"m@v2/b.go"}}},
+		{"not text", map[string]string{
+			"a.go":   "package a\n",
+			"nul":    "a\x00b",
+			"utf8":   "\xff\xfe",
+			"line":   strings.Repeat("x", maxLineLen+1),
+			"z/a.go": "package a\n",
+		}, [][]string{{"a.go", "z/a.go"}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ix, err := Open(writeIndex(t, tt.files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ix.Close()
+			if ix.NumFiles() != len(tt.want) {
+				t.Fatalf("NumFiles() = %d, want %d", ix.NumFiles(), len(tt.want))
+			}
+			all := make(map[string]bool)
+			for id, want := range tt.want {
+				fileid := uint32(id)
+				names, err := ix.Names(fileid)
+				if err != nil || !reflect.DeepEqual(names, want) {
+					t.Errorf("Names(%d) = %q, %v, want %q", id, names, err, want)
+				}
+				if name, err := ix.Name(fileid); err != nil || name != want[0] {
+					t.Errorf("Name(%d) = %q, %v, want %q", id, name, err, want[0])
+				}
+				content := tt.files[want[0]]
+				if h, err := ix.Hash(fileid); err != nil || h != sha256.Sum256([]byte(content)) {
+					t.Errorf("Hash(%d) = %x, %v, want hash of %q", id, h, err, content)
+				}
+				for tri := range trigrams(content) {
+					all[tri] = true
+				}
+			}
+			if _, err := ix.Names(uint32(len(tt.want))); err == nil {
+				t.Errorf("Names(%d) succeeded past the last file", len(tt.want))
+			}
+
+			// The posting list of each trigram lists exactly the files
+			// containing it.
+			for tri := range all {
+				var want []uint32
+				for id, names := range tt.want {
+					if strings.Contains(tt.files[names[0]], tri) {
+						want = append(want, uint32(id))
+					}
+				}
+				got, err := ix.PostingList(trigramOf(tri))
+				if err != nil || !reflect.DeepEqual(got, want) {
+					t.Errorf("PostingList(%q) = %v, %v, want %v", tri, got, err, want)
+				}
+			}
+			if got, err := ix.PostingList(trigramOf("\x00\x00\x00")); err != nil || len(got) != 0 {
+				t.Errorf("PostingList of absent trigram = %v, %v, want none", got, err)
+			}
+		})
+	}
+}
+
+func TestOpenCorrupt(t *testing.T) {
+	file := writeIndex(t, map[string]string{
+		"a.go": "package a\n\nfunc A() {}\n",
+		"b.go": "package b\n\nfunc B() {}\n",
+		"c.go": "package a\n\nfunc A() {}\n",
+	})
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := filepath.Join(t.TempDir(), "corrupt.idx")
+	open := func(data []byte) (*Index, error) {
+		if err := os.WriteFile(corrupt, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		return Open(corrupt)
+	}
+	edit := func(f func(d []byte)) []byte {
+		d := append([]byte(nil), data...)
+		f(d)
+		return d
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", data[:headerSize-1]},
+		{"magic", edit(func(d []byte) { d[0] = 'M' })},
+		{"version", edit(func(d []byte) { binary.LittleEndian.PutUint32(d[8:], version+1) })},
+		{"truncated", data[:len(data)-len(data)/3]},
+		{"offset order", edit(func(d []byte) { binary.LittleEndian.PutUint64(d[24:], binary.LittleEndian.Uint64(d[32:])+1) })},
+		{"offset past end", edit(func(d []byte) { binary.LittleEndian.PutUint64(d[56:], uint64(len(d)+1)) })},
+		{"file count", edit(func(d []byte) { binary.LittleEndian.PutUint32(d[12:], 1000) })},
+		{"trigram count", edit(func(d []byte) { binary.LittleEndian.PutUint32(d[16:], 1000) })},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ix, err := open(tt.data)
+			if err == nil {
+				ix.Close()
+				t.Fatalf("Open succeeded, want error")
+			}
+		})
+	}
+
+	// Whatever byte is changed, reading the index returns errors or
+	// wrong answers but does not panic.
+	for i := range data {
+		ix, err := open(edit(func(d []byte) { d[i] ^= 0xff }))
+		if err != nil {
+			continue
+		}
+		for id := uint32(0); id <= uint32(ix.NumFiles()); id++ {
+			ix.Names(id)
+			ix.Hash(id)
+		}
+		for tri := range trigrams("package a\n\nfunc A() {}\npackage b\n\nfunc B() {}\n") {
+			ix.PostingList(trigramOf(tri))
+		}
+		ix.Close()
+	}
+}
+
+// TestWriteSpill checks that an index written while spilling posting
+// entries to disk is identical to one written from memory.
+func TestWriteSpill(t *testing.T) {
+	files := searchCorpus(t)
+	want, err := os.ReadFile(writeIndex(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(n int) { maxPost = n }(maxPost)
+	for _, n := range []int{1000, 7777} {
+		maxPost = n
+		got, err := os.ReadFile(writeIndex(t, files))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("with maxPost = %d, index differs from one written in memory", n)
+		}
+	}
+}
+
+// TestOpenVersion2 checks that an index in version 2 of the format,
+// with 32-bit name offsets, can still be read.
+func TestOpenVersion2(t *testing.T) {
+	files := map[string]string{
+		"a.go": "package a\n\nfunc A() {}\n",
+		"b.go": "package b\n\nfunc B() {}\n",
+		"c.go": "package a\n\nfunc A() {}\n",
+	}
+	data, err := os.ReadFile(writeIndex(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rewrite the name index with 32-bit offsets, moving the sections
+	// after it.
+	nfiles := int(binary.LittleEndian.Uint32(data[12:]))
+	start := binary.LittleEndian.Uint64(data[24:])
+	end := binary.LittleEndian.Uint64(data[32:])
+	nameIndex := make([]byte, 4*(nfiles+1))
+	for i := 0; i <= nfiles; i++ {
+		binary.LittleEndian.PutUint32(nameIndex[4*i:], uint32(binary.LittleEndian.Uint64(data[start+8*uint64(i):])))
+	}
+	old := append(append(append([]byte(nil), data[:start]...), nameIndex...), data[end:]...)
+	binary.LittleEndian.PutUint32(old[8:], 2)
+	shift := end - start - uint64(len(nameIndex))
+	for i := 1; i < 5; i++ {
+		binary.LittleEndian.PutUint64(old[24+8*i:], binary.LittleEndian.Uint64(old[24+8*i:])-shift)
+	}
+	file := filepath.Join(t.TempDir(), "v2.idx")
+	if err := os.WriteFile(file, old, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	want := [][]string{{"a.go", "c.go"}, {"b.go"}}
+	for id := range want {
+		if names, err := ix.Names(uint32(id)); err != nil || !reflect.DeepEqual(names, want[id]) {
+			t.Errorf("Names(%d) = %q, %v, want %q", id, names, err, want[id])
+		}
+	}
+	if got, err := ix.PostingList(trigramOf("c B")); err != nil || !reflect.DeepEqual(got, []uint32{1}) {
+		t.Errorf("PostingList(%q) = %v, %v, want [1]", "c B", got, err)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package index
+
+import "os"
+
+// An mmapData is a file read into memory.
+// On systems without mmap the whole file is read.
+type mmapData struct {
+	d []byte
+}
+
+func mmap(file string) (mmapData, error) {
+	d, err := os.ReadFile(file)
+	if err != nil {
+		return mmapData{}, err
+	}
+	return mmapData{d: d}, nil
+}
+
+func (m mmapData) close() error {
+	return nil
+}
@@ -0,0 +1,52 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// An mmapData is a file mapped into memory.
+type mmapData struct {
+	f *os.File
+	d []byte
+}
+
+func mmap(file string) (mmapData, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return mmapData{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return mmapData{}, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return mmapData{f: f}, nil
+	}
+	if int64(int(size)) != size {
+		f.Close()
+		return mmapData{}, &os.PathError{Op: "mmap", Path: file, Err: syscall.EFBIG}
+	}
+	d, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		f.Close()
+		return mmapData{}, &os.PathError{Op: "mmap", Path: file, Err: err}
+	}
+	return mmapData{f: f, d: d}, nil
+}
+
+func (m mmapData) close() error {
+	var err error
+	if m.d != nil {
+		err = syscall.Munmap(m.d)
+	}
+	if cerr := m.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
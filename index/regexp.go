@@ -0,0 +1,30 @@
+package index
+
+import (
+	"regexp/syntax"
+
+	csindex "github.com/google/codesearch/index"
+)
+
+// A Query is a boolean query over trigrams: a file matches the query
+// if its trigrams satisfy it. Queries are computed from regular
+// expressions by RegexpQuery.
+type Query = csindex.Query
+
+// A QueryOp is the operator of a Query.
+type QueryOp = csindex.QueryOp
+
+const (
+	QAll  = csindex.QAll  // everything matches
+	QNone = csindex.QNone // nothing matches
+	QAnd  = csindex.QAnd  // all of Trigram and Sub must match
+	QOr   = csindex.QOr   // at least one of Trigram or Sub must match
+)
+
+// RegexpQuery returns a Query matching every file that may contain a
+// match of re, using the trigram query planner of codesearch, which
+// follows the algorithm described in
+// https://swtch.com/~rsc/regexp/regexp4.html.
+func RegexpQuery(re *syntax.Regexp) *Query {
+	return csindex.RegexpQuery(re)
+}
@@ -0,0 +1,221 @@
+package index
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"regexp/syntax"
+)
+
+// A Result is a line of a file matching a search.
+//
+// Files with identical contents are searched once, so a Result stands
+// for the same line in each of them: Files lists them all, and File is
+// the first of Files.
+type Result struct {
+	File   string   // file name
+	Files  []string // names of all files with the same contents
+	Line   int      // line number, starting at 1
+	Column int      // byte offset of the match within the line, starting at 1
+	Text   string   // the matching line, without its newline
+
+	hash [hashSize]byte // hash of the file contents
+}
+
+// SearchOptions controls a search.
+type SearchOptions struct {
+	IgnoreCase bool                   // match case-insensitively
+	MaxResults int                    // stop after this many results, if positive
+	Filter     func(file string) bool // if non-nil, search only files for which Filter returns true
+
+	// ReadFile, if non-nil, is used instead of os.ReadFile to read
+	// the files being searched, such as files indexed from a module
+	// zip without being extracted.
+	ReadFile func(file string) ([]byte, error)
+}
+
+// Search returns the lines matching the regular expression expr,
+// which uses the syntax accepted by package regexp. The options may be nil.
+//
+// The index narrows the search to the files containing the trigrams
+// that any match of expr must contain (see RegexpQuery), and the
+// regular expression is then run over each line of those files.
+// Files that cannot be read are skipped. Like grep, Search reports each
+// matching line once, with the position of its first match.
+// Files rejected by opts.Filter are left out of Result.Files.
+func (ix *Index) Search(expr string, opts *SearchOptions) ([]Result, error) {
+	q, err := compileQuery(expr, opts)
+	if err != nil {
+		return nil, err
+	}
+	return ix.search(q, nil, q.opts.MaxResults)
+}
+
+// A searchQuery is a compiled search.
+type searchQuery struct {
+	re   *regexp.Regexp
+	q    *Query
+	opts *SearchOptions
+}
+
+func compileQuery(expr string, opts *SearchOptions) (*searchQuery, error) {
+	if opts == nil {
+		opts = new(SearchOptions)
+	}
+	if opts.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	sre, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	return &searchQuery{re: re, q: RegexpQuery(sre), opts: opts}, nil
+}
+
+// search runs q over the files in ix, skipping the file names for which
+// live returns false, if live is non-nil. It stops after limit results,
+// if limit is positive.
+func (ix *Index) search(q *searchQuery, live func(name string) bool, limit int) ([]Result, error) {
+	fileids, err := ix.PostingQuery(q.q)
+	if err != nil {
+		return nil, err
+	}
+	var results []Result
+	for _, fileid := range fileids {
+		all, err := ix.Names(fileid)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, name := range all {
+			if live != nil && !live(name) {
+				continue
+			}
+			if q.opts.Filter != nil && !q.opts.Filter(name) {
+				continue
+			}
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			continue
+		}
+		readFile := os.ReadFile
+		if q.opts.ReadFile != nil {
+			readFile = q.opts.ReadFile
+		}
+		data, err := readFile(names[0])
+		if err != nil {
+			continue
+		}
+		hash, err := ix.Hash(fileid)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range grep(q.re, names[0], data) {
+			r.Files = names
+			r.hash = hash
+			results = append(results, r)
+		}
+		if limit > 0 && len(results) >= limit {
+			return results[:limit], nil
+		}
+	}
+	return results, nil
+}
+
+// grep returns the lines of data matching re.
+func grep(re *regexp.Regexp, name string, data []byte) []Result {
+	var results []Result
+	for lineno := 1; len(data) > 0; lineno++ {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		if m := re.FindIndex(line); m != nil {
+			results = append(results, Result{
+				File:   name,
+				Line:   lineno,
+				Column: m[0] + 1,
+				Text:   string(line),
+			})
+		}
+	}
+	return results
+}
+
+// PostingQuery returns the IDs of the files satisfying q,
+// in increasing order.
+func (ix *Index) PostingQuery(q *Query) ([]uint32, error) {
+	return ix.postingQuery(q, nil)
+}
+
+// postingQuery is PostingQuery restricted to the files in restrict,
+// if restrict is non-nil.
+func (ix *Index) postingQuery(q *Query, restrict []uint32) ([]uint32, error) {
+	var list []uint32
+	switch q.Op {
+	case QNone:
+		// nothing
+	case QAll:
+		if restrict != nil {
+			return restrict, nil
+		}
+		list = make([]uint32, ix.nfiles)
+		for i := range list {
+			list[i] = uint32(i)
+		}
+	case QAnd:
+		list = restrict
+		for i, t := range q.Trigram {
+			post, err := ix.PostingList(trigramOf(t))
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 && restrict == nil {
+				list = post
+			} else {
+				list = intersect(list, post)
+			}
+			if len(list) == 0 {
+				return nil, nil
+			}
+		}
+		for _, sub := range q.Sub {
+			var err error
+			list, err = ix.postingQuery(sub, list)
+			if err != nil || len(list) == 0 {
+				return nil, err
+			}
+		}
+	case QOr:
+		for _, t := range q.Trigram {
+			post, err := ix.PostingList(trigramOf(t))
+			if err != nil {
+				return nil, err
+			}
+			if restrict != nil {
+				post = intersect(post, restrict)
+			}
+			list = union(list, post)
+		}
+		for _, sub := range q.Sub {
+			post, err := ix.postingQuery(sub, restrict)
+			if err != nil {
+				return nil, err
+			}
+			list = union(list, post)
+		}
+	}
+	return list, nil
+}
+
+// trigramOf returns the packed form of the three-byte string t.
+func trigramOf(t string) uint32 {
+	return uint32(t[0])<<16 | uint32(t[1])<<8 | uint32(t[2])
+}
@@ -0,0 +1,164 @@
+package index
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+// searchCorpus returns the files searched by TestSearch: the sources
+// of package strings and a few files aimed at the query planner.
+func searchCorpus(t *testing.T) map[string]string {
+	files := map[string]string{
+		"x/a.txt":   "abcabcd\nabd\nac\n",
+		"x/b.txt":   "Hello, World\nhello world\nHELLO\n",
+		"x/c.txt":   "naïve café\nnaive cafe\n",
+		"x/d.txt":   "123 4567\nline with trailing space \n",
+		"x/dup.txt": "abcabcd\nabd\nac\n",
+		"x/e.txt":   "",
+		"x/f.txt":   "no newline at end",
+	}
+	matches, _ := filepath.Glob(filepath.Join(build.Default.GOROOT, "src", "strings", "*.go"))
+	for _, name := range matches {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = string(data)
+	}
+	return files
+}
+
+// bruteForce returns the matches of expr in files, line by line, as
+// "file:line:column" strings, in the way Search reports them.
+func bruteForce(files map[string]string, expr string) []string {
+	re := regexp.MustCompile(expr)
+	var matches []string
+	for name, content := range files {
+		for _, r := range grep(re, name, []byte(content)) {
+			matches = append(matches, fmt.Sprintf("%s:%d:%d", r.File, r.Line, r.Column))
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func TestSearch(t *testing.T) {
+	files := searchCorpus(t)
+	ix, err := Open(writeIndex(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	opts := &SearchOptions{
+		ReadFile: func(name string) ([]byte, error) { return []byte(files[name]), nil },
+	}
+
+	for _, expr := range []string{
+		`abc`,
+		`abcd`,
+		`func`,
+		`func \w+\(`,
+		`Index(Byte|Rune|Func)?\(`,
+		`Builder|Reader`,
+		`(?i)builder`,
+		`(?i)hello`,
+		`hello|HELLO`,
+		`[A-Z]\w*Func`,
+		`^package`,
+		`^\t}$`,
+		`\)$`,
+		`a.c`,
+		`ab?c`,
+		`(abc)+d`,
+		`[^a-z]ize`,
+		`\d{3}`,
+		`\d+ \d+`,
+		`caf[eé]`,
+		`naïve`,
+		`\.`,
+		`x*`,
+		`.`,
+		`^$`,
+		`space $`,
+		`end$`,
+		`zzzzzz`,
+	} {
+		t.Run(expr, func(t *testing.T) {
+			results, err := ix.Search(expr, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range results {
+				for _, name := range r.Files {
+					got = append(got, fmt.Sprintf("%s:%d:%d", name, r.Line, r.Column))
+				}
+			}
+			sort.Strings(got)
+			if want := bruteForce(files, expr); !reflect.DeepEqual(got, want) {
+				t.Errorf("Search(%q) found %d matches, grep %d\ngot  %q\nwant %q", expr, len(got), len(want), got, want)
+			}
+		})
+	}
+}
+
+// TestRegexpQuery checks that the trigram query of each regexp selects
+// every file the regexp matches, and, for literals whose trigrams occur
+// only in the files containing them, only those.
+func TestRegexpQuery(t *testing.T) {
+	files := searchCorpus(t)
+	file := writeIndex(t, files)
+	ix, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	for _, tt := range []struct {
+		expr  string
+		exact bool // whether the query selects exactly the matching files
+	}{
+		{`abcabcd`, true},
+		{`HELLO`, true},
+		{`Builder`, false},
+		{`Builder|Reader`, false},
+		{`func \w+\(`, false},
+		{`(?i)hello`, false},
+		{`a.c`, false},
+		{`x*`, false},
+	} {
+		q, err := compileQuery(tt.expr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err := ix.PostingQuery(q.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		selected := make(map[string]bool)
+		for _, id := range ids {
+			names, err := ix.Names(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range names {
+				selected[name] = true
+			}
+		}
+		for name, content := range files {
+			match := q.re.MatchString(content)
+			if match && !selected[name] {
+				t.Errorf("%s: query %v misses %s", tt.expr, q.q, name)
+			}
+			if tt.exact && !match && selected[name] {
+				t.Errorf("%s: query %v selects %s, which does not match", tt.expr, q.q, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,686 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julieqiu/modcache/catalog"
+)
+
+// A Dir is a trigram index of the module cache, stored as a directory
+// of index files called segments so that it can be updated
+// incrementally as versions are added to and removed from the cache.
+//
+// Adding a module version writes a new segment holding just that
+// version. Removing a version marks it deleted (a tombstone) in the
+// manifest; its files are skipped by searches until Compact merges the
+// segments into one, dropping deleted versions. Each segment indexes a
+// given file content once, and Compact merges identical files across
+// segments, so a file unchanged across many versions of a module is
+// indexed once under all of its names. The segments Compact replaces
+// are removed by an Update or Compact at least an hour later, so that
+// searches in other processes that read the manifest before the
+// compaction can still open them.
+//
+// The manifest file lists one module version per line:
+//
+//	segment path version ziphash live|deleted srcdir
+//
+// where srcdir is the directory holding the version's extracted source,
+// or where it would be extracted if it was indexed from its zip file;
+// the names of the version's files in the segment begin with it.
+// The ziphash is the h1: hash from the version's .ziphash file, or "-"
+// if there is none; a version whose hash changes is indexed again.
+// An index written in an older format is discarded when opened, and
+// rebuilt by the next Update.
+type Dir struct {
+	dir string
+
+	mu      sync.Mutex
+	entries []*segmentEntry
+}
+
+// An entryKey identifies a segmentEntry.
+type entryKey struct {
+	segment, path, version string
+}
+
+// A segmentEntry records where a module version is in the index.
+type segmentEntry struct {
+	segment string
+	path    string
+	version string
+	zipHash string
+	srcDir  string
+	deleted bool
+}
+
+const (
+	manifestName   = "manifest"
+	manifestPrefix = "mcindex manifest "
+	manifestHeader = manifestPrefix + "2"
+
+	// maxSegments is the number of segments above which
+	// NeedsCompaction reports that the index should be compacted.
+	maxSegments = 64
+
+	// retiredName is the file listing the segments dropped by Compact
+	// that have not been removed yet, which are removed by the first
+	// Update or Compact at least retireDelay later.
+	retiredName = "retired"
+	retireDelay = time.Hour
+)
+
+// OpenDir opens the segmented index in dir, creating dir if needed.
+func OpenDir(dir string) (*Dir, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	d := &Dir{dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return d, nil
+		}
+		return nil, err
+	}
+	if d.entries, err = parseManifest(data); err != nil {
+		if err == errOldManifest {
+			return d, d.discard()
+		}
+		return nil, fmt.Errorf("%s: %v", filepath.Join(dir, manifestName), err)
+	}
+	return d, nil
+}
+
+var errOldManifest = errors.New("old manifest format")
+
+func parseManifest(data []byte) ([]*segmentEntry, error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	if !s.Scan() || s.Text() != manifestHeader {
+		if strings.HasPrefix(s.Text(), manifestPrefix) {
+			return nil, errOldManifest
+		}
+		return nil, fmt.Errorf("unsupported manifest format")
+	}
+	var entries []*segmentEntry
+	for lineno := 2; s.Scan(); lineno++ {
+		f := strings.SplitN(s.Text(), " ", 6)
+		if len(f) != 6 || (f[4] != "live" && f[4] != "deleted") || f[5] == "" {
+			return nil, fmt.Errorf("line %d: malformed entry", lineno)
+		}
+		entries = append(entries, &segmentEntry{
+			segment: f[0],
+			path:    f[1],
+			version: f[2],
+			zipHash: f[3],
+			deleted: f[4] == "deleted",
+			srcDir:  f[5],
+		})
+	}
+	return entries, s.Err()
+}
+
+// discard removes the manifest and segments of an index in an older
+// format.
+func (d *Dir) discard() error {
+	segments, err := filepath.Glob(filepath.Join(d.dir, "*.idx"))
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		if err := os.Remove(seg); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{retiredName, manifestName} {
+		if err := os.Remove(filepath.Join(d.dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeManifest writes the manifest, replacing the old one atomically.
+// d.mu must be held.
+func (d *Dir) writeManifest() error {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, manifestHeader)
+	for _, e := range d.entries {
+		state := "live"
+		if e.deleted {
+			state = "deleted"
+		}
+		fmt.Fprintf(&buf, "%s %s %s %s %s %s\n", e.segment, e.path, e.version, e.zipHash, state, e.srcDir)
+	}
+	name := filepath.Join(d.dir, manifestName)
+	if err := os.WriteFile(name+".tmp", buf.Bytes(), 0666); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+func (e *segmentEntry) key() entryKey {
+	return entryKey{e.segment, e.path, e.version}
+}
+
+// lookup returns the live entry for path@version, or nil.
+// d.mu must be held.
+func (d *Dir) lookup(path, version string) *segmentEntry {
+	for _, e := range d.entries {
+		if !e.deleted && e.path == path && e.version == version {
+			return e
+		}
+	}
+	return nil
+}
+
+// UpdateStats reports the changes made by Update.
+type UpdateStats struct {
+	Added   int // module versions indexed
+	Removed int // module versions marked deleted
+}
+
+// Update brings the index up to date with the module versions whose
+// source is in the module cache described by c, extracted or as a zip
+// file. It indexes versions that are new or whose .ziphash has changed,
+// and marks deleted the versions that are no longer in the cache.
+// Versions that have not been extracted are read from their zip files,
+// under the names their files would have if extracted.
+func (d *Dir) Update(c *catalog.Catalog) (UpdateStats, error) {
+	var stats UpdateStats
+	mods, err := c.Modules()
+	if err != nil {
+		return stats, err
+	}
+	d.mu.Lock()
+	live := make(map[string]*segmentEntry)
+	for _, e := range d.entries {
+		if !e.deleted {
+			live[e.path+"@"+e.version] = e
+		}
+	}
+	d.mu.Unlock()
+
+	present := make(map[string]bool)
+	for _, m := range mods {
+		for _, v := range m.Versions {
+			srcDir, err := c.SourceDir(v.Path, v.Version)
+			if err != nil {
+				continue
+			}
+			extracted := true
+			if _, err := os.Stat(srcDir); err != nil {
+				if v.Zip == "" {
+					continue
+				}
+				extracted = false
+			}
+			present[v.String()] = true
+			zipHash, err := v.ReadZipHash()
+			if err != nil {
+				zipHash = "-"
+			}
+			d.mu.Lock()
+			e := live[v.String()]
+			stale := e != nil && e.zipHash != zipHash
+			if stale {
+				e.deleted = true
+				stats.Removed++
+			}
+			d.mu.Unlock()
+			if e != nil && !stale {
+				continue
+			}
+			if extracted {
+				err = d.add(v.Path, v.Version, zipHash, srcDir, func(w *Writer) error {
+					return w.AddDir(srcDir)
+				})
+			} else {
+				err = d.addZip(c, v, zipHash)
+			}
+			if err != nil {
+				return stats, err
+			}
+			stats.Added++
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range d.entries {
+		if !e.deleted && !present[e.path+"@"+e.version] {
+			e.deleted = true
+			stats.Removed++
+		}
+	}
+	if err := d.writeManifest(); err != nil {
+		return stats, err
+	}
+	return stats, d.removeRetired(nil, time.Now())
+}
+
+// Add indexes the source of path@version, extracted in srcDir, as a new
+// segment. zipHash identifies the version's contents; see Dir.
+func (d *Dir) Add(path, version, zipHash, srcDir string) error {
+	err := d.add(path, version, zipHash, srcDir, func(w *Writer) error {
+		return w.AddDir(srcDir)
+	})
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.writeManifest()
+}
+
+// AddZip is like Add, but reads the source of path@version from z.
+func (d *Dir) AddZip(path, version, zipHash string, z *catalog.ZipDir) error {
+	err := d.add(path, version, zipHash, z.Root(), func(w *Writer) error {
+		return w.AddZip(z)
+	})
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.writeManifest()
+}
+
+// addZip adds the source of v from its zip file, without writing the
+// manifest.
+func (d *Dir) addZip(c *catalog.Catalog, v *catalog.Version, zipHash string) error {
+	z, err := c.OpenZip(v)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+	return d.add(v.Path, v.Version, zipHash, z.Root(), func(w *Writer) error {
+		return w.AddZip(z)
+	})
+}
+
+// add adds a segment for path@version holding the files added by
+// addFiles, without writing the manifest.
+func (d *Dir) add(path, version, zipHash, srcDir string, addFiles func(*Writer) error) error {
+	// The time keeps the name unique even if the same version was
+	// indexed before, removed, and not yet compacted away.
+	segment := segmentName(fmt.Sprintf("%s@%s %s %d", path, version, zipHash, time.Now().UnixNano()))
+	w := Create(filepath.Join(d.dir, segment))
+	defer w.removeTemp()
+	if err := addFiles(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries = append(d.entries, &segmentEntry{
+		segment: segment,
+		path:    path,
+		version: version,
+		zipHash: zipHash,
+		srcDir:  srcDir,
+	})
+	return nil
+}
+
+// Remove marks path@version deleted. Its files are no longer searched,
+// and are dropped from the index by the next compaction.
+func (d *Dir) Remove(path, version string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.lookup(path, version)
+	if e == nil {
+		return nil
+	}
+	e.deleted = true
+	return d.writeManifest()
+}
+
+// segmentName returns the file name of the segment identified by key.
+func segmentName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%x.idx", sum[:12])
+}
+
+// NeedsCompaction reports whether the index has accumulated enough
+// segments or deleted versions that it should be compacted.
+func (d *Dir) NeedsCompaction() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	segments := make(map[string]bool)
+	deleted := 0
+	for _, e := range d.entries {
+		segments[e.segment] = true
+		if e.deleted {
+			deleted++
+		}
+	}
+	return len(segments) > maxSegments || deleted > len(d.entries)/2
+}
+
+// Compact merges all segments into one, dropping deleted versions.
+// Searches and updates may proceed while Compact runs: versions added
+// or removed meanwhile are carried over to the compacted index.
+func (d *Dir) Compact() error {
+	d.mu.Lock()
+	snapshot := make([]segmentEntry, len(d.entries))
+	inSnapshot := make(map[entryKey]bool)
+	for i, e := range d.entries {
+		snapshot[i] = *e
+		inSnapshot[e.key()] = true
+	}
+	d.mu.Unlock()
+
+	bySegment := groupBySegment(snapshot)
+	var keys []string
+	deleted := false
+	for _, e := range snapshot {
+		if e.deleted {
+			deleted = true
+		} else {
+			keys = append(keys, e.path+"@"+e.version+" "+e.zipHash)
+		}
+	}
+	if len(bySegment) <= 1 && !deleted {
+		return nil
+	}
+	segment := segmentName(strings.Join(keys, "\n"))
+	w := Create(filepath.Join(d.dir, segment))
+	defer w.removeTemp()
+	merged := make(map[entryKey]*segmentEntry)
+	for _, seg := range sortedKeys(bySegment) {
+		live := false
+		for _, e := range bySegment[seg] {
+			if !e.deleted {
+				ne := *e
+				ne.segment = segment
+				merged[e.key()] = &ne
+				live = true
+			}
+		}
+		if !live {
+			continue
+		}
+		ix, err := Open(filepath.Join(d.dir, seg))
+		if err != nil {
+			return err
+		}
+		err = w.merge(ix, liveFunc(bySegment[seg]))
+		ix.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", filepath.Join(d.dir, seg), err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var entries []*segmentEntry
+	for _, e := range d.entries {
+		if ne, ok := merged[e.key()]; ok {
+			// Carry over deletions made while compacting.
+			ne.deleted = e.deleted
+			entries = append(entries, ne)
+			continue
+		}
+		if inSnapshot[e.key()] {
+			// Deleted before compaction began; now gone.
+			continue
+		}
+		entries = append(entries, e)
+	}
+	d.entries = entries
+	if err := d.writeManifest(); err != nil {
+		return err
+	}
+
+	// Segment files no longer referenced may still be about to be
+	// opened by searches in other processes that read the manifest
+	// before it was rewritten, so they are removed by a later run.
+	return d.removeRetired(sortedKeys(bySegment), time.Now())
+}
+
+// removeRetired records that the segments in retired that are not
+// referenced by the manifest are retired, and removes the segments
+// retired more than retireDelay before now. d.mu must be held.
+//
+// The retired segments are listed in the file named by retiredName,
+// one per line with the Unix time at which they were retired.
+func (d *Dir) removeRetired(retired []string, now time.Time) error {
+	name := filepath.Join(d.dir, retiredName)
+	data, err := os.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	inUse := make(map[string]bool)
+	for _, e := range d.entries {
+		inUse[e.segment] = true
+	}
+	var buf bytes.Buffer
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) != 2 || inUse[f[0]] {
+			// A later compaction can produce a segment of
+			// the same name again.
+			continue
+		}
+		t, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if now.Sub(time.Unix(t, 0)) < retireDelay {
+			fmt.Fprintf(&buf, "%s %s\n", f[0], f[1])
+			continue
+		}
+		if err := os.Remove(filepath.Join(d.dir, f[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, seg := range retired {
+		if !inUse[seg] {
+			fmt.Fprintf(&buf, "%s %d\n", seg, now.Unix())
+		}
+	}
+	if buf.Len() == 0 && len(data) == 0 {
+		return nil
+	}
+	if err := os.WriteFile(name+".tmp", buf.Bytes(), 0666); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// CompactInBackground runs Compact in a new goroutine and returns a
+// channel that receives its result.
+func (d *Dir) CompactInBackground() <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- d.Compact()
+	}()
+	return errc
+}
+
+// merge adds the files of ix to w, keeping only the names for which
+// live returns true, if live is non-nil. Content already in w is
+// recorded under the additional names without being indexed again.
+func (w *Writer) merge(ix *Index, live func(name string) bool) error {
+	return w.mergeNames(ix, func(name string) (string, bool) {
+		return name, live == nil || live(name)
+	})
+}
+
+// mergeNames is like merge, but records each file of ix under the name
+// returned by rename, dropping the names for which it returns false.
+func (w *Writer) mergeNames(ix *Index, rename func(name string) (string, bool)) error {
+	remap := make([]int64, ix.nfiles)
+	for id := range remap {
+		remap[id] = -1
+		all, err := ix.Names(uint32(id))
+		if err != nil {
+			return err
+		}
+		var names []string
+		for _, name := range all {
+			if newName, ok := rename(name); ok {
+				names = append(names, newName)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		sum, err := ix.Hash(uint32(id))
+		if err != nil {
+			return err
+		}
+		if fileid, ok := w.byHash[sum]; ok {
+			w.names[fileid] = append(w.names[fileid], names...)
+			continue
+		}
+		remap[id] = int64(w.newFile(sum, names))
+	}
+	return w.addPostings(ix, remap)
+}
+
+// addPostings adds the posting lists of ix to w, mapping file ID i of
+// ix to remap[i] and dropping files whose remap entry is negative.
+func (w *Writer) addPostings(ix *Index, remap []int64) error {
+	for i := 0; i < ix.ntrigrams; i++ {
+		t := entryTrigram(ix.trigrams[i*trigramEntrySize:])
+		list, err := ix.PostingList(t)
+		if err != nil {
+			return err
+		}
+		for _, id := range list {
+			if remap[id] >= 0 {
+				w.addPost(t, uint32(remap[id]))
+			}
+		}
+	}
+	return nil
+}
+
+func groupBySegment(entries []segmentEntry) map[string][]*segmentEntry {
+	m := make(map[string][]*segmentEntry)
+	for i := range entries {
+		e := &entries[i]
+		m[e.segment] = append(m[e.segment], e)
+	}
+	return m
+}
+
+// liveFunc returns a function reporting whether a file name in a
+// segment holding the given entries belongs to a live module version,
+// or nil if all of them are live.
+func liveFunc(entries []*segmentEntry) func(name string) bool {
+	dirs := make(map[string]bool)
+	deleted := false
+	for _, e := range entries {
+		if e.deleted {
+			deleted = true
+		} else {
+			dirs[e.srcDir] = true
+		}
+	}
+	if !deleted {
+		return nil
+	}
+	return func(name string) bool {
+		dir := filepath.Dir(name)
+		for !dirs[dir] {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return false
+			}
+			dir = parent
+		}
+		return true
+	}
+}
+
+func sortedKeys(m map[string][]*segmentEntry) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Search is like Index.Search, but searches every segment,
+// skipping deleted module versions. Results for identical files in
+// different segments are merged.
+func (d *Dir) Search(expr string, opts *SearchOptions) ([]Result, error) {
+	q, err := compileQuery(expr, opts)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	snapshot := make([]segmentEntry, len(d.entries))
+	for i, e := range d.entries {
+		snapshot[i] = *e
+	}
+	d.mu.Unlock()
+
+	type lineKey struct {
+		hash [hashSize]byte
+		line int
+	}
+	bySegment := groupBySegment(snapshot)
+	var results []Result
+	seen := make(map[lineKey]int) // index in results
+	for _, seg := range sortedKeys(bySegment) {
+		live := false
+		for _, e := range bySegment[seg] {
+			if !e.deleted {
+				live = true
+			}
+		}
+		if !live {
+			continue
+		}
+		ix, err := Open(filepath.Join(d.dir, seg))
+		if err != nil {
+			return nil, err
+		}
+		limit := 0
+		if q.opts.MaxResults > 0 {
+			limit = q.opts.MaxResults - len(results)
+		}
+		segResults, err := ix.search(q, liveFunc(bySegment[seg]), limit)
+		ix.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Join(d.dir, seg), err)
+		}
+		for _, r := range segResults {
+			k := lineKey{r.hash, r.Line}
+			if i, ok := seen[k]; ok {
+				// Copy: results for one file share their Files.
+				files := results[i].Files
+				results[i].Files = append(files[:len(files):len(files)], r.Files...)
+				continue
+			}
+			seen[k] = len(results)
+			results = append(results, r)
+		}
+		if q.opts.MaxResults > 0 && len(results) >= q.opts.MaxResults {
+			break
+		}
+	}
+	return results, nil
+}
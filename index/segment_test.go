@@ -0,0 +1,302 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+// A testCache is a module cache whose files are known to the test.
+type testCache struct {
+	t        *testing.T
+	modCache string
+	cacheDir string
+	files    map[string]string // contents of the live files, by name
+}
+
+// write writes path@version holding files to the cache, replacing any
+// earlier contents.
+func (tc *testCache) write(path, version string, files map[string]string, extract bool) {
+	files["go.mod"] = "module " + path + "\n"
+	var srcDir string
+	tc.cacheDir, srcDir = modtest.WriteModule(tc.t, tc.modCache, path, version, files, extract)
+	tc.forget(srcDir)
+	for name, data := range files {
+		tc.files[filepath.Join(srcDir, filepath.FromSlash(name))] = data
+	}
+}
+
+// forget drops the files in srcDir from the live files.
+func (tc *testCache) forget(srcDir string) {
+	for name := range tc.files {
+		if strings.HasPrefix(name, srcDir+string(filepath.Separator)) {
+			delete(tc.files, name)
+		}
+	}
+}
+
+func (tc *testCache) catalog() *catalog.Catalog {
+	c, err := catalog.Open(tc.cacheDir)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	return c
+}
+
+var segmentExprs = []string{`package`, `func Hello`, `Version = "\w+"`, `example\.com/[bc]`}
+
+// checkSearch checks that d finds the matches of each of segmentExprs
+// in the live files of tc, and no others.
+func checkSearch(t *testing.T, d *Dir, tc *testCache, when string) {
+	opts := &SearchOptions{
+		// Some versions are indexed from their zip files.
+		ReadFile: func(name string) ([]byte, error) {
+			data, ok := tc.files[name]
+			if !ok {
+				return nil, fmt.Errorf("%s: not live", name)
+			}
+			return []byte(data), nil
+		},
+	}
+	for _, expr := range segmentExprs {
+		results, err := d.Search(expr, opts)
+		if err != nil {
+			t.Fatalf("%s: Search(%q): %v", when, expr, err)
+		}
+		var got []string
+		for _, r := range results {
+			for _, name := range r.Files {
+				got = append(got, fmt.Sprintf("%s:%d:%d", name, r.Line, r.Column))
+			}
+		}
+		sort.Strings(got)
+		if want := bruteForce(tc.files, expr); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Search(%q)\ngot  %q\nwant %q", when, expr, got, want)
+		}
+	}
+}
+
+// segmentFiles returns the names of the segment files in dir.
+func segmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range files {
+		files[i] = filepath.Base(f)
+	}
+	return files
+}
+
+func TestDirUpdate(t *testing.T) {
+	tc := &testCache{t: t, modCache: t.TempDir(), files: make(map[string]string)}
+	hello := "package a\n\nfunc Hello() string { return \"hello\" }\n"
+	tc.write("example.com/a", "v1.0.0", map[string]string{"a.go": hello}, true)
+	tc.write("example.com/a", "v1.1.0", map[string]string{"a.go": hello, "b.go": "package a\n\nfunc Goodbye() {}\n"}, true)
+	tc.write("example.com/b", "v1.0.0", map[string]string{"b.go": "package b\n\nconst Version = \"one\"\n"}, false)
+	tc.write("example.com/c", "v1.0.0", map[string]string{"c.go": "package c\n\nfunc Hello() {}\n"}, true)
+
+	dir := t.TempDir()
+	d, err := OpenDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := func(want UpdateStats) {
+		stats, err := d.Update(tc.catalog())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats != want {
+			t.Errorf("Update = %+v, want %+v", stats, want)
+		}
+	}
+	update(UpdateStats{Added: 4})
+	checkSearch(t, d, tc, "first Update")
+	update(UpdateStats{})
+
+	// A version whose .ziphash changes is indexed again.
+	tc.write("example.com/b", "v1.0.0", map[string]string{"b.go": "package b\n\nconst Version = \"two\"\n"}, false)
+	update(UpdateStats{Added: 1, Removed: 1})
+	checkSearch(t, d, tc, "after ziphash change")
+
+	// A version removed from the cache is marked deleted.
+	srcDir := filepath.Join(tc.modCache, "example.com", "c@v1.0.0")
+	base := filepath.Join(tc.cacheDir, "example.com", "c", "@v", "v1.0.0")
+	for _, name := range []string{srcDir, base + ".zip", base + ".ziphash"} {
+		if err := os.RemoveAll(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tc.forget(srcDir)
+	update(UpdateStats{Removed: 1})
+	checkSearch(t, d, tc, "after removal from the cache")
+
+	// Remove marks a version deleted; its file shared with another
+	// version is still found under that version's name.
+	if err := d.Remove("example.com/a", "v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	tc.forget(filepath.Join(tc.modCache, "example.com", "a@v1.0.0"))
+	checkSearch(t, d, tc, "after Remove")
+
+	// The deletions are recorded in the manifest.
+	d, err = OpenDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSearch(t, d, tc, "after reopening")
+}
+
+func TestDirCompact(t *testing.T) {
+	tc := &testCache{t: t, modCache: t.TempDir(), files: make(map[string]string)}
+	hello := "package a\n\nfunc Hello() string { return \"hello\" }\n"
+	tc.write("example.com/a", "v1.0.0", map[string]string{"a.go": hello}, true)
+	tc.write("example.com/a", "v1.1.0", map[string]string{"a.go": hello, "b.go": "package a\n\nfunc Goodbye() {}\n"}, false)
+	tc.write("example.com/b", "v1.0.0", map[string]string{"b.go": "package b\n\nconst Version = \"one\"\n"}, false)
+	tc.write("example.com/c", "v1.0.0", map[string]string{"c.go": "package c\n\nfunc Hello() {}\n"}, true)
+
+	dir := t.TempDir()
+	d, err := OpenDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Update(tc.catalog()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Remove("example.com/c", "v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	tc.forget(filepath.Join(tc.modCache, "example.com", "c@v1.0.0"))
+	old := segmentFiles(t, dir)
+	if len(old) != 4 {
+		t.Fatalf("before Compact, index has segments %v, want 4", old)
+	}
+	// A search that opened a segment before the compaction.
+	ix, err := Open(filepath.Join(dir, old[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	if err := d.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	checkSearch(t, d, tc, "after Compact")
+	d.mu.Lock()
+	var segments []string
+	for _, e := range d.entries {
+		if e.deleted {
+			t.Errorf("after Compact, %s@%s is still listed deleted", e.path, e.version)
+		}
+		segments = append(segments, e.segment)
+	}
+	d.mu.Unlock()
+	if len(segments) != 3 || segments[0] != segments[1] || segments[1] != segments[2] {
+		t.Errorf("after Compact, versions are in segments %v, want 3 in one", segments)
+	}
+	// Compacting a compacted index does nothing.
+	if err := d.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The compacted index finds the same as one built afresh.
+	fresh, err := OpenDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fresh.Update(tc.catalog()); err != nil {
+		t.Fatal(err)
+	}
+	fresh.Remove("example.com/c", "v1.0.0")
+	checkSearch(t, fresh, tc, "fresh index")
+
+	// The replaced segments are kept for other processes until
+	// retireDelay has passed.
+	if got := segmentFiles(t, dir); len(got) != 5 {
+		t.Errorf("after Compact, index has segments %v, want 5", got)
+	}
+	if _, err := ix.PostingList(trigramOf("pac")); err != nil {
+		t.Errorf("reading a retired segment: %v", err)
+	}
+	d.mu.Lock()
+	err = d.removeRetired(nil, time.Now().Add(retireDelay/2))
+	if got := segmentFiles(t, dir); err != nil || len(got) != 5 {
+		t.Errorf("before retireDelay, removeRetired = %v, index has segments %v, want 5", err, got)
+	}
+	err = d.removeRetired(nil, time.Now().Add(retireDelay+time.Minute))
+	if got := segmentFiles(t, dir); err != nil || len(got) != 1 || got[0] != segments[0] {
+		t.Errorf("after retireDelay, removeRetired = %v, index has segments %v, want [%s]", err, got, segments[0])
+	}
+	d.mu.Unlock()
+	if data, err := os.ReadFile(filepath.Join(dir, retiredName)); err != nil || len(data) != 0 {
+		t.Errorf("after removing retired segments, %s = %q, %v, want empty", retiredName, data, err)
+	}
+	checkSearch(t, d, tc, "after removing retired segments")
+}
+
+// TestDirDedupe checks that a file unchanged across versions is
+// reported once, under all of its names, and indexed once after
+// compaction.
+func TestDirDedupe(t *testing.T) {
+	tc := &testCache{t: t, modCache: t.TempDir(), files: make(map[string]string)}
+	for _, v := range []string{"v1.0.0", "v1.1.0", "v1.2.0"} {
+		tc.write("example.com/a", v, map[string]string{
+			"same.go": "package a\n\nfunc Same() {}\n",
+			"v.go":    "package a\n\nconst V = \"" + v + "\"\n",
+		}, v != "v1.1.0")
+	}
+	dir := t.TempDir()
+	d, err := OpenDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Update(tc.catalog()); err != nil {
+		t.Fatal(err)
+	}
+	check := func(when string) {
+		results, err := d.Search(`func Same`, &SearchOptions{ReadFile: func(name string) ([]byte, error) {
+			return []byte(tc.files[name]), nil
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("%s: Search found %d results, want 1", when, len(results))
+		}
+		got := append([]string(nil), results[0].Files...)
+		sort.Strings(got)
+		var want []string
+		for _, v := range []string{"v1.0.0", "v1.1.0", "v1.2.0"} {
+			want = append(want, filepath.Join(tc.modCache, "example.com", "a@"+v, "same.go"))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Search found %q, want %q", when, got, want)
+		}
+	}
+	check("after Update")
+
+	if err := d.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check("after Compact")
+	d.mu.Lock()
+	segment := d.entries[0].segment
+	d.mu.Unlock()
+	ix, err := Open(filepath.Join(dir, segment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	// One same.go, three v.go, and the go.mod shared by all versions.
+	if n := ix.NumFiles(); n != 5 {
+		t.Errorf("compacted index holds %d files, want 5", n)
+	}
+}
@@ -0,0 +1,452 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/julieqiu/modcache/catalog"
+)
+
+// Limits on the files worth indexing. Files that exceed them are
+// almost always generated data or binaries rather than source code.
+const (
+	maxFileLen      = 1 << 30
+	maxLineLen      = 2000
+	maxTextTrigrams = 20000
+)
+
+// maxPost is the number of posting entries a Writer holds in memory.
+// When it has that many, it sorts them and appends them to a temporary
+// file, as codesearch's index writer does, and the sorted runs in the
+// file are merged when the index is flushed.
+var maxPost = 64 << 20 / 8
+
+// A Writer builds an index file.
+type Writer struct {
+	file     string
+	names    [][]string // file ID to names of files with that content
+	hashes   [][hashSize]byte
+	byHash   map[[hashSize]byte]uint32
+	skipped  map[[hashSize]byte]bool // content found not to be text
+	post     []postEntry             // posting entries not yet spilled
+	postFile *os.File                // sorted runs of spilled entries
+	postRuns []int64                 // length in entries of each run
+	err      error                   // first error spilling entries
+}
+
+// A postEntry records that a file contains a trigram. It holds the
+// trigram in its high 32 bits and the file ID in its low 32 bits, so
+// that entries sort by trigram and then by file ID.
+type postEntry uint64
+
+func makePostEntry(trigram, fileid uint32) postEntry {
+	return postEntry(trigram)<<32 | postEntry(fileid)
+}
+
+func (p postEntry) trigram() uint32 { return uint32(p >> 32) }
+func (p postEntry) fileid() uint32  { return uint32(p) }
+
+// Create returns a Writer that will write the index to file when
+// flushed.
+func Create(file string) *Writer {
+	return &Writer{
+		file:    file,
+		byHash:  make(map[[hashSize]byte]uint32),
+		skipped: make(map[[hashSize]byte]bool),
+	}
+}
+
+// AddDir adds every indexable file in the tree rooted at dir.
+// Version control directories are skipped.
+func (w *Writer) AddDir(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			switch d.Name() {
+			case ".git", ".hg", ".svn", ".bzr":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !isTextName(path) {
+			return nil
+		}
+		_, err = w.AddFile(path)
+		return err
+	})
+}
+
+// AddZip adds every indexable file in z, under the names the files
+// would have if the module were extracted.
+func (w *Writer) AddZip(z *catalog.ZipDir) error {
+	for _, name := range z.Files() {
+		if !isTextName(name) {
+			continue
+		}
+		data, err := z.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		w.Add(name, data)
+	}
+	return nil
+}
+
+// AddFile adds the named file to the index.
+// It reports whether the file was indexed; see Add.
+func (w *Writer) AddFile(name string) (bool, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() > maxFileLen {
+		return false, nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return false, err
+	}
+	return w.Add(name, data), nil
+}
+
+// Add adds a file with the given name and contents to the index.
+// It reports whether the file was indexed: files that do not look like
+// text (invalid UTF-8, NUL bytes, very long lines or too many distinct
+// trigrams) are skipped. If the index already holds a file with the
+// same contents, Add only records name as another name for it.
+func (w *Writer) Add(name string, data []byte) bool {
+	sum := sha256.Sum256(data)
+	if w.addName(name, sum) {
+		return true
+	}
+	if w.skipped[sum] {
+		return false
+	}
+	return w.add(name, sum, data)
+}
+
+// addName records name as a name of the indexed content with the given
+// hash, reporting whether there is such content.
+func (w *Writer) addName(name string, sum [hashSize]byte) bool {
+	fileid, ok := w.byHash[sum]
+	if ok {
+		w.names[fileid] = append(w.names[fileid], name)
+	}
+	return ok
+}
+
+// add indexes data, which is not yet in the index, under name.
+func (w *Writer) add(name string, sum [hashSize]byte, data []byte) bool {
+	trigrams, ok := fileTrigrams(data)
+	if !ok {
+		w.skipped[sum] = true
+		return false
+	}
+	fileid := w.newFile(sum, []string{name})
+	for _, t := range trigrams {
+		w.addPost(t, fileid)
+	}
+	return true
+}
+
+// addPost records that the file with the given ID contains trigram.
+func (w *Writer) addPost(trigram, fileid uint32) {
+	if len(w.post) >= maxPost {
+		w.spill()
+	}
+	w.post = append(w.post, makePostEntry(trigram, fileid))
+}
+
+// spill sorts the posting entries held in memory and writes them to a
+// temporary file. An error is recorded in w.err, to be returned by
+// Flush.
+func (w *Writer) spill() {
+	if w.err != nil {
+		w.post = w.post[:0]
+		return
+	}
+	sortPost(w.post)
+	if w.postFile == nil {
+		f, err := os.CreateTemp("", "mcindex-post")
+		if err != nil {
+			w.err = err
+			return
+		}
+		w.postFile = f
+	}
+	w.postRuns = append(w.postRuns, int64(len(w.post)))
+	bw := bufio.NewWriter(w.postFile)
+	var b8 [8]byte
+	for _, p := range w.post {
+		binary.LittleEndian.PutUint64(b8[:], uint64(p))
+		bw.Write(b8[:])
+	}
+	if err := bw.Flush(); err != nil {
+		w.err = err
+	}
+	w.post = w.post[:0]
+}
+
+// removeTemp removes the temporary file holding spilled posting
+// entries. Flush calls it, and so should code that may abandon a
+// Writer without flushing it.
+func (w *Writer) removeTemp() {
+	if w.postFile != nil {
+		w.postFile.Close()
+		os.Remove(w.postFile.Name())
+		w.postFile = nil
+	}
+}
+
+func sortPost(post []postEntry) {
+	sort.Slice(post, func(i, j int) bool { return post[i] < post[j] })
+}
+
+// newFile allocates a file ID for content with the given hash and names.
+func (w *Writer) newFile(sum [hashSize]byte, names []string) uint32 {
+	fileid := uint32(len(w.names))
+	w.names = append(w.names, names)
+	w.hashes = append(w.hashes, sum)
+	w.byHash[sum] = fileid
+	return fileid
+}
+
+// fileTrigrams returns the sorted distinct trigrams in data,
+// or false if data does not look like text.
+func fileTrigrams(data []byte) ([]uint32, bool) {
+	if len(data) > maxFileLen || !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return nil, false
+	}
+	seen := make(map[uint32]bool)
+	var trigrams []uint32
+	var tv uint32
+	lineLen := 0
+	for i, c := range data {
+		tv = (tv<<8 | uint32(c)) & (1<<24 - 1)
+		if i >= 2 && !seen[tv] {
+			seen[tv] = true
+			trigrams = append(trigrams, tv)
+			if len(trigrams) > maxTextTrigrams {
+				return nil, false
+			}
+		}
+		lineLen++
+		if c == '\n' {
+			lineLen = 0
+		}
+		if lineLen > maxLineLen {
+			return nil, false
+		}
+	}
+	sort.Slice(trigrams, func(i, j int) bool { return trigrams[i] < trigrams[j] })
+	return trigrams, true
+}
+
+// Flush writes the index file.
+// The file is written to a temporary name and renamed into place,
+// so readers never observe a partially written index.
+func (w *Writer) Flush() error {
+	defer w.removeTemp()
+	if w.err != nil {
+		return w.err
+	}
+	tmp := w.file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := w.write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, w.file)
+}
+
+func (w *Writer) write(f *os.File) error {
+	var nameIndex, nameData, hashes bytes.Buffer
+	var b8 [8]byte
+	for i, names := range w.names {
+		binary.LittleEndian.PutUint64(b8[:], uint64(nameData.Len()))
+		nameIndex.Write(b8[:])
+		nameData.WriteString(strings.Join(names, "\n"))
+		hashes.Write(w.hashes[i][:])
+	}
+	binary.LittleEndian.PutUint64(b8[:], uint64(nameData.Len()))
+	nameIndex.Write(b8[:])
+
+	// The posting lists follow the trigram table, so they are written
+	// to a temporary file while the table is built.
+	pf, err := os.CreateTemp("", "mcindex-post")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(pf.Name())
+	defer pf.Close()
+	postings := bufio.NewWriter(pf)
+	var table bytes.Buffer
+	var entry [trigramEntrySize]byte
+	var vbuf [binary.MaxVarintLen32]byte
+	ntrigrams, postLen := 0, uint64(0)
+	count, last := 0, ^uint32(0)
+	endList := func() {
+		if count > 0 {
+			binary.LittleEndian.PutUint32(entry[3:], uint32(count))
+			table.Write(entry[:])
+			ntrigrams++
+		}
+	}
+	err = w.mergePost(func(p postEntry) {
+		t, id := p.trigram(), p.fileid()
+		if count == 0 || t != entryTrigram(entry[:]) {
+			endList()
+			entry[0], entry[1], entry[2] = byte(t>>16), byte(t>>8), byte(t)
+			binary.LittleEndian.PutUint64(entry[7:], postLen)
+			count, last = 0, ^uint32(0)
+		} else if id == last {
+			return
+		}
+		n := binary.PutUvarint(vbuf[:], uint64(id-last))
+		postings.Write(vbuf[:n])
+		postLen += uint64(n)
+		count++
+		last = id
+	})
+	if err != nil {
+		return err
+	}
+	endList()
+	if err := postings.Flush(); err != nil {
+		return err
+	}
+	if _, err := pf.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var hdr [headerSize]byte
+	copy(hdr[:], magic)
+	binary.LittleEndian.PutUint32(hdr[8:], version)
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(w.names)))
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(ntrigrams))
+	off := uint64(headerSize)
+	for i, n := range []int{nameIndex.Len(), nameData.Len(), hashes.Len(), table.Len()} {
+		binary.LittleEndian.PutUint64(hdr[24+8*i:], off)
+		off += uint64(n)
+	}
+	binary.LittleEndian.PutUint64(hdr[56:], off)
+
+	bw := bufio.NewWriter(f)
+	for _, b := range [][]byte{hdr[:], nameIndex.Bytes(), nameData.Bytes(), hashes.Bytes(), table.Bytes()} {
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+	if _, err := io.Copy(bw, pf); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// mergePost calls f with each posting entry of w, in sorted order,
+// merging the entries held in memory with the runs spilled to disk.
+func (w *Writer) mergePost(f func(postEntry)) error {
+	sortPost(w.post)
+	var h postHeap
+	if r := (&postRun{mem: w.post}); r.next() {
+		h = append(h, r)
+	}
+	var off int64
+	for _, n := range w.postRuns {
+		r := &postRun{r: bufio.NewReader(io.NewSectionReader(w.postFile, 8*off, 8*n))}
+		off += n
+		if r.next() {
+			h = append(h, r)
+		} else if r.err != nil {
+			return r.err
+		}
+	}
+	heap.Init(&h)
+	for len(h) > 0 {
+		r := h[0]
+		f(r.head)
+		if r.next() {
+			heap.Fix(&h, 0)
+			continue
+		}
+		if r.err != nil {
+			return r.err
+		}
+		heap.Pop(&h)
+	}
+	return nil
+}
+
+// A postRun is a sorted run of posting entries, in memory or spilled
+// to disk.
+type postRun struct {
+	mem  []postEntry
+	r    *bufio.Reader
+	head postEntry
+	err  error
+}
+
+// next advances r to its next entry, reporting whether there is one.
+func (r *postRun) next() bool {
+	if r.r == nil {
+		if len(r.mem) == 0 {
+			return false
+		}
+		r.head, r.mem = r.mem[0], r.mem[1:]
+		return true
+	}
+	var b8 [8]byte
+	if _, err := io.ReadFull(r.r, b8[:]); err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+		return false
+	}
+	r.head = postEntry(binary.LittleEndian.Uint64(b8[:]))
+	return true
+}
+
+// A postHeap is a min-heap of runs ordered by their next entry.
+type postHeap []*postRun
+
+func (h postHeap) Len() int            { return len(h) }
+func (h postHeap) Less(i, j int) bool  { return h[i].head < h[j].head }
+func (h postHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *postHeap) Push(x interface{}) { *h = append(*h, x.(*postRun)) }
+
+func (h *postHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// isTextName reports whether name looks like a source file name,
+// as opposed to an archive or image.
+func isTextName(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".zip", ".gz", ".tgz", ".png", ".jpg", ".jpeg", ".gif", ".ico", ".pdf", ".a", ".so", ".syso":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+// Package modtest writes module caches for tests.
+package modtest
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// WriteModule writes the .info, .mod, .zip and .ziphash files of the
+// module version path@version holding files to the module cache
+// modCache, as "go mod download" would, and, if extract is set,
+// extracts the files to their directory in modCache. The .mod file
+// holds files["go.mod"]. It returns the download cache,
+// modCache/cache/download, and the directory of the extracted files,
+// whether or not they were extracted.
+func WriteModule(t testing.TB, modCache, path, version string, files map[string]string, extract bool) (cacheDir, srcDir string) {
+	escPath, err := module.EscapePath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	escVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDir = filepath.Join(modCache, "cache", "download")
+	base := filepath.Join(cacheDir, filepath.FromSlash(escPath), "@v", escVersion)
+	srcDir = filepath.Join(modCache, filepath.FromSlash(escPath)+"@"+escVersion)
+	WriteFile(t, base+".info", `{"Version":"`+version+`","Time":"2024-01-01T00:00:00Z"}`)
+	WriteFile(t, base+".mod", files["go.mod"])
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w, err := zw.Create(path + "@" + version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(files[name]))
+		if extract {
+			WriteFile(t, filepath.Join(srcDir, filepath.FromSlash(name)), files[name])
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	WriteFile(t, base+".zip", buf.String())
+	h, err := dirhash.HashZip(base+".zip", dirhash.Hash1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteFile(t, base+".ziphash", h+"\n")
+	return cacheDir, srcDir
+}
+
+// WriteFile writes data to the file name, creating its directory.
+func WriteFile(t testing.TB, name, data string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"hash"
 	"io"
 	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -17,6 +16,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/mod/module"
 )
 
 // StringList flattens its arguments into a single []string.
@@ -47,13 +48,19 @@ var (
 	defaultCache *Cache
 )
 
-func ModCache(cacheDir, modulePath string) *Cache {
-	dir := filepath.Join(cacheDir, modulePath, "@v")
+// ModCache returns the cache holding the package metadata of the
+// versions of modulePath, in its directory of the download cache cacheDir.
+func ModCache(cacheDir, modulePath string) (*Cache, error) {
+	escPath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize build cache for %s: %v", modulePath, err)
+	}
+	dir := filepath.Join(cacheDir, escPath, "@v")
 	c, err := Open(dir)
 	if err != nil {
-		log.Fatalf("failed to initialize build cache at %s: %s\n", dir, err)
+		return nil, fmt.Errorf("failed to initialize build cache at %s: %v", dir, err)
 	}
-	return c
+	return c, nil
 }
 
 // Default returns the default cache to use, or nil if no cache should be used.
@@ -63,17 +70,15 @@ func Default() *Cache {
 }
 
 // initDefaultCache does the work of finding the default cache
-// the first time Default is called.
+// the first time Default is called. It leaves defaultCache nil if
+// GOCACHE is off or the cache cannot be initialized.
 func initDefaultCache() {
 	dir := DefaultDir()
 	if dir == "off" {
-		if defaultDirErr != nil {
-			log.Fatalf("build cache is required, but could not be located: %v", defaultDirErr)
-		}
-		log.Fatalf("build cache is disabled by GOCACHE=off, but required as of Go 1.12")
+		return
 	}
 	if err := os.MkdirAll(dir, 0777); err != nil {
-		log.Fatalf("failed to initialize build cache at %s: %s\n", dir, err)
+		return
 	}
 	if _, err := os.Stat(filepath.Join(dir, "README")); err != nil {
 		// Best effort.
@@ -81,7 +86,7 @@ func initDefaultCache() {
 	}
 	c, err := Open(dir)
 	if err != nil {
-		log.Fatalf("failed to initialize build cache at %s: %s\n", dir, err)
+		return
 	}
 	defaultCache = c
 }
@@ -126,7 +131,6 @@ func (c *Cache) GetBytes(id ActionID) ([]byte, Entry, error) {
 	if err != nil {
 		return nil, entry, err
 	}
-	fmt.Println(c.OutputFile(entry.OutputID))
 	data, _ := os.ReadFile(c.OutputFile(entry.OutputID))
 	if sha256.Sum256(data) != entry.OutputID {
 		return nil, entry, &entryNotFoundError{Err: errors.New("bad checksum")}
@@ -373,6 +377,16 @@ func (e *entryNotFoundError) Error() string {
 
 // get is Get but does not respect verify mode, so that Put can use it.
 func (c *Cache) get(id ActionID) (Entry, error) {
+	entry, err := c.readEntry(id)
+	if err != nil {
+		return entry, err
+	}
+	c.used(c.fileName(id, "a"))
+	return entry, nil
+}
+
+// readEntry reads the action entry for id, without marking it used.
+func (c *Cache) readEntry(id ActionID) (Entry, error) {
 	missing := func(reason error) (Entry, error) {
 		return Entry{}, &entryNotFoundError{Err: reason}
 	}
@@ -429,8 +443,6 @@ func (c *Cache) get(id ActionID) (Entry, error) {
 		return missing(errors.New("negative timestamp"))
 	}
 
-	c.used(c.fileName(id, "a"))
-
 	return Entry{buf, size, time.Unix(0, tm)}, nil
 }
 
@@ -481,8 +493,6 @@ func (c *Cache) used(file string) {
 }
 
 func (c *Cache) put(id ActionID, file io.ReadSeeker, allowVerify bool) (OutputID, int64, error) {
-	fmt.Println("putting: ", id)
-
 	// Compute output ID.
 	h := sha256.New()
 	if _, err := file.Seek(0, 0); err != nil {
@@ -525,6 +535,11 @@ func (c *Cache) copyFile(file io.ReadSeeker, out OutputID, size int64) error {
 	}
 
 	// Copy file to cache directory.
+	// The subdirectories are created on demand rather than by Open,
+	// to avoid littering the download cache with empty directories.
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
 	mode := os.O_RDWR | os.O_CREATE
 	if err == nil && info.Size() > size { // shouldn't happen but fix in case
 		mode |= os.O_TRUNC
@@ -614,6 +629,9 @@ func (c *Cache) putIndexEntry(id ActionID, out OutputID, size int64, allowVerify
 	file := c.fileName(id, "a")
 
 	// Copy file to cache directory.
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
 	mode := os.O_WRONLY | os.O_CREATE
 	f, err := os.OpenFile(file, mode, 0666)
 	if err != nil {
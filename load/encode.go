@@ -0,0 +1,456 @@
+package load
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Cache entries for LegacyCachedPackage are written in a compact binary
+// format rather than JSON. The format is:
+//
+//	magic    "gocmd pkg\n"
+//	version  uvarint (encodingVersion)
+//	strings  uvarint count, then for each string: uvarint length, bytes
+//	fields   uvarint count, then for each field:
+//	         uvarint name (string index), uvarint offset, uvarint length
+//	data     field values
+//
+// Every string, including the field names, is stored once in the string
+// table and referred to by index, so the file names repeated in every
+// token.Position cost a byte or two each. The field table lets readers
+// decode just the fields they need (see PackageDecoder).
+//
+// Top-level fields are named "Build.<field>" for the fields of
+// build.Package and "FileHash" for the file hashes. Fields are matched
+// by name when decoding, so entries survive fields being added to or
+// removed from build.Package by a new Go release. Other cache entries
+// use the same format, with one top-level field for each field of the
+// struct they hold (see EncodeStruct).
+//
+// Field values are encoded according to their kind:
+//
+//	string  uvarint string index
+//	bool    one byte, 0 or 1
+//	int     varint
+//	slice   uvarint 0 for nil, otherwise length+1, then the elements
+//	map     uvarint 0 for nil, otherwise length+1, then key/value
+//	        pairs in sorted key order
+//	struct  the exported fields, in order
+const (
+	encodingMagic   = "gocmd pkg\n"
+	encodingVersion = 1
+)
+
+var errBadEncoding = errors.New("malformed package encoding")
+
+// EncodePackage returns the binary encoding of cp.
+// The encoding is deterministic: equal packages encode identically.
+func EncodePackage(cp *LegacyCachedPackage) []byte {
+	e := &encoder{index: make(map[string]int)}
+	var fields []encodedField
+	add := func(name string, v reflect.Value) {
+		start := e.data.Len()
+		e.value(v)
+		fields = append(fields, encodedField{e.intern(name), start, e.data.Len() - start})
+	}
+	build := reflect.ValueOf(&cp.Build).Elem()
+	for i := 0; i < build.NumField(); i++ {
+		if f := build.Type().Field(i); f.PkgPath == "" {
+			add("Build."+f.Name, build.Field(i))
+		}
+	}
+	add("FileHash", reflect.ValueOf(cp.FileHash))
+	return e.encoding(fields)
+}
+
+// EncodeStruct returns the binary encoding of the struct pointed to by
+// v, in the format used by EncodePackage, with one field for each of
+// its exported fields. It is used for cache entries other than
+// packages; see DecodeStruct.
+func EncodeStruct(v interface{}) []byte {
+	e := &encoder{index: make(map[string]int)}
+	var fields []encodedField
+	s := reflect.ValueOf(v).Elem()
+	for i := 0; i < s.NumField(); i++ {
+		if f := s.Type().Field(i); f.PkgPath == "" {
+			start := e.data.Len()
+			e.value(s.Field(i))
+			fields = append(fields, encodedField{e.intern(f.Name), start, e.data.Len() - start})
+		}
+	}
+	return e.encoding(fields)
+}
+
+// DecodeStruct decodes data, written by EncodeStruct, into the struct
+// pointed to by v. Fields missing from data are left unchanged.
+func DecodeStruct(data []byte, v interface{}) error {
+	d, err := NewPackageDecoder(data)
+	if err != nil {
+		return err
+	}
+	s := reflect.ValueOf(v).Elem()
+	for i := 0; i < s.NumField(); i++ {
+		if f := s.Type().Field(i); f.PkgPath == "" {
+			if err := d.field(f.Name, s.Field(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encoding returns the complete encoding of the given fields,
+// whose values have been written to e.
+func (e *encoder) encoding(fields []encodedField) []byte {
+	var out bytes.Buffer
+	out.WriteString(encodingMagic)
+	putUvarint(&out, encodingVersion)
+	putUvarint(&out, uint64(len(e.strings)))
+	for _, s := range e.strings {
+		putUvarint(&out, uint64(len(s)))
+		out.WriteString(s)
+	}
+	putUvarint(&out, uint64(len(fields)))
+	for _, f := range fields {
+		putUvarint(&out, uint64(f.name))
+		putUvarint(&out, uint64(f.off))
+		putUvarint(&out, uint64(f.len))
+	}
+	out.Write(e.data.Bytes())
+	return out.Bytes()
+}
+
+// DecodePackage decodes every field of a cache entry holding a
+// LegacyCachedPackage. It accepts both the binary encoding written by
+// EncodePackage and the JSON encoding used by earlier versions of the
+// cache, which LegacyCachedImport rewrites in the binary encoding when
+// it reads them. Callers that need only some of the fields of a binary
+// entry should use a PackageDecoder instead.
+func DecodePackage(data []byte) (*LegacyCachedPackage, error) {
+	cp := new(LegacyCachedPackage)
+	if isJSONEntry(data) {
+		if err := json.Unmarshal(data, cp); err != nil {
+			return nil, err
+		}
+		return cp, nil
+	}
+	d, err := NewPackageDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+	build := reflect.ValueOf(&cp.Build).Elem()
+	for i := 0; i < build.NumField(); i++ {
+		f := build.Type().Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if err := d.field("Build."+f.Name, build.Field(i)); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.Field("FileHash", &cp.FileHash); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// isJSONEntry reports whether data is a JSON-encoded cache entry.
+func isJSONEntry(data []byte) bool {
+	return len(data) > 0 && data[0] == '{'
+}
+
+// A PackageDecoder decodes the fields of a binary-encoded
+// LegacyCachedPackage on demand. Strings are only materialized
+// when a field that refers to them is decoded.
+type PackageDecoder struct {
+	data    []byte
+	strOff  []int    // offset of each string in data
+	strLen  []int    // length of each string
+	strs    []string // strings decoded so far
+	decoded []bool
+	fields  map[string][]byte
+}
+
+// NewPackageDecoder returns a decoder for the binary encoding in data.
+func NewPackageDecoder(data []byte) (*PackageDecoder, error) {
+	if !bytes.HasPrefix(data, []byte(encodingMagic)) {
+		return nil, errBadEncoding
+	}
+	r := &reader{data: data, off: len(encodingMagic)}
+	if v := r.uvarint(); v != encodingVersion {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, fmt.Errorf("unsupported package encoding version %d", v)
+	}
+	d := &PackageDecoder{data: data}
+	n := r.count()
+	d.strOff = make([]int, n)
+	d.strLen = make([]int, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		d.strLen[i] = r.count()
+		d.strOff[i] = r.off
+		r.skip(d.strLen[i])
+	}
+	d.strs = make([]string, n)
+	d.decoded = make([]bool, n)
+
+	type span struct{ name, off, len int }
+	spans := make([]span, r.count())
+	for i := range spans {
+		spans[i] = span{r.count(), r.count(), r.count()}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	body := data[r.off:]
+	d.fields = make(map[string][]byte, len(spans))
+	for _, s := range spans {
+		if s.name >= n || s.off+s.len > len(body) {
+			return nil, errBadEncoding
+		}
+		d.fields[d.str(s.name)] = body[s.off : s.off+s.len]
+	}
+	return d, nil
+}
+
+// Field decodes the named field into the value pointed to by ptr.
+// For example,
+//
+//	var imports []string
+//	err := d.Field("Build.Imports", &imports)
+//
+// If the encoding has no such field, ptr is left unchanged.
+func (d *PackageDecoder) Field(name string, ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("PackageDecoder.Field: non-pointer %T", ptr)
+	}
+	return d.field(name, v.Elem())
+}
+
+func (d *PackageDecoder) field(name string, v reflect.Value) error {
+	data, ok := d.fields[name]
+	if !ok {
+		return nil
+	}
+	r := &reader{data: data}
+	d.value(r, v)
+	if r.err != nil {
+		return fmt.Errorf("decoding %s: %v", name, r.err)
+	}
+	return nil
+}
+
+// str returns string i of the string table.
+func (d *PackageDecoder) str(i int) string {
+	if !d.decoded[i] {
+		d.strs[i] = string(d.data[d.strOff[i] : d.strOff[i]+d.strLen[i]])
+		d.decoded[i] = true
+	}
+	return d.strs[i]
+}
+
+func (d *PackageDecoder) value(r *reader, v reflect.Value) {
+	if r.err != nil {
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		i := r.uvarint()
+		if i >= uint64(len(d.strs)) {
+			r.fail()
+			return
+		}
+		v.SetString(d.str(int(i)))
+	case reflect.Bool:
+		v.SetBool(r.byte() != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(r.varint())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(r.uvarint())
+	case reflect.Slice:
+		n := r.count()
+		if n == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		n--
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			d.value(r, s.Index(i))
+		}
+		v.Set(s)
+	case reflect.Map:
+		n := r.count()
+		if n == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		n--
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for i := 0; i < n && r.err == nil; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			elem := reflect.New(v.Type().Elem()).Elem()
+			d.value(r, key)
+			d.value(r, elem)
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				d.value(r, v.Field(i))
+			}
+		}
+	default:
+		r.err = fmt.Errorf("cannot decode %s", v.Type())
+	}
+}
+
+type encodedField struct {
+	name, off, len int
+}
+
+// An encoder accumulates field values and the string table.
+type encoder struct {
+	data    bytes.Buffer
+	strings []string
+	index   map[string]int
+}
+
+// intern returns the string table index of s, adding it if needed.
+func (e *encoder) intern(s string) int {
+	i, ok := e.index[s]
+	if !ok {
+		i = len(e.strings)
+		e.strings = append(e.strings, s)
+		e.index[s] = i
+	}
+	return i
+}
+
+func (e *encoder) value(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		putUvarint(&e.data, uint64(e.intern(v.String())))
+	case reflect.Bool:
+		if v.Bool() {
+			e.data.WriteByte(1)
+		} else {
+			e.data.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var buf [binary.MaxVarintLen64]byte
+		e.data.Write(buf[:binary.PutVarint(buf[:], v.Int())])
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		putUvarint(&e.data, v.Uint())
+	case reflect.Slice:
+		if v.IsNil() {
+			putUvarint(&e.data, 0)
+			return
+		}
+		putUvarint(&e.data, uint64(v.Len()+1))
+		for i := 0; i < v.Len(); i++ {
+			e.value(v.Index(i))
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			putUvarint(&e.data, 0)
+			return
+		}
+		putUvarint(&e.data, uint64(v.Len()+1))
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			e.value(k)
+			e.value(v.MapIndex(k))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				e.value(v.Field(i))
+			}
+		}
+	default:
+		panic("EncodePackage: cannot encode " + v.Type().String())
+	}
+}
+
+func putUvarint(b *bytes.Buffer, x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	b.Write(buf[:binary.PutUvarint(buf[:], x)])
+}
+
+// A reader reads varints from data, recording the first error.
+type reader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (r *reader) fail() {
+	if r.err == nil {
+		r.err = errBadEncoding
+	}
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(r.data[r.off:])
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.off += n
+	return x
+}
+
+func (r *reader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Varint(r.data[r.off:])
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.off += n
+	return x
+}
+
+// count reads a uvarint that must fit in the remaining data,
+// such as a length or an index.
+func (r *reader) count() int {
+	x := r.uvarint()
+	if x > uint64(len(r.data)) {
+		r.fail()
+		return 0
+	}
+	return int(x)
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || r.off >= len(r.data) {
+		r.fail()
+		return 0
+	}
+	b := r.data[r.off]
+	r.off++
+	return b
+}
+
+func (r *reader) skip(n int) {
+	if r.off+n > len(r.data) {
+		r.fail()
+		return
+	}
+	r.off += n
+}
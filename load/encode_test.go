@@ -0,0 +1,173 @@
+package load
+
+import (
+	"bytes"
+	"go/build"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// stdPackage returns the package importPath of the standard library,
+// with the file hashes of its Go files.
+func stdPackage(t testing.TB, importPath string) *LegacyCachedPackage {
+	p, err := build.Default.Import(importPath, "", 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	cp := &LegacyCachedPackage{Build: *p, FileHash: make(map[string]string)}
+	for _, name := range StringList(p.GoFiles, p.TestGoFiles, p.XTestGoFiles) {
+		sum, err := FileHash(filepath.Join(p.Dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cp.FileHash[name] = string(sum[:4])
+	}
+	return cp
+}
+
+func TestEncodePackage(t *testing.T) {
+	pos := token.Position{Filename: "/src/p/a.go", Offset: 12, Line: 3, Column: 8}
+	for _, tt := range []struct {
+		name string
+		cp   *LegacyCachedPackage
+	}{
+		{"zero", &LegacyCachedPackage{}},
+		{"empty", &LegacyCachedPackage{
+			Build:    build.Package{GoFiles: []string{}, ImportPos: map[string][]token.Position{}},
+			FileHash: map[string]string{},
+		}},
+		{"fields", &LegacyCachedPackage{
+			Build: build.Package{
+				Dir:        "/src/p",
+				Name:       "p",
+				ImportPath: "example.com/p",
+				Goroot:     true,
+				BinaryOnly: true,
+				GoFiles:    []string{"a.go", "b.go", ""},
+				Imports:    []string{"fmt", "os"},
+				ImportPos: map[string][]token.Position{
+					"fmt": {pos, pos},
+					"os":  {{Filename: "/src/p/b.go", Line: -1}},
+					"":    nil,
+				},
+			},
+			FileHash: map[string]string{"a.go": "0123", "b.go": "\x00\xff"},
+		}},
+		{"fmt", stdPackage(t, "fmt")},
+		{"net/http", stdPackage(t, "net/http")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := EncodePackage(tt.cp)
+			if again := EncodePackage(tt.cp); !bytes.Equal(data, again) {
+				t.Errorf("EncodePackage is not deterministic")
+			}
+			got, err := DecodePackage(data)
+			if err != nil {
+				t.Fatalf("DecodePackage: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.cp) {
+				t.Errorf("DecodePackage(EncodePackage(cp)) = %+v, want %+v", got, tt.cp)
+			}
+
+			d, err := NewPackageDecoder(data)
+			if err != nil {
+				t.Fatalf("NewPackageDecoder: %v", err)
+			}
+			var dir string
+			if err := d.Field("Build.Dir", &dir); err != nil || dir != tt.cp.Build.Dir {
+				t.Errorf("Field(Build.Dir) = %q, %v, want %q", dir, err, tt.cp.Build.Dir)
+			}
+			var imports []string
+			if err := d.Field("Build.Imports", &imports); err != nil || !reflect.DeepEqual(imports, tt.cp.Build.Imports) {
+				t.Errorf("Field(Build.Imports) = %q, %v, want %q", imports, err, tt.cp.Build.Imports)
+			}
+			missing := "unchanged"
+			if err := d.Field("Build.NoSuchField", &missing); err != nil || missing != "unchanged" {
+				t.Errorf("Field(Build.NoSuchField) = %q, %v, want unchanged", missing, err)
+			}
+		})
+	}
+}
+
+func TestDecodePackageMalformed(t *testing.T) {
+	data := EncodePackage(stdPackage(t, "fmt"))
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"magic", append([]byte("gocmd pkh\n"), data[len(encodingMagic):]...)},
+		{"version", append([]byte(encodingMagic+"\x7f"), data[len(encodingMagic)+1:]...)},
+		{"header", data[:len(encodingMagic)+1]},
+		{"truncated", data[:len(data)/2]},
+		{"json", []byte(`{"Build": {"Dir": `)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodePackage(tt.data); err == nil {
+				t.Errorf("DecodePackage succeeded, want error")
+			}
+		})
+	}
+	// No prefix of a valid encoding may make the decoder panic.
+	for n := 0; n < len(data); n++ {
+		DecodePackage(data[:n])
+	}
+}
+
+func TestEncodeStruct(t *testing.T) {
+	type entry struct {
+		Name  string
+		Files []string
+		Pos   map[string]token.Position
+		OK    bool
+		N     int
+	}
+	for _, want := range []*entry{
+		{},
+		{Name: "a", Files: []string{"x.go"}, Pos: map[string]token.Position{"x": {Line: 1}}, OK: true, N: -5},
+	} {
+		got := new(entry)
+		if err := DecodeStruct(EncodeStruct(want), got); err != nil {
+			t.Fatalf("DecodeStruct: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("DecodeStruct(EncodeStruct(%+v)) = %+v", want, got)
+		}
+	}
+}
+
+func BenchmarkEncodePackage(b *testing.B) {
+	cp := stdPackage(b, "net/http")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		EncodePackage(cp)
+	}
+}
+
+func BenchmarkDecodePackage(b *testing.B) {
+	data := EncodePackage(stdPackage(b, "net/http"))
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodePackage(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPackageDecoderField(b *testing.B) {
+	data := EncodePackage(stdPackage(b, "net/http"))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d, err := NewPackageDecoder(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var dir string
+		if err := d.Field("Build.Dir", &dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
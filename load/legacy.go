@@ -2,104 +2,88 @@ package load
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"go/build"
+	"go/token"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
 )
 
-// cachedPackage is the data structure stored in the cache.
-// It would be more efficient not to use JSON here.
+// LegacyCachedPackage is the data structure stored in the cache.
+// It is stored using the binary encoding implemented by EncodePackage.
 type LegacyCachedPackage struct {
-	// TODO: what is https://pkg.go.dev/go/token#Position
 	Build    build.Package
-	FileHash map[string]string
+	FileHash map[string]string // hex SHA-256 hash by file name
 }
 
-// cachedImport is cfg.BuildContext.Import but cached.
+// LegacyCachedImport is ctx.Import but cached.
 func LegacyCachedImport(ctx *build.Context, path, srcDir, modulePath, cacheDir string, mode build.ImportMode) (*build.Package, error) {
 	// Rewrite Import into ImportDir by asking Import
 	// to find the dir but not read any files.
 	// Then we don't need to have separate cache entries for search srcDir.
-	fmt.Println("ctx.Import: ", path, srcDir)
-	// TODO: why does this return
-	// /Users/julieqiu/go/pkg/mod/golang.org/x/tools@v0.0.0-20200915173823-2db8f0ff891c/godoc
+	//
+	// Note that for a non-local path in module mode, ctx.Import asks
+	// the go command to resolve path relative to the module containing
+	// srcDir, which may select a different version than the caller
+	// intended. Callers that have already resolved the package
+	// directory should pass "." as path and the directory as srcDir.
+	//
+	// ctx may read the package from a module zip instead of the
+	// extracted directory (see catalog.ZipDir.Context). Both share a
+	// cache entry, since the action ID of a directory in the module
+	// cache depends only on the module version, not on the files.
 	p, err := ctx.Import(path, srcDir, mode|build.FindOnly)
-	if err != nil {
-		fmt.Println(1)
+	if err != nil || mode&build.FindOnly != 0 {
 		return p, err
 	}
-	/*
-		if mode&build.FindOnly != 0 {
-			fmt.Println(2)
-			return p, nil
-		}
-	*/
 	// The IgnoreVendor bit doesn't matter to ImportDir.
 	// Clear it to get more cache hits.
-	fmt.Println(3)
 	return legacyCachedImportDir(ctx, p.Dir, modulePath, cacheDir, mode&^build.IgnoreVendor)
 }
 
+// ImportDirFunc, if set, reads the package in dir, a directory of the
+// module modulePath in the module cache whose download cache is
+// cacheDir, as ctx.ImportDir(dir, 0) would. LegacyCachedImport calls it
+// in place of ctx.ImportDir for packages missing from the package cache.
+// Package cache sets it, so that such packages are assembled from the
+// cached metadata of their files (see cache.ImportDir), and reading a
+// package for another build context does not parse its files again.
+var ImportDirFunc func(ctx *build.Context, dir, modulePath, cacheDir string) (*build.Package, error)
+
+// cacheVerify, set by GOCMDCACHEVERIFY=1, makes LegacyCachedImport
+// read every package again and report an error if it does not match
+// the cached entry.
 var cacheVerify = os.Getenv("GOCMDCACHEVERIFY") == "1"
 
 const HashSize = 32
 
 func legacyCachedImportDir(ctx *build.Context, dir, modulePath, cacheDir string, mode build.ImportMode) (*build.Package, error) {
 	uncached := func() (*build.Package, error) {
-		fmt.Println("uncached: ctx.ImportDir")
+		if ImportDirFunc != nil && mode == 0 {
+			return ImportDirFunc(ctx, dir, modulePath, cacheDir)
+		}
 		return ctx.ImportDir(dir, mode)
 	}
-	// 1. Does there exist a Cache? If not, nothing is cached so call
-	// ctx.ImportDir.
-	c := ModCache(cacheDir, modulePath)
-	// spew.Dump(c)
-	if c == nil {
-		println("NO CACHE")
-		fmt.Println(1)
-		return uncached()
+	// 1. Open the Cache of the module's versions.
+	c, err := ModCache(cacheDir, modulePath)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("cachedImportDir: dir: ", dir)
 
 	// 2. A Cache exists and we know the directory we should read from.
-	//
-	// Read infos ([]fs.FileInfo) from that dir:
-	// /Users/julieqiu/go/pkg/mod/golang.org/x/tools@v0.1.0/godoc
-	infos, err := ioutil.ReadDir(dir)
+	// Compute the action ID for importing it.
+	actionID, err := importActionID(ctx, dir, modulePath, cacheDir, mode)
 	if err != nil {
-		println("BAD READDIR", err.Error())
-		fmt.Println(2)
 		return uncached()
 	}
-	// spew.Dump(infos)
 
-	// We have a list of files.
-	// Create a new hash.
-	h := NewHash("build.Import")
-	if debugHash {
-		fmt.Fprintf(h, "ImportDir %s mode %d\n", dir, int(mode))
-		fmt.Fprintf(h, "cfg goarch %q goos %q goroot %q gopath %q\n",
-			ctx.GOARCH, ctx.GOOS, ctx.GOROOT, ctx.GOPATH)
-		fmt.Fprintf(h, "cfg cgoenabled %v useallfiles %v compiler %q\n",
-			ctx.CgoEnabled, ctx.UseAllFiles, ctx.Compiler)
-		fmt.Fprintf(h, "cfg buildtags %q releasetags %q installsuffix %q\n",
-			ctx.BuildTags, ctx.ReleaseTags, ctx.InstallSuffix)
-		for _, info := range infos {
-			fmt.Fprintf(h, "name %s size %d mtime %d\n", info.Name(), info.Size(), info.ModTime().UnixNano())
-			if sys := info.Sys(); sys != nil {
-				fmt.Println(h, sys)
-			}
-		}
-	}
-	actionID := h.Sum()
-	fmt.Println("actionID")
-	// spew.Dump(actionID)
-
-	fmt.Println("-----------------")
 	// actionID = hash
 	// 1. GetBytes of the actionID
 	//		GetBytes looks up the action ID in the cache and returns
@@ -110,16 +94,21 @@ func legacyCachedImportDir(ctx *build.Context, dir, modulePath, cacheDir string,
 	// 2. Unmarshal the data from those bytes into the cached package
 	// 3. For every filename, filehash:
 	//		Decode the string
-	//		(?) SetFileHash sets the hash returned by FileHash for file.
+	//		SetFileHash sets the hash returned by FileHash for file,
+	//		so that later hashing of the same files is free.
 	var cacheEntry []byte
 	if data, _, err := c.GetBytes(actionID); err == nil {
 		if cacheVerify {
 			cacheEntry = data
 		} else {
-			var cp LegacyCachedPackage
-			if err := json.Unmarshal(data, &cp); err == nil {
+			// The entry is keyed by module version, not location, so
+			// it may describe another copy of the module, such as one
+			// in a module cache imported from another machine.
+			if cp, err := DecodePackage(data); err == nil {
+				if cp.Build.Dir != dir {
+					relocate(&cp.Build, dir)
+				}
 				for name, hash := range cp.FileHash {
-					fmt.Println(name, hash)
 					var sum [HashSize]byte
 					x, err := hex.DecodeString(hash)
 					if err == nil && len(x) == HashSize {
@@ -127,20 +116,21 @@ func legacyCachedImportDir(ctx *build.Context, dir, modulePath, cacheDir string,
 						SetFileHash(filepath.Join(dir, name), sum)
 					}
 				}
+				if isJSONEntry(data) {
+					// Written by an earlier version; upgrade it in place.
+					c.PutBytes(actionID, EncodePackage(cp))
+				}
 				return &cp.Build, nil
 			}
-
 		}
 	}
-	// TODO: why call uncached here?
-	fmt.Println("-----------------")
+	// Cache miss: read the directory and record the result.
 	pkg, err := uncached()
 	if err != nil {
 		return pkg, err
 	}
-	// Got something again, call log.Fatal if something went wrong?
 	if pkg.Dir != dir {
-		log.Fatalf("internal error: LoadImport: found %s but expected %s", pkg.Dir, dir)
+		return nil, fmt.Errorf("internal error: LoadImport: found %s but expected %s", pkg.Dir, dir)
 	}
 
 	// We have the:
@@ -164,27 +154,133 @@ func legacyCachedImportDir(ctx *build.Context, dir, modulePath, cacheDir string,
 		pkg.XTestGoFiles,
 	)
 	cp.FileHash = make(map[string]string)
-	fmt.Println("-----------------")
 	for _, file := range allFiles {
-		sum, err := FileHash(filepath.Join(dir, file))
+		sum, err := ContextFileHash(ctx, filepath.Join(dir, file))
 		if err == nil {
 			cp.FileHash[file] = hex.EncodeToString(sum[:])
-			fmt.Println("-----> ", file, cp.FileHash[file])
 		}
 	}
-	data, err := json.MarshalIndent(&cp, "", "\t")
-	if err == nil {
-		data = append(data, '\n')
-		if cacheEntry != nil && !bytes.Equal(data, cacheEntry) {
-			fmt.Fprintf(os.Stderr, "cfg goarch %q goos %q goroot %q gopath %q cgoenabled %v useallfiles %v compiler %q buildtags %q releasetags %q installsuffix %q\n",
-				ctx.GOARCH, ctx.GOOS, ctx.GOROOT, ctx.GOPATH, ctx.CgoEnabled, ctx.UseAllFiles, ctx.Compiler, ctx.BuildTags, ctx.ReleaseTags, ctx.InstallSuffix)
-			fmt.Fprintf(os.Stderr, "cache mismatch for %s:\nFOUND:\n%s\nCOMPUTED:\n%s", dir, cacheEntry, data)
-			panic(1)
-		}
-		// Write to the cache
-		c.PutBytes(actionID, data)
+	data := EncodePackage(&cp)
+	if cacheEntry != nil && !bytes.Equal(data, cacheEntry) {
+		return nil, fmt.Errorf("package cache mismatch for %s (goos %q goarch %q buildtags %q):\nfound:\n%q\ncomputed:\n%q",
+			dir, ctx.GOOS, ctx.GOARCH, ctx.BuildTags, cacheEntry, data)
 	}
+	// Write to the cache
+	c.PutBytes(actionID, data)
 
 	// Return the package
-	return pkg, err
+	return pkg, nil
+}
+
+// relocate rewrites the file names recorded in p, a package read from
+// another copy of its directory, to refer to dir instead.
+func relocate(p *build.Package, dir string) {
+	old := p.Dir
+	p.Dir = dir
+	for _, m := range []map[string][]token.Position{
+		p.ImportPos, p.TestImportPos, p.XTestImportPos,
+		p.EmbedPatternPos, p.TestEmbedPatternPos, p.XTestEmbedPatternPos,
+	} {
+		for _, list := range m {
+			for i := range list {
+				rel, err := filepath.Rel(old, list[i].Filename)
+				if err == nil && !strings.HasPrefix(rel, "..") {
+					list[i].Filename = filepath.Join(dir, rel)
+				}
+			}
+		}
+	}
+}
+
+// ContextFileHash is like FileHash, but reads the file using
+// ctx.OpenFile if it is set, so that files that are not on the local
+// file system, such as those read from a module zip, can be hashed.
+func ContextFileHash(ctx *build.Context, file string) ([HashSize]byte, error) {
+	if ctx.OpenFile == nil {
+		return FileHash(file)
+	}
+	hashFileCache.Lock()
+	out, ok := hashFileCache.m[file]
+	hashFileCache.Unlock()
+	if ok {
+		return out, nil
+	}
+	f, err := ctx.OpenFile(file)
+	if err != nil {
+		return [HashSize]byte{}, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return [HashSize]byte{}, err
+	}
+	h.Sum(out[:0])
+	SetFileHash(file, out)
+	return out, nil
+}
+
+// importActionID returns the action ID for importing the package in dir
+// with the given build context and mode.
+//
+// Directories in the module cache are immutable, so when dir is inside
+// GOMODCACHE the ID is derived from the module version and the
+// package's directory within it, without reading the directory.
+// Otherwise the directory listing is hashed as well, so that changes
+// to the files produce a new ID.
+func importActionID(ctx *build.Context, dir, modulePath, cacheDir string, mode build.ImportMode) (ActionID, error) {
+	h := NewHash("build.Import")
+	fmt.Fprintf(h, "ImportDir mode %d\n", int(mode))
+	fmt.Fprintf(h, "cfg goarch %q goos %q compiler %q\n", ctx.GOARCH, ctx.GOOS, ctx.Compiler)
+	fmt.Fprintf(h, "cfg cgoenabled %v useallfiles %v\n", ctx.CgoEnabled, ctx.UseAllFiles)
+	fmt.Fprintf(h, "cfg buildtags %q tooltags %q releasetags %q installsuffix %q\n",
+		ctx.BuildTags, ctx.ToolTags, ctx.ReleaseTags, ctx.InstallSuffix)
+
+	if version, rel, ok := moduleCacheDir(dir, modulePath, cacheDir); ok {
+		fmt.Fprintf(h, "module %s@%s dir %s\n", modulePath, version, rel)
+		return h.Sum(), nil
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ActionID{}, err
+	}
+	fmt.Fprintf(h, "dir %s\n", dir)
+	for _, info := range infos {
+		fmt.Fprintf(h, "name %s size %d mtime %d\n", info.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return h.Sum(), nil
+}
+
+// moduleCacheDir reports whether dir is a directory in the extracted copy
+// of a version of modulePath in the module cache containing the download
+// cache cacheDir. If so, it returns the version and the slash-separated
+// path of dir relative to the module root.
+func moduleCacheDir(dir, modulePath, cacheDir string) (version, rel string, ok bool) {
+	escPath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return "", "", false
+	}
+	modRoot := filepath.Dir(filepath.Dir(cacheDir))
+	r, err := filepath.Rel(modRoot, dir)
+	if err != nil {
+		return "", "", false
+	}
+	r = filepath.ToSlash(r)
+	if !strings.HasPrefix(r, escPath+"@") {
+		return "", "", false
+	}
+	r = r[len(escPath)+1:]
+	escVersion := r
+	if i := strings.Index(r, "/"); i >= 0 {
+		escVersion, rel = r[:i], r[i+1:]
+	}
+	version, err = module.UnescapeVersion(escVersion)
+	if err != nil || module.Check(modulePath, version) != nil {
+		return "", "", false
+	}
+	if rel == "" {
+		rel = "."
+	}
+	return version, rel, true
 }
@@ -0,0 +1,200 @@
+package load
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/build"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newModuleDir returns the download cache of a new module cache holding
+// example.com/p@v1.0.0, and the directory of that version, which holds
+// files.
+func newModuleDir(t *testing.T, files map[string]string) (cacheDir, dir string) {
+	modCache := t.TempDir()
+	cacheDir = filepath.Join(modCache, "cache", "download")
+	if err := os.MkdirAll(filepath.Join(cacheDir, "example.com", "p", "@v"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	dir = filepath.Join(modCache, "example.com", "p@v1.0.0")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cacheDir, dir
+}
+
+// TestLegacyCachedImportJSON checks that a package cache entry in the
+// JSON encoding used by earlier versions is read, and rewritten in the
+// binary encoding.
+func TestLegacyCachedImportJSON(t *testing.T) {
+	cacheDir, dir := newModuleDir(t, map[string]string{
+		"a.go": "package p\n\nimport \"fmt\"\n\nvar _ = fmt.Sprint\n",
+	})
+	ctx := build.Default
+	want, err := ctx.ImportDir(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write the entry as earlier versions did, with a name that tells
+	// it apart from what ImportDir returns.
+	cp := LegacyCachedPackage{Build: *want, FileHash: map[string]string{}}
+	cp.Build.Name = "fromjson"
+	data, err := json.MarshalIndent(&cp, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, '\n')
+	c, err := ModCache(cacheDir, "example.com/p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := importActionID(&ctx, dir, "example.com/p", cacheDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutBytes(id, data); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LegacyCachedImport(&ctx, ".", dir, "example.com/p", cacheDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "fromjson" {
+		t.Fatalf("LegacyCachedImport returned package %q, want %q from the JSON entry", p.Name, "fromjson")
+	}
+	if !reflect.DeepEqual(p.Imports, want.Imports) || !reflect.DeepEqual(p.ImportPos, want.ImportPos) {
+		t.Errorf("LegacyCachedImport: imports %v at %v, want %v at %v", p.Imports, p.ImportPos, want.Imports, want.ImportPos)
+	}
+
+	data, _, err = c.GetBytes(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(encodingMagic)) {
+		t.Fatalf("entry not rewritten in the binary encoding:\n%s", data)
+	}
+	got, err := DecodePackage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, &cp) {
+		t.Errorf("rewritten entry = %+v, want %+v", got, cp)
+	}
+}
+
+// TestImportActionID checks that the action ID of a package changes
+// with the build context, and, outside the module cache, with the
+// files of its directory.
+func TestImportActionID(t *testing.T) {
+	cacheDir, modDir := newModuleDir(t, map[string]string{"a.go": "package p\n"})
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.go")
+	if err := os.WriteFile(file, []byte("package p\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	base := build.Default
+	base.GOOS, base.GOARCH = "linux", "amd64"
+	base.BuildTags = []string{"a", "b"}
+	base.ToolTags = []string{"goexperiment.x", "goexperiment.y"}
+	base.ReleaseTags = []string{"go1.1", "go1.2"}
+	id := func(ctx build.Context, dir string) ActionID {
+		id, err := importActionID(&ctx, dir, "example.com/p", cacheDir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	baseID, baseModID := id(base, dir), id(base, modDir)
+
+	for _, tt := range []struct {
+		name string
+		edit func(ctx *build.Context)
+	}{
+		{"GOOS", func(ctx *build.Context) { ctx.GOOS = "windows" }},
+		{"GOARCH", func(ctx *build.Context) { ctx.GOARCH = "arm64" }},
+		{"BuildTags", func(ctx *build.Context) { ctx.BuildTags = []string{"a"} }},
+		{"BuildTags order", func(ctx *build.Context) { ctx.BuildTags = []string{"b", "a"} }},
+		{"ToolTags", func(ctx *build.Context) { ctx.ToolTags = nil }},
+		{"ToolTags order", func(ctx *build.Context) { ctx.ToolTags = []string{"goexperiment.y", "goexperiment.x"} }},
+		{"ReleaseTags", func(ctx *build.Context) { ctx.ReleaseTags = []string{"go1.1"} }},
+		{"ReleaseTags order", func(ctx *build.Context) { ctx.ReleaseTags = []string{"go1.2", "go1.1"} }},
+		{"CgoEnabled", func(ctx *build.Context) { ctx.CgoEnabled = !ctx.CgoEnabled }},
+	} {
+		ctx := base
+		tt.edit(&ctx)
+		if id(ctx, dir) == baseID {
+			t.Errorf("changing %s leaves the action ID unchanged", tt.name)
+		}
+		if id(ctx, modDir) == baseModID {
+			t.Errorf("changing %s leaves the action ID in the module cache unchanged", tt.name)
+		}
+	}
+
+	// Outside the module cache, the ID changes when a file changes.
+	if err := os.WriteFile(file, []byte("package p // edited\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	edited := id(base, dir)
+	if edited == baseID {
+		t.Errorf("editing a file leaves the action ID unchanged")
+	}
+	// Same size, new modification time.
+	if err := os.WriteFile(file, []byte("package q // edited\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	touched := id(base, dir)
+	if touched == edited {
+		t.Errorf("editing a file without changing its size leaves the action ID unchanged")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.go"), []byte("package p\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if id(base, dir) == touched {
+		t.Errorf("adding a file leaves the action ID unchanged")
+	}
+
+	// In the module cache, the ID depends on the module version and
+	// directory, not on the files, which cannot change.
+	if err := os.WriteFile(filepath.Join(modDir, "a.go"), []byte("package p // edited\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if id(base, modDir) != baseModID {
+		t.Errorf("editing a file in the module cache changes the action ID")
+	}
+}
+
+// TestLegacyCachedImportFindOnly checks that with build.FindOnly,
+// LegacyCachedImport only finds the package directory.
+func TestLegacyCachedImportFindOnly(t *testing.T) {
+	cacheDir, dir := newModuleDir(t, map[string]string{"a.go": "package p\n"})
+	ctx := build.Default
+	p, err := LegacyCachedImport(&ctx, ".", dir, "example.com/p", cacheDir, build.FindOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Dir != dir || len(p.GoFiles) != 0 {
+		t.Errorf("LegacyCachedImport(FindOnly) = dir %s, files %v, want dir %s and no files", p.Dir, p.GoFiles, dir)
+	}
+	c, err := ModCache(cacheDir, "example.com/p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := c.ActionIDs(); err != nil || len(ids) != 0 {
+		t.Errorf("after LegacyCachedImport(FindOnly), cache holds %d entries, %v, want none", len(ids), err)
+	}
+}
@@ -0,0 +1,231 @@
+package load
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrimOptions controls which entries Trim removes.
+// If neither MaxAge nor MaxSize is set, entries unused for
+// trimLimit (5 days) are removed.
+type TrimOptions struct {
+	MaxAge  time.Duration // remove entries unused for longer than MaxAge
+	MaxSize int64         // then remove least recently used entries until the caches hold at most MaxSize bytes in total
+	Force   bool          // trim even if the caches were trimmed within trimInterval
+	DryRun  bool          // report what would be removed, but do not remove it
+}
+
+// A TrimmedFile is an action or output file removed by Trim.
+type TrimmedFile struct {
+	Name    string    // file name
+	Size    int64     // size in bytes
+	ModTime time.Time // approximate time of last use
+}
+
+// Trim removes old and least recently used entries from c, according
+// to opts, and returns the files removed. It records the time of the
+// last trim in the file trim.txt in the cache directory; see Trim.
+func (c *Cache) Trim(opts TrimOptions) ([]TrimmedFile, error) {
+	return Trim([]*Cache{c}, filepath.Join(c.dir, "trim.txt"), opts)
+}
+
+// Trim removes old and least recently used entries from the caches,
+// according to opts, and returns the files removed. The caches are
+// trimmed as a whole: opts.MaxSize limits their total size, and the
+// least recently used entries among all of them are removed first.
+//
+// An entry is an action (-a) file together with its output (-d) file,
+// which is removed with it unless another remaining action refers to
+// it, so that no action is left referring to a removed output. Output
+// files that no action refers to are entries of their own. An entry is
+// as old as its action file, or as its output file if it has none.
+//
+// Trim records the time of the last trim in the file marker, and does
+// nothing if the caches were trimmed less than trimInterval ago, unless
+// opts.Force or opts.DryRun is set. A dry run neither removes files nor
+// updates marker.
+func Trim(caches []*Cache, marker string, opts TrimOptions) ([]TrimmedFile, error) {
+	now := time.Now()
+	if len(caches) > 0 {
+		now = caches[0].now()
+	}
+	if !opts.Force && !opts.DryRun {
+		if data, err := os.ReadFile(marker); err == nil {
+			if t, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil && now.Sub(time.Unix(t, 0)) < trimInterval {
+				return nil, nil
+			}
+		}
+	}
+
+	var entries []*trimEntry
+	refs := make(map[string]int) // number of remaining actions referring to each output file
+	var total int64
+	for _, c := range caches {
+		ce, size, err := c.trimEntries(refs)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, ce...)
+		total += size
+	}
+	// Oldest first.
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].action.ModTime.Before(entries[j].action.ModTime) })
+
+	maxAge := opts.MaxAge
+	if maxAge == 0 && opts.MaxSize == 0 {
+		maxAge = trimLimit
+	}
+	remove := func(f TrimmedFile) error {
+		if !opts.DryRun {
+			if err := os.Remove(f.Name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		total -= f.Size
+		return nil
+	}
+	var trimmed []TrimmedFile
+	for _, e := range entries {
+		tooOld := maxAge > 0 && now.Sub(e.action.ModTime) > maxAge
+		tooBig := opts.MaxSize > 0 && total > opts.MaxSize
+		if !tooOld && !tooBig {
+			break
+		}
+		if err := remove(e.action); err != nil {
+			return trimmed, err
+		}
+		trimmed = append(trimmed, e.action)
+		if e.output == nil {
+			continue
+		}
+		refs[e.output.Name]--
+		if refs[e.output.Name] > 0 {
+			continue
+		}
+		if err := remove(*e.output); err != nil {
+			return trimmed, err
+		}
+		trimmed = append(trimmed, *e.output)
+	}
+
+	if !opts.DryRun {
+		// Ignore errors from here: if we don't write the complete timestamp,
+		// the cache will appear older than it is, and we'll trim it again next time.
+		os.WriteFile(marker, []byte(fmt.Sprintf("%d", now.Unix())), 0666)
+	}
+	return trimmed, nil
+}
+
+// A trimEntry is a unit of removal for Trim.
+type trimEntry struct {
+	action TrimmedFile  // action file, or an output file no action refers to
+	output *TrimmedFile // output file of action, if any
+}
+
+// trimEntries returns the entries of c, and the total size of their
+// files, counting in refs the actions referring to each output file.
+func (c *Cache) trimEntries(refs map[string]int) ([]*trimEntry, int64, error) {
+	files, err := c.entryFiles()
+	if err != nil {
+		return nil, 0, err
+	}
+	var size int64
+	outputs := make(map[string]*TrimmedFile)
+	for i, f := range files {
+		size += f.Size
+		if strings.HasSuffix(f.Name, "-d") {
+			outputs[f.Name] = &files[i]
+		}
+	}
+	var entries []*trimEntry
+	for _, f := range files {
+		name := filepath.Base(f.Name)
+		if !strings.HasSuffix(name, "-a") {
+			continue
+		}
+		e := &trimEntry{action: f}
+		var id ActionID
+		if b, err := hex.DecodeString(strings.TrimSuffix(name, "-a")); err == nil && len(b) == HashSize {
+			copy(id[:], b)
+			// An action whose file cannot be read is removed on its own.
+			if entry, err := c.readEntry(id); err == nil {
+				if out := outputs[c.fileName(entry.OutputID, "d")]; out != nil {
+					e.output = out
+					refs[out.Name]++
+				}
+			}
+		}
+		entries = append(entries, e)
+	}
+	for name, out := range outputs {
+		if refs[name] == 0 {
+			entries = append(entries, &trimEntry{action: *out})
+		}
+	}
+	return entries, size, nil
+}
+
+// entryFiles returns the action and output files in the cache.
+func (c *Cache) entryFiles() ([]TrimmedFile, error) {
+	var files []TrimmedFile
+	for i := 0; i < 256; i++ {
+		subdir := filepath.Join(c.dir, fmt.Sprintf("%02x", i))
+		entries, err := os.ReadDir(subdir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || !(strings.HasSuffix(name, "-a") || strings.HasSuffix(name, "-d")) {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			files = append(files, TrimmedFile{
+				Name:    filepath.Join(subdir, name),
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			})
+		}
+	}
+	return files, nil
+}
+
+// ActionIDs returns the IDs of the action entries in the cache,
+// in no particular order.
+func (c *Cache) ActionIDs() ([]ActionID, error) {
+	files, err := c.entryFiles()
+	if err != nil {
+		return nil, err
+	}
+	var ids []ActionID
+	for _, f := range files {
+		name := filepath.Base(f.Name)
+		if !strings.HasSuffix(name, "-a") {
+			continue
+		}
+		var id ActionID
+		if b, err := hex.DecodeString(strings.TrimSuffix(name, "-a")); err == nil && len(b) == HashSize {
+			copy(id[:], b)
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// ActionFile returns the name of the cache file storing the entry
+// for the action ID.
+func (c *Cache) ActionFile(id ActionID) string {
+	return c.fileName(id, "a")
+}
@@ -0,0 +1,255 @@
+package load
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var trimStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTrimCache returns a new cache whose clock reads the time *now.
+func newTrimCache(t *testing.T, now *time.Time) *Cache {
+	c, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return *now }
+	return c
+}
+
+// putAt stores data as the output of the action named key in c at time
+// at, and returns the action ID.
+func putAt(t *testing.T, c *Cache, now *time.Time, at time.Time, key, data string) ActionID {
+	*now = at
+	id := testID(key)
+	if err := c.PutBytes(id, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// testID returns an action ID derived from key.
+func testID(key string) ActionID {
+	h := NewHash("trim test")
+	h.Write([]byte(key))
+	return h.Sum()
+}
+
+// cacheFiles returns the names of the action and output files in c,
+// sorted.
+func cacheFiles(t *testing.T, c *Cache) []string {
+	files, err := c.entryFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f.Name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// trimmedNames returns the base names of files, sorted.
+func trimmedNames(files []TrimmedFile) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f.Name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// entryNames returns the base names of the action and output files of
+// the entries for ids, sorted.
+func entryNames(t *testing.T, c *Cache, ids ...ActionID) []string {
+	var names []string
+	for _, id := range ids {
+		e, err := c.readEntry(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.Base(c.fileName(id, "a")), filepath.Base(c.fileName(e.OutputID, "d")))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkEntries checks that every action in c has its output, and every
+// output in c is the output of an action.
+func checkEntries(t *testing.T, c *Cache) {
+	ids, err := c.ActionIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := make(map[string]bool)
+	for _, id := range ids {
+		e, err := c.readEntry(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := c.fileName(e.OutputID, "d")
+		if _, err := os.Stat(out); err != nil {
+			t.Errorf("action %x refers to removed output: %v", id, err)
+		}
+		outputs[filepath.Base(out)] = true
+	}
+	for _, name := range cacheFiles(t, c) {
+		if strings.HasSuffix(name, "-d") && !outputs[name] {
+			t.Errorf("output %s left without an action", name)
+		}
+	}
+}
+
+func TestTrimMaxAge(t *testing.T) {
+	var now time.Time
+	c := newTrimCache(t, &now)
+	day := 24 * time.Hour
+	old := putAt(t, c, &now, trimStart, "old", "old")
+	shared := putAt(t, c, &now, trimStart, "shared old", "shared")
+	mid := putAt(t, c, &now, trimStart.Add(3*day), "mid", "mid")
+	recent := putAt(t, c, &now, trimStart.Add(6*day), "shared recent", "shared")
+	// An output that no action refers to.
+	orphan := c.fileName(testID("orphan"), "d")
+	if err := os.MkdirAll(filepath.Dir(orphan), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(orphan, []byte("orphan"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(orphan, trimStart, trimStart)
+	now = trimStart.Add(7 * day)
+
+	// By default, entries unused for 5 days are removed. The output
+	// shared with a recent action stays.
+	want := append(entryNames(t, c, old), filepath.Base(c.fileName(shared, "a")), filepath.Base(orphan))
+	sort.Strings(want)
+	wantMid := entryNames(t, c, mid)
+	trimmed, err := c.Trim(TrimOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := trimmedNames(trimmed); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Trim removed %v, want %v", got, want)
+	}
+	if got, want := cacheFiles(t, c), entryNames(t, c, mid, recent); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("after Trim, cache holds %v, want %v", got, want)
+	}
+	checkEntries(t, c)
+	if data, _, err := c.GetBytes(recent); err != nil || string(data) != "shared" {
+		t.Errorf("GetBytes(recent) = %q, %v, want %q", data, err, "shared")
+	}
+
+	// MaxAge overrides the default.
+	trimmed, err = c.Trim(TrimOptions{MaxAge: 2 * day, Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := trimmedNames(trimmed), wantMid; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Trim(MaxAge: 2 days) removed %v, want %v", got, want)
+	}
+	checkEntries(t, c)
+}
+
+func TestTrimMaxSize(t *testing.T) {
+	var now time.Time
+	c := newTrimCache(t, &now)
+	var ids []ActionID
+	for i, key := range []string{"a", "b", "c", "d"} {
+		ids = append(ids, putAt(t, c, &now, trimStart.Add(time.Duration(i)*time.Minute), key, strings.Repeat(key, 1000)))
+	}
+	actionSize := int64(entrySize)
+	entry := actionSize + 1000
+
+	// Room for two entries: the two least recently used go, each with
+	// its output, although they are younger than the default age limit.
+	want := entryNames(t, c, ids[0], ids[1])
+	trimmed, err := c.Trim(TrimOptions{MaxSize: 2*entry + entry/2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := trimmedNames(trimmed); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Trim(MaxSize) removed %v, want %v", got, want)
+	}
+	var size int64
+	files, err := c.entryFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		size += f.Size
+	}
+	if size != 2*entry {
+		t.Errorf("after Trim(MaxSize), cache holds %d bytes, want %d", size, 2*entry)
+	}
+	checkEntries(t, c)
+
+	// Using an entry makes it recently used.
+	now = trimStart.Add(2 * time.Hour)
+	if _, _, err := c.GetBytes(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	want = entryNames(t, c, ids[3])
+	trimmed, err = c.Trim(TrimOptions{MaxSize: entry, Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := trimmedNames(trimmed); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Trim(MaxSize) after use removed %v, want %v", got, want)
+	}
+	checkEntries(t, c)
+}
+
+func TestTrimMarker(t *testing.T) {
+	var now time.Time
+	c := newTrimCache(t, &now)
+	putAt(t, c, &now, trimStart, "a", "a")
+	now = trimStart.Add(trimLimit + time.Hour)
+	all := cacheFiles(t, c)
+
+	// A dry run reports what would be removed, but removes nothing and
+	// does not count as a trim.
+	trimmed, err := c.Trim(TrimOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := trimmedNames(trimmed); strings.Join(got, " ") != strings.Join(all, " ") {
+		t.Errorf("dry run reported %v, want %v", got, all)
+	}
+	if got := cacheFiles(t, c); strings.Join(got, " ") != strings.Join(all, " ") {
+		t.Errorf("after dry run, cache holds %v, want %v", got, all)
+	}
+	if _, err := os.Stat(filepath.Join(c.dir, "trim.txt")); err == nil {
+		t.Errorf("dry run wrote trim.txt")
+	}
+
+	if trimmed, err := c.Trim(TrimOptions{}); err != nil || len(trimmed) != len(all) {
+		t.Fatalf("Trim = %v, %v, want %d files removed", trimmed, err, len(all))
+	}
+
+	// Within trimInterval of the last trim, Trim does nothing unless
+	// forced.
+	putAt(t, c, &now, trimStart, "b", "b")
+	now = trimStart.Add(trimLimit + trimInterval)
+	if trimmed, err := c.Trim(TrimOptions{}); err != nil || len(trimmed) != 0 {
+		t.Errorf("Trim within trimInterval = %v, %v, want nothing removed", trimmed, err)
+	}
+	if trimmed, err := c.Trim(TrimOptions{DryRun: true}); err != nil || len(trimmed) != 2 {
+		t.Errorf("dry run within trimInterval = %v, %v, want 2 files", trimmed, err)
+	}
+	now = now.Add(2 * time.Hour)
+	if trimmed, err := c.Trim(TrimOptions{}); err != nil || len(trimmed) != 2 {
+		t.Errorf("Trim after trimInterval = %v, %v, want 2 files removed", trimmed, err)
+	}
+
+	later := now
+	putAt(t, c, &now, trimStart, "c", "c")
+	now = later
+	if trimmed, err := c.Trim(TrimOptions{Force: true}); err != nil || len(trimmed) != 2 {
+		t.Errorf("forced Trim = %v, %v, want 2 files removed", trimmed, err)
+	}
+}
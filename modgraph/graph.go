@@ -0,0 +1,420 @@
+// Package modgraph computes module requirement graphs and build lists
+// using only the go.mod files in the module download cache, so that
+// a build's dependencies can be checked without network access.
+//
+// The graph is computed as the go command does in module mode: by
+// minimal version selection (MVS) over the requirements of the main
+// modules and of their dependencies, after applying the replace and
+// exclude directives of the main modules (or of the go.work file) and,
+// for main modules declaring go 1.17 or later, module graph pruning.
+package modgraph
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julieqiu/modcache/catalog"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// A MissingError reports that the go.mod file of a module version
+// needed to compute a requirement graph is not in the module cache.
+type MissingError struct {
+	Module module.Version
+}
+
+func (e *MissingError) Error() string {
+	return "missing from cache: " + e.Module.String()
+}
+
+// A Graph is the module requirement graph of a main module or of the
+// main modules of a workspace.
+type Graph struct {
+	main     []module.Version // main modules, with empty versions unless loaded by LoadVersion
+	mainDirs map[string]string
+	replace  map[module.Version]module.Version // by old path@version, or old path with empty version
+	reqs     map[module.Version][]module.Version
+	selected map[string]string
+}
+
+// BuildList returns the build list of the main module, or of the
+// workspace, whose go.mod or go.work file is file, computed from the
+// go.mod files in c. See Load.
+func BuildList(c *catalog.Catalog, file string) ([]module.Version, error) {
+	g, err := Load(c, file)
+	if err != nil {
+		return nil, err
+	}
+	return g.BuildList(), nil
+}
+
+// Load reads the requirement graph of the main module whose go.mod file
+// is file, or, if file is named go.work, of the workspace it describes.
+// The go.mod files of the dependencies are read from the download cache
+// of c, or, for dependencies replaced by local directories, from those
+// directories. If one is missing, Load returns a *MissingError.
+//
+// As in the go command since Go 1.16, requirements on excluded versions
+// are ignored.
+func Load(c *catalog.Catalog, file string) (*Graph, error) {
+	g := &Graph{
+		mainDirs: make(map[string]string),
+		replace:  make(map[module.Version]module.Version),
+		reqs:     make(map[module.Version][]module.Version),
+		selected: make(map[string]string),
+	}
+	var mainFiles []*modfile.File
+	var goVersion string
+	if filepath.Base(file) == "go.work" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		wf, err := modfile.ParseWork(file, data, nil)
+		if err != nil {
+			return nil, err
+		}
+		if wf.Go != nil {
+			goVersion = wf.Go.Version
+		}
+		for _, u := range wf.Use {
+			dir := u.Path
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(filepath.Dir(file), dir)
+			}
+			f, err := readMainModule(filepath.Join(dir, "go.mod"))
+			if err != nil {
+				return nil, err
+			}
+			mainFiles = append(mainFiles, f)
+			g.mainDirs[f.Module.Mod.Path] = dir
+		}
+		// Replacements in go.work override those in the go.mod files.
+		g.addReplace(wf.Replace, filepath.Dir(file))
+	} else {
+		f, err := readMainModule(file)
+		if err != nil {
+			return nil, err
+		}
+		if f.Go != nil {
+			goVersion = f.Go.Version
+		}
+		mainFiles = append(mainFiles, f)
+		g.mainDirs[f.Module.Mod.Path] = filepath.Dir(file)
+	}
+	excluded := make(map[module.Version]bool)
+	for _, f := range mainFiles {
+		g.main = append(g.main, f.Module.Mod)
+		g.addReplace(f.Replace, g.mainDirs[f.Module.Mod.Path])
+		for _, x := range f.Exclude {
+			excluded[x.Mod] = true
+		}
+	}
+	if err := g.load(c, mainFiles, goVersion, excluded); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// LoadVersion is like Load, but reads the requirement graph of the
+// cached module version m, as if it were the main module. Its replace
+// and exclude directives are ignored, as they would be if m were a
+// dependency. Unlike modules loaded by Load, the main module of the
+// graph has a version, and its source is read from the module cache.
+func LoadVersion(c *catalog.Catalog, m module.Version) (*Graph, error) {
+	g := &Graph{
+		main:     []module.Version{m},
+		mainDirs: make(map[string]string),
+		replace:  make(map[module.Version]module.Version),
+		reqs:     make(map[module.Version][]module.Version),
+		selected: make(map[string]string),
+	}
+	l := &loader{c: c, g: g}
+	f, err := l.goMod(m)
+	if err != nil {
+		return nil, err
+	}
+	var goVersion string
+	if f.Go != nil {
+		goVersion = f.Go.Version
+	}
+	if err := g.load(c, []*modfile.File{f}, goVersion, nil); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// load adds the requirements of the main modules, whose go.mod files
+// are mainFiles, to g.
+func (g *Graph) load(c *catalog.Catalog, mainFiles []*modfile.File, goVersion string, excluded map[module.Version]bool) error {
+	l := &loader{
+		c:        c,
+		g:        g,
+		excluded: excluded,
+		pruned:   make(map[module.Version]bool),
+		unpruned: make(map[module.Version]bool),
+	}
+	unpruned := !goAtLeast(goVersion, "1.17")
+	for i, f := range mainFiles {
+		g.reqs[g.main[i]] = l.requirements(f)
+		for _, r := range g.reqs[g.main[i]] {
+			l.queue = append(l.queue, work{r, true, unpruned})
+		}
+	}
+	return l.run()
+}
+
+// readMainModule reads the go.mod file of a main module.
+func readMainModule(file string) (*modfile.File, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f, err := modfile.Parse(file, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.Module == nil {
+		return nil, fmt.Errorf("%s: no module declaration", file)
+	}
+	return f, nil
+}
+
+// addReplace records the replacements, unless already replaced.
+// Directory replacements are made absolute, relative to dir.
+func (g *Graph) addReplace(replace []*modfile.Replace, dir string) {
+	for _, r := range replace {
+		if _, ok := g.replace[r.Old]; ok {
+			continue
+		}
+		to := r.New
+		if to.Version == "" && !filepath.IsAbs(to.Path) {
+			to.Path = filepath.Join(dir, to.Path)
+		}
+		g.replace[r.Old] = to
+	}
+}
+
+// A work is a module version waiting to be added to the graph.
+type work struct {
+	m        module.Version
+	expand   bool // whether to read its requirements
+	unpruned bool // whether to read their requirements too, transitively
+}
+
+// A loader adds module versions to a graph.
+type loader struct {
+	c        *catalog.Catalog
+	g        *Graph
+	excluded map[module.Version]bool
+	queue    []work
+	pruned   map[module.Version]bool // whether a loaded version's go.mod is at go 1.17 or later
+	unpruned map[module.Version]bool // versions loaded with unpruned set
+}
+
+// run adds the versions in the queue, and their requirements, to the
+// graph, breadth first.
+//
+// As in the go command, the requirements of a version reached through a
+// pruned module graph are read only if the version's own go.mod is
+// below go 1.17: one at go 1.17 or later lists everything its packages
+// need. Below an unpruned version, the graph is read in full.
+func (l *loader) run() error {
+	for len(l.queue) > 0 {
+		w := l.queue[0]
+		l.queue = l.queue[1:]
+		if semver.Compare(w.m.Version, l.g.selected[w.m.Path]) > 0 {
+			l.g.selected[w.m.Path] = w.m.Version
+		}
+		if !w.expand {
+			continue
+		}
+		if w.unpruned {
+			if l.unpruned[w.m] {
+				continue
+			}
+			l.unpruned[w.m] = true
+		} else if _, ok := l.g.reqs[w.m]; ok {
+			continue
+		}
+		if _, ok := l.g.reqs[w.m]; !ok {
+			f, err := l.goMod(w.m)
+			if err != nil {
+				return err
+			}
+			l.g.reqs[w.m] = l.requirements(f)
+			l.pruned[w.m] = f.Go != nil && goAtLeast(f.Go.Version, "1.17")
+		}
+		next := w.unpruned || !l.pruned[w.m]
+		for _, r := range l.g.reqs[w.m] {
+			l.queue = append(l.queue, work{r, next, next})
+		}
+	}
+	return nil
+}
+
+// goMod reads the go.mod file of m, or of its replacement.
+func (l *loader) goMod(m module.Version) (*modfile.File, error) {
+	r := l.g.Replacement(m)
+	var data []byte
+	var file string
+	if r.Version == "" {
+		file = filepath.Join(r.Path, "go.mod")
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, err
+		}
+	} else {
+		cm, err := l.c.Module(r.Path)
+		if err != nil {
+			return nil, &MissingError{r}
+		}
+		v := cm.Lookup(r.Version)
+		if v == nil || v.GoMod == "" {
+			return nil, &MissingError{r}
+		}
+		file = v.GoMod
+		if data, err = v.ReadGoMod(); err != nil {
+			return nil, err
+		}
+	}
+	return modfile.ParseLax(file, data, nil)
+}
+
+// requirements returns the requirements of f, with requirements on
+// main modules and on excluded versions dropped.
+func (l *loader) requirements(f *modfile.File) []module.Version {
+	var reqs []module.Version
+	for _, r := range f.Require {
+		m := r.Mod
+		if l.g.isMain(m.Path) || l.excluded[m] {
+			continue
+		}
+		reqs = append(reqs, m)
+	}
+	return reqs
+}
+
+// Main returns the main modules.
+func (g *Graph) Main() []module.Version {
+	return g.main
+}
+
+// MainDir returns the directory of the main module with the given path,
+// or "" if there is none.
+func (g *Graph) MainDir(path string) string {
+	return g.mainDirs[path]
+}
+
+// Replacement returns the module whose contents are used for m:
+// its replacement, if any, or m itself. A replacement by a local
+// directory has the absolute directory as its path and no version.
+func (g *Graph) Replacement(m module.Version) module.Version {
+	if r, ok := g.replace[m]; ok {
+		return r
+	}
+	if r, ok := g.replace[module.Version{Path: m.Path}]; ok {
+		return r
+	}
+	return m
+}
+
+// isMain reports whether path is the path of a main module.
+func (g *Graph) isMain(path string) bool {
+	for _, m := range g.main {
+		if m.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Selected returns the version of the module path selected by MVS,
+// the version of a main module (empty unless loaded by LoadVersion),
+// or "none" if the path is not in the graph.
+func (g *Graph) Selected(path string) string {
+	for _, m := range g.main {
+		if m.Path == path {
+			return m.Version
+		}
+	}
+	if v, ok := g.selected[path]; ok {
+		return v
+	}
+	return "none"
+}
+
+// BuildList returns the main modules followed by the selected version
+// of every other module in the graph, sorted by path.
+func (g *Graph) BuildList() []module.Version {
+	list := append([]module.Version(nil), g.main...)
+	var paths []string
+	for path := range g.selected {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		list = append(list, module.Version{Path: path, Version: g.selected[path]})
+	}
+	return list
+}
+
+// Required returns the requirements of m in the graph. It returns nil
+// for versions whose requirements were pruned from the graph.
+func (g *Graph) Required(m module.Version) []module.Version {
+	return g.reqs[m]
+}
+
+// Modules returns the versions in the graph whose requirements were
+// read, other than main modules, sorted by path and version. The go
+// command needs the go.mod files of these, and of their replacements,
+// to load the graph.
+func (g *Graph) Modules() []module.Version {
+	var list []module.Version
+	for m := range g.reqs {
+		if m.Version != "" && !g.isMain(m.Path) {
+			list = append(list, m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+		return semver.Compare(list[i].Version, list[j].Version) < 0
+	})
+	return list
+}
+
+// goAtLeast reports whether the Go language version v, such as "1.16"
+// or "1.21.0", is at least min. An empty v is taken as the default for
+// go.mod files without a go directive, go 1.16.
+func goAtLeast(v, min string) bool {
+	if v == "" {
+		v = "1.16"
+	}
+	a, b := goMinor(v), goMinor(min)
+	return a[0] > b[0] || a[0] == b[0] && a[1] >= b[1]
+}
+
+// goMinor returns the major and minor numbers of a Go version.
+func goMinor(v string) [2]int {
+	var n [2]int
+	for i, f := range strings.SplitN(v, ".", 3) {
+		if i == 2 {
+			break
+		}
+		// Drop any prerelease suffix, as in 1.21rc1.
+		j := strings.IndexFunc(f, func(r rune) bool { return r < '0' || r > '9' })
+		if j >= 0 {
+			f = f[:j]
+		}
+		n[i], _ = strconv.Atoi(f)
+	}
+	return n
+}
@@ -0,0 +1,291 @@
+package modgraph
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// graphCache holds the go.mod files of the module cache used by
+// TestLoad, by module path and version. Modules at go 1.17 or later
+// have pruned module graphs; the others do not.
+var graphCache = map[string]string{
+	"example.com/a@v1.0.0": "go 1.17\n\nrequire example.com/c v1.0.0\n",
+	"example.com/a@v1.1.0": "go 1.17\n\nrequire example.com/c v1.1.0\n",
+	"example.com/b@v1.0.0": "require (\n\texample.com/c v1.2.0\n\texample.com/d v1.0.0\n)\n",
+	"example.com/c@v1.0.0": "go 1.17\n\nrequire example.com/d v1.1.0\n",
+	"example.com/c@v1.1.0": "go 1.17\n",
+	"example.com/c@v1.2.0": "go 1.17\n",
+	"example.com/c@v1.3.0": "go 1.17\n\nrequire example.com/d v1.2.0\n",
+	"example.com/d@v1.0.0": "go 1.17\n",
+	"example.com/d@v1.1.0": "go 1.17\n",
+	"example.com/d@v1.2.0": "go 1.17\n",
+	"example.com/e@v1.0.0": "go 1.16\n\nrequire example.com/c v1.0.0\n",
+	"example.com/f@v1.0.0": "go 1.17\n\nrequire example.com/d v1.2.0\n",
+	"example.com/g@v1.0.0": "go 1.17\n\nrequire (\n\texample.com/main v1.0.0\n\texample.com/d v1.0.0\n)\n",
+}
+
+// newGraphCache writes the go.mod and .info files of graphCache to a
+// new module cache and returns its download cache, and the go.sum
+// lines for the go.mod files.
+func newGraphCache(t *testing.T) (cacheDir, goSum string) {
+	cacheDir = filepath.Join(t.TempDir(), "cache", "download")
+	var sum strings.Builder
+	for mv, data := range graphCache {
+		path, version, _ := strings.Cut(mv, "@")
+		data = "module " + path + "\n\n" + data
+		escPath, err := module.EscapePath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		base := filepath.Join(cacheDir, filepath.FromSlash(escPath), "@v", version)
+		if err := os.MkdirAll(filepath.Dir(base), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(base+".mod", []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		info := `{"Version":"` + version + `","Time":"2024-01-01T00:00:00Z"}`
+		if err := os.WriteFile(base+".info", []byte(info), 0666); err != nil {
+			t.Fatal(err)
+		}
+		h, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(data)), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum.WriteString(path + " " + version + "/go.mod " + h + "\n")
+	}
+	return cacheDir, sum.String()
+}
+
+func TestLoad(t *testing.T) {
+	cacheDir, goSum := newGraphCache(t)
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The build lists are checked against "go list -m all", with c
+	// served as the module proxy.
+	var gocmd string
+	if !testing.Short() {
+		gocmd, _ = exec.LookPath("go")
+	}
+	srv := httptest.NewServer(catalog.NewProxy(c))
+	defer srv.Close()
+
+	const mainMod = "module example.com/main\n\n"
+	for _, tt := range []struct {
+		name    string
+		files   map[string]string // main module files; the graph is loaded from the go.work or go.mod file
+		want    string            // build list, one "path version" per line
+		modules string            // versions whose go.mod files are read, if checked
+		err     error
+	}{
+		{
+			name:    "unpruned",
+			files:   map[string]string{"go.mod": mainMod + "require (\n\texample.com/a v1.0.0\n\texample.com/b v1.0.0\n)\n"},
+			want:    "example.com/main \nexample.com/a v1.0.0\nexample.com/b v1.0.0\nexample.com/c v1.2.0\nexample.com/d v1.1.0\n",
+			modules: "example.com/a@v1.0.0 example.com/b@v1.0.0 example.com/c@v1.0.0 example.com/c@v1.2.0 example.com/d@v1.0.0 example.com/d@v1.1.0",
+		},
+		{
+			name:    "pruned",
+			files:   map[string]string{"go.mod": mainMod + "go 1.17\n\nrequire example.com/a v1.0.0\n"},
+			want:    "example.com/main \nexample.com/a v1.0.0\nexample.com/c v1.0.0\n",
+			modules: "example.com/a@v1.0.0",
+		},
+		{
+			name:    "pruned, unpruned dependency",
+			files:   map[string]string{"go.mod": mainMod + "go 1.17\n\nrequire example.com/e v1.0.0\n"},
+			want:    "example.com/main \nexample.com/c v1.0.0\nexample.com/d v1.1.0\nexample.com/e v1.0.0\n",
+			modules: "example.com/c@v1.0.0 example.com/d@v1.1.0 example.com/e@v1.0.0",
+		},
+		{
+			name:    "pruned, mixed",
+			files:   map[string]string{"go.mod": mainMod + "go 1.21\n\nrequire (\n\texample.com/a v1.1.0\n\texample.com/b v1.0.0\n)\n"},
+			want:    "example.com/main \nexample.com/a v1.1.0\nexample.com/b v1.0.0\nexample.com/c v1.2.0\nexample.com/d v1.0.0\n",
+			modules: "example.com/a@v1.1.0 example.com/b@v1.0.0 example.com/c@v1.2.0 example.com/d@v1.0.0",
+		},
+		{
+			name:  "replace version",
+			files: map[string]string{"go.mod": mainMod + "go 1.17\n\nrequire example.com/a v1.0.0\n\nreplace example.com/a v1.0.0 => example.com/f v1.0.0\n"},
+			want:  "example.com/main \nexample.com/a v1.0.0\nexample.com/d v1.2.0\n",
+		},
+		{
+			name:  "replace all versions",
+			files: map[string]string{"go.mod": mainMod + "require example.com/c v1.0.0\n\nreplace example.com/c => example.com/c v1.3.0\n"},
+			want:  "example.com/main \nexample.com/c v1.0.0\nexample.com/d v1.2.0\n",
+		},
+		{
+			name: "replace directory",
+			files: map[string]string{
+				"go.mod":   mainMod + "go 1.17\n\nrequire example.com/b v1.0.0\n\nreplace example.com/b => ./b\n",
+				"b/go.mod": "module example.com/b\n\nrequire example.com/c v1.0.0\n",
+			},
+			want: "example.com/main \nexample.com/b v1.0.0\nexample.com/c v1.0.0\nexample.com/d v1.1.0\n",
+		},
+		{
+			name:  "exclude",
+			files: map[string]string{"go.mod": mainMod + "go 1.17\n\nrequire example.com/c v1.0.0\n\nexclude example.com/c v1.0.0\n"},
+			want:  "example.com/main \n",
+		},
+		{
+			name:  "exclude, required elsewhere",
+			files: map[string]string{"go.mod": mainMod + "go 1.17\n\nrequire (\n\texample.com/a v1.1.0\n\texample.com/c v1.0.0\n)\n\nexclude example.com/c v1.0.0\n"},
+			want:  "example.com/main \nexample.com/a v1.1.0\nexample.com/c v1.1.0\n",
+		},
+		{
+			name:    "exclude dependency",
+			files:   map[string]string{"go.mod": mainMod + "require example.com/a v1.0.0\n\nexclude example.com/d v1.1.0\n"},
+			want:    "example.com/main \nexample.com/a v1.0.0\nexample.com/c v1.0.0\n",
+			modules: "example.com/a@v1.0.0 example.com/c@v1.0.0",
+		},
+		{
+			name:  "requires main module",
+			files: map[string]string{"go.mod": mainMod + "go 1.17\n\nrequire example.com/g v1.0.0\n"},
+			want:  "example.com/main \nexample.com/d v1.0.0\nexample.com/g v1.0.0\n",
+		},
+		{
+			name: "workspace",
+			files: map[string]string{
+				"go.work":   "go 1.18\n\nuse (\n\t./m1\n\t./m2\n)\n\nreplace example.com/c v1.0.0 => example.com/c v1.3.0\n",
+				"m1/go.mod": "module example.com/m1\n\ngo 1.18\n\nrequire example.com/e v1.0.0\n",
+				"m2/go.mod": "module example.com/m2\n\ngo 1.18\n\nrequire example.com/c v1.1.0\n",
+			},
+			want: "example.com/m1 \nexample.com/m2 \nexample.com/c v1.1.0\nexample.com/d v1.2.0\nexample.com/e v1.0.0\n",
+		},
+		{
+			name:  "missing",
+			files: map[string]string{"go.mod": mainMod + "go 1.17\n\nrequire example.com/z v1.0.0\n"},
+			err:   &MissingError{module.Version{Path: "example.com/z", Version: "v1.0.0"}},
+		},
+		{
+			name:  "missing, unpruned",
+			files: map[string]string{"go.mod": mainMod + "require example.com/b v1.0.0\n\nreplace example.com/d v1.0.0 => example.com/d v1.9.0\n"},
+			err:   &MissingError{module.Version{Path: "example.com/d", Version: "v1.9.0"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "go.mod")
+			for name, data := range tt.files {
+				name = filepath.Join(dir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(name, []byte(data), 0666); err != nil {
+					t.Fatal(err)
+				}
+				if filepath.Base(name) == "go.mod" {
+					if err := os.WriteFile(filepath.Join(filepath.Dir(name), "go.sum"), []byte(goSum), 0666); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if filepath.Base(name) == "go.work" {
+					file = name
+				}
+			}
+
+			g, err := Load(c, file)
+			if tt.err != nil {
+				var missing *MissingError
+				if !errors.As(err, &missing) || *missing != *tt.err.(*MissingError) {
+					t.Fatalf("Load: error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got strings.Builder
+			for _, m := range g.BuildList() {
+				got.WriteString(m.Path + " " + m.Version + "\n")
+			}
+			if got.String() != tt.want {
+				t.Errorf("BuildList:\n%s\nwant:\n%s", got.String(), tt.want)
+			}
+			if tt.modules != "" {
+				var read []string
+				for _, m := range g.Modules() {
+					read = append(read, m.String())
+				}
+				if strings.Join(read, " ") != tt.modules {
+					t.Errorf("Modules() = %s, want %s", strings.Join(read, " "), tt.modules)
+				}
+			}
+
+			if gocmd == "" {
+				return
+			}
+			cmd := exec.Command(gocmd, "list", "-m", "-f", "{{.Path}} {{.Version}}", "all")
+			cmd.Dir = filepath.Dir(file)
+			cmd.Env = append(os.Environ(),
+				"GOPROXY="+srv.URL,
+				"GOMODCACHE="+t.TempDir(),
+				"GOSUMDB=off",
+				"GONOPROXY=",
+				"GOPRIVATE=",
+				"GOTOOLCHAIN=local",
+				"GO111MODULE=on",
+			)
+			if filepath.Base(file) == "go.work" {
+				cmd.Env = append(cmd.Env, "GOWORK="+file, "GOFLAGS=-modcacherw")
+			} else {
+				// -mod=mod lets the go command drop requirements on
+				// excluded versions from go.mod.
+				cmd.Env = append(cmd.Env, "GOWORK=off", "GOFLAGS=-modcacherw -mod=mod")
+			}
+			out, err := cmd.Output()
+			if ee, ok := err.(*exec.ExitError); ok {
+				t.Fatalf("go list -m all: %v\n%s", err, ee.Stderr)
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("go list -m all:\n%s\nwant:\n%s", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadVersion(t *testing.T) {
+	cacheDir, _ := newGraphCache(t)
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		m    module.Version
+		want string
+	}{
+		{module.Version{Path: "example.com/a", Version: "v1.0.0"}, "example.com/a v1.0.0\nexample.com/c v1.0.0\nexample.com/d v1.1.0\n"},
+		{module.Version{Path: "example.com/b", Version: "v1.0.0"}, "example.com/b v1.0.0\nexample.com/c v1.2.0\nexample.com/d v1.0.0\n"},
+		{module.Version{Path: "example.com/e", Version: "v1.0.0"}, "example.com/e v1.0.0\nexample.com/c v1.0.0\nexample.com/d v1.1.0\n"},
+	} {
+		g, err := LoadVersion(c, tt.m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got strings.Builder
+		for _, m := range g.BuildList() {
+			got.WriteString(m.Path + " " + m.Version + "\n")
+		}
+		if got.String() != tt.want {
+			t.Errorf("LoadVersion(%v).BuildList():\n%s\nwant:\n%s", tt.m, got.String(), tt.want)
+		}
+		if v := g.Selected(tt.m.Path); v != tt.m.Version {
+			t.Errorf("LoadVersion(%v).Selected(%s) = %s", tt.m, tt.m.Path, v)
+		}
+	}
+}
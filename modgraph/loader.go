@@ -0,0 +1,240 @@
+package modgraph
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	_ "github.com/julieqiu/modcache/cache" // sets load.ImportDirFunc
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/load"
+	"golang.org/x/mod/module"
+)
+
+// A Loader finds the modules providing packages and reads the packages
+// from their source: the directories of main modules loaded by Load and
+// of directory replacements, and otherwise the module cache, where the
+// selected version, or its replacement, is read from its extracted
+// directory or, if it has not been extracted, from its zip file.
+type Loader struct {
+	c    *catalog.Catalog
+	g    *Graph         // nil if the requirement graph is incomplete
+	main module.Version // the main module, if g is nil
+	zips map[module.Version]*catalog.ZipDir
+}
+
+// NewLoader returns a Loader for the packages of the modules in g.
+//
+// If g is nil, as when some of the go.mod files needed to compute the
+// requirement graph of a module version are not cached, the packages
+// of the cached module version main are read from it, and those of
+// other modules from the latest cached version providing them (see
+// catalog.Catalog.Resolve).
+//
+// The zip files opened by the Loader stay open until Close is called.
+func NewLoader(c *catalog.Catalog, g *Graph, main module.Version) *Loader {
+	return &Loader{c: c, g: g, main: main, zips: make(map[module.Version]*catalog.ZipDir)}
+}
+
+// Close closes the zip files opened by l.
+func (l *Loader) Close() {
+	for _, z := range l.zips {
+		if z != nil {
+			z.Close()
+		}
+	}
+}
+
+// A Location is where the source of a package, or of a module, is.
+type Location struct {
+	ImportPath string
+	Module     module.Version   // module in the build list providing the package
+	Source     module.Version   // Module or its replacement, which has no version if it is a directory
+	Version    *catalog.Version // cached version of Source, or nil if Source is not read from the module cache
+	Root       string           // root directory of Source
+	Dir        string           // package directory
+	Zip        *catalog.ZipDir  // if non-nil, Root has not been extracted and is read from Zip
+
+	cacheDir string
+}
+
+// Find returns the location of the package importPath. As in the go
+// command, the package must be provided by exactly one of the modules
+// whose paths are prefixes of importPath, among the main modules and
+// the versions selected by the requirement graph.
+func (l *Loader) Find(importPath string) (*Location, error) {
+	if l.g == nil {
+		return l.findLatest(importPath)
+	}
+	var found *Location
+	var srcErr error
+	for p := importPath; p != "." && p != "/"; p = path.Dir(p) {
+		version := l.g.Selected(p)
+		if version == "none" {
+			continue
+		}
+		loc, err := l.locate(module.Version{Path: p, Version: version}, importPath)
+		if err != nil {
+			if srcErr == nil {
+				srcErr = err
+			}
+			continue
+		}
+		if !loc.exists() {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("ambiguous import: found package %s in multiple modules:\n\t%s\n\t%s", importPath, found.Module, loc.Module)
+		}
+		found = loc
+	}
+	if found != nil {
+		return found, nil
+	}
+	if srcErr != nil {
+		return nil, srcErr
+	}
+	return nil, fmt.Errorf("no required module provides package %s", importPath)
+}
+
+// findLatest returns the location of the package importPath in the
+// main module or, if it is not in it, in the latest cached version
+// providing it.
+func (l *Loader) findLatest(importPath string) (*Location, error) {
+	m := l.main
+	if m.Path == "" || importPath != m.Path && !strings.HasPrefix(importPath, m.Path+"/") {
+		pkg, err := l.c.Resolve(importPath, "")
+		if err != nil {
+			return nil, err
+		}
+		m = module.Version{Path: pkg.Version.Path, Version: pkg.Version.Version}
+	}
+	return l.locate(m, importPath)
+}
+
+// Locate returns the location of the root of the module m.
+func (l *Loader) Locate(m module.Version) (*Location, error) {
+	return l.locate(m, m.Path)
+}
+
+// locate returns the location of the package importPath in the module
+// m. The package directory need not exist.
+func (l *Loader) locate(m module.Version, importPath string) (*Location, error) {
+	loc := &Location{ImportPath: importPath, Module: m, Source: m, cacheDir: l.c.Dir()}
+	if err := l.open(loc); err != nil {
+		return nil, err
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(importPath, m.Path), "/")
+	loc.Dir = filepath.Join(loc.Root, filepath.FromSlash(rel))
+	return loc, nil
+}
+
+// open sets the Source, Version, Root and Zip fields of loc for its
+// module.
+func (l *Loader) open(loc *Location) error {
+	if l.g != nil {
+		if dir := l.g.MainDir(loc.Module.Path); dir != "" {
+			loc.Root = dir
+			return nil
+		}
+		loc.Source = l.g.Replacement(loc.Module)
+	}
+	rm := loc.Source
+	if rm.Version == "" {
+		loc.Root = rm.Path
+		return nil
+	}
+	cm, err := l.c.Module(rm.Path)
+	if err != nil {
+		return err
+	}
+	if loc.Version = cm.Lookup(rm.Version); loc.Version == nil {
+		return fmt.Errorf("%s: not in module cache", rm)
+	}
+	if loc.Root, err = l.c.SourceDir(rm.Path, rm.Version); err != nil {
+		return err
+	}
+	if _, err := os.Stat(loc.Root); err == nil {
+		return nil
+	}
+	z, ok := l.zips[rm]
+	if !ok {
+		if loc.Version.Zip != "" {
+			z, _ = l.c.OpenZip(loc.Version)
+		}
+		l.zips[rm] = z
+	}
+	if z == nil {
+		return fmt.Errorf("%s: source not in module cache", rm)
+	}
+	loc.Zip = z
+	return nil
+}
+
+// exists reports whether the package directory of loc exists.
+func (loc *Location) exists() bool {
+	if loc.Zip != nil {
+		return loc.Zip.IsDir(loc.Dir)
+	}
+	fi, err := os.Stat(loc.Dir)
+	return err == nil && fi.IsDir()
+}
+
+// Context returns ctx or, if loc is read from a zip file, a copy of
+// ctx reading the zip file (see catalog.ZipDir.Context).
+func (loc *Location) Context(ctx *build.Context) *build.Context {
+	if loc.Zip != nil {
+		return loc.Zip.Context(ctx)
+	}
+	return ctx
+}
+
+// Import reads the package at loc, selecting its files using ctx, and
+// returns it with its import path set. Packages in the module cache are
+// read using load.LegacyCachedImport, so that their metadata is read
+// from the package cache, or assembled from the cached metadata of
+// their files, if present; others are read afresh.
+func (loc *Location) Import(ctx *build.Context) (*build.Package, error) {
+	var p *build.Package
+	var err error
+	if loc.Version == nil {
+		p, err = ctx.ImportDir(loc.Dir, 0)
+	} else {
+		p, err = load.LegacyCachedImport(loc.Context(ctx), ".", loc.Dir, loc.Source.Path, loc.cacheDir, 0)
+	}
+	if p != nil {
+		p.ImportPath = loc.ImportPath
+	}
+	return p, err
+}
+
+// IsStandard reports whether pkg is in the standard library,
+// which is the case for paths whose first element has no dot.
+func IsStandard(pkg string) bool {
+	elem := pkg
+	if i := strings.Index(pkg, "/"); i >= 0 {
+		elem = pkg[:i]
+	}
+	return !strings.Contains(elem, ".")
+}
+
+// VendorPath returns the import path of the package imported as
+// importPath by the package in the directory fromDir, or named on the
+// command line if fromDir is "". As in the go command, a package outside
+// the standard library imported by one in GOROOT is vendored in GOROOT
+// and named vendor/importPath.
+func VendorPath(ctx *build.Context, importPath, fromDir string) string {
+	if fromDir != "" && InGoroot(ctx, fromDir) && !IsStandard(importPath) {
+		return "vendor/" + importPath
+	}
+	return importPath
+}
+
+// InGoroot reports whether file is in the source tree of ctx.GOROOT.
+func InGoroot(ctx *build.Context, file string) bool {
+	rel, err := filepath.Rel(filepath.Join(ctx.GOROOT, "src"), file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,107 @@
+package modgraph
+
+import (
+	"go/build"
+	"path"
+	"path/filepath"
+
+	"github.com/julieqiu/modcache/catalog"
+	"golang.org/x/mod/module"
+)
+
+// A Package is a package in the import graph of the main modules.
+type Package struct {
+	ImportPath string
+	Module     module.Version // the main module or selected version providing it
+	Imports    []string       // imports of its non-test files
+}
+
+// Packages returns the packages of the main modules followed by those
+// they import, directly or indirectly, in breadth-first order. Standard
+// library packages are listed among the imports, but not returned.
+//
+// The packages of main modules loaded by Load are read from their
+// directories. Those of other modules are those of the selected
+// versions, read from the module cache, extracted or from zip files,
+// using a Loader, so that their metadata is read from the package cache
+// if present. Packages whose source is not available are left out.
+func (g *Graph) Packages(c *catalog.Catalog, ctx *build.Context) ([]*Package, error) {
+	l := NewLoader(c, g, module.Version{})
+	defer l.Close()
+	mainPkgs, err := l.mainPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var list []*Package
+	seen := make(map[string]bool)
+	for _, p := range mainPkgs {
+		seen[p.ImportPath] = true
+		list = append(list, &Package{ImportPath: p.ImportPath, Module: l.module(p.ImportPath), Imports: p.Imports})
+	}
+	for i := 0; i < len(list); i++ {
+		for _, imp := range list[i].Imports {
+			if seen[imp] || IsStandard(imp) {
+				continue
+			}
+			seen[imp] = true
+			if p := l.load(ctx, imp); p != nil {
+				list = append(list, &Package{ImportPath: imp, Module: l.module(imp), Imports: p.Imports})
+			}
+		}
+	}
+	return list, nil
+}
+
+// mainPackages returns the packages of the main modules, with their
+// import paths set.
+func (l *Loader) mainPackages(ctx *build.Context) ([]*build.Package, error) {
+	var pkgs []*build.Package
+	for _, m := range l.g.main {
+		loc, err := l.Locate(m)
+		if err != nil {
+			continue
+		}
+		dirs, err := catalog.PackageDirs(loc.Root, loc.Zip)
+		if err != nil {
+			return nil, err
+		}
+		for _, pkgDir := range dirs {
+			rel, err := filepath.Rel(loc.Root, pkgDir)
+			if err != nil {
+				return nil, err
+			}
+			if p := l.load(ctx, path.Join(m.Path, filepath.ToSlash(rel))); p != nil {
+				pkgs = append(pkgs, p)
+			}
+		}
+	}
+	return pkgs, nil
+}
+
+// module returns the module in the build list providing the package
+// pkg, the one with the longest path that is a prefix of pkg.
+func (l *Loader) module(pkg string) module.Version {
+	for p := pkg; p != "." && p != "/"; p = path.Dir(p) {
+		if l.g.isMain(p) {
+			return module.Version{Path: p, Version: l.g.Selected(p)}
+		}
+		if v := l.g.Selected(p); v != "none" {
+			return module.Version{Path: p, Version: v}
+		}
+	}
+	return module.Version{}
+}
+
+// load reads the package pkg, or returns nil if its source is not
+// available.
+func (l *Loader) load(ctx *build.Context, pkg string) *build.Package {
+	loc, err := l.Find(pkg)
+	if err != nil {
+		return nil
+	}
+	p, err := loc.Import(ctx)
+	if err != nil {
+		return nil
+	}
+	return p
+}
@@ -0,0 +1,101 @@
+package modgraph
+
+import (
+	"go/build"
+	"strings"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/load"
+	"golang.org/x/mod/module"
+)
+
+// Why returns the shortest chain of requirements from a main module to
+// a version of the module target, starting with the main module and
+// ending with the target, or nil if no module in the graph requires it.
+// If target has the form path@version, the chain ends at that version.
+func (g *Graph) Why(target string) []module.Version {
+	targetPath, targetVersion := target, ""
+	if i := strings.Index(target, "@"); i >= 0 {
+		targetPath, targetVersion = target[:i], target[i+1:]
+	}
+	parent := make(map[module.Version]module.Version)
+	queue := append([]module.Version(nil), g.main...)
+	seen := make(map[module.Version]bool)
+	for _, m := range queue {
+		seen[m] = true
+	}
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+		if m.Path == targetPath && (targetVersion == "" || m.Version == targetVersion) {
+			chain := []module.Version{m}
+			for p, ok := parent[m]; ok; p, ok = parent[p] {
+				chain = append(chain, p)
+			}
+			for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+				chain[i], chain[j] = chain[j], chain[i]
+			}
+			return chain
+		}
+		for _, r := range g.reqs[m] {
+			if !seen[r] {
+				seen[r] = true
+				parent[r] = m
+				queue = append(queue, r)
+			}
+		}
+	}
+	return nil
+}
+
+// ImportChain returns the shortest chain of imports from a package of a
+// main module to a package of the module target, starting with the
+// main module's package and ending with the target's, or nil if no
+// package of a main module imports one of target's, even indirectly.
+//
+// The imports of the tests of the main modules' packages are included,
+// but not those of other packages. Packages are read as described in
+// Packages.
+func (g *Graph) ImportChain(c *catalog.Catalog, ctx *build.Context, target string) ([]string, error) {
+	l := NewLoader(c, g, module.Version{})
+	defer l.Close()
+	pkgs, err := l.mainPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var queue []string
+	parent := make(map[string]string)
+	imports := make(map[string][]string)
+	for _, p := range pkgs {
+		queue = append(queue, p.ImportPath)
+		parent[p.ImportPath] = ""
+		imports[p.ImportPath] = load.StringList(p.Imports, p.TestImports, p.XTestImports)
+	}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		if pkg == target || strings.HasPrefix(pkg, target+"/") && l.module(pkg).Path == target {
+			chain := []string{pkg}
+			for p := parent[pkg]; p != ""; p = parent[p] {
+				chain = append(chain, p)
+			}
+			for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+				chain[i], chain[j] = chain[j], chain[i]
+			}
+			return chain, nil
+		}
+		list, ok := imports[pkg]
+		if !ok {
+			if p := l.load(ctx, pkg); p != nil {
+				list = p.Imports
+			}
+		}
+		for _, imp := range list {
+			if _, ok := parent[imp]; !ok && !IsStandard(imp) {
+				parent[imp] = pkg
+				queue = append(queue, imp)
+			}
+		}
+	}
+	return nil, nil
+}
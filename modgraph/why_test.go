@@ -0,0 +1,97 @@
+package modgraph
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/internal/modtest"
+)
+
+func TestWhy(t *testing.T) {
+	cacheDir, _ := newGraphCache(t)
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "go.mod")
+	modtest.WriteFile(t, file, "module example.com/main\n\nrequire (\n\texample.com/a v1.0.0\n\texample.com/b v1.0.0\n)\n")
+	g, err := Load(c, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		target string
+		want   string // chain, "" if nil
+	}{
+		// The shortest chain, to any version.
+		{"example.com/d", "example.com/main example.com/b@v1.0.0 example.com/d@v1.0.0"},
+		{"example.com/d@v1.1.0", "example.com/main example.com/a@v1.0.0 example.com/c@v1.0.0 example.com/d@v1.1.0"},
+		{"example.com/c@v1.2.0", "example.com/main example.com/b@v1.0.0 example.com/c@v1.2.0"},
+		{"example.com/main", "example.com/main"},
+		{"example.com/d@v1.2.0", ""},
+		{"example.com/f", ""},
+	} {
+		var got []string
+		for _, m := range g.Why(tt.target) {
+			got = append(got, m.String())
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("Why(%s) = %v, want [%s]", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestImportChain(t *testing.T) {
+	modCache := t.TempDir()
+	var cacheDir string
+	for _, m := range []struct {
+		path    string
+		files   map[string]string
+		extract bool
+	}{
+		{"example.com/x", map[string]string{"p/p.go": "package p\n\nimport _ \"example.com/z/q\"\n"}, true},
+		{"example.com/y", map[string]string{"y.go": "package y\n"}, false},
+		{"example.com/z", map[string]string{"q/q.go": "package q\n\nimport _ \"fmt\"\n"}, false},
+		{"example.com/unused", map[string]string{"u.go": "package unused\n"}, true},
+	} {
+		m.files["go.mod"] = "module " + m.path + "\n\ngo 1.17\n"
+		cacheDir, _ = modtest.WriteModule(t, modCache, m.path, "v1.0.0", m.files, m.extract)
+	}
+	c, err := catalog.Open(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	modtest.WriteFile(t, filepath.Join(dir, "go.mod"), "module example.com/main\n\ngo 1.17\n\nrequire (\n\texample.com/unused v1.0.0\n\texample.com/x v1.0.0\n\texample.com/y v1.0.0\n\texample.com/z v1.0.0\n)\n")
+	modtest.WriteFile(t, filepath.Join(dir, "main.go"), "package main\n\nimport _ \"example.com/x/p\"\n\nfunc main() {}\n")
+	// Only the test of the main package imports example.com/y.
+	modtest.WriteFile(t, filepath.Join(dir, "main_test.go"), "package main\n\nimport _ \"example.com/y\"\n")
+	g, err := Load(c, filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := build.Default
+	for _, tt := range []struct {
+		target string
+		want   string // chain, "" if nil
+	}{
+		{"example.com/z", "example.com/main example.com/x/p example.com/z/q"},
+		{"example.com/x", "example.com/main example.com/x/p"},
+		{"example.com/x/p", "example.com/main example.com/x/p"},
+		{"example.com/y", "example.com/main example.com/y"},
+		{"example.com/unused", ""},
+		{"fmt", ""},
+	} {
+		chain, err := g.ImportChain(c, &ctx, tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(chain, " "); got != tt.want {
+			t.Errorf("ImportChain(%s) = %v, want [%s]", tt.target, chain, tt.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package rdeps
+
+import (
+	"go/build"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/julieqiu/modcache/cache"
+	"github.com/julieqiu/modcache/catalog"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// A Candidate is a package that may be the one meant by an unresolved
+// selector name.Symbol, for which goimports would add an import.
+type Candidate struct {
+	ImportPath string         // import path of the package
+	Module     string         // path of the module containing the package
+	Version    string         // version of the module
+	Pos        token.Position // position of the declaration
+	InGoMod    bool           // whether the caller's go.mod requires Version of Module
+	Importers  int            // number of other modules importing the package
+}
+
+// CandidateOptions controls the search and ranking done by Candidates.
+type CandidateOptions struct {
+	// GoMod is the caller's go.mod file, or "" if none. The versions it
+	// requires are searched instead of the latest cached ones, and
+	// ranked first.
+	GoMod string
+}
+
+// Candidates returns the packages in the module cache whose package name
+// is name and which export symbol, best first. One version of each
+// module is searched: the version required by opts.GoMod, if its source
+// is cached, or the latest version whose source is, extracted or as a
+// zip file. Candidates are ranked by whether their module is required by
+// opts.GoMod, then by popularity, the number of other modules in the
+// index importing them, then by the length of their import paths.
+//
+// The packages named name are found in ix, so versions added to the
+// cache since ix was last updated are not searched. Their exports are
+// read from the per-file metadata cache (see cache.ImportDir), from all
+// their non-test files, whatever their build constraints. The options
+// may be nil.
+func (ix *Index) Candidates(c *catalog.Catalog, name, symbol string, opts *CandidateOptions) ([]*Candidate, error) {
+	if opts == nil {
+		opts = new(CandidateOptions)
+	}
+	required := make(map[string]string)
+	if opts.GoMod != "" {
+		data, err := os.ReadFile(opts.GoMod)
+		if err != nil {
+			return nil, err
+		}
+		f, err := modfile.ParseLax(opts.GoMod, data, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range f.Require {
+			required[r.Mod.Path] = r.Mod.Version
+		}
+	}
+
+	// The modules with a package named name in some version.
+	named := make(map[string]bool)
+	for mv, e := range ix.versions {
+		for _, n := range e.names {
+			if n == name {
+				named[mv.Path] = true
+				break
+			}
+		}
+	}
+	var paths []string
+	for p := range named {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var list []*Candidate
+	for _, modPath := range paths {
+		mv := module.Version{Path: modPath, Version: required[modPath]}
+		if e, ok := ix.versions[mv]; !ok || !e.source {
+			v, err := c.LatestSource(modPath)
+			if err != nil {
+				continue
+			}
+			mv.Version = v.Version
+		}
+		e, ok := ix.versions[mv]
+		if !ok || !e.source {
+			continue
+		}
+		found, err := candidatesIn(c, mv, e, name, symbol)
+		if err != nil {
+			return nil, err
+		}
+		for _, cand := range found {
+			cand.InGoMod = required[modPath] == mv.Version
+		}
+		list = append(list, found...)
+	}
+	ix.countImporters(list)
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.InGoMod != b.InGoMod {
+			return a.InGoMod
+		}
+		if a.Importers != b.Importers {
+			return a.Importers > b.Importers
+		}
+		if len(a.ImportPath) != len(b.ImportPath) {
+			return len(a.ImportPath) < len(b.ImportPath)
+		}
+		return a.ImportPath < b.ImportPath
+	})
+	return list, nil
+}
+
+// candidatesIn returns the packages of the module version mv, whose
+// edges are e, named name that export symbol.
+func candidatesIn(c *catalog.Catalog, mv module.Version, e *versionEdges, name, symbol string) ([]*Candidate, error) {
+	var pkgs []string
+	for pkg, n := range e.names {
+		if n == name {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	sort.Strings(pkgs)
+	srcDir, err := c.SourceDir(mv.Path, mv.Version)
+	if err != nil {
+		return nil, nil
+	}
+	ctx := &build.Default
+	if _, err := os.Stat(srcDir); err != nil {
+		m, err := c.Module(mv.Path)
+		if err != nil {
+			return nil, err
+		}
+		z, err := c.OpenZip(m.Lookup(mv.Version))
+		if err != nil {
+			return nil, err
+		}
+		defer z.Close()
+		ctx = z.Context(ctx)
+	}
+	var list []*Candidate
+	for _, pkg := range pkgs {
+		rel := strings.TrimPrefix(strings.TrimPrefix(pkg, mv.Path), "/")
+		files, err := cache.Files(ctx, filepath.Join(srcDir, filepath.FromSlash(rel)), mv.Path, c.Dir())
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if strings.HasSuffix(f.Filename, "_test.go") || f.Name != name {
+				continue
+			}
+			if pos, ok := f.ExportPos[symbol]; ok {
+				list = append(list, &Candidate{
+					ImportPath: pkg,
+					Module:     mv.Path,
+					Version:    mv.Version,
+					Pos:        pos,
+				})
+				break
+			}
+		}
+	}
+	return list, nil
+}
+
+// countImporters sets the Importers field of each candidate to the
+// number of modules in ix other than its own that import it.
+func (ix *Index) countImporters(list []*Candidate) {
+	byPath := make(map[string][]*Candidate)
+	for _, cand := range list {
+		byPath[cand.ImportPath] = append(byPath[cand.ImportPath], cand)
+	}
+	importers := make(map[*Candidate]map[string]bool)
+	for mv, e := range ix.versions {
+		for _, imports := range e.imports {
+			for _, imp := range imports {
+				for _, cand := range byPath[imp] {
+					if mv.Path == cand.Module {
+						continue
+					}
+					if importers[cand] == nil {
+						importers[cand] = make(map[string]bool)
+					}
+					importers[cand][mv.Path] = true
+				}
+			}
+		}
+	}
+	for cand, mods := range importers {
+		cand.Importers = len(mods)
+	}
+}
@@ -0,0 +1,366 @@
+// Package rdeps maintains a reverse-dependency index of the module
+// cache, answering which cached packages import a given package and
+// which cached module versions require a given module, so that the
+// users of an API can be found before changing it.
+//
+// The index records two kinds of edges for each cached module version:
+// the requirements listed in its .mod file, and, for versions whose
+// source is in the cache, extracted or as a zip file, the imports of
+// each of its packages, as listed in build.Package.Imports, with the
+// package's name, so that packages can be found by name (see
+// Candidates). Imports by test files are not recorded. Packages are read
+// with load.LegacyCachedImport, so that their cached metadata is used
+// when present, and recorded in the package cache otherwise.
+//
+// The index is a text file listing, for each module version, a line
+//
+//	v path version ziphash mod|src
+//
+// followed by one line per requirement and per package:
+//
+//	r path version
+//	p importpath name import...
+//
+// The ziphash is the h1: hash from the version's .ziphash file, or "-"
+// if there is none, and src marks versions whose source was read. A
+// version whose hash changes, or whose source has been added to the
+// cache since, is indexed again. An index file in an older format is
+// replaced by Update.
+package rdeps
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/load"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+const fileHeader = "mcrdeps 2"
+
+// An Index is a reverse-dependency index of the module cache.
+type Index struct {
+	file     string
+	versions map[module.Version]*versionEdges
+}
+
+// versionEdges holds the dependency edges of a module version.
+type versionEdges struct {
+	zipHash  string              // "-" if none
+	source   bool                // whether the source was read
+	requires []module.Version    // requirements from the .mod file
+	imports  map[string][]string // imports, by importing package
+	names    map[string]string   // package names, by import path
+}
+
+func newVersionEdges() *versionEdges {
+	return &versionEdges{imports: make(map[string][]string), names: make(map[string]string)}
+}
+
+// An Importer is a package that imports another.
+type Importer struct {
+	ImportPath string         // the importing package
+	Module     module.Version // the module version containing it
+	Imports    string         // the imported package
+}
+
+// A Requirer is a module version that requires another module.
+type Requirer struct {
+	Module  module.Version // the requiring module version
+	Version string         // the version it requires
+}
+
+// Open reads the index stored in file. If file does not exist, the
+// index is empty until Update is called.
+func Open(file string) (*Index, error) {
+	ix := &Index{file: file, versions: make(map[module.Version]*versionEdges)}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ix, nil
+		}
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(fileHeader+"\n")) && bytes.HasPrefix(data, []byte("mcrdeps ")) {
+		// An older format, rebuilt by Update.
+		return ix, nil
+	}
+	if err := ix.parse(data); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return ix, nil
+}
+
+// parse reads the edges in data into ix.
+func (ix *Index) parse(data []byte) error {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Buffer(nil, 1<<20)
+	if !s.Scan() || s.Text() != fileHeader {
+		return fmt.Errorf("unsupported index format")
+	}
+	var cur *versionEdges
+	for lineno := 2; s.Scan(); lineno++ {
+		f := strings.Fields(s.Text())
+		if len(f) == 0 {
+			continue
+		}
+		switch {
+		case f[0] == "v" && len(f) == 5 && (f[4] == "mod" || f[4] == "src"):
+			cur = newVersionEdges()
+			cur.zipHash, cur.source = f[3], f[4] == "src"
+			ix.versions[module.Version{Path: f[1], Version: f[2]}] = cur
+		case f[0] == "r" && len(f) == 3 && cur != nil:
+			cur.requires = append(cur.requires, module.Version{Path: f[1], Version: f[2]})
+		case f[0] == "p" && len(f) >= 3 && cur != nil:
+			cur.names[f[1]] = f[2]
+			cur.imports[f[1]] = f[3:]
+		default:
+			return fmt.Errorf("line %d: malformed entry", lineno)
+		}
+	}
+	return s.Err()
+}
+
+// write writes the index to its file, replacing the old one atomically.
+func (ix *Index) write() error {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, fileHeader)
+	for _, m := range sortedVersions(ix.versions) {
+		e := ix.versions[m]
+		kind := "mod"
+		if e.source {
+			kind = "src"
+		}
+		fmt.Fprintf(&buf, "v %s %s %s %s\n", m.Path, m.Version, e.zipHash, kind)
+		for _, r := range e.requires {
+			fmt.Fprintf(&buf, "r %s %s\n", r.Path, r.Version)
+		}
+		var pkgs []string
+		for pkg := range e.imports {
+			pkgs = append(pkgs, pkg)
+		}
+		sort.Strings(pkgs)
+		for _, pkg := range pkgs {
+			fmt.Fprintf(&buf, "p %s\n", strings.Join(append([]string{pkg, e.names[pkg]}, e.imports[pkg]...), " "))
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(ix.file), 0777); err != nil {
+		return err
+	}
+	if err := os.WriteFile(ix.file+".tmp", buf.Bytes(), 0666); err != nil {
+		return err
+	}
+	return os.Rename(ix.file+".tmp", ix.file)
+}
+
+// UpdateStats reports the changes made by Update.
+type UpdateStats struct {
+	Added   int // module versions indexed
+	Removed int // module versions dropped
+}
+
+// Update brings the index up to date with the module versions in the
+// module cache described by c. It indexes versions that are new, whose
+// .ziphash has changed, or whose source has been added to the cache
+// since they were indexed, and drops those no longer in the cache.
+func (ix *Index) Update(c *catalog.Catalog) (UpdateStats, error) {
+	var stats UpdateStats
+	mods, err := c.Modules()
+	if err != nil {
+		return stats, err
+	}
+	present := make(map[module.Version]bool)
+	for _, m := range mods {
+		for _, v := range m.Versions {
+			mv := module.Version{Path: v.Path, Version: v.Version}
+			srcDir, zipHash := source(c, v)
+			if v.GoMod == "" && srcDir == "" {
+				continue
+			}
+			present[mv] = true
+			if e, ok := ix.versions[mv]; ok && e.zipHash == zipHash && e.source == (srcDir != "") {
+				continue
+			}
+			e, err := readVersion(c, v, srcDir)
+			if err != nil {
+				return stats, err
+			}
+			e.zipHash = zipHash
+			e.source = srcDir != ""
+			ix.versions[mv] = e
+			stats.Added++
+		}
+	}
+	for mv := range ix.versions {
+		if !present[mv] {
+			delete(ix.versions, mv)
+			stats.Removed++
+		}
+	}
+	if stats.Added == 0 && stats.Removed == 0 {
+		if _, err := os.Stat(ix.file); err == nil {
+			return stats, nil
+		}
+	}
+	return stats, ix.write()
+}
+
+// source returns the directory holding the source of v, extracted or
+// as a zip file, or "" if the source is not in the cache, and the hash
+// from v's .ziphash file, or "-".
+func source(c *catalog.Catalog, v *catalog.Version) (srcDir, zipHash string) {
+	zipHash, err := v.ReadZipHash()
+	if err != nil {
+		zipHash = "-"
+	}
+	srcDir, err = c.SourceDir(v.Path, v.Version)
+	if err != nil {
+		return "", zipHash
+	}
+	if _, err := os.Stat(srcDir); err != nil && v.Zip == "" {
+		return "", zipHash
+	}
+	return srcDir, zipHash
+}
+
+// readVersion returns the edges of v, whose source, if srcDir is not
+// empty, is in srcDir or, if not extracted, in its zip file.
+func readVersion(c *catalog.Catalog, v *catalog.Version, srcDir string) (*versionEdges, error) {
+	e := newVersionEdges()
+	if v.GoMod != "" {
+		data, err := v.ReadGoMod()
+		if err != nil {
+			return nil, err
+		}
+		f, err := modfile.ParseLax(v.GoMod, data, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range f.Require {
+			e.requires = append(e.requires, r.Mod)
+		}
+	}
+	if srcDir == "" {
+		return e, nil
+	}
+	ctx := &build.Default
+	var z *catalog.ZipDir
+	if _, err := os.Stat(srcDir); err != nil {
+		if z, err = c.OpenZip(v); err != nil {
+			return nil, err
+		}
+		defer z.Close()
+		ctx = z.Context(ctx)
+	}
+	dirs, err := catalog.PackageDirs(srcDir, z)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		p, err := load.LegacyCachedImport(ctx, ".", dir, v.Path, c.Dir(), 0)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(srcDir, dir)
+		if err != nil {
+			return nil, err
+		}
+		var imports []string
+		for _, imp := range p.Imports {
+			if !strings.ContainsAny(imp, " \t") {
+				imports = append(imports, imp)
+			}
+		}
+		importPath := path.Join(v.Path, filepath.ToSlash(rel))
+		e.imports[importPath] = imports
+		e.names[importPath] = p.Name
+	}
+	return e, nil
+}
+
+// Importers returns the indexed packages that import pkg, sorted by
+// import path and module version. If pkg ends in "/...", the packages
+// importing pkg or any package below it are returned.
+func (ix *Index) Importers(pkg string) []Importer {
+	prefix := strings.TrimSuffix(pkg, "/...")
+	match := func(imp string) bool {
+		return imp == prefix || prefix != pkg && strings.HasPrefix(imp, prefix+"/")
+	}
+	var list []Importer
+	for _, mv := range sortedVersions(ix.versions) {
+		for importer, imports := range ix.versions[mv].imports {
+			for _, imp := range imports {
+				if match(imp) {
+					list = append(list, Importer{ImportPath: importer, Module: mv, Imports: imp})
+				}
+			}
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].ImportPath != list[j].ImportPath {
+			return list[i].ImportPath < list[j].ImportPath
+		}
+		return list[i].Imports < list[j].Imports
+	})
+	return list
+}
+
+// Requirers returns the indexed module versions whose .mod files
+// require a version of the module modPath, sorted by path and version.
+func (ix *Index) Requirers(modPath string) []Requirer {
+	var list []Requirer
+	for _, mv := range sortedVersions(ix.versions) {
+		for _, r := range ix.versions[mv].requires {
+			if r.Path == modPath {
+				list = append(list, Requirer{Module: mv, Version: r.Version})
+			}
+		}
+	}
+	return list
+}
+
+// Imports returns the recorded imports of the package importPath in
+// the module version mv, and whether the package is in the index.
+func (ix *Index) Imports(mv module.Version, importPath string) ([]string, bool) {
+	e, ok := ix.versions[mv]
+	if !ok {
+		return nil, false
+	}
+	imports, ok := e.imports[importPath]
+	return imports, ok
+}
+
+// Requires returns the recorded requirements of the module version mv,
+// and whether it is in the index.
+func (ix *Index) Requires(mv module.Version) ([]module.Version, bool) {
+	e, ok := ix.versions[mv]
+	if !ok {
+		return nil, false
+	}
+	return e.requires, true
+}
+
+// sortedVersions returns the keys of m sorted by path and version.
+func sortedVersions(m map[module.Version]*versionEdges) []module.Version {
+	list := make([]module.Version, 0, len(m))
+	for mv := range m {
+		list = append(list, mv)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+		return semver.Compare(list[i].Version, list[j].Version) < 0
+	})
+	return list
+}
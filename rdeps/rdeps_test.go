@@ -0,0 +1,235 @@
+package rdeps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julieqiu/modcache/catalog"
+	"github.com/julieqiu/modcache/internal/modtest"
+	"golang.org/x/mod/module"
+)
+
+// indexFile is an index in the current format, as written by write.
+const indexFile = fileHeader + `
+v example.com/a v1.0.0 h1:aaa= src
+r example.com/b v1.0.0
+p example.com/a a example.com/b/pkg fmt
+p example.com/a/cmd main example.com/a example.com/b/pkg/sub
+v example.com/b v1.0.0 - mod
+v example.com/c v1.2.0 h1:ccc= mod
+r example.com/b v1.1.0
+v example.com/c v1.10.0 h1:ccc= src
+r example.com/b v1.1.0
+r example.com/d v0.1.0
+p example.com/c c example.com/bb
+`
+
+func TestFormat(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "rdeps")
+	modtest.WriteFile(t, file, indexFile)
+	ix, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := module.Version{Path: "example.com/a", Version: "v1.0.0"}
+	if imports, ok := ix.Imports(a, "example.com/a/cmd"); !ok || strings.Join(imports, " ") != "example.com/a example.com/b/pkg/sub" {
+		t.Errorf("Imports(%s, example.com/a/cmd) = %v, %v", a, imports, ok)
+	}
+	if reqs, ok := ix.Requires(module.Version{Path: "example.com/b", Version: "v1.0.0"}); !ok || len(reqs) != 0 {
+		t.Errorf("Requires(example.com/b@v1.0.0) = %v, %v, want none", reqs, ok)
+	}
+	if _, ok := ix.Requires(module.Version{Path: "example.com/b", Version: "v1.1.0"}); ok {
+		t.Errorf("Requires(example.com/b@v1.1.0) found a version not in the index")
+	}
+	var got []string
+	for _, r := range ix.Requirers("example.com/b") {
+		got = append(got, r.Module.String()+" "+r.Version)
+	}
+	// Sorted by semantic version.
+	want := "example.com/a@v1.0.0 v1.0.0, example.com/c@v1.2.0 v1.1.0, example.com/c@v1.10.0 v1.1.0"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("Requirers(example.com/b) = %s, want %s", strings.Join(got, ", "), want)
+	}
+
+	// Written back, the index is unchanged.
+	ix.file = filepath.Join(dir, "sub", "rdeps")
+	if err := ix.write(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(ix.file); err != nil || string(data) != indexFile {
+		t.Errorf("written index:\n%s\nwant:\n%s", data, indexFile)
+	}
+
+	for _, tt := range []struct {
+		name, data string
+		empty      bool // read as an empty index rather than failing
+	}{
+		{"older format", "mcrdeps 1\nexample.com/a v1.0.0 example.com/b\n", true},
+		{"not an index", "something else\n", false},
+		{"newer format", "mcrdeps 3\n", true},
+		{"short version line", fileHeader + "\nv example.com/a v1.0.0 src\n", false},
+		{"bad kind", fileHeader + "\nv example.com/a v1.0.0 - zip\n", false},
+		{"edge before version", fileHeader + "\nr example.com/b v1.0.0\n", false},
+		{"unknown line", fileHeader + "\nx example.com/a\n", false},
+	} {
+		file := filepath.Join(dir, "bad")
+		modtest.WriteFile(t, file, tt.data)
+		ix, err := Open(file)
+		if !tt.empty {
+			if err == nil {
+				t.Errorf("%s: Open succeeded, want error", tt.name)
+			}
+			continue
+		}
+		if err != nil || len(ix.versions) != 0 {
+			t.Errorf("%s: Open = %d versions, %v, want an empty index", tt.name, len(ix.versions), err)
+		}
+	}
+	if ix, err := Open(filepath.Join(dir, "none")); err != nil || len(ix.versions) != 0 {
+		t.Errorf("Open of a missing file = %v, want an empty index", err)
+	}
+}
+
+func TestImporters(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rdeps")
+	modtest.WriteFile(t, file, indexFile)
+	ix, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		pkg  string
+		want string
+	}{
+		{"example.com/b/pkg", "example.com/a@v1.0.0 example.com/a imports example.com/b/pkg"},
+		{"example.com/b/pkg/...", "example.com/a@v1.0.0 example.com/a imports example.com/b/pkg, example.com/a@v1.0.0 example.com/a/cmd imports example.com/b/pkg/sub"},
+		// A path prefix that is not a parent directory does not match.
+		{"example.com/b/...", "example.com/a@v1.0.0 example.com/a imports example.com/b/pkg, example.com/a@v1.0.0 example.com/a/cmd imports example.com/b/pkg/sub"},
+		{"example.com/b", ""},
+		{"example.com/bb", "example.com/c@v1.10.0 example.com/c imports example.com/bb"},
+		{"example.com/b/p/...", ""},
+		{"example.com/a", "example.com/a@v1.0.0 example.com/a/cmd imports example.com/a"},
+		{"fmt", "example.com/a@v1.0.0 example.com/a imports fmt"},
+	} {
+		var got []string
+		for _, imp := range ix.Importers(tt.pkg) {
+			got = append(got, fmt.Sprintf("%s %s imports %s", imp.Module, imp.ImportPath, imp.Imports))
+		}
+		if strings.Join(got, ", ") != tt.want {
+			t.Errorf("Importers(%s) = %s, want %s", tt.pkg, strings.Join(got, ", "), tt.want)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	modCache := t.TempDir()
+	write := func(path, version string, files map[string]string, extract bool) string {
+		cacheDir, _ := modtest.WriteModule(t, modCache, path, version, files, extract)
+		return cacheDir
+	}
+	write("example.com/a", "v1.0.0", map[string]string{
+		"go.mod":      "module example.com/a\n\nrequire example.com/b v1.0.0\n",
+		"a.go":        "package a\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/b/pkg\"\n)\n\nvar _ = fmt.Sprint(pkg.X)\n",
+		"a_test.go":   "package a\n\nimport _ \"example.com/test/only\"\n",
+		"cmd/main.go": "package main\n\nimport _ \"example.com/b/pkg/sub\"\n\nfunc main() {}\n",
+	}, true)
+	// Read from its zip file.
+	write("example.com/b", "v1.0.0", map[string]string{
+		"go.mod":       "module example.com/b\n",
+		"pkg/pkg.go":   "package pkg\n\nconst X = 1\n",
+		"pkg/sub/s.go": "package sub\n",
+	}, false)
+	// Only the .mod and .info files are cached.
+	cacheDir := write("example.com/c", "v1.0.0", map[string]string{"go.mod": "module example.com/c\n\nrequire example.com/b v1.1.0\n"}, false)
+	cBase := filepath.Join(cacheDir, "example.com", "c", "@v", "v1.0.0")
+	for _, name := range []string{cBase + ".zip", cBase + ".ziphash"} {
+		if err := os.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "rdeps")
+	update := func(when string, want UpdateStats) *Index {
+		t.Helper()
+		c, err := catalog.Open(cacheDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ix, err := Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats, err := ix.Update(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats != want {
+			t.Errorf("%s: Update = %+v, want %+v", when, stats, want)
+		}
+		return ix
+	}
+	importers := func(ix *Index, pkg string) string {
+		var list []string
+		for _, imp := range ix.Importers(pkg) {
+			list = append(list, imp.Module.String()+" "+imp.ImportPath)
+		}
+		return strings.Join(list, ", ")
+	}
+
+	ix := update("first Update", UpdateStats{Added: 3})
+	if got, want := importers(ix, "example.com/b/..."), "example.com/a@v1.0.0 example.com/a, example.com/a@v1.0.0 example.com/a/cmd"; got != want {
+		t.Errorf("Importers(example.com/b/...) = %s, want %s", got, want)
+	}
+	// Imports by test files are not recorded.
+	if got := importers(ix, "example.com/test/only"); got != "" {
+		t.Errorf("Importers(example.com/test/only) = %s, want none", got)
+	}
+	c1 := module.Version{Path: "example.com/c", Version: "v1.0.0"}
+	if reqs, ok := ix.Requires(c1); !ok || len(reqs) != 1 || reqs[0].String() != "example.com/b@v1.1.0" {
+		t.Errorf("Requires(%s) = %v, %v, want [example.com/b@v1.1.0]", c1, reqs, ok)
+	}
+	if e := ix.versions[c1]; e.source || e.zipHash != "-" {
+		t.Errorf("%s indexed with source %v, ziphash %s, want false, -", c1, e.source, e.zipHash)
+	}
+	update("second Update", UpdateStats{})
+
+	// A version whose .ziphash changes is indexed again. Its packages
+	// come from the package cache, which depends only on the version.
+	b1 := module.Version{Path: "example.com/b", Version: "v1.0.0"}
+	modtest.WriteFile(t, filepath.Join(cacheDir, "example.com", "b", "@v", "v1.0.0.ziphash"), "h1:changed=\n")
+	ix = update("after ziphash change", UpdateStats{Added: 1})
+	if e := ix.versions[b1]; e.zipHash != "h1:changed=" || !e.source {
+		t.Errorf("after ziphash change, %s indexed with ziphash %s, source %v", b1, e.zipHash, e.source)
+	}
+	update("after reindexing", UpdateStats{})
+
+	// So is a version whose source has been added since.
+	write("example.com/c", "v1.0.0", map[string]string{
+		"go.mod": "module example.com/c\n\nrequire example.com/b v1.1.0\n",
+		"c.go":   "package c\n\nimport _ \"example.com/b/pkg\"\n",
+	}, true)
+	ix = update("after adding source", UpdateStats{Added: 1})
+	if got := importers(ix, "example.com/b/pkg"); got != "example.com/a@v1.0.0 example.com/a, example.com/c@v1.0.0 example.com/c" {
+		t.Errorf("after adding source, Importers(example.com/b/pkg) = %s", got)
+	}
+
+	// A version removed from the cache is dropped.
+	aBase := filepath.Join(cacheDir, "example.com", "a", "@v", "v1.0.0")
+	for _, name := range []string{filepath.Join(modCache, "example.com", "a@v1.0.0"), aBase + ".mod", aBase + ".zip", aBase + ".ziphash", aBase + ".info"} {
+		if err := os.RemoveAll(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix = update("after removal", UpdateStats{Removed: 1})
+	if got := importers(ix, "example.com/b/..."); got != "example.com/c@v1.0.0 example.com/c" {
+		t.Errorf("after removal, Importers(example.com/b/...) = %s", got)
+	}
+
+	// An index in an older format is rebuilt.
+	modtest.WriteFile(t, file, "mcrdeps 1\n")
+	update("after downgrade", UpdateStats{Added: 2})
+}